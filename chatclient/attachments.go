@@ -0,0 +1,172 @@
+package chatclient
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"io"
+	"mime"
+	"net/http"
+	"os"
+	"path/filepath"
+
+	chitchat "homework3/chitchat"
+)
+
+// how much of a file each upload chunk carries
+const uploadChunkSize = 64 << 10
+
+// ErrChecksum is returned by Download when the file that arrived is not the one that was shared.
+var ErrChecksum = errors.New("chatclient: the downloaded file does not match its checksum")
+
+// Attachment is a file shared in a room. Its ID is the ID of the message that shared it.
+type Attachment struct {
+	ID          string
+	FileName    string
+	ContentType string
+	Size        int64
+	//hex SHA-256 of the file's contents
+	SHA256 string
+}
+
+func attachmentFrom(attachment *chitchat.Attachment) *Attachment {
+	if attachment == nil {
+		return nil
+	}
+	return &Attachment{
+		ID:          attachment.Id,
+		FileName:    attachment.FileName,
+		ContentType: attachment.ContentType,
+		Size:        attachment.Size,
+		SHA256:      attachment.Sha256,
+	}
+}
+
+// ShareFile shares the file at path in the room, under its base name. See Share.
+func (c *Client) ShareFile(ctx context.Context, path string) (string, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return "", err
+	}
+	defer file.Close()
+	return c.Share(ctx, filepath.Base(path), "", file)
+}
+
+// Share shares a file in the room. Everyone in it gets a MessageEvent whose Message has the Attachment,
+// and can fetch the file with Download. An empty contentType is worked out from the file name, or
+// else from the first bytes. content is read twice: once for the checksum, and once to send it.
+// It returns the id the server gave the message, which is also the attachment's.
+func (c *Client) Share(ctx context.Context, fileName string, contentType string, content io.ReadSeeker) (string, error) {
+	hash := sha256.New()
+	start := make([]byte, 512)
+	n, err := io.ReadFull(content, start)
+	if err != nil && err != io.ErrUnexpectedEOF && err != io.EOF {
+		return "", err
+	}
+	hash.Write(start[:n])
+	rest, err := io.Copy(hash, content)
+	if err != nil {
+		return "", err
+	}
+	if contentType == "" {
+		contentType = mime.TypeByExtension(filepath.Ext(fileName))
+	}
+	if contentType == "" {
+		contentType = http.DetectContentType(start[:n])
+	}
+	if _, err := content.Seek(0, io.SeekStart); err != nil {
+		return "", err
+	}
+
+	c.mutex.Lock()
+	if c.leaving {
+		c.mutex.Unlock()
+		return "", ErrLeft
+	}
+	c.typingDone()
+	c.lamport++
+	header := &chitchat.UploadHeader{
+		Name:        c.user.Name,
+		Room:        c.user.Room,
+		FileName:    fileName,
+		ContentType: contentType,
+		Size:        int64(n) + rest,
+		Sha256:      hex.EncodeToString(hash.Sum(nil)),
+		Lamport:     c.lamport,
+	}
+	c.mutex.Unlock()
+	header.Sign(c.key)
+
+	stream, err := c.service.Upload(ctx)
+	if err != nil {
+		return "", err
+	}
+	chunk := &chitchat.UploadChunk{Header: header}
+	buffer := make([]byte, uploadChunkSize)
+	for {
+		n, err := content.Read(buffer)
+		if n > 0 {
+			chunk.Data = buffer[:n]
+			if err := stream.Send(chunk); err != nil {
+				//the server ended the upload, and says why when it is closed.
+				break
+			}
+			chunk = &chitchat.UploadChunk{}
+		}
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			stream.CloseSend()
+			return "", err
+		}
+	}
+	//an empty file is only the header.
+	if chunk.Header != nil {
+		if err := stream.Send(chunk); err != nil && err != io.EOF {
+			return "", err
+		}
+	}
+	confirmation, err := stream.CloseAndRecv()
+	if err != nil {
+		return "", err
+	}
+	return confirmation.MessageId, nil
+}
+
+// Download fetches a shared file and writes it to w, checking it against the size and checksum
+// it was shared with. It returns what the file is. If the file is not the one that was shared,
+// it returns ErrChecksum, and whatever was written to w should be thrown away.
+func (c *Client) Download(ctx context.Context, attachmentID string, w io.Writer) (*Attachment, error) {
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+	stream, err := c.service.Download(ctx, &chitchat.DownloadRequest{AttachmentId: attachmentID})
+	if err != nil {
+		return nil, err
+	}
+	var attachment *Attachment
+	hash := sha256.New()
+	var written int64
+	for {
+		chunk, err := stream.Recv()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, err
+		}
+		if attachment == nil {
+			attachment = attachmentFrom(chunk.Attachment)
+		}
+		hash.Write(chunk.Data)
+		if _, err := w.Write(chunk.Data); err != nil {
+			return nil, err
+		}
+		written += int64(len(chunk.Data))
+	}
+	if attachment == nil || written != attachment.Size || hex.EncodeToString(hash.Sum(nil)) != attachment.SHA256 {
+		return attachment, ErrChecksum
+	}
+	return attachment, nil
+}
@@ -0,0 +1,618 @@
+// Package chatclient connects to a Chitty-Chat server as one user in one room. It signs
+// what the user sends, keeps the user's Lamport clock, checks who wrote what arrives and
+// reconnects when the connection is lost, so bots and tools only have to handle Events.
+package chatclient
+
+import (
+	"bytes"
+	"context"
+	"crypto/ed25519"
+	"errors"
+	"io"
+	"log/slog"
+	"math/rand"
+	"slices"
+	"strconv"
+	"sync"
+	"time"
+
+	chitchat "homework3/chitchat"
+	"homework3/tracing"
+
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/trace"
+	"google.golang.org/genproto/googleapis/rpc/errdetails"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/credentials/insecure"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/proto"
+)
+
+// ErrLeft is returned by calls made after Leave.
+var ErrLeft = errors.New("chatclient: the client has left the chat")
+
+// Client is a user connected to a Chitty-Chat server.
+type Client struct {
+	options   options
+	conn      *grpc.ClientConn
+	service   chitchat.ChatServiceClient
+	key       ed25519.PrivateKey
+	sessionID string
+	logger    *slog.Logger
+
+	//guards everything below it
+	mutex   sync.Mutex
+	user    *chitchat.User
+	lamport int32
+	//public keys seen for each author, used to spot messages signed by someone else
+	knownKeys map[string]ed25519.PublicKey
+	//presence the user set, to set again after reconnecting
+	presence       chitchat.Presence
+	presenceStatus string
+	//whether the user is typing, when the room was last told, and the timer that decides they stopped
+	typing      bool
+	typingSent  time.Time
+	typingPause *time.Timer
+	//ends the current Join stream
+	cancelStream context.CancelFunc
+	leaving      bool
+	err          error
+
+	//closed by Leave, to stop waiting to reconnect and sending typing updates
+	stop chan struct{}
+	//typing updates on their way to sendTyping
+	typingUpdates chan bool
+	//message ids waiting to be acknowledged, by kind of receipt, and a nudge for sendReceipts
+	pendingReceipts map[chitchat.ReceiptKind][]string
+	receiptsQueued  chan struct{}
+	//direct messages to the user that have reached it, and the ones still to acknowledge
+	directSeen  map[string]bool
+	pendingAcks []string
+	//events from the receiver on their way to order
+	received chan Event
+	events   chan Event
+	//closed once the Events channel is
+	done chan struct{}
+}
+
+// Connect joins the chat at address as name, signing with key, and returns once the server
+// has confirmed the user is in. Read Events until it is closed, or the client stalls.
+func Connect(ctx context.Context, address string, name string, key ed25519.PrivateKey, opts ...Option) (*Client, error) {
+	c := &Client{
+		options:   defaultOptions(),
+		key:       key,
+		knownKeys: make(map[string]ed25519.PublicKey),
+		stop:      make(chan struct{}),
+		received:  make(chan Event),
+		done:      make(chan struct{}),
+
+		typingUpdates:   make(chan bool, 4),
+		pendingReceipts: make(map[chitchat.ReceiptKind][]string),
+		receiptsQueued:  make(chan struct{}, 1),
+		directSeen:      make(map[string]bool),
+	}
+	for _, opt := range opts {
+		opt(&c.options)
+	}
+	c.logger = c.options.logger
+	if c.logger == nil {
+		c.logger = slog.Default()
+	}
+	c.sessionID = c.options.sessionID
+	if c.sessionID == "" {
+		c.sessionID = newID()
+	}
+	id := c.options.id
+	if id == 0 {
+		id = rand.Int31n(999999) + 1
+	}
+	c.user = &chitchat.User{
+		Id:        id,
+		Name:      name,
+		Room:      c.options.room,
+		PublicKey: key.Public().(ed25519.PublicKey),
+	}
+	c.events = make(chan Event, c.options.eventBuffer)
+
+	dialOptions := []grpc.DialOption{
+		grpc.WithTransportCredentials(insecure.NewCredentials()),
+		tracing.DialOption(),
+		grpc.WithChainUnaryInterceptor(c.loggingUnaryInterceptor),
+		grpc.WithChainStreamInterceptor(c.loggingStreamInterceptor),
+	}
+	conn, err := grpc.DialContext(ctx, address, append(dialOptions, c.options.dialOptions...)...)
+	if err != nil {
+		return nil, err
+	}
+	c.conn = conn
+	c.service = chitchat.NewChatServiceClient(conn)
+
+	stream, err := c.join(ctx)
+	if err != nil {
+		conn.Close()
+		return nil, err
+	}
+	go c.receive(stream)
+	go c.order()
+	go c.sendTyping()
+	go c.sendReceipts()
+	return c, nil
+}
+
+// join opens a Join stream and waits until the server confirms the user is in, or turns them away.
+func (c *Client) join(ctx context.Context) (chitchat.ChatService_JoinClient, error) {
+	//signing a nonce from the server shows it the user holds the key, and makes the join one nobody can send again.
+	challenge, err := c.service.GetJoinChallenge(ctx, &chitchat.JoinChallengeRequest{})
+	if err != nil {
+		return nil, err
+	}
+	c.mutex.Lock()
+	c.user.Lamport = c.lamport
+	user := proto.Clone(c.user).(*chitchat.User)
+	//the stream outlives ctx, which only bounds the wait for the server's answer.
+	streamCtx, cancel := context.WithCancel(context.Background())
+	c.cancelStream = cancel
+	c.mutex.Unlock()
+	user.Nonce = challenge.Nonce
+	user.SignJoin(c.key)
+
+	stream, err := c.service.Join(streamCtx, user)
+	if err != nil {
+		cancel()
+		return nil, err
+	}
+	confirmed := make(chan error, 1)
+	go func() {
+		//the server sends the stream's headers once the user is in.
+		header, err := stream.Header()
+		if err == nil && len(header.Get(chitchat.JoinedHeader)) == 0 {
+			//the stream ended before the server let us in; Recv says why.
+			_, err = stream.Recv()
+			if err == nil {
+				err = status.Error(codes.Internal, "the server did not confirm the join")
+			}
+		} else if err == nil {
+			//the server may have restarted, or been busy elsewhere; what is signed from here on must be newer.
+			if joined, err := strconv.Atoi(header.Get(chitchat.JoinedHeader)[0]); err == nil {
+				c.mutex.Lock()
+				c.lamport = max(c.lamport, int32(joined))
+				c.mutex.Unlock()
+			}
+		}
+		confirmed <- err
+	}()
+	select {
+	case err := <-confirmed:
+		if err != nil {
+			cancel()
+			return nil, err
+		}
+		return stream, nil
+	case <-ctx.Done():
+		cancel()
+		return nil, ctx.Err()
+	}
+}
+
+// Events returns the channel events are delivered on. It is closed after Leave,
+// or after an ErrorEvent when the client gives up.
+func (c *Client) Events() <-chan Event {
+	return c.events
+}
+
+// Done is closed once the Events channel is.
+func (c *Client) Done() <-chan struct{} {
+	return c.done
+}
+
+// Err returns why the client gave up, or nil while it is connected or after Leave.
+func (c *Client) Err() error {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	return c.err
+}
+
+// ID returns the id the user joined with.
+func (c *Client) ID() int32 {
+	return c.user.Id
+}
+
+// Name returns the name the user joined with.
+func (c *Client) Name() string {
+	return c.user.Name
+}
+
+// Room returns the room the user is in.
+func (c *Client) Room() string {
+	return c.user.Room
+}
+
+// Lamport returns the client's current Lamport time.
+func (c *Client) Lamport() int32 {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	return c.lamport
+}
+
+// nextLamport advances the client's Lamport time for something it is about to sign, and returns it.
+func (c *Client) nextLamport() int32 {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	c.lamport++
+	return c.lamport
+}
+
+// Send signs a message and sends it to everyone in the room. It returns the id the server gave the message.
+func (c *Client) Send(ctx context.Context, text string) (string, error) {
+	return c.send(ctx, text, "", 0)
+}
+
+// SendEphemeral sends a message that disappears after ttl, rounded up to the second: the server
+// deletes it from the history then, and every client gets a DeleteEvent for it.
+// It returns the id the server gave the message.
+func (c *Client) SendEphemeral(ctx context.Context, text string, ttl time.Duration) (string, error) {
+	if ttl <= 0 {
+		return "", errors.New("chatclient: an ephemeral message must last for some time")
+	}
+	return c.send(ctx, text, "", int32((ttl+time.Second-1)/time.Second))
+}
+
+// Reply sends a message as a reply to the message with the given id, which must be in the room
+// and must have reached the client already. It returns the id the server gave the reply.
+func (c *Client) Reply(ctx context.Context, replyTo string, text string) (string, error) {
+	return c.send(ctx, text, replyTo, 0)
+}
+
+func (c *Client) send(ctx context.Context, text string, replyTo string, ttlSeconds int32) (string, error) {
+	//The trace started here follows the message through the server to every recipient.
+	ctx, span := tracing.Tracer().Start(ctx, "chitchat.send_message")
+	defer span.End()
+
+	c.mutex.Lock()
+	if c.leaving {
+		c.mutex.Unlock()
+		return "", ErrLeft
+	}
+	c.typingDone()
+	c.lamport++
+	message := &chitchat.ClientMessage{
+		Name:       c.user.Name,
+		Text:       text,
+		Lamport:    c.lamport,
+		Room:       c.user.Room,
+		ReplyTo:    replyTo,
+		TtlSeconds: ttlSeconds,
+	}
+	c.mutex.Unlock()
+	message.Sign(c.key)
+
+	confirmation, err := c.service.Broadcast(ctx, message)
+	if err != nil {
+		return "", err
+	}
+	return confirmation.MessageId, nil
+}
+
+// ListParticipants asks the server who is in the room. includeOffline also lists
+// participants who have been in the room but are not connected anymore.
+func (c *Client) ListParticipants(ctx context.Context, includeOffline bool) ([]Participant, error) {
+	response, err := c.service.ListParticipants(ctx, &chitchat.ListParticipantsRequest{Room: c.user.Room, IncludeOffline: includeOffline})
+	if err != nil {
+		return nil, err
+	}
+	return participantsFrom(response.Participants), nil
+}
+
+// SetPresence tells the room the user is away, with status saying why, or back with
+// chitchat.Presence_ONLINE. It is set again whenever the client reconnects.
+func (c *Client) SetPresence(ctx context.Context, presence chitchat.Presence, status string) error {
+	c.mutex.Lock()
+	if c.leaving {
+		c.mutex.Unlock()
+		return ErrLeft
+	}
+	c.lamport++
+	update := &chitchat.PresenceUpdate{
+		Name:     c.user.Name,
+		Room:     c.user.Room,
+		Presence: presence,
+		Status:   status,
+		Lamport:  c.lamport,
+	}
+	c.mutex.Unlock()
+	update.Sign(c.key)
+
+	if _, err := c.service.SetPresence(ctx, update); err != nil {
+		return err
+	}
+	c.mutex.Lock()
+	c.presence = presence
+	c.presenceStatus = status
+	c.mutex.Unlock()
+	return nil
+}
+
+// restorePresence sets the user away again after reconnecting, if they were.
+func (c *Client) restorePresence() {
+	c.mutex.Lock()
+	presence, status := c.presence, c.presenceStatus
+	c.mutex.Unlock()
+	if presence == chitchat.Presence_ONLINE {
+		return
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), c.options.reconnect.MaxDelay)
+	defer cancel()
+	if err := c.SetPresence(ctx, presence, status); err != nil {
+		c.logger.Warn("could not set presence again after reconnecting", "error", err)
+	}
+}
+
+// Leave tells the server the user is leaving and closes the connection once the server
+// has ended the stream, or ctx is done. The Events channel is closed after whatever
+// was still on its way has been delivered.
+func (c *Client) Leave(ctx context.Context) error {
+	//let the authors know what the user saw before they go, and the server which direct messages arrived.
+	c.flushReceipts(ctx)
+	c.flushAcks(ctx)
+	c.mutex.Lock()
+	if c.leaving {
+		c.mutex.Unlock()
+		return ErrLeft
+	}
+	c.leaving = true
+	c.typingDone()
+	close(c.stop)
+	c.lamport++
+	c.user.Lamport = c.lamport
+	user := proto.Clone(c.user).(*chitchat.User)
+	c.mutex.Unlock()
+	user.SignLeave(c.key)
+
+	_, err := c.service.Leave(ctx, user)
+	if err == nil {
+		//the server ends our stream once we have left.
+		select {
+		case <-c.done:
+		case <-ctx.Done():
+		}
+	}
+	c.mutex.Lock()
+	c.cancelStream()
+	c.mutex.Unlock()
+	c.conn.Close()
+	return err
+}
+
+// receive reads messages from the stream until the user leaves, reconnecting when the stream breaks.
+func (c *Client) receive(stream chitchat.ChatService_JoinClient) {
+	defer close(c.received)
+	for {
+		message, err := stream.Recv()
+		if err == nil {
+			if message.Kind == chitchat.ServerMessage_TYPING || message.Kind == chitchat.ServerMessage_RECEIPTS || message.Kind == chitchat.ServerMessage_MENTION {
+				c.ephemeralEvent(message)
+				continue
+			}
+			if message.Kind == chitchat.ServerMessage_DIRECT && c.seenDirect(message.Id) {
+				continue
+			}
+			c.received <- c.messageEvent(message)
+			continue
+		}
+		c.mutex.Lock()
+		leaving := c.leaving
+		c.mutex.Unlock()
+		if leaving {
+			return
+		}
+		c.logger.Info("lost the stream", "error", err)
+		stream = c.reconnect(err)
+		if stream == nil {
+			return
+		}
+		go c.restorePresence()
+	}
+}
+
+// messageEvent updates the Lamport clock for a received message and turns it into an event.
+func (c *Client) messageEvent(message *chitchat.ServerMessage) Event {
+	//Continue the sender's trace, so it covers the message all the way to whoever handles the event.
+	_, span := tracing.Tracer().Start(tracing.Extract(context.Background(), message.TraceContext), "chitchat.receive",
+		trace.WithAttributes(attribute.Int("chitchat.lamport", int(message.Lamport))))
+
+	c.mutex.Lock()
+	//Find lamport timestamp of incoming message, select the highest and increment.
+	c.lamport = max(c.lamport, message.Lamport)
+	c.lamport++
+	lamport := c.lamport
+	verified := c.verify(message)
+	c.mutex.Unlock()
+	if !verified {
+		c.logger.Warn("message failed signature verification", "author", message.Name, "lamport", message.Lamport)
+	}
+
+	event := Event{
+		Kind:     MessageEvent,
+		Message:  messageFrom(message, verified),
+		Lamport:  lamport,
+		span:     span,
+		received: time.Now(),
+	}
+	event.Message.Mentioned = slices.Contains(message.Mentions, c.user.Name)
+	switch message.Kind {
+	case chitchat.ServerMessage_JOINED:
+		event.Kind = JoinEvent
+		event.Participant = message.Subject
+	case chitchat.ServerMessage_LEFT:
+		event.Kind = LeaveEvent
+		event.Participant = message.Subject
+	case chitchat.ServerMessage_NOTICE:
+		event.Kind = NoticeEvent
+	case chitchat.ServerMessage_PRESENCE:
+		event.Kind = PresenceEvent
+		event.Participant = message.Subject
+		event.Presence = message.Presence
+		event.Status = message.Status
+	case chitchat.ServerMessage_PARTICIPANTS:
+		event.Kind = ParticipantsEvent
+		event.Participants = participantsFrom(message.Participants)
+	case chitchat.ServerMessage_EDITED:
+		event.Kind = EditEvent
+		event.Participant = message.Subject
+		event.Target = message.Target
+	case chitchat.ServerMessage_DELETED:
+		event.Kind = DeleteEvent
+		event.Participant = message.Subject
+		event.Target = message.Target
+	case chitchat.ServerMessage_REACTIONS:
+		event.Kind = ReactionEvent
+		event.Participant = message.Subject
+		event.Target = message.Target
+		event.Emoji = message.Emoji
+		event.Removed = message.Removed
+		event.Reactions = reactionsFrom(message.Reactions)
+	case chitchat.ServerMessage_DIRECT:
+		event.Kind = DirectEvent
+	}
+	return event
+}
+
+func messageFrom(message *chitchat.ServerMessage, verified bool) *Message {
+	converted := &Message{
+		Author:     message.Name,
+		Text:       message.Text,
+		Room:       message.Room,
+		Lamport:    message.Lamport,
+		ID:         message.Id,
+		To:         message.To,
+		ReplyTo:    message.ReplyTo,
+		Attachment: attachmentFrom(message.Attachment),
+		Verified:   verified,
+		Replayed:   message.Replayed,
+		Mentions:   message.Mentions,
+		Raw:        message,
+	}
+	if message.Quote != nil {
+		converted.Quote = &Quote{Author: message.Quote.Author, Text: message.Quote.Text}
+	}
+	if message.SentAt != nil {
+		converted.SentAt = message.SentAt.AsTime()
+	}
+	if message.ExpiresAt != nil {
+		converted.ExpiresAt = message.ExpiresAt.AsTime()
+	}
+	return converted
+}
+
+// ephemeralEvent passes on a typing indicator about someone else, receipts for one of the
+// user's messages, or someone mentioning the user. None of them is part of the chat, so they
+// leave the Lamport clock alone and skip the reorder window.
+func (c *Client) ephemeralEvent(message *chitchat.ServerMessage) {
+	if message.Kind == chitchat.ServerMessage_TYPING && message.Subject == c.user.Name {
+		return
+	}
+	c.mutex.Lock()
+	verified := c.verify(message)
+	lamport := c.lamport
+	c.mutex.Unlock()
+	if !verified {
+		c.logger.Warn("server message failed signature verification", "kind", message.Kind.String(), "participant", message.Subject)
+		return
+	}
+	event := Event{Kind: TypingEvent, Participant: message.Subject, Typing: message.Typing, Lamport: lamport}
+	switch {
+	case message.Kind == chitchat.ServerMessage_RECEIPTS && message.Receipts != nil:
+		event.Kind = ReceiptEvent
+		event.Receipts = receiptsFrom(message.Receipts)
+	case message.Kind == chitchat.ServerMessage_MENTION:
+		event.Kind = MentionEvent
+		event.Target = message.Target
+		event.Message = messageFrom(message, verified)
+	}
+	c.received <- event
+}
+
+// verify checks the signature on a message and that its author signs with the same key as before.
+// An edit must also carry its editor's signature, by the key they sign with.
+// The first key seen for an author is trusted from then on. It must be called with the mutex held.
+func (c *Client) verify(message *chitchat.ServerMessage) bool {
+	if !message.Verify() || !c.trust(message.Name, message.PublicKey) {
+		return false
+	}
+	if message.Kind == chitchat.ServerMessage_EDITED {
+		return message.VerifyEdit() && c.trust(message.Subject, message.SubjectKey)
+	}
+	return true
+}
+
+// trust reports whether key is the one name signs with, trusting the first key seen for them.
+// It must be called with the mutex held.
+func (c *Client) trust(name string, key []byte) bool {
+	knownKey, seen := c.knownKeys[name]
+	if !seen {
+		c.knownKeys[name] = ed25519.PublicKey(key)
+		return true
+	}
+	return bytes.Equal(knownKey, key)
+}
+
+// reconnect joins again after the stream broke with err, following the reconnect policy.
+// It returns nil if the client gave up or the user left in the meantime.
+func (c *Client) reconnect(err error) chitchat.ChatService_JoinClient {
+	policy := c.options.reconnect
+	if !reconnectable(err) || policy.MaxAttempts <= 0 {
+		c.fail(err)
+		return nil
+	}
+	delay := retryDelay(err, policy.InitialDelay)
+	for attempt := 1; attempt <= policy.MaxAttempts; attempt++ {
+		c.received <- Event{Kind: ReconnectingEvent, Err: err, Attempt: attempt, Delay: delay, Lamport: c.Lamport()}
+		select {
+		case <-time.After(delay):
+		case <-c.stop:
+			return nil
+		}
+
+		ctx, cancel := context.WithTimeout(context.Background(), policy.MaxDelay)
+		var stream chitchat.ChatService_JoinClient
+		stream, err = c.join(ctx)
+		cancel()
+		if err == nil {
+			c.logger.Info("reconnected", "attempt", attempt)
+			c.received <- Event{Kind: ReconnectedEvent, Attempt: attempt, Lamport: c.Lamport()}
+			return stream
+		}
+		c.logger.Info("could not reconnect", "attempt", attempt, "error", err)
+		if !reconnectable(err) {
+			break
+		}
+		delay = min(max(delay*2, retryDelay(err, 0)), policy.MaxDelay)
+	}
+	c.fail(err)
+	return nil
+}
+
+// fail records why the client gave up and tells whoever reads the events.
+func (c *Client) fail(err error) {
+	c.mutex.Lock()
+	c.err = err
+	c.mutex.Unlock()
+	c.received <- Event{Kind: ErrorEvent, Err: err, Lamport: c.Lamport()}
+}
+
+// reconnectable is true for errors that joining again might fix: the server going away
+// or ending the stream without saying why.
+func reconnectable(err error) bool {
+	return err == io.EOF || status.Code(err) == codes.Unavailable || status.Code(err) == codes.DeadlineExceeded
+}
+
+// retryDelay is how long the server asked us to wait before reconnecting, or fallback if it did not say.
+func retryDelay(err error, fallback time.Duration) time.Duration {
+	for _, detail := range status.Convert(err).Details() {
+		if retryInfo, ok := detail.(*errdetails.RetryInfo); ok {
+			return retryInfo.RetryDelay.AsDuration()
+		}
+	}
+	return fallback
+}
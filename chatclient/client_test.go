@@ -0,0 +1,180 @@
+package chatclient_test
+
+import (
+	"context"
+	"crypto/ed25519"
+	"errors"
+	"io"
+	"log/slog"
+	"net"
+	"testing"
+	"time"
+
+	"homework3/chatclient"
+	"homework3/chatserver"
+)
+
+var quiet = slog.New(slog.NewTextHandler(io.Discard, nil))
+
+// startServer starts a server on address, keeping what it stores in dir, and returns it with the
+// address it listens on. The server is stopped when the test is done, unless it was already.
+func startServer(t *testing.T, dir string, address string) (*chatserver.Server, string) {
+	t.Helper()
+	storage, err := chatserver.OpenFileStorage(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	listener, err := net.Listen("tcp", address)
+	if err != nil {
+		t.Fatal(err)
+	}
+	s, err := chatserver.New(
+		chatserver.WithStorage(storage),
+		chatserver.WithListeners(listener),
+		chatserver.WithLogger(quiet),
+		//what the server asks clients to wait before reconnecting when it stops
+		chatserver.WithReconnectDelay(10*time.Millisecond),
+	)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := s.Start(); err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { s.Stop(context.Background()) })
+	return s, listener.Addr().String()
+}
+
+func newKey(t *testing.T) ed25519.PrivateKey {
+	t.Helper()
+	_, key, err := ed25519.GenerateKey(nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return key
+}
+
+func connect(t *testing.T, address string, name string, opts ...chatclient.Option) *chatclient.Client {
+	t.Helper()
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	client, err := chatclient.Connect(ctx, address, name, newKey(t), append([]chatclient.Option{chatclient.WithLogger(quiet)}, opts...)...)
+	if err != nil {
+		t.Fatalf("connecting as %s: %v", name, err)
+	}
+	t.Cleanup(func() { client.Leave(context.Background()) })
+	return client
+}
+
+// waitFor returns the first event of the given kind the client gets, failing the test if none comes.
+func waitFor(t *testing.T, client *chatclient.Client, kind chatclient.EventKind) chatclient.Event {
+	t.Helper()
+	timeout := time.After(5 * time.Second)
+	for {
+		select {
+		case event, ok := <-client.Events():
+			if !ok {
+				t.Fatalf("%s's events ended waiting for a %s event: %v", client.Name(), kind, client.Err())
+			}
+			if event.Kind == kind {
+				return event
+			}
+		case <-timeout:
+			t.Fatalf("%s got no %s event", client.Name(), kind)
+		}
+	}
+}
+
+func TestSendAndLeave(t *testing.T) {
+	ctx := context.Background()
+	_, address := startServer(t, t.TempDir(), "127.0.0.1:0")
+	alice := connect(t, address, "alice", chatclient.WithRoom("ops"))
+	waitFor(t, alice, chatclient.ParticipantsEvent)
+	bob := connect(t, address, "bob", chatclient.WithRoom("ops"))
+	if joined := waitFor(t, alice, chatclient.JoinEvent); joined.Participant != "bob" {
+		t.Errorf("alice saw %s join, want bob", joined.Participant)
+	}
+
+	lamport := alice.Lamport()
+	id, err := alice.Send(ctx, "hello, ops")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if alice.Lamport() <= lamport {
+		t.Errorf("alice's Lamport time is %d after sending, want it past %d", alice.Lamport(), lamport)
+	}
+	received := waitFor(t, bob, chatclient.MessageEvent)
+	message := received.Message
+	if message.ID != id || message.Author != "alice" || message.Text != "hello, ops" || message.Room != "ops" || !message.Verified {
+		t.Errorf("bob got %+v, want alice's verified message %s", message, id)
+	}
+	if received.Lamport <= message.Lamport || bob.Lamport() < received.Lamport {
+		t.Errorf("bob's Lamport time is %d after a message at %d", received.Lamport, message.Lamport)
+	}
+
+	if err := bob.Leave(ctx); err != nil {
+		t.Fatal(err)
+	}
+	if left := waitFor(t, alice, chatclient.LeaveEvent); left.Participant != "bob" {
+		t.Errorf("alice saw %s leave, want bob", left.Participant)
+	}
+	select {
+	case <-bob.Done():
+	case <-time.After(5 * time.Second):
+		t.Fatal("bob's events were not closed after leaving")
+	}
+	if _, err := bob.Send(ctx, "still here?"); err == nil {
+		t.Error("sending after leaving worked")
+	}
+	if err := bob.Leave(ctx); !errors.Is(err, chatclient.ErrLeft) {
+		t.Errorf("leaving twice: %v, want ErrLeft", err)
+	}
+}
+
+func TestReconnect(t *testing.T) {
+	dir := t.TempDir()
+	first, address := startServer(t, dir, "127.0.0.1:0")
+	alice := connect(t, address, "alice", chatclient.WithReconnectPolicy(chatclient.ReconnectPolicy{
+		MaxAttempts:  20,
+		InitialDelay: 50 * time.Millisecond,
+		MaxDelay:     100 * time.Millisecond,
+	}))
+	waitFor(t, alice, chatclient.ParticipantsEvent)
+	if err := first.Stop(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	waitFor(t, alice, chatclient.ReconnectingEvent)
+	startServer(t, dir, address)
+	waitFor(t, alice, chatclient.ReconnectedEvent)
+
+	bob := connect(t, address, "bob")
+	waitFor(t, bob, chatclient.ParticipantsEvent)
+	if _, err := alice.Send(context.Background(), "back again"); err != nil {
+		t.Fatalf("sending after reconnecting: %v", err)
+	}
+	if message := waitFor(t, bob, chatclient.MessageEvent).Message; message.Text != "back again" || !message.Verified {
+		t.Errorf("bob got %+v after alice reconnected", message)
+	}
+}
+
+func TestGiveUp(t *testing.T) {
+	s, address := startServer(t, t.TempDir(), "127.0.0.1:0")
+	alice := connect(t, address, "alice", chatclient.WithReconnectPolicy(chatclient.ReconnectPolicy{
+		MaxAttempts:  2,
+		InitialDelay: 10 * time.Millisecond,
+		MaxDelay:     50 * time.Millisecond,
+	}))
+	waitFor(t, alice, chatclient.ParticipantsEvent)
+	if err := s.Stop(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	failed := waitFor(t, alice, chatclient.ErrorEvent)
+	if failed.Err == nil || alice.Err() == nil {
+		t.Errorf("the client gave up with %v, and Err says %v", failed.Err, alice.Err())
+	}
+	select {
+	case <-alice.Done():
+	case <-time.After(5 * time.Second):
+		t.Fatal("the events were not closed after giving up")
+	}
+}
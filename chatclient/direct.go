@@ -0,0 +1,79 @@
+package chatclient
+
+import (
+	"context"
+
+	chitchat "homework3/chitchat"
+)
+
+// SendDirect signs a private message and sends it to one user, wherever they are. A user who is
+// offline gets it the next time they join. Both get a DirectEvent. It returns the id the server gave the message.
+func (c *Client) SendDirect(ctx context.Context, to string, text string) (string, error) {
+	c.mutex.Lock()
+	if c.leaving {
+		c.mutex.Unlock()
+		return "", ErrLeft
+	}
+	c.lamport++
+	message := &chitchat.DirectMessage{
+		Name:    c.user.Name,
+		To:      to,
+		Text:    text,
+		Lamport: c.lamport,
+	}
+	c.mutex.Unlock()
+	message.Sign(c.key)
+
+	confirmation, err := c.service.SendDirect(ctx, message)
+	if err != nil {
+		return "", err
+	}
+	return confirmation.MessageId, nil
+}
+
+// seenDirect reports whether a direct message has reached the client before, which happens when
+// it was sent while the client was joining, or the client reconnected before acknowledging it.
+func (c *Client) seenDirect(messageID string) bool {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	if c.directSeen[messageID] {
+		return true
+	}
+	c.directSeen[messageID] = true
+	return false
+}
+
+// queueAck adds a direct message to the user to the next batch of acknowledgements,
+// which are sent along with the receipts.
+func (c *Client) queueAck(messageID string) {
+	c.mutex.Lock()
+	if c.leaving {
+		c.mutex.Unlock()
+		return
+	}
+	c.pendingAcks = append(c.pendingAcks, messageID)
+	c.mutex.Unlock()
+	select {
+	case c.receiptsQueued <- struct{}{}:
+	default:
+	}
+}
+
+// flushAcks tells the server every queued direct message has reached the user, so it can take them
+// out of the user's mailbox. Ones the server does not take are sent again the next time the user joins.
+func (c *Client) flushAcks(ctx context.Context) {
+	c.mutex.Lock()
+	pending := c.pendingAcks
+	c.pendingAcks = nil
+	c.mutex.Unlock()
+
+	for len(pending) > 0 {
+		batch := pending[:min(len(pending), maxReceiptBatch)]
+		pending = pending[len(batch):]
+		ack := &chitchat.MailboxAck{Name: c.user.Name, MessageIds: batch, Lamport: c.nextLamport()}
+		ack.Sign(c.key)
+		if _, err := c.service.AcknowledgeDirect(ctx, ack); err != nil {
+			c.logger.Debug("could not acknowledge direct messages", "messages", len(batch), "error", err)
+		}
+	}
+}
@@ -0,0 +1,94 @@
+package chatclient
+
+import (
+	"context"
+	"time"
+
+	chitchat "homework3/chitchat"
+)
+
+// Version is the text a message had from some Lamport time on.
+type Version struct {
+	Text string
+	//the author for the first version, and whoever edited it for the others
+	Editor  string
+	Lamport int32
+	At      time.Time
+}
+
+// EditHistory is every version of a message, oldest first. A deleted message has none left.
+type EditHistory struct {
+	MessageID string
+	Author    string
+	Versions  []Version
+	Deleted   bool
+	DeletedBy string
+}
+
+// Edit changes the text of a message the user wrote, or of anyone's if they are a moderator.
+// Everyone in the room gets an EditEvent.
+func (c *Client) Edit(ctx context.Context, messageID string, text string) error {
+	c.mutex.Lock()
+	if c.leaving {
+		c.mutex.Unlock()
+		return ErrLeft
+	}
+	c.lamport++
+	edit := &chitchat.MessageEdit{
+		Name:      c.user.Name,
+		Room:      c.user.Room,
+		MessageId: messageID,
+		Text:      text,
+		Lamport:   c.lamport,
+	}
+	c.mutex.Unlock()
+	edit.Sign(c.key)
+
+	_, err := c.service.Edit(ctx, edit)
+	return err
+}
+
+// Delete deletes a message the user wrote, or anyone's if they are a moderator.
+// Everyone in the room gets a DeleteEvent.
+func (c *Client) Delete(ctx context.Context, messageID string) error {
+	c.mutex.Lock()
+	if c.leaving {
+		c.mutex.Unlock()
+		return ErrLeft
+	}
+	c.lamport++
+	deletion := &chitchat.MessageDeletion{
+		Name:      c.user.Name,
+		Room:      c.user.Room,
+		MessageId: messageID,
+		Lamport:   c.lamport,
+	}
+	c.mutex.Unlock()
+	deletion.Sign(c.key)
+
+	_, err := c.service.Delete(ctx, deletion)
+	return err
+}
+
+// EditHistory asks the server for every version of a message. The server only remembers recent messages.
+func (c *Client) EditHistory(ctx context.Context, messageID string) (*EditHistory, error) {
+	history, err := c.service.GetEditHistory(ctx, &chitchat.EditHistoryRequest{MessageId: messageID})
+	if err != nil {
+		return nil, err
+	}
+	converted := &EditHistory{
+		MessageID: history.MessageId,
+		Author:    history.Author,
+		Deleted:   history.Deleted,
+		DeletedBy: history.DeletedBy,
+	}
+	for _, version := range history.Versions {
+		converted.Versions = append(converted.Versions, Version{
+			Text:    version.Text,
+			Editor:  version.Editor,
+			Lamport: version.Lamport,
+			At:      version.At.AsTime(),
+		})
+	}
+	return converted, nil
+}
@@ -0,0 +1,286 @@
+package chatclient
+
+import (
+	"sort"
+	"time"
+
+	chitchat "homework3/chitchat"
+
+	"go.opentelemetry.io/otel/trace"
+)
+
+// EventKind says what an Event is about.
+type EventKind int
+
+const (
+	// MessageEvent is a message a user wrote.
+	MessageEvent EventKind = iota
+	// JoinEvent is a participant joining the room.
+	JoinEvent
+	// LeaveEvent is a participant leaving the room, or being disconnected from it.
+	LeaveEvent
+	// NoticeEvent is anything else the server announces, like that it is shutting down.
+	NoticeEvent
+	// ReconnectingEvent is sent before each attempt to get back into the chat.
+	ReconnectingEvent
+	// ReconnectedEvent is sent once the client is back in the chat.
+	ReconnectedEvent
+	// ErrorEvent is the last event before the channel closes when the client gives up.
+	ErrorEvent
+	// PresenceEvent is a participant going away, idle or offline, or coming back.
+	PresenceEvent
+	// ParticipantsEvent lists everyone in the room. It comes right after joining, and again after reconnecting.
+	ParticipantsEvent
+	// TypingEvent is another participant starting or stopping to type. A message from them,
+	// or them leaving, is preceded by a TypingEvent saying they stopped.
+	TypingEvent
+	// ReceiptEvent is someone receiving or reading one of the user's messages.
+	ReceiptEvent
+	// EditEvent is a message being changed. Message.Text is its new text.
+	EditEvent
+	// DeleteEvent is a message being deleted.
+	DeleteEvent
+	// ReactionEvent is someone reacting to a message, or taking their reaction back.
+	ReactionEvent
+	// MentionEvent is someone mentioning the user with @name, in this room or any other.
+	// Message.Room is the room they did it in.
+	MentionEvent
+	// DirectEvent is a private message to the user, or one the user sent from anywhere.
+	// Message.To is who it is for, and Message.Room is empty.
+	DirectEvent
+)
+
+func (kind EventKind) String() string {
+	switch kind {
+	case MessageEvent:
+		return "message"
+	case JoinEvent:
+		return "join"
+	case LeaveEvent:
+		return "leave"
+	case NoticeEvent:
+		return "notice"
+	case ReconnectingEvent:
+		return "reconnecting"
+	case ReconnectedEvent:
+		return "reconnected"
+	case ErrorEvent:
+		return "error"
+	case PresenceEvent:
+		return "presence"
+	case ParticipantsEvent:
+		return "participants"
+	case TypingEvent:
+		return "typing"
+	case ReceiptEvent:
+		return "receipt"
+	case EditEvent:
+		return "edit"
+	case DeleteEvent:
+		return "delete"
+	case ReactionEvent:
+		return "reaction"
+	case MentionEvent:
+		return "mention"
+	case DirectEvent:
+		return "direct"
+	}
+	return "unknown"
+}
+
+// Message is a message received from the server.
+type Message struct {
+	Author string
+	Text   string
+	Room   string
+	//Lamport time the server gave the message
+	Lamport int32
+	//when the server accepted a chat message, zero for other messages
+	SentAt time.Time
+	//when the server deletes a message that does not last, zero for messages kept as long as the room keeps them
+	ExpiresAt time.Time
+	//id the server gave a chat message, empty for messages from the server itself
+	ID string
+	//who a direct message is for, empty for messages to the room
+	To string
+	//the file the message shares, nil for other messages. Text is the file's name.
+	Attachment *Attachment
+	//the id of the message this one replies to, and what that message said
+	ReplyTo string
+	Quote   *Quote
+	//the registered users the message mentions with @name, and whether the user is one of them
+	Mentions  []string
+	Mentioned bool
+	//true when the signature is valid and the author signs with the same key as before
+	Verified bool
+	//true for messages from the room's history, sent right after joining
+	Replayed bool
+	//the message as it came from the server
+	Raw *chitchat.ServerMessage
+}
+
+// Quote is the message a reply replies to, as it was when the reply was sent.
+type Quote struct {
+	Author string
+	//empty once the message was deleted
+	Text string
+}
+
+// Participant is someone in the room, as the server sees them.
+type Participant struct {
+	Name     string
+	Presence chitchat.Presence
+	//what they said when they went away
+	Status string
+	//when they last joined, wrote or changed their presence
+	LastActive time.Time
+}
+
+func participantsFrom(participants []*chitchat.Participant) []Participant {
+	converted := make([]Participant, 0, len(participants))
+	for _, participant := range participants {
+		converted = append(converted, Participant{
+			Name:       participant.Name,
+			Presence:   participant.Presence,
+			Status:     participant.Status,
+			LastActive: participant.LastActive.AsTime(),
+		})
+	}
+	return converted
+}
+
+// Event is something that happened in the chat or to the connection.
+type Event struct {
+	Kind EventKind
+	//set for every kind of event that comes from the server but TypingEvent and ReceiptEvent.
+	//For MentionEvent it is the server's notice, in the room the user was mentioned in.
+	Message *Message
+	//who joined or left, for JoinEvent and LeaveEvent, whose presence changed, for PresenceEvent,
+	//who edited, deleted or reacted to the message, for EditEvent, DeleteEvent and ReactionEvent,
+	//or who mentioned the user, for MentionEvent
+	Participant string
+	//the id of the message that was edited, deleted or reacted to, for EditEvent, DeleteEvent and ReactionEvent,
+	//or that mentions the user, for MentionEvent
+	Target string
+	//the emoji Participant reacted with, or took back if Removed, and every reaction
+	//the message has now, for ReactionEvent
+	Emoji     string
+	Removed   bool
+	Reactions []Reaction
+	//the participant's new presence and what they said about it, for PresenceEvent
+	Presence chitchat.Presence
+	Status   string
+	//everyone in the room, for ParticipantsEvent
+	Participants []Participant
+	//whether Participant started or stopped, for TypingEvent
+	Typing bool
+	//who has received and read the message, for ReceiptEvent. Participant is who just did.
+	Receipts *Receipts
+	//the client's Lamport time once it had received the event
+	Lamport int32
+	//why the connection was lost for ReconnectingEvent, and why the client gave up for ErrorEvent
+	Err error
+	//which attempt is about to be made and how long until then, for ReconnectingEvent
+	Attempt int
+	Delay   time.Duration
+
+	//the receive span, ended once the event has been delivered
+	span trace.Span
+	//when the event was received, for holding it back
+	received time.Time
+}
+
+// order delivers received events on the Events channel. Messages are held back for the
+// reorder window and released in Lamport order; other events first release everything held.
+// Typing indicators are passed on straight away, once per change.
+// It closes the Events channel once the receiver is done.
+func (c *Client) order() {
+	defer close(c.done)
+	defer close(c.events)
+
+	var held []Event
+	typing := typingTracker{expires: make(map[string]time.Time)}
+	deliver := func(event Event) {
+		if stopped, ok := typing.stoppedBy(event); ok {
+			c.events <- stopped
+		}
+		if event.Kind == MessageEvent && event.Message.ID != "" && event.Message.Author != c.user.Name && c.options.deliveryReceipts {
+			c.queueReceipts(chitchat.ReceiptKind_DELIVERED, []string{event.Message.ID})
+		}
+		if event.Kind == DirectEvent && event.Message.To == c.user.Name {
+			c.queueAck(event.Message.ID)
+		}
+		c.events <- event
+		if event.span != nil {
+			event.span.End()
+		}
+	}
+	//release delivers every held message up to and including the given Lamport time.
+	release := func(upTo int32) {
+		released := 0
+		for released < len(held) && held[released].Message.Lamport <= upTo {
+			deliver(held[released])
+			released++
+		}
+		held = held[released:]
+	}
+
+	for {
+		var timeout <-chan time.Time
+		if len(held) > 0 {
+			oldest := held[0].received
+			for _, event := range held {
+				if event.received.Before(oldest) {
+					oldest = event.received
+				}
+			}
+			timeout = time.After(time.Until(oldest.Add(c.options.reorderWindow)))
+		}
+
+		select {
+		case event, ok := <-c.received:
+			if !ok {
+				release(maxLamport(held))
+				return
+			}
+			if event.Kind == TypingEvent {
+				if news, ok := typing.update(event); ok {
+					deliver(news)
+				}
+				continue
+			}
+			if event.Kind == ReceiptEvent || event.Kind == MentionEvent {
+				deliver(event)
+				continue
+			}
+			if event.Message == nil || c.options.reorderWindow <= 0 {
+				release(maxLamport(held))
+				deliver(event)
+				continue
+			}
+			held = append(held, event)
+			sort.SliceStable(held, func(i, j int) bool { return held[i].Message.Lamport < held[j].Message.Lamport })
+		case now := <-timeout:
+			//release what has waited long enough, and anything that should come before it.
+			var upTo int32
+			for _, event := range held {
+				if !now.Before(event.received.Add(c.options.reorderWindow)) {
+					upTo = max(upTo, event.Message.Lamport)
+				}
+			}
+			release(upTo)
+		case now := <-typing.next():
+			for _, stopped := range typing.expired(now, c.Lamport()) {
+				c.events <- stopped
+			}
+		}
+	}
+}
+
+func maxLamport(events []Event) int32 {
+	var highest int32
+	for _, event := range events {
+		highest = max(highest, event.Message.Lamport)
+	}
+	return highest
+}
@@ -0,0 +1,82 @@
+package chatclient
+
+import (
+	"slices"
+	"testing"
+	"time"
+
+	chitchat "homework3/chitchat"
+)
+
+// newOrderingClient returns a client with nothing but what order needs, and starts order.
+func newOrderingClient(window time.Duration) *Client {
+	c := &Client{
+		options:  options{reorderWindow: window, eventBuffer: 16},
+		user:     &chitchat.User{Name: "alice"},
+		received: make(chan Event),
+		events:   make(chan Event, 16),
+		done:     make(chan struct{}),
+	}
+	go c.order()
+	return c
+}
+
+func message(text string, lamport int32) Event {
+	return Event{Kind: MessageEvent, Message: &Message{Author: "bob", Text: text, Lamport: lamport}, received: time.Now()}
+}
+
+// delivered returns the texts of the messages delivered once the receiver is done, and the kinds of the other events.
+func delivered(c *Client) []string {
+	close(c.received)
+	var texts []string
+	for event := range c.events {
+		if event.Message != nil {
+			texts = append(texts, event.Message.Text)
+		} else {
+			texts = append(texts, event.Kind.String())
+		}
+	}
+	return texts
+}
+
+func TestOrderReordersWithinTheWindow(t *testing.T) {
+	c := newOrderingClient(time.Hour)
+	c.received <- message("third", 7)
+	c.received <- message("first", 3)
+	c.received <- message("second", 5)
+	//anything that is not a message releases what was held first.
+	c.received <- Event{Kind: ReconnectingEvent}
+	c.received <- message("fourth", 9)
+	want := []string{"first", "second", "third", "reconnecting", "fourth"}
+	if got := delivered(c); !slices.Equal(got, want) {
+		t.Errorf("delivered %q, want %q", got, want)
+	}
+}
+
+func TestOrderReleasesAfterTheWindow(t *testing.T) {
+	c := newOrderingClient(20 * time.Millisecond)
+	c.received <- message("second", 5)
+	c.received <- message("first", 3)
+	select {
+	case event := <-c.events:
+		if event.Message.Text != "first" {
+			t.Errorf("delivered %q first, want the earlier message", event.Message.Text)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("messages were held past the reorder window")
+	}
+	//too late to come before what was delivered; it is passed on as it is.
+	c.received <- message("late", 1)
+	if got, want := delivered(c), []string{"second", "late"}; !slices.Equal(got, want) {
+		t.Errorf("delivered %q, want %q", got, want)
+	}
+}
+
+func TestOrderWithoutWindow(t *testing.T) {
+	c := newOrderingClient(0)
+	c.received <- message("third", 7)
+	c.received <- message("first", 3)
+	if got, want := delivered(c), []string{"third", "first"}; !slices.Equal(got, want) {
+		t.Errorf("delivered %q, want them as they came", got)
+	}
+}
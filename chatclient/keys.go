@@ -0,0 +1,38 @@
+package chatclient
+
+import (
+	"crypto/ed25519"
+	"fmt"
+	"net/url"
+	"os"
+	"path/filepath"
+)
+
+// LoadOrCreateKey reads the signing key for a username from the key directory,
+// generating and saving a new one the first time the name is used.
+func LoadOrCreateKey(keyDir string, username string) (ed25519.PrivateKey, error) {
+	keyPath := filepath.Join(keyDir, url.PathEscape(username)+".key")
+
+	seed, err := os.ReadFile(keyPath)
+	if err == nil {
+		if len(seed) != ed25519.SeedSize {
+			return nil, fmt.Errorf("key file %s is corrupt", keyPath)
+		}
+		return ed25519.NewKeyFromSeed(seed), nil
+	}
+	if !os.IsNotExist(err) {
+		return nil, err
+	}
+
+	_, key, err := ed25519.GenerateKey(nil)
+	if err != nil {
+		return nil, err
+	}
+	if err := os.MkdirAll(filepath.Dir(keyPath), 0700); err != nil {
+		return nil, err
+	}
+	if err := os.WriteFile(keyPath, key.Seed(), 0600); err != nil {
+		return nil, err
+	}
+	return key, nil
+}
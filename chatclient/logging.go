@@ -0,0 +1,51 @@
+package chatclient
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"log/slog"
+	"time"
+
+	chitchat "homework3/chitchat"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/metadata"
+)
+
+func newID() string {
+	id := make([]byte, 8)
+	rand.Read(id)
+	return hex.EncodeToString(id)
+}
+
+// withCorrelationIDs adds the session id and a fresh request id to an outgoing call,
+// returning a logger tagged with the request id.
+func (c *Client) withCorrelationIDs(ctx context.Context, method string) (context.Context, *slog.Logger) {
+	requestID := newID()
+	ctx = metadata.AppendToOutgoingContext(ctx, chitchat.SessionIDHeader, c.sessionID, chitchat.RequestIDHeader, requestID)
+	return ctx, c.logger.With("method", method, "request_id", requestID)
+}
+
+func (c *Client) loggingUnaryInterceptor(ctx context.Context, method string, request, reply any, conn *grpc.ClientConn, invoker grpc.UnaryInvoker, opts ...grpc.CallOption) error {
+	ctx, logger := c.withCorrelationIDs(ctx, method)
+	start := time.Now()
+	err := invoker(ctx, method, request, reply, conn, opts...)
+	if err != nil {
+		logger.Warn("call failed", "duration", time.Since(start), "error", err)
+	} else {
+		logger.Debug("call finished", "duration", time.Since(start))
+	}
+	return err
+}
+
+func (c *Client) loggingStreamInterceptor(ctx context.Context, desc *grpc.StreamDesc, conn *grpc.ClientConn, method string, streamer grpc.Streamer, opts ...grpc.CallOption) (grpc.ClientStream, error) {
+	ctx, logger := c.withCorrelationIDs(ctx, method)
+	stream, err := streamer(ctx, desc, conn, method, opts...)
+	if err != nil {
+		logger.Warn("could not open stream", "error", err)
+	} else {
+		logger.Debug("stream opened")
+	}
+	return stream, err
+}
@@ -0,0 +1,87 @@
+package chatclient_test
+
+import (
+	"context"
+	"slices"
+	"testing"
+	"time"
+
+	"homework3/chatclient"
+)
+
+// collect returns the events the client gets up to and including the first one last accepts.
+func collect(t *testing.T, client *chatclient.Client, last func(chatclient.Event) bool) []chatclient.Event {
+	t.Helper()
+	var events []chatclient.Event
+	timeout := time.After(5 * time.Second)
+	for {
+		select {
+		case event := <-client.Events():
+			events = append(events, event)
+			if last(event) {
+				return events
+			}
+		case <-timeout:
+			t.Fatalf("%s did not get the event waited for", client.Name())
+		}
+	}
+}
+
+func kinds(events []chatclient.Event, kind chatclient.EventKind) []chatclient.Event {
+	var matching []chatclient.Event
+	for _, event := range events {
+		if event.Kind == kind {
+			matching = append(matching, event)
+		}
+	}
+	return matching
+}
+
+func TestMentions(t *testing.T) {
+	ctx := context.Background()
+	_, address := startServer(t, t.TempDir(), "127.0.0.1:0")
+	alice := connect(t, address, "alice")
+	bob := connect(t, address, "bob")
+	carol := connect(t, address, "carol", chatclient.WithRoom("ops"))
+	waitFor(t, carol, chatclient.ParticipantsEvent)
+	waitFor(t, alice, chatclient.JoinEvent)
+
+	id, err := alice.Send(ctx, "@carol can you look? cc @bob @alice @nobody")
+	if err != nil {
+		t.Fatal(err)
+	}
+	//mentions are not held back to be put in order, so bob's may come before the message.
+	seen := make(map[chatclient.EventKind]bool)
+	bobGot := collect(t, bob, func(event chatclient.Event) bool {
+		seen[event.Kind] = true
+		return seen[chatclient.MessageEvent] && seen[chatclient.MentionEvent]
+	})
+	messages, mentions := kinds(bobGot, chatclient.MessageEvent), kinds(bobGot, chatclient.MentionEvent)
+	if len(messages) != 1 || len(mentions) != 1 {
+		t.Fatalf("bob got %d messages and %d mentions, want one of each", len(messages), len(mentions))
+	}
+	message := messages[0].Message
+	if want := []string{"carol", "bob", "alice"}; !slices.Equal(message.Mentions, want) || !message.Mentioned {
+		t.Errorf("bob got a message mentioning %q, mentioned %v; want %q and true", message.Mentions, message.Mentioned, want)
+	}
+
+	//carol is told in the room she is in, though she was mentioned in another.
+	mentioned := waitFor(t, carol, chatclient.MentionEvent)
+	if mentioned.Participant != "alice" || mentioned.Target != id || mentioned.Message.Room != "general" || !mentioned.Message.Verified {
+		t.Errorf("carol got %+v, with %+v", mentioned, mentioned.Message)
+	}
+
+	//nobody is told they mentioned themselves.
+	if _, err := bob.Send(ctx, "on it"); err != nil {
+		t.Fatal(err)
+	}
+	aliceGot := collect(t, alice, func(event chatclient.Event) bool {
+		return event.Kind == chatclient.MessageEvent && event.Message.Author == "bob"
+	})
+	if mentions := kinds(aliceGot, chatclient.MentionEvent); len(mentions) > 0 {
+		t.Errorf("alice was told she mentioned herself: %+v", mentions[0].Message)
+	}
+	if messages := kinds(aliceGot, chatclient.MessageEvent); !messages[0].Message.Mentioned {
+		t.Error("alice's own message does not say it mentions her")
+	}
+}
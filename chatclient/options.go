@@ -0,0 +1,102 @@
+package chatclient
+
+import (
+	"log/slog"
+	"time"
+
+	chitchat "homework3/chitchat"
+
+	"google.golang.org/grpc"
+)
+
+// ReconnectPolicy says how a Client gets back into the chat after losing its connection.
+// The delay starts at InitialDelay, or at what the server asked for, and doubles after
+// every failed attempt up to MaxDelay.
+type ReconnectPolicy struct {
+	//attempts before giving up, 0 to never reconnect
+	MaxAttempts  int
+	InitialDelay time.Duration
+	MaxDelay     time.Duration
+}
+
+// DefaultReconnectPolicy is the policy a Client uses unless WithReconnectPolicy is given.
+var DefaultReconnectPolicy = ReconnectPolicy{
+	MaxAttempts:  10,
+	InitialDelay: 500 * time.Millisecond,
+	MaxDelay:     30 * time.Second,
+}
+
+// Option configures a Client made with Connect.
+type Option func(*options)
+
+type options struct {
+	id            int32
+	room          string
+	dialOptions   []grpc.DialOption
+	logger        *slog.Logger
+	sessionID     string
+	reconnect     ReconnectPolicy
+	reorderWindow time.Duration
+	eventBuffer   int
+	//acknowledge messages as they are delivered
+	deliveryReceipts bool
+}
+
+// WithID sets the id the user joins with. A random one is picked if none is given.
+func WithID(id int32) Option {
+	return func(o *options) { o.id = id }
+}
+
+// WithRoom sets the room to join. It defaults to chitchat.DefaultRoom.
+func WithRoom(room string) Option {
+	return func(o *options) { o.room = room }
+}
+
+// WithDialOptions passes extra options to grpc.Dial, like transport credentials or keepalive.
+// Without credentials the connection is not encrypted.
+func WithDialOptions(dialOptions ...grpc.DialOption) Option {
+	return func(o *options) { o.dialOptions = append(o.dialOptions, dialOptions...) }
+}
+
+// WithLogger sets the logger diagnostics go to. It defaults to slog.Default().
+func WithLogger(logger *slog.Logger) Option {
+	return func(o *options) { o.logger = logger }
+}
+
+// WithSessionID sets the session id sent with every call, so the server's log lines
+// can be matched with the client's. A random one is picked if none is given.
+func WithSessionID(sessionID string) Option {
+	return func(o *options) { o.sessionID = sessionID }
+}
+
+// WithReconnectPolicy replaces DefaultReconnectPolicy.
+func WithReconnectPolicy(policy ReconnectPolicy) Option {
+	return func(o *options) { o.reconnect = policy }
+}
+
+// WithReorderWindow sets how long messages are held back so ones that overtook each other
+// on the way can be delivered in Lamport order. 0 delivers them as they arrive.
+func WithReorderWindow(window time.Duration) Option {
+	return func(o *options) { o.reorderWindow = window }
+}
+
+// WithEventBuffer sets how many events can wait in the Events channel.
+func WithEventBuffer(size int) Option {
+	return func(o *options) { o.eventBuffer = size }
+}
+
+// WithDeliveryReceipts sets whether the client tells authors when their messages reach it.
+// It does by default. Read receipts are only sent for what is passed to MarkRead.
+func WithDeliveryReceipts(enabled bool) Option {
+	return func(o *options) { o.deliveryReceipts = enabled }
+}
+
+func defaultOptions() options {
+	return options{
+		room:             chitchat.DefaultRoom,
+		reconnect:        DefaultReconnectPolicy,
+		reorderWindow:    50 * time.Millisecond,
+		eventBuffer:      64,
+		deliveryReceipts: true,
+	}
+}
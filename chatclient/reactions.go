@@ -0,0 +1,103 @@
+package chatclient
+
+import (
+	"context"
+	"strings"
+
+	chitchat "homework3/chitchat"
+)
+
+// Reaction is everyone who reacted to a message with one emoji, in the order they did.
+type Reaction struct {
+	Emoji string
+	Names []string
+}
+
+func reactionsFrom(reactions []*chitchat.Reaction) []Reaction {
+	converted := make([]Reaction, 0, len(reactions))
+	for _, reaction := range reactions {
+		converted = append(converted, Reaction{Emoji: reaction.Emoji, Names: reaction.Names})
+	}
+	return converted
+}
+
+// React reacts to a message with an emoji, or a shortcode like :thumbsup: for one.
+// Everyone in the room gets a ReactionEvent.
+func (c *Client) React(ctx context.Context, messageID string, emoji string) error {
+	return c.react(ctx, messageID, emoji, false)
+}
+
+// Unreact takes back the user's reaction to a message.
+func (c *Client) Unreact(ctx context.Context, messageID string, emoji string) error {
+	return c.react(ctx, messageID, emoji, true)
+}
+
+func (c *Client) react(ctx context.Context, messageID string, emoji string, remove bool) error {
+	c.mutex.Lock()
+	if c.leaving {
+		c.mutex.Unlock()
+		return ErrLeft
+	}
+	c.lamport++
+	update := &chitchat.ReactionUpdate{
+		Name:      c.user.Name,
+		Room:      c.user.Room,
+		MessageId: messageID,
+		Emoji:     ExpandShortcode(emoji),
+		Remove:    remove,
+		Lamport:   c.lamport,
+	}
+	c.mutex.Unlock()
+	update.Sign(c.key)
+
+	_, err := c.service.React(ctx, update)
+	return err
+}
+
+// shortcodes are the emoji that can be written as :name:, as in most chat programs.
+var shortcodes = map[string]string{
+	"+1":               "👍",
+	"thumbsup":         "👍",
+	"-1":               "👎",
+	"thumbsdown":       "👎",
+	"heart":            "❤️",
+	"smile":            "😄",
+	"laughing":         "😆",
+	"joy":              "😂",
+	"wink":             "😉",
+	"thinking":         "🤔",
+	"cry":              "😢",
+	"scream":           "😱",
+	"eyes":             "👀",
+	"tada":             "🎉",
+	"fire":             "🔥",
+	"rocket":           "🚀",
+	"clap":             "👏",
+	"pray":             "🙏",
+	"wave":             "👋",
+	"ok_hand":          "👌",
+	"muscle":           "💪",
+	"100":              "💯",
+	"star":             "⭐",
+	"sparkles":         "✨",
+	"white_check_mark": "✅",
+	"x":                "❌",
+	"warning":          "⚠️",
+	"question":         "❓",
+	"coffee":           "☕",
+	"pizza":            "🍕",
+	"beer":             "🍺",
+	"bug":              "🐛",
+}
+
+// ExpandShortcode returns the emoji a shortcode like :tada: stands for. Anything else is returned as it is.
+func ExpandShortcode(text string) string {
+	if name, ok := strings.CutPrefix(text, ":"); ok {
+		if name, ok := strings.CutSuffix(name, ":"); ok {
+			if emoji, ok := shortcodes[name]; ok {
+				return emoji
+			}
+		}
+	}
+	return text
+}
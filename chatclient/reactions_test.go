@@ -0,0 +1,102 @@
+package chatclient_test
+
+import (
+	"context"
+	"slices"
+	"testing"
+
+	"homework3/chatclient"
+
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+func TestExpandShortcode(t *testing.T) {
+	tests := []struct {
+		text string
+		want string
+	}{
+		{":tada:", "🎉"},
+		{":+1:", "👍"},
+		{"🎉", "🎉"},
+		{":nonsense:", ":nonsense:"},
+		{"tada", "tada"},
+		{":tada", ":tada"},
+	}
+	for _, test := range tests {
+		if got := chatclient.ExpandShortcode(test.text); got != test.want {
+			t.Errorf("ExpandShortcode(%q) = %q, want %q", test.text, got, test.want)
+		}
+	}
+}
+
+func TestReactions(t *testing.T) {
+	ctx := context.Background()
+	_, address := startServer(t, t.TempDir(), "127.0.0.1:0")
+	alice := connect(t, address, "alice")
+	bob := connect(t, address, "bob")
+	waitFor(t, alice, chatclient.JoinEvent)
+	id, err := alice.Send(ctx, "shipped it")
+	if err != nil {
+		t.Fatal(err)
+	}
+	waitFor(t, bob, chatclient.MessageEvent)
+
+	if err := bob.React(ctx, id, ":tada:"); err != nil {
+		t.Fatal(err)
+	}
+	reacted := waitFor(t, alice, chatclient.ReactionEvent)
+	want := []chatclient.Reaction{{Emoji: "🎉", Names: []string{"bob"}}}
+	if reacted.Target != id || reacted.Participant != "bob" || reacted.Emoji != "🎉" || reacted.Removed || !slices.EqualFunc(reacted.Reactions, want, sameReaction) {
+		t.Errorf("alice got %+v", reacted)
+	}
+	if err := alice.React(ctx, id, "🎉"); err != nil {
+		t.Fatal(err)
+	}
+	want = []chatclient.Reaction{{Emoji: "🎉", Names: []string{"bob", "alice"}}}
+	if reacted := reactionBy(t, bob, "alice"); !slices.EqualFunc(reacted.Reactions, want, sameReaction) {
+		t.Errorf("after alice reacted too, the message has %+v", reacted.Reactions)
+	}
+	if err := bob.Unreact(ctx, id, ":tada:"); err != nil {
+		t.Fatal(err)
+	}
+	want = []chatclient.Reaction{{Emoji: "🎉", Names: []string{"alice"}}}
+	if taken := reactionBy(t, alice, "bob"); !taken.Removed || taken.Participant != "bob" || !slices.EqualFunc(taken.Reactions, want, sameReaction) {
+		t.Errorf("after bob took back the reaction, alice got %+v", taken)
+	}
+
+	if err := alice.Delete(ctx, id); err != nil {
+		t.Fatal(err)
+	}
+	tests := []struct {
+		name      string
+		messageID string
+		emoji     string
+		want      codes.Code
+	}{
+		{"no emoji", id, "", codes.InvalidArgument},
+		{"too long", id, "supercalifragilistic", codes.InvalidArgument},
+		{"more than one word", id, "so good", codes.InvalidArgument},
+		{"no such message", "0000", "👍", codes.NotFound},
+		{"deleted", id, "👍", codes.FailedPrecondition},
+	}
+	for _, test := range tests {
+		if err := bob.React(ctx, test.messageID, test.emoji); status.Code(err) != test.want {
+			t.Errorf("%s: reacting got %v, want %v", test.name, err, test.want)
+		}
+	}
+}
+
+// reactionBy returns the next reaction event for a reaction of name's, skipping the others.
+func reactionBy(t *testing.T, client *chatclient.Client, name string) chatclient.Event {
+	t.Helper()
+	for {
+		if event := waitFor(t, client, chatclient.ReactionEvent); event.Participant == name {
+			return event
+		}
+	}
+}
+
+func sameReaction(a, b chatclient.Reaction) bool {
+	return a.Emoji == b.Emoji && slices.Equal(a.Names, b.Names)
+}
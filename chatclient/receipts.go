@@ -0,0 +1,129 @@
+package chatclient
+
+import (
+	"context"
+	"time"
+
+	chitchat "homework3/chitchat"
+)
+
+const (
+	// how long receipts are collected before they are sent together
+	receiptBatchDelay = 250 * time.Millisecond
+	// most messages the server takes in one receipt
+	maxReceiptBatch = 256
+)
+
+// Receipt is one participant having received or read a message.
+type Receipt struct {
+	Name string
+	At   time.Time
+}
+
+// Receipts is who has received and read one of the user's messages, oldest first.
+// Reading a message means it was received too.
+type Receipts struct {
+	MessageID string
+	//how many others were in the room when the message was sent
+	Recipients int
+	Delivered  []Receipt
+	Read       []Receipt
+}
+
+func receiptsFrom(receipts *chitchat.Receipts) *Receipts {
+	converted := &Receipts{MessageID: receipts.MessageId, Recipients: int(receipts.Recipients)}
+	for _, entry := range receipts.Delivered {
+		converted.Delivered = append(converted.Delivered, Receipt{Name: entry.Name, At: entry.At.AsTime()})
+	}
+	for _, entry := range receipts.Read {
+		converted.Read = append(converted.Read, Receipt{Name: entry.Name, At: entry.At.AsTime()})
+	}
+	return converted
+}
+
+// MarkRead tells the authors of the messages with these ids that the user has seen them.
+// Receipts are collected for a moment and sent together.
+func (c *Client) MarkRead(messageIDs ...string) {
+	c.queueReceipts(chitchat.ReceiptKind_READ, messageIDs)
+}
+
+// Receipts asks the server who has received and read one of the user's own messages.
+// The server only remembers receipts for recent messages.
+func (c *Client) Receipts(ctx context.Context, messageID string) (*Receipts, error) {
+	c.mutex.Lock()
+	c.lamport++
+	request := &chitchat.ReceiptsRequest{Name: c.user.Name, MessageId: messageID, Lamport: c.lamport}
+	c.mutex.Unlock()
+	request.Sign(c.key)
+
+	receipts, err := c.service.GetReceipts(ctx, request)
+	if err != nil {
+		return nil, err
+	}
+	return receiptsFrom(receipts), nil
+}
+
+// queueReceipts adds message ids to the next batch of receipts of a kind.
+func (c *Client) queueReceipts(kind chitchat.ReceiptKind, messageIDs []string) {
+	if len(messageIDs) == 0 {
+		return
+	}
+	c.mutex.Lock()
+	if c.leaving {
+		c.mutex.Unlock()
+		return
+	}
+	c.pendingReceipts[kind] = append(c.pendingReceipts[kind], messageIDs...)
+	c.mutex.Unlock()
+	select {
+	case c.receiptsQueued <- struct{}{}:
+	default:
+	}
+}
+
+// sendReceipts sends queued receipts and acknowledgements in batches until the user leaves.
+func (c *Client) sendReceipts() {
+	for {
+		select {
+		case <-c.receiptsQueued:
+		case <-c.stop:
+			return
+		}
+		select {
+		case <-time.After(receiptBatchDelay):
+		case <-c.stop:
+			return
+		}
+		ctx, cancel := context.WithTimeout(context.Background(), c.options.reconnect.MaxDelay)
+		c.flushReceipts(ctx)
+		c.flushAcks(ctx)
+		cancel()
+	}
+}
+
+// flushReceipts sends every receipt queued so far. Receipts the server does not take are dropped;
+// they are a courtesy to the authors, not something to hold up the chat for.
+func (c *Client) flushReceipts(ctx context.Context) {
+	c.mutex.Lock()
+	pending := c.pendingReceipts
+	c.pendingReceipts = make(map[chitchat.ReceiptKind][]string)
+	c.mutex.Unlock()
+
+	for kind, messageIDs := range pending {
+		for len(messageIDs) > 0 {
+			batch := messageIDs[:min(len(messageIDs), maxReceiptBatch)]
+			messageIDs = messageIDs[len(batch):]
+			receipt := &chitchat.Receipt{
+				Name:       c.user.Name,
+				Room:       c.user.Room,
+				Kind:       kind,
+				MessageIds: batch,
+				Lamport:    c.nextLamport(),
+			}
+			receipt.Sign(c.key)
+			if _, err := c.service.Acknowledge(ctx, receipt); err != nil {
+				c.logger.Debug("could not send receipts", "kind", kind.String(), "messages", len(batch), "error", err)
+			}
+		}
+	}
+}
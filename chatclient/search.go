@@ -0,0 +1,77 @@
+package chatclient
+
+import (
+	"context"
+	"time"
+
+	chitchat "homework3/chitchat"
+
+	"google.golang.org/protobuf/types/known/timestamppb"
+)
+
+// SearchQuery is what to search the chat history for. Every field given must match,
+// and the zero value finds everything.
+type SearchQuery struct {
+	//words the messages must contain, and phrases in double quotes they must contain word for word
+	Text   string
+	Author string
+	//the room to search, empty for every room
+	Room string
+	//Lamport and wall-clock times the messages must be from, inclusive. Zero values are open ends.
+	FromLamport, ToLamport int32
+	From, To               time.Time
+	//how many results a page has, 0 for the server's default
+	Limit int
+	//NextPageToken of the previous page, to get the one after it
+	PageToken string
+}
+
+// SearchPage is a page of search results, the latest messages first.
+type SearchPage struct {
+	Results []SearchResult
+	//empty on the last page
+	NextPageToken string
+	//how many messages match in all
+	Total int
+}
+
+// SearchResult is a message that matches. Text is what it says now, and Verified is about
+// the message as it was first written.
+type SearchResult struct {
+	Message
+	Edited bool
+}
+
+// Search asks the server for the messages whose current text matches a query. Deleted messages are never found.
+func (c *Client) Search(ctx context.Context, query SearchQuery) (*SearchPage, error) {
+	request := &chitchat.SearchRequest{
+		Query:       query.Text,
+		Author:      query.Author,
+		Room:        query.Room,
+		FromLamport: query.FromLamport,
+		ToLamport:   query.ToLamport,
+		PageSize:    int32(query.Limit),
+		PageToken:   query.PageToken,
+	}
+	if !query.From.IsZero() {
+		request.FromTime = timestamppb.New(query.From)
+	}
+	if !query.To.IsZero() {
+		request.ToTime = timestamppb.New(query.To)
+	}
+	response, err := c.service.Search(ctx, request)
+	if err != nil {
+		return nil, err
+	}
+	page := &SearchPage{NextPageToken: response.NextPageToken, Total: int(response.Total)}
+	for _, result := range response.Results {
+		c.mutex.Lock()
+		verified := c.verify(result.Message)
+		c.mutex.Unlock()
+		message := messageFrom(result.Message, verified)
+		//the text is the server's word for it once the message was edited.
+		message.Text = result.Text
+		page.Results = append(page.Results, SearchResult{Message: *message, Edited: result.Edited})
+	}
+	return page, nil
+}
@@ -0,0 +1,93 @@
+package chatclient
+
+import (
+	"context"
+
+	chitchat "homework3/chitchat"
+)
+
+// Thread is a message and every reply in its thread, in the order they were written, with the text they have now.
+type Thread struct {
+	RootID   string
+	Messages []*ThreadMessage
+}
+
+// ThreadMessage is a message in a thread. Verified is about the message as it was first written.
+type ThreadMessage struct {
+	Message
+	Edited    bool
+	Deleted   bool
+	Reactions []Reaction
+}
+
+// ThreadSummary is a message in the room that has replies.
+type ThreadSummary struct {
+	RootID string
+	Author string
+	//the message's text now, empty if it was deleted
+	Text    string
+	Replies int
+	//everyone who replied, in the order they first did
+	Participants []string
+	//Lamport time of the latest reply
+	LastLamport int32
+}
+
+// Thread asks the server for the thread a message is in.
+func (c *Client) Thread(ctx context.Context, messageID string) (*Thread, error) {
+	response, err := c.service.GetThread(ctx, &chitchat.ThreadRequest{MessageId: messageID})
+	if err != nil {
+		return nil, err
+	}
+	thread := &Thread{RootID: response.RootId}
+	byID := make(map[string]*ThreadMessage)
+	for _, entry := range response.Messages {
+		c.mutex.Lock()
+		verified := c.verify(entry)
+		c.mutex.Unlock()
+		switch entry.Kind {
+		case chitchat.ServerMessage_CHAT, chitchat.ServerMessage_ATTACHMENT:
+			message := &ThreadMessage{Message: *messageFrom(entry, verified)}
+			byID[message.ID] = message
+			thread.Messages = append(thread.Messages, message)
+		case chitchat.ServerMessage_EDITED:
+			//the text is the server's word for it, so the message is only verified if the edit is too.
+			if message, ok := byID[entry.Target]; ok {
+				message.Text = entry.Text
+				message.Verified = message.Verified && verified
+				message.Edited = true
+			}
+		case chitchat.ServerMessage_REACTIONS:
+			if message, ok := byID[entry.Target]; ok {
+				message.Reactions = reactionsFrom(entry.Reactions)
+			}
+		case chitchat.ServerMessage_DELETED:
+			//a deleted message is only its tombstone.
+			tombstone := &ThreadMessage{Message: *messageFrom(entry, verified), Deleted: true}
+			tombstone.ID = entry.Target
+			thread.Messages = append(thread.Messages, tombstone)
+		}
+	}
+	return thread, nil
+}
+
+// Threads lists the messages in the room that have replies, the ones replied to last first.
+// limit is the most to list, 0 for all.
+func (c *Client) Threads(ctx context.Context, limit int) ([]ThreadSummary, error) {
+	response, err := c.service.ListThreads(ctx, &chitchat.ListThreadsRequest{Room: c.user.Room, Limit: int32(limit)})
+	if err != nil {
+		return nil, err
+	}
+	var threads []ThreadSummary
+	for _, summary := range response.Threads {
+		threads = append(threads, ThreadSummary{
+			RootID:       summary.RootId,
+			Author:       summary.Author,
+			Text:         summary.Text,
+			Replies:      int(summary.Replies),
+			Participants: summary.Participants,
+			LastLamport:  summary.LastLamport,
+		})
+	}
+	return threads, nil
+}
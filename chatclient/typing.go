@@ -0,0 +1,159 @@
+package chatclient
+
+import (
+	"context"
+	"time"
+
+	chitchat "homework3/chitchat"
+)
+
+const (
+	// TypingRefresh is how often a Client repeats that the user is still typing.
+	TypingRefresh = 3 * time.Second
+	// TypingTimeout is how long someone counts as typing after the last time they said so.
+	// It covers clients that went away without saying they stopped.
+	TypingTimeout = 2 * TypingRefresh
+	// how long after the last keystroke the user counts as having stopped
+	typingPause = 3 * time.Second
+)
+
+// Typing tells the room the user is typing. Call it on every keystroke: it only sends
+// when the user starts, and again every TypingRefresh while they keep going. Once it has
+// not been called for a few seconds the room is told the user stopped. Sending a message
+// stops it without telling the room, since the message says as much.
+func (c *Client) Typing() {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	if c.leaving {
+		return
+	}
+	now := time.Now()
+	if !c.typing || now.Sub(c.typingSent) >= TypingRefresh {
+		c.typingSent = now
+		c.queueTyping(true)
+	}
+	c.typing = true
+	if c.typingPause == nil {
+		c.typingPause = time.AfterFunc(typingPause, c.stopTyping)
+	} else {
+		c.typingPause.Reset(typingPause)
+	}
+}
+
+// stopTyping tells the room the user stopped typing, if they were.
+func (c *Client) stopTyping() {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	if c.typing && !c.leaving {
+		c.queueTyping(false)
+	}
+	c.typing = false
+}
+
+// typingDone forgets the user was typing without telling anyone. It must be called with the mutex held.
+func (c *Client) typingDone() {
+	c.typing = false
+	if c.typingPause != nil {
+		c.typingPause.Stop()
+	}
+}
+
+// queueTyping hands a typing update to sendTyping. Updates are dropped rather than waited for
+// when the server is slow, since TypingTimeout cleans up after any that go missing.
+// It must be called with the mutex held.
+func (c *Client) queueTyping(typing bool) {
+	select {
+	case c.typingUpdates <- typing:
+	default:
+	}
+}
+
+// sendTyping sends typing updates one at a time, so they reach the server in order, until the user leaves.
+func (c *Client) sendTyping() {
+	for {
+		var typing bool
+		select {
+		case typing = <-c.typingUpdates:
+		case <-c.stop:
+			return
+		}
+		c.mutex.Lock()
+		//the server does not advance its clock for typing, but a time of its own keeps every update's signature apart.
+		c.lamport++
+		update := &chitchat.TypingUpdate{
+			Name:    c.user.Name,
+			Room:    c.user.Room,
+			Typing:  typing,
+			Lamport: c.lamport,
+		}
+		c.mutex.Unlock()
+		update.Sign(c.key)
+
+		ctx, cancel := context.WithTimeout(context.Background(), TypingRefresh)
+		if _, err := c.service.SetTyping(ctx, update); err != nil {
+			c.logger.Debug("could not send typing update", "typing", typing, "error", err)
+		}
+		cancel()
+	}
+}
+
+// typingTracker keeps track of who is typing, for order. It turns the updates the server
+// repeats into one TypingEvent when someone starts and one when they stop.
+type typingTracker struct {
+	//when each participant who is typing stops counting as typing
+	expires map[string]time.Time
+}
+
+// update handles a TypingEvent from the server, returning it if it is news.
+func (tracker *typingTracker) update(event Event) (Event, bool) {
+	_, wasTyping := tracker.expires[event.Participant]
+	if event.Typing {
+		tracker.expires[event.Participant] = time.Now().Add(TypingTimeout)
+		return event, !wasTyping
+	}
+	delete(tracker.expires, event.Participant)
+	return event, wasTyping
+}
+
+// stoppedBy returns a TypingEvent for someone who was typing and is done, because they
+// wrote a message or left. The second result is false if nobody stopped.
+func (tracker *typingTracker) stoppedBy(event Event) (Event, bool) {
+	var name string
+	switch {
+	case event.Kind == MessageEvent:
+		name = event.Message.Author
+	case event.Kind == LeaveEvent, event.Kind == PresenceEvent && event.Presence == chitchat.Presence_OFFLINE:
+		name = event.Participant
+	}
+	if _, typing := tracker.expires[name]; !typing {
+		return Event{}, false
+	}
+	delete(tracker.expires, name)
+	return Event{Kind: TypingEvent, Participant: name, Lamport: event.Lamport}, true
+}
+
+// expired returns TypingEvents for everyone who has not said they are still typing for TypingTimeout.
+func (tracker *typingTracker) expired(now time.Time, lamport int32) []Event {
+	var stopped []Event
+	for name, expires := range tracker.expires {
+		if !now.Before(expires) {
+			delete(tracker.expires, name)
+			stopped = append(stopped, Event{Kind: TypingEvent, Participant: name, Lamport: lamport})
+		}
+	}
+	return stopped
+}
+
+// next returns a channel that fires when the next typing indicator expires, or nil if nobody is typing.
+func (tracker *typingTracker) next() <-chan time.Time {
+	var earliest time.Time
+	for _, expires := range tracker.expires {
+		if earliest.IsZero() || expires.Before(earliest) {
+			earliest = expires
+		}
+	}
+	if earliest.IsZero() {
+		return nil
+	}
+	return time.After(time.Until(earliest))
+}
@@ -0,0 +1,256 @@
+package chatserver
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"fmt"
+	"net"
+	"sort"
+
+	chitchat "homework3/chitchat"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	healthpb "google.golang.org/grpc/health/grpc_health_v1"
+	"google.golang.org/grpc/peer"
+	"google.golang.org/grpc/reflection"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/types/known/timestamppb"
+)
+
+// adminService implements the Admin service for a Server.
+type adminService struct {
+	chitchat.UnimplementedAdminServer
+	server *Server
+}
+
+// AdminService returns the server's Admin service, for callers that serve it themselves.
+// It should be kept away from the network chat users connect from.
+func (s *Server) AdminService() chitchat.AdminServer {
+	return &adminService{server: s}
+}
+
+// serveAdmin serves the Admin service on its own listener, alongside health checking and reflection.
+func (s *Server) serveAdmin(listen net.Listener) *grpc.Server {
+	s.logger.Info("admin service listening", "address", listen.Addr().String())
+
+	adminServer := grpc.NewServer(s.ServerOptions()...)
+	chitchat.RegisterAdminServer(adminServer, s.AdminService())
+	healthpb.RegisterHealthServer(adminServer, s.health)
+	reflection.Register(adminServer)
+	go func() {
+		if err := adminServer.Serve(listen); err != nil {
+			s.logger.Error("admin server stopped serving", "error", err)
+		}
+	}()
+	return adminServer
+}
+
+func (a *adminService) ListSessions(ctx context.Context, request *chitchat.ListSessionsRequest) (*chitchat.ListSessionsResponse, error) {
+	s := a.server
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	response := &chitchat.ListSessionsResponse{}
+	for _, userStream := range s.userStreams {
+		response.Sessions = append(response.Sessions, &chitchat.Session{
+			Id:             userStream.UserId,
+			Name:           userStream.Name,
+			Room:           userStream.Room,
+			RemoteAddress:  userStream.RemoteAddress,
+			ConnectedSince: timestamppb.New(userStream.ConnectedSince),
+			Lamport:        userStream.Lamport,
+		})
+	}
+	sort.Slice(response.Sessions, func(i, j int) bool {
+		return response.Sessions[i].ConnectedSince.AsTime().Before(response.Sessions[j].ConnectedSince.AsTime())
+	})
+	return response, nil
+}
+
+func (a *adminService) ListRooms(ctx context.Context, request *chitchat.ListRoomsRequest) (*chitchat.ListRoomsResponse, error) {
+	s := a.server
+	kept, err := s.storage.Rooms()
+	if err != nil {
+		loggerFrom(ctx, s.logger).Error("could not read the rooms kept", "error", err)
+		return nil, status.Error(codes.Internal, "could not read the rooms kept")
+	}
+	s.mutex.Lock()
+	participants := make(map[string]int32)
+	for _, userStream := range s.userStreams {
+		participants[userStream.Room]++
+	}
+	s.mutex.Unlock()
+
+	//every room kept, even the empty ones, and any a user is in that could not be kept.
+	response := &chitchat.ListRoomsResponse{}
+	for _, room := range kept {
+		response.Rooms = append(response.Rooms, &chitchat.Room{Name: room.Name, Participants: participants[room.Name], Created: timestamppb.New(room.Created)})
+		delete(participants, room.Name)
+	}
+	for name, count := range participants {
+		response.Rooms = append(response.Rooms, &chitchat.Room{Name: name, Participants: count})
+	}
+	sort.Slice(response.Rooms, func(i, j int) bool { return response.Rooms[i].Name < response.Rooms[j].Name })
+	return response, nil
+}
+
+func (a *adminService) GetStats(ctx context.Context, request *chitchat.StatsRequest) (*chitchat.Stats, error) {
+	s := a.server
+	users, err := s.storage.Users()
+	if err != nil {
+		loggerFrom(ctx, s.logger).Error("could not read the registered users", "error", err)
+		return nil, status.Error(codes.Internal, "could not read the registered users")
+	}
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	rooms := make(map[string]bool)
+	for _, userStream := range s.userStreams {
+		rooms[userStream.Room] = true
+	}
+	return &chitchat.Stats{
+		StartedAt:         timestamppb.New(s.startedAt),
+		Participants:      int32(len(s.userStreams)),
+		Rooms:             int32(len(rooms)),
+		MessagesBroadcast: s.metrics.messagesBroadcastCount.Load(),
+		SendFailures:      s.metrics.sendFailureCount.Load(),
+		Lamport:           s.lamport,
+		Users:             int32(len(users)),
+	}, nil
+}
+
+// Disconnect ends a user's session. Their Join call returns with codes.Aborted and the reason given.
+func (a *adminService) Disconnect(ctx context.Context, request *chitchat.DisconnectRequest) (*chitchat.Confirmation, error) {
+	s := a.server
+	reason := request.Reason
+	if reason == "" {
+		reason = "no reason given"
+	}
+
+	s.mutex.Lock()
+	userStream, ok := s.userStreams[request.Id]
+	if !ok {
+		s.mutex.Unlock()
+		return nil, status.Errorf(codes.NotFound, "no session with id %d", request.Id)
+	}
+	s.removeUserStream(userStream, status.Errorf(codes.Aborted, "disconnected by an administrator: %s", reason))
+	s.lamport++
+	kickLamport := s.lamport
+	s.mutex.Unlock()
+
+	admin := adminActor(ctx)
+	loggerFrom(ctx, s.logger).Info("disconnected session", "user_id", userStream.UserId, "user", userStream.Name, "admin", admin, "reason", reason)
+	s.recordEvent(ctx, Event{Kind: EventKick, Actor: admin, Subject: userStream.Name, Room: userStream.Room, Lamport: kickLamport})
+	s.recordEvent(ctx, Event{Kind: EventLeave, Actor: userStream.Name, Room: userStream.Room, Lamport: kickLamport})
+	s.announce(ctx, userStream.Room, chitchat.ServerMessage_LEFT, userStream.Name, fmt.Sprintf("Participant %s was disconnected by an administrator at Lamport time %d", userStream.Name, kickLamport))
+	return &chitchat.Confirmation{}, nil
+}
+
+// adminActor names whoever called the Admin service in the audit log. The service has no accounts,
+// so it is the address they called from.
+func adminActor(ctx context.Context) string {
+	if remote, ok := peer.FromContext(ctx); ok {
+		return "admin@" + remote.Addr.String()
+	}
+	return "admin"
+}
+
+// historyChunkWriter sends what is written to it as the data of HistoryChunks.
+type historyChunkWriter struct {
+	stream chitchat.Admin_ExportHistoryServer
+}
+
+func (writer historyChunkWriter) Write(data []byte) (int, error) {
+	if err := writer.stream.Send(&chitchat.HistoryChunk{Data: bytes.Clone(data)}); err != nil {
+		return 0, err
+	}
+	return len(data), nil
+}
+
+// ExportHistory sends everything the server keeps of a room, in the requested format.
+func (a *adminService) ExportHistory(request *chitchat.ExportHistoryRequest, stream chitchat.Admin_ExportHistoryServer) error {
+	s := a.server
+	ctx := stream.Context()
+	switch request.Format {
+	case "jsonl", "csv", "markdown":
+	default:
+		return status.Errorf(codes.InvalidArgument, "unknown format %q, use jsonl, csv or markdown", request.Format)
+	}
+	room := request.Room
+	if room == "" {
+		room = chitchat.DefaultRoom
+	}
+	entries, err := s.transcript(room)
+	if err != nil {
+		loggerFrom(ctx, s.logger).Error("could not read the stored messages", "room", room, "error", err)
+		return status.Error(codes.Internal, "could not read the stored messages")
+	}
+	if len(entries) == 0 {
+		return status.Errorf(codes.NotFound, "nothing was said in %q", room)
+	}
+	chunks := bufio.NewWriterSize(historyChunkWriter{stream: stream}, downloadChunkSize)
+	if err := WriteTranscript(chunks, request.Format, entries); err != nil {
+		return err
+	}
+	if err := chunks.Flush(); err != nil {
+		return err
+	}
+	loggerFrom(ctx, s.logger).Info("history exported", "room", room, "format", request.Format, "entries", len(entries))
+	return nil
+}
+
+// historyChunkReader reads the data of the ImportHistoryChunks a stream receives.
+type historyChunkReader struct {
+	stream  chitchat.Admin_ImportHistoryServer
+	pending []byte
+}
+
+func (reader *historyChunkReader) Read(data []byte) (int, error) {
+	for len(reader.pending) == 0 {
+		chunk, err := reader.stream.Recv()
+		if err != nil {
+			return 0, err
+		}
+		if chunk.Header != nil {
+			return 0, status.Error(codes.InvalidArgument, "only the first chunk has a header")
+		}
+		reader.pending = chunk.Data
+	}
+	n := copy(data, reader.pending)
+	reader.pending = reader.pending[n:]
+	return n, nil
+}
+
+// ImportHistory keeps an exported history as if it was said just now, giving every entry a new
+// Lamport time after the server's and keeping the order they had.
+func (a *adminService) ImportHistory(stream chitchat.Admin_ImportHistoryServer) error {
+	s := a.server
+	ctx := stream.Context()
+	first, err := stream.Recv()
+	if err != nil {
+		return err
+	}
+	if first.Header == nil {
+		return status.Error(codes.InvalidArgument, "the first chunk must have the header")
+	}
+	header := first.Header
+	entries, err := ReadTranscript(&historyChunkReader{stream: stream, pending: first.Data}, header.Format)
+	if err != nil {
+		if _, ok := status.FromError(err); ok {
+			return err
+		}
+		return status.Errorf(codes.InvalidArgument, "could not read the history: %v", err)
+	}
+	firstLamport, lastLamport, err := s.importTranscript(entries, header.Room)
+	if _, ok := status.FromError(err); err != nil && !ok {
+		loggerFrom(ctx, s.logger).Error("could not import the history", "error", err)
+		return status.Error(codes.Internal, "could not store the history, nothing was imported")
+	}
+	if err != nil {
+		return err
+	}
+	loggerFrom(ctx, s.logger).Info("history imported", "room", header.Room, "format", header.Format, "entries", len(entries), "first_lamport", firstLamport, "last_lamport", lastLamport)
+	return stream.SendAndClose(&chitchat.ImportHistoryResponse{Entries: int32(len(entries)), FirstLamport: firstLamport, LastLamport: lastLamport})
+}
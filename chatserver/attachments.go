@@ -0,0 +1,240 @@
+package chatserver
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"io"
+	"mime"
+	"net/http"
+	"os"
+	"strings"
+	"unicode/utf8"
+
+	chitchat "homework3/chitchat"
+
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+const (
+	//most data one upload chunk can carry, and how much each download chunk carries
+	maxUploadChunk    = 1 << 20
+	downloadChunkSize = 64 << 10
+	//longest file name accepted, in characters
+	maxFileNameLength = 255
+)
+
+// Upload receives a file a participant shares in a room, checks it against the size and checksum
+// they signed, keeps it and sends everyone in the room an ATTACHMENT message about it.
+func (s *Server) Upload(stream chitchat.ChatService_UploadServer) error {
+	ctx := stream.Context()
+	if s.blobs == nil {
+		return status.Error(codes.Unimplemented, "this server does not keep files")
+	}
+	chunk, err := stream.Recv()
+	if err != nil {
+		return err
+	}
+	header := chunk.Header
+	if header == nil {
+		return status.Error(codes.InvalidArgument, "the first chunk of an upload must carry its header")
+	}
+	authorKey, err := s.authorKey(ctx, header.Name)
+	if err != nil {
+		return err
+	}
+	if !header.Verify(authorKey) {
+		return status.Errorf(codes.Unauthenticated, "upload signature does not match the key registered to %q", header.Name)
+	}
+	if err := s.checkFresh(header.Name, header.Room, header.Lamport, header.Signature); err != nil {
+		return err
+	}
+	if err := s.checkUploadHeader(header); err != nil {
+		return err
+	}
+	if err := s.runTextHooks(ctx, header.Name, header.Room, header.FileName, header.Lamport); err != nil {
+		return err
+	}
+	room := header.Room
+	if room == "" {
+		room = chitchat.DefaultRoom
+	}
+
+	uploaded, err := s.blobs.create()
+	if err != nil {
+		loggerFrom(ctx, s.logger).Error("could not store an upload", "error", err)
+		return status.Error(codes.Internal, "could not store the file")
+	}
+	//the file only stays once it is kept under its id.
+	defer os.Remove(uploaded.Name())
+	defer uploaded.Close()
+	hash := sha256.New()
+	var written int64
+	var sniffed []byte
+	for {
+		if len(chunk.Data) > maxUploadChunk {
+			return status.Errorf(codes.InvalidArgument, "upload chunks must be no larger than %d bytes", maxUploadChunk)
+		}
+		if written+int64(len(chunk.Data)) > header.Size {
+			return status.Errorf(codes.InvalidArgument, "the file is larger than the %d bytes the header said", header.Size)
+		}
+		if len(sniffed) < 512 {
+			sniffed = append(sniffed, chunk.Data[:min(len(chunk.Data), 512-len(sniffed))]...)
+		}
+		hash.Write(chunk.Data)
+		if _, err := uploaded.Write(chunk.Data); err != nil {
+			loggerFrom(ctx, s.logger).Error("could not store an upload", "error", err)
+			return status.Error(codes.Internal, "could not store the file")
+		}
+		written += int64(len(chunk.Data))
+
+		chunk, err = stream.Recv()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return err
+		}
+		if chunk.Header != nil {
+			return status.Error(codes.InvalidArgument, "only the first chunk of an upload carries a header")
+		}
+	}
+	if written != header.Size {
+		return status.Errorf(codes.InvalidArgument, "the file is %d bytes, not the %d the header said", written, header.Size)
+	}
+	if checksum := hex.EncodeToString(hash.Sum(nil)); checksum != strings.ToLower(header.Sha256) {
+		return status.Error(codes.InvalidArgument, "the file does not match its checksum")
+	}
+	if err := checkContentType(header.ContentType, sniffed); err != nil {
+		return err
+	}
+	if err := uploaded.Close(); err != nil {
+		loggerFrom(ctx, s.logger).Error("could not store an upload", "error", err)
+		return status.Error(codes.Internal, "could not store the file")
+	}
+
+	s.mutex.Lock()
+	id := s.newMessageID()
+	for s.blobs.exists(id) {
+		id = s.newMessageID()
+	}
+	attachment := &chitchat.Attachment{
+		Id:          id,
+		FileName:    header.FileName,
+		ContentType: header.ContentType,
+		Size:        header.Size,
+		Sha256:      strings.ToLower(header.Sha256),
+	}
+	if err := s.blobs.keep(uploaded.Name(), attachment); err != nil {
+		s.mutex.Unlock()
+		loggerFrom(ctx, s.logger).Error("could not keep an upload", "id", id, "error", err)
+		return status.Error(codes.Internal, "could not store the file")
+	}
+	s.lamport = max(s.lamport, header.Lamport)
+	backMessage := s.markActive(room, header.Name)
+	s.lamport++
+	serverMessage := &chitchat.ServerMessage{
+		Name:          header.Name,
+		Text:          header.FileName,
+		Lamport:       s.lamport,
+		Room:          room,
+		Signature:     header.Signature,
+		PublicKey:     authorKey,
+		SignedLamport: header.Lamport,
+		Kind:          chitchat.ServerMessage_ATTACHMENT,
+		Id:            id,
+		Attachment:    attachment,
+	}
+	s.trackReceipts(serverMessage)
+	s.remember(serverMessage)
+	s.mutex.Unlock()
+
+	if backMessage != nil {
+		s.sendAnnouncement(ctx, backMessage)
+	}
+	loggerFrom(ctx, s.logger).Info("file shared", "id", id, "lamport", serverMessage.Lamport, "author", header.Name, "room", room,
+		"file_name", header.FileName, "content_type", header.ContentType, "size", header.Size)
+	s.metrics.countBroadcast("user")
+	s.sendToRoom(ctx, serverMessage)
+	return stream.SendAndClose(&chitchat.Confirmation{MessageId: id, Lamport: serverMessage.Lamport})
+}
+
+// checkUploadHeader checks what a participant says about a file before any of it is stored.
+func (s *Server) checkUploadHeader(header *chitchat.UploadHeader) error {
+	maxSize := s.currentLimits().MaxAttachmentSize
+	if maxSize <= 0 {
+		return status.Error(codes.FailedPrecondition, "sharing files is turned off on this server")
+	}
+	if header.Size < 0 || header.Size > maxSize {
+		return status.Errorf(codes.InvalidArgument, "files must be no larger than %d bytes", maxSize)
+	}
+	if header.FileName == "" || header.FileName == "." || header.FileName == ".." || strings.ContainsAny(header.FileName, "/\\\x00") {
+		return status.Errorf(codes.InvalidArgument, "%q is not a file name", header.FileName)
+	}
+	if utf8.RuneCountInString(header.FileName) > maxFileNameLength {
+		return status.Errorf(codes.InvalidArgument, "file names must be no longer than %d characters", maxFileNameLength)
+	}
+	if _, _, err := mime.ParseMediaType(header.ContentType); err != nil {
+		return status.Errorf(codes.InvalidArgument, "%q is not a content type", header.ContentType)
+	}
+	if checksum, err := hex.DecodeString(header.Sha256); err != nil || len(checksum) != sha256.Size {
+		return status.Error(codes.InvalidArgument, "the checksum must be a hex SHA-256")
+	}
+	return nil
+}
+
+// checkContentType checks that a file said to be an image is one, from its first bytes,
+// since clients may show images without asking. Other content types are taken at their word.
+func checkContentType(contentType string, start []byte) error {
+	declared, _, _ := mime.ParseMediaType(contentType)
+	if !strings.HasPrefix(declared, "image/") {
+		return nil
+	}
+	detected, _, _ := mime.ParseMediaType(http.DetectContentType(start))
+	if detected != declared {
+		return status.Errorf(codes.InvalidArgument, "the file does not look like %s, but like %s", declared, detected)
+	}
+	return nil
+}
+
+// Download sends a shared file: what it is first, then its contents in chunks.
+func (s *Server) Download(request *chitchat.DownloadRequest, stream chitchat.ChatService_DownloadServer) error {
+	if s.blobs == nil {
+		return status.Error(codes.Unimplemented, "this server does not keep files")
+	}
+	file, attachment, err := s.blobs.open(request.AttachmentId)
+	if errors.Is(err, os.ErrNotExist) {
+		return status.Errorf(codes.NotFound, "there is no file %q, or it was deleted", request.AttachmentId)
+	}
+	if err != nil {
+		loggerFrom(stream.Context(), s.logger).Error("could not open a shared file", "id", request.AttachmentId, "error", err)
+		return status.Error(codes.Internal, "could not read the file")
+	}
+	defer file.Close()
+
+	chunk := &chitchat.DownloadChunk{Attachment: attachment}
+	buffer := make([]byte, downloadChunkSize)
+	for {
+		n, err := file.Read(buffer)
+		if n > 0 {
+			chunk.Data = buffer[:n]
+			if err := stream.Send(chunk); err != nil {
+				return err
+			}
+			chunk = &chitchat.DownloadChunk{}
+		}
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			loggerFrom(stream.Context(), s.logger).Error("could not read a shared file", "id", request.AttachmentId, "error", err)
+			return status.Error(codes.Internal, "could not read the file")
+		}
+	}
+	//an empty file is only the attachment.
+	if chunk.Attachment != nil {
+		return stream.Send(chunk)
+	}
+	return nil
+}
@@ -0,0 +1,192 @@
+package chatserver
+
+import (
+	"bufio"
+	"crypto/sha256"
+	"encoding/csv"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// hash the first entry of a log points back to.
+var auditGenesisHash = hex.EncodeToString(make([]byte, sha256.Size))
+
+// AuditEntry is one line of the audit log. Hash covers every other field,
+// including the hash of the entry before it, so no entry can be changed,
+// removed or reordered without breaking the chain. Subject is who the event
+// happened to when that is not the actor, like the user an administrator disconnected.
+type AuditEntry struct {
+	Seq      int64     `json:"seq"`
+	Lamport  int32     `json:"lamport"`
+	Time     time.Time `json:"time"`
+	Event    string    `json:"event"`
+	Actor    string    `json:"actor"`
+	Subject  string    `json:"subject,omitempty"`
+	Room     string    `json:"room"`
+	PrevHash string    `json:"prev_hash"`
+	Hash     string    `json:"hash"`
+}
+
+func (entry *AuditEntry) computeHash() string {
+	//Encode the fields as a JSON array so every field is unambiguously delimited.
+	fields := []any{
+		entry.Seq,
+		entry.Lamport,
+		entry.Time.UTC().Format(time.RFC3339Nano),
+		entry.Event,
+		entry.Actor,
+		entry.Room,
+		entry.PrevHash,
+	}
+	//entries without a subject hash as they did before there were subjects.
+	if entry.Subject != "" {
+		fields = append(fields, entry.Subject)
+	}
+	encoded, _ := json.Marshal(fields)
+	sum := sha256.Sum256(encoded)
+	return hex.EncodeToString(sum[:])
+}
+
+// AuditLog is an append-only, hash-chained log of membership and moderation events, stored as JSON lines.
+type AuditLog struct {
+	mutex    sync.Mutex
+	file     *os.File
+	seq      int64
+	lastHash string
+}
+
+// OpenAuditLog opens the log at path, creating it if needed.
+// An existing log is verified first so new entries are never chained onto a broken one.
+func OpenAuditLog(path string) (*AuditLog, error) {
+	auditLog := &AuditLog{lastHash: auditGenesisHash}
+
+	existing, err := os.Open(path)
+	if err == nil {
+		err = ReadAuditLog(existing, func(entry *AuditEntry) {
+			auditLog.seq = entry.Seq
+			auditLog.lastHash = entry.Hash
+		})
+		existing.Close()
+		if err != nil {
+			return nil, fmt.Errorf("existing audit log %s: %w", path, err)
+		}
+	} else if !os.IsNotExist(err) {
+		return nil, err
+	}
+
+	auditLog.file, err = os.OpenFile(path, os.O_WRONLY|os.O_APPEND|os.O_CREATE, 0600)
+	if err != nil {
+		return nil, err
+	}
+	return auditLog, nil
+}
+
+// Append records an event and flushes it to disk. subject is empty for events that only concern the actor.
+func (auditLog *AuditLog) Append(event string, actor string, subject string, room string, lamport int32) error {
+	auditLog.mutex.Lock()
+	defer auditLog.mutex.Unlock()
+
+	entry := &AuditEntry{
+		Seq:      auditLog.seq + 1,
+		Lamport:  lamport,
+		Time:     time.Now().UTC(),
+		Event:    event,
+		Actor:    actor,
+		Subject:  subject,
+		Room:     room,
+		PrevHash: auditLog.lastHash,
+	}
+	entry.Hash = entry.computeHash()
+
+	line, err := json.Marshal(entry)
+	if err != nil {
+		return err
+	}
+	if _, err := auditLog.file.Write(append(line, '\n')); err != nil {
+		return err
+	}
+	if err := auditLog.file.Sync(); err != nil {
+		return err
+	}
+	auditLog.seq = entry.Seq
+	auditLog.lastHash = entry.Hash
+	return nil
+}
+
+func (auditLog *AuditLog) Close() error {
+	return auditLog.file.Close()
+}
+
+// ReadAuditLog reads every entry in order, checking the chain as it goes,
+// and stops with an error at the first entry that does not fit.
+func ReadAuditLog(reader io.Reader, visit func(entry *AuditEntry)) error {
+	scanner := bufio.NewScanner(reader)
+	prevHash := auditGenesisHash
+	var seq int64
+	for scanner.Scan() {
+		var entry AuditEntry
+		if err := json.Unmarshal(scanner.Bytes(), &entry); err != nil {
+			return fmt.Errorf("entry after seq %d is not valid JSON: %w", seq, err)
+		}
+		if entry.Seq != seq+1 {
+			return fmt.Errorf("entry seq %d follows seq %d", entry.Seq, seq)
+		}
+		if entry.PrevHash != prevHash {
+			return fmt.Errorf("entry seq %d does not point at the entry before it", entry.Seq)
+		}
+		if entry.Hash != entry.computeHash() {
+			return fmt.Errorf("entry seq %d has been modified", entry.Seq)
+		}
+		visit(&entry)
+		seq = entry.Seq
+		prevHash = entry.Hash
+	}
+	return scanner.Err()
+}
+
+// ExportAuditLog writes a verified copy of the log in the given format.
+// Nothing is written unless the whole chain is intact.
+func ExportAuditLog(reader io.Reader, writer io.Writer, format string) error {
+	var entries []*AuditEntry
+	if err := ReadAuditLog(reader, func(entry *AuditEntry) { entries = append(entries, entry) }); err != nil {
+		return err
+	}
+
+	switch format {
+	case "jsonl":
+		encoder := json.NewEncoder(writer)
+		for _, entry := range entries {
+			if err := encoder.Encode(entry); err != nil {
+				return err
+			}
+		}
+		return nil
+	case "csv":
+		csvWriter := csv.NewWriter(writer)
+		csvWriter.Write([]string{"seq", "lamport", "time", "event", "actor", "subject", "room", "prev_hash", "hash"})
+		for _, entry := range entries {
+			csvWriter.Write([]string{
+				strconv.FormatInt(entry.Seq, 10),
+				strconv.FormatInt(int64(entry.Lamport), 10),
+				entry.Time.UTC().Format(time.RFC3339Nano),
+				entry.Event,
+				entry.Actor,
+				entry.Subject,
+				entry.Room,
+				entry.PrevHash,
+				entry.Hash,
+			})
+		}
+		csvWriter.Flush()
+		return csvWriter.Error()
+	default:
+		return errors.New("unknown format " + strconv.Quote(format))
+	}
+}
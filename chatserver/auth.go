@@ -0,0 +1,104 @@
+package chatserver
+
+import (
+	"context"
+	"crypto/rand"
+	"time"
+
+	chitchat "homework3/chitchat"
+
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+const (
+	// how long a join challenge can be used for
+	challengeLifetime = time.Minute
+	// most join challenges handed out and not used yet, so asking for them cannot fill up the memory
+	maxChallenges = 10000
+)
+
+// JoinHook decides whether a user may join. It runs after the server has checked
+// the user's name, that they hold their key and that the name is not registered to
+// another key, so it only has to add rules of its own, like checking a token in the
+// call's metadata. A name is only registered to a key once the hooks let the user in.
+type JoinHook func(ctx context.Context, user *chitchat.User) error
+
+// BroadcastHook decides whether a message may be sent. It runs after the server has
+// checked the message's signature. Text users send other than with Broadcast runs
+// through the same hooks, as the ClientMessage it would be if it were broadcast:
+// the new text of an Edit, the emoji of a React that adds one, the text of a
+// SendDirect, which has no room, and the file name of an Upload. grpc.Method(ctx) tells which call it came with.
+type BroadcastHook func(ctx context.Context, message *chitchat.ClientMessage) error
+
+// GetJoinChallenge hands out a nonce for someone about to join to sign, so the server knows they hold
+// the key they join with and are not sending a join they saw someone else send.
+func (s *Server) GetJoinChallenge(ctx context.Context, request *chitchat.JoinChallengeRequest) (*chitchat.JoinChallenge, error) {
+	nonce := make([]byte, 32)
+	if _, err := rand.Read(nonce); err != nil {
+		return nil, status.Error(codes.Internal, "could not make a challenge")
+	}
+	now := time.Now()
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	if len(s.challenges) >= maxChallenges {
+		for challenge, expires := range s.challenges {
+			if now.After(expires) {
+				delete(s.challenges, challenge)
+			}
+		}
+		if len(s.challenges) >= maxChallenges {
+			return nil, status.Error(codes.ResourceExhausted, "too many people are joining, try again in a minute")
+		}
+	}
+	s.challenges[string(nonce)] = now.Add(challengeLifetime)
+	return &chitchat.JoinChallenge{Nonce: nonce}, nil
+}
+
+// checkJoinSignature checks that a user joining signed what they join with, over a challenge the
+// server handed out and nobody has used, with the key they join with. The challenge is used up either way.
+func (s *Server) checkJoinSignature(user *chitchat.User) error {
+	s.mutex.Lock()
+	expires, ok := s.challenges[string(user.Nonce)]
+	delete(s.challenges, string(user.Nonce))
+	s.mutex.Unlock()
+	if !ok || time.Now().After(expires) {
+		return status.Error(codes.Unauthenticated, "sign a challenge from GetJoinChallenge to join")
+	}
+	if !user.VerifyJoin() {
+		return status.Error(codes.Unauthenticated, "the join signature does not match the key joined with")
+	}
+	return nil
+}
+
+// hookError turns an error from a hook into the status the call fails with.
+// Errors that are not gRPC statuses already are reported as PermissionDenied.
+func hookError(err error) error {
+	if _, ok := status.FromError(err); ok {
+		return err
+	}
+	return status.Error(codes.PermissionDenied, err.Error())
+}
+
+func (s *Server) runJoinHooks(ctx context.Context, user *chitchat.User) error {
+	for _, hook := range s.options.joinHooks {
+		if err := hook(ctx, user); err != nil {
+			return hookError(err)
+		}
+	}
+	return nil
+}
+
+// runTextHooks runs the broadcast hooks on text a user sends other than with Broadcast.
+func (s *Server) runTextHooks(ctx context.Context, name string, room string, text string, lamport int32) error {
+	return s.runBroadcastHooks(ctx, &chitchat.ClientMessage{Name: name, Room: room, Text: text, Lamport: lamport})
+}
+
+func (s *Server) runBroadcastHooks(ctx context.Context, message *chitchat.ClientMessage) error {
+	for _, hook := range s.options.broadcastHooks {
+		if err := hook(ctx, message); err != nil {
+			return hookError(err)
+		}
+	}
+	return nil
+}
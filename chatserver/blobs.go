@@ -0,0 +1,85 @@
+package chatserver
+
+import (
+	"encoding/hex"
+	"os"
+	"path/filepath"
+
+	chitchat "homework3/chitchat"
+
+	"google.golang.org/protobuf/encoding/protojson"
+)
+
+// blobStore keeps shared files on local disk, each under its id, with what it is in a JSON file beside it.
+type blobStore struct {
+	dir string
+}
+
+func openBlobStore(dir string) (*blobStore, error) {
+	if err := os.MkdirAll(dir, 0700); err != nil {
+		return nil, err
+	}
+	return &blobStore{dir: dir}, nil
+}
+
+// validBlobID reports whether id could be one the server gave out. Anything else could be a path.
+func validBlobID(id string) bool {
+	_, err := hex.DecodeString(id)
+	return id != "" && err == nil
+}
+
+func (blobs *blobStore) path(id string) string {
+	return filepath.Join(blobs.dir, id)
+}
+
+// create opens a temporary file to receive an upload in, so half a file is never kept under an id.
+func (blobs *blobStore) create() (*os.File, error) {
+	return os.CreateTemp(blobs.dir, "upload-*.tmp")
+}
+
+func (blobs *blobStore) exists(id string) bool {
+	_, err := os.Stat(blobs.path(id))
+	return err == nil
+}
+
+// keep moves a finished upload under the attachment's id, after saving what it is.
+func (blobs *blobStore) keep(uploaded string, attachment *chitchat.Attachment) error {
+	metadata, err := protojson.Marshal(attachment)
+	if err != nil {
+		return err
+	}
+	if err := replaceFile(blobs.path(attachment.Id)+".json", metadata); err != nil {
+		return err
+	}
+	return os.Rename(uploaded, blobs.path(attachment.Id))
+}
+
+// open returns a shared file and what it is. The error satisfies os.IsNotExist if there is no such file.
+func (blobs *blobStore) open(id string) (*os.File, *chitchat.Attachment, error) {
+	if !validBlobID(id) {
+		return nil, nil, os.ErrNotExist
+	}
+	metadata, err := os.ReadFile(blobs.path(id) + ".json")
+	if err != nil {
+		return nil, nil, err
+	}
+	attachment := &chitchat.Attachment{}
+	if err := protojson.Unmarshal(metadata, attachment); err != nil {
+		return nil, nil, err
+	}
+	file, err := os.Open(blobs.path(id))
+	if err != nil {
+		return nil, nil, err
+	}
+	return file, attachment, nil
+}
+
+func (blobs *blobStore) remove(id string) error {
+	if err := os.Remove(blobs.path(id)); err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	if err := os.Remove(blobs.path(id) + ".json"); err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	return nil
+}
@@ -0,0 +1,161 @@
+package chatserver
+
+import (
+	"cmp"
+	"context"
+	"slices"
+	"time"
+	"unicode/utf8"
+
+	chitchat "homework3/chitchat"
+
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/proto"
+)
+
+// how often direct messages that waited too long are taken out of their mailboxes
+const mailboxCheckInterval = time.Minute
+
+// SendDirect sends a private message to one user. It reaches every connection they and the
+// sender have, whatever room it is in, and waits in the recipient's mailbox until they
+// acknowledge it, so a recipient who is offline gets it the next time they join.
+func (s *Server) SendDirect(ctx context.Context, message *chitchat.DirectMessage) (*chitchat.Confirmation, error) {
+	authorKey, err := s.authorKey(ctx, message.Name)
+	if err != nil {
+		return nil, err
+	}
+	if !message.Verify(authorKey) {
+		return nil, status.Errorf(codes.Unauthenticated, "message signature does not match the key registered to %q", message.Name)
+	}
+	if err := s.checkFresh(message.Name, "", message.Lamport, message.Signature); err != nil {
+		return nil, err
+	}
+	limits := s.currentLimits()
+	if utf8.RuneCountInString(message.Text) > limits.MaxMessageLength {
+		return nil, status.Errorf(codes.InvalidArgument, "messages must be no longer than %d characters", limits.MaxMessageLength)
+	}
+	if message.To == message.Name {
+		return nil, status.Error(codes.InvalidArgument, "direct messages are for someone else")
+	}
+	recipientKey, err := s.storage.PublicKey(message.To)
+	if err != nil {
+		loggerFrom(ctx, s.logger).Error("could not look up the recipient", "recipient", message.To, "error", err)
+		return nil, status.Error(codes.Internal, "could not look up the recipient")
+	}
+	if recipientKey == nil {
+		return nil, status.Errorf(codes.NotFound, "%q has never joined the chat", message.To)
+	}
+	if err := s.runTextHooks(ctx, message.Name, "", message.Text, message.Lamport); err != nil {
+		return nil, err
+	}
+
+	//the mailbox is checked and filled while the Lamport time is held, so it stays in Lamport order.
+	s.mutex.Lock()
+	waiting, err := s.storage.Mailbox(message.To)
+	if err == nil && limits.MailboxSize > 0 && len(waiting) >= limits.MailboxSize {
+		s.mutex.Unlock()
+		return nil, status.Errorf(codes.ResourceExhausted, "%q has too many messages waiting, try again later", message.To)
+	}
+	s.lamport = max(s.lamport, message.Lamport)
+	s.lamport++
+	serverMessage := &chitchat.ServerMessage{
+		Name:          message.Name,
+		Text:          message.Text,
+		Lamport:       s.lamport,
+		Signature:     message.Signature,
+		PublicKey:     authorKey,
+		SignedLamport: message.Lamport,
+		Kind:          chitchat.ServerMessage_DIRECT,
+		Id:            s.newMessageID(),
+		To:            message.To,
+	}
+	if err == nil {
+		err = s.storage.StoreDirect(MailboxEntry{Message: serverMessage, Stored: time.Now()})
+	}
+	s.mutex.Unlock()
+	if err != nil {
+		loggerFrom(ctx, s.logger).Error("could not store a direct message", "author", message.Name, "recipient", message.To, "error", err)
+		return nil, status.Error(codes.Internal, "could not store the message")
+	}
+
+	//the text is private, so unlike room messages it is not logged.
+	loggerFrom(ctx, s.logger).Info("direct message sent", "id", serverMessage.Id, "author", message.Name, "recipient", message.To, "lamport", serverMessage.Lamport)
+	s.sendTo(ctx, serverMessage, func(userStream *connectedUser) bool {
+		return userStream.Name == message.To || userStream.Name == message.Name
+	})
+	return &chitchat.Confirmation{MessageId: serverMessage.Id, Lamport: serverMessage.Lamport}, nil
+}
+
+// AcknowledgeDirect takes direct messages that have reached their recipient out of the recipient's mailbox.
+func (s *Server) AcknowledgeDirect(ctx context.Context, ack *chitchat.MailboxAck) (*chitchat.Confirmation, error) {
+	key, err := s.authorKey(ctx, ack.Name)
+	if err != nil {
+		return nil, err
+	}
+	if !ack.Verify(key) {
+		return nil, status.Errorf(codes.Unauthenticated, "acknowledgement signature does not match the key registered to %q", ack.Name)
+	}
+	if err := s.checkFresh(ack.Name, "", ack.Lamport, ack.Signature); err != nil {
+		return nil, err
+	}
+	if err := s.storage.RemoveDirect(ack.Name, ack.MessageIds); err != nil {
+		loggerFrom(ctx, s.logger).Error("could not empty the mailbox", "user", ack.Name, "error", err)
+		return nil, status.Error(codes.Internal, "could not empty your mailbox")
+	}
+	return &chitchat.Confirmation{}, nil
+}
+
+// deliverMailbox queues the direct messages waiting for a user who just joined, in Lamport order.
+// It returns those that do not fit in the queue, which the user's Join call sends once the queue is empty.
+// It must be called with the mutex held.
+func (s *Server) deliverMailbox(userStream *connectedUser) []*chitchat.ServerMessage {
+	waiting, err := s.storage.Mailbox(userStream.Name)
+	if err != nil {
+		userStream.logger.Error("could not open the mailbox", "error", err)
+		return nil
+	}
+	if retention := s.currentLimits().MailboxRetention; retention > 0 {
+		waiting = slices.DeleteFunc(waiting, func(entry MailboxEntry) bool { return time.Since(entry.Stored) > retention })
+	}
+	slices.SortStableFunc(waiting, func(a, b MailboxEntry) int { return cmp.Compare(a.Message.Lamport, b.Message.Lamport) })
+	//one place is left free, so a message sent to the room right now still fits.
+	count := max(min(len(waiting), cap(userStream.queue)-len(userStream.queue)-1), 0)
+	var later []*chitchat.ServerMessage
+	for i, entry := range waiting {
+		message := proto.Clone(entry.Message).(*chitchat.ServerMessage)
+		if i < count {
+			userStream.queue <- queuedMessage{message: message, enqueued: time.Now(), fanOut: userStream.Stream.Context()}
+		} else {
+			later = append(later, message)
+		}
+	}
+	if len(waiting) > 0 {
+		userStream.logger.Info("delivering the mailbox", "messages", len(waiting), "queued", count)
+	}
+	return later
+}
+
+// expireMailboxes takes direct messages that have waited longer than the retention out of
+// their mailboxes, until Stop is called.
+func (s *Server) expireMailboxes() {
+	ticker := time.NewTicker(mailboxCheckInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-s.stopped:
+			return
+		case <-ticker.C:
+		}
+		retention := s.currentLimits().MailboxRetention
+		if retention <= 0 {
+			continue
+		}
+		expired, err := s.storage.ExpireDirect(time.Now().Add(-retention))
+		if err != nil {
+			s.logger.Error("could not expire direct messages", "error", err)
+		} else if expired > 0 {
+			s.logger.Info("expired direct messages", "messages", expired)
+		}
+	}
+}
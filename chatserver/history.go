@@ -0,0 +1,435 @@
+package chatserver
+
+import (
+	"context"
+	"time"
+	"unicode/utf8"
+
+	chitchat "homework3/chitchat"
+
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/proto"
+	"google.golang.org/protobuf/types/known/timestamppb"
+)
+
+// how many chat messages, edits and deletions the server keeps for each room
+const historyKept = 1000
+
+// roomHistory is the latest messages, edits and deletions in a room, in Lamport order.
+// A deleted message's own entry and its edits are taken out, leaving the DELETED message as its tombstone.
+type roomHistory struct {
+	log []*chitchat.ServerMessage
+}
+
+// storedMessage is a chat message the server can still edit, delete or reply to.
+type storedMessage struct {
+	id      string
+	room    string
+	author  string
+	lamport int32
+	//the file an ATTACHMENT message shares, nil for other messages
+	attachment *chitchat.Attachment
+	//the message this one replies to, and the first message of its thread, empty if it is not a reply
+	replyTo string
+	thread  string
+	//replies in the thread this message starts, oldest first
+	replies []string
+	//every text the message has had, oldest first. Empty once it is deleted.
+	versions []*chitchat.MessageVersion
+	//the EDITED message with the current text, nil if it was never edited
+	lastEdit *chitchat.ServerMessage
+	//the DELETED message, nil unless it was deleted
+	deletion *chitchat.ServerMessage
+	//the reactions the message has, in the order each emoji was first used, and the latest REACTIONS message
+	reactions     []*reaction
+	lastReactions *chitchat.ServerMessage
+}
+
+// targetOf returns the id of the chat message a message in the history is, or is about.
+func targetOf(message *chitchat.ServerMessage) string {
+	if isChat(message) {
+		return message.Id
+	}
+	return message.Target
+}
+
+// isChat reports whether a message is one a participant wrote or shared a file with, rather than one about those.
+func isChat(message *chitchat.ServerMessage) bool {
+	return message.Kind == chitchat.ServerMessage_CHAT || message.Kind == chitchat.ServerMessage_ATTACHMENT
+}
+
+// kept reports whether a message is one the storage keeps: what was said in a room and how it
+// changed or was reacted to, rather than receipts or typing.
+func kept(message *chitchat.ServerMessage) bool {
+	switch message.Kind {
+	case chitchat.ServerMessage_EDITED, chitchat.ServerMessage_DELETED, chitchat.ServerMessage_REACTIONS:
+		return true
+	}
+	return isChat(message)
+}
+
+// remember adds a chat message, edit, deletion or reactions to its room's history, forgetting the oldest
+// entry once historyKept are kept. Those the storage keeps are stored and indexed too, with the time
+// they were sent. It must be called with the mutex held.
+func (s *Server) remember(message *chitchat.ServerMessage) {
+	if kept(message) {
+		message.SentAt = timestamppb.Now()
+	}
+	//the message is about to be fanned out, which sets its trace context, so keep a copy.
+	message = proto.Clone(message).(*chitchat.ServerMessage)
+	if kept(message) {
+		//the mutex keeps the storage in Lamport order. A message that could not be stored is still
+		//sent, and found until the server restarts, but not replayed.
+		if err := s.storage.AppendMessage(message); err != nil {
+			s.logger.Error("could not store a message", "id", message.Id, "kind", message.Kind, "error", err)
+		}
+		s.index.add(message, s.currentLimits().MaxIndexedMessages)
+	}
+	s.addToHistory(message)
+}
+
+// addToHistory adds a message to its room's history, and a chat message to the messages that can
+// be edited, deleted and replied to. It must be called with the mutex held.
+func (s *Server) addToHistory(message *chitchat.ServerMessage) {
+	history, ok := s.history[message.Room]
+	if !ok {
+		history = &roomHistory{}
+		s.history[message.Room] = history
+	}
+	if isChat(message) {
+		stored := &storedMessage{
+			id:         message.Id,
+			room:       message.Room,
+			author:     message.Name,
+			lamport:    message.Lamport,
+			attachment: message.Attachment,
+			replyTo:    message.ReplyTo,
+			versions: []*chitchat.MessageVersion{{
+				Text:    message.Text,
+				Editor:  message.Name,
+				Lamport: message.Lamport,
+				At:      message.SentAt,
+			}},
+		}
+		s.messages[message.Id] = stored
+		s.addToThread(stored)
+	}
+	history.log = append(history.log, message)
+	if len(history.log) > historyKept {
+		oldest := history.log[0]
+		history.log = history.log[1:]
+		//edits and reactions are newer than what they are about, so only the message itself or its tombstone ends it.
+		if isChat(oldest) || oldest.Kind == chitchat.ServerMessage_DELETED {
+			delete(s.messages, targetOf(oldest))
+		}
+	}
+}
+
+// restore puts a message the storage kept back in its room's history, bringing the message it is
+// about up to date the way the call that sent it did. It must be called with the mutex held.
+func (s *Server) restore(message *chitchat.ServerMessage) {
+	stored, ok := s.messages[targetOf(message)]
+	switch message.Kind {
+	case chitchat.ServerMessage_DELETED:
+		if ok {
+			s.forget(stored)
+			stored.versions = nil
+			stored.lastEdit = nil
+			stored.reactions = nil
+			stored.lastReactions = nil
+			stored.deletion = message
+		}
+	case chitchat.ServerMessage_EDITED:
+		if ok {
+			stored.versions = append(stored.versions, &chitchat.MessageVersion{
+				Text:    message.Text,
+				Editor:  message.Subject,
+				Lamport: message.Lamport,
+				At:      message.SentAt,
+			})
+			stored.lastEdit = message
+		}
+	case chitchat.ServerMessage_REACTIONS:
+		if ok {
+			stored.reactions = reactionsFrom(message.Reactions)
+			stored.lastReactions = message
+		}
+	}
+	s.addToHistory(message)
+}
+
+// forget takes a deleted message and its edits out of its room's history. It must be called with the mutex held.
+func (s *Server) forget(stored *storedMessage) {
+	history := s.history[stored.room]
+	kept := history.log[:0]
+	for _, message := range history.log {
+		if targetOf(message) != stored.id {
+			kept = append(kept, message)
+		}
+		//replies should not keep quoting what was deleted.
+		if message.ReplyTo == stored.id && message.Quote != nil {
+			message.Quote.Text = ""
+		}
+	}
+	//clear the tail so the forgotten messages can be collected.
+	for i := len(kept); i < len(history.log); i++ {
+		history.log[i] = nil
+	}
+	history.log = kept
+}
+
+// erase takes a message out of its room's history and the search index, leaving deleteMessage as
+// its tombstone. The message may be too old for the history. It must be called with the mutex held.
+func (s *Server) erase(id string, deleteMessage *chitchat.ServerMessage) {
+	stored, ok := s.messages[id]
+	if ok {
+		s.forget(stored)
+		stored.versions = nil
+		stored.lastEdit = nil
+		stored.reactions = nil
+		stored.lastReactions = nil
+	}
+	s.remember(deleteMessage)
+	if ok {
+		history := s.history[deleteMessage.Room]
+		stored.deletion = history.log[len(history.log)-1]
+	}
+}
+
+// replayHistory queues the room's latest messages that are newer than the Lamport time a user
+// joined with, so a new participant sees what was said before and a reconnecting one what they missed.
+// Edited messages come with their latest edit, messages with reactions with the reactions
+// they have now, and deleted ones only as a tombstone. They are read from the storage, so they
+// are there after a restart too. It must be called with the mutex held.
+func (s *Server) replayHistory(userStream *connectedUser, after int32) {
+	//the participants message is queued already, and whatever happens next must fit too.
+	count := min(s.currentLimits().HistoryReplay, cap(userStream.queue)-len(userStream.queue)-1)
+	if count <= 0 {
+		return
+	}
+	//edits, reactions and deletions the replay leaves out take up room too, so read as much as the history keeps.
+	stored, err := s.storage.RoomMessages(userStream.Room, after, historyKept)
+	if err != nil {
+		userStream.logger.Error("could not read the history to replay", "error", err)
+		return
+	}
+	replay := currentOf(stored)
+	if len(replay) > count {
+		replay = replay[len(replay)-count:]
+	}
+	for _, replayed := range replay {
+		replayed.Replayed = true
+		userStream.queue <- queuedMessage{message: replayed, enqueued: time.Now(), fanOut: userStream.Stream.Context()}
+	}
+}
+
+// currentOf keeps the stored messages that say what the messages among them are now: the chat
+// messages, the latest edit and reactions of each, and the tombstones of deleted ones. Replies to
+// deleted messages stop quoting them. The messages must be all of a room's from some point on.
+func currentOf(messages []*chitchat.ServerMessage) []*chitchat.ServerMessage {
+	deleted := make(map[string]bool)
+	lastEdit := make(map[string]*chitchat.ServerMessage)
+	lastReactions := make(map[string]*chitchat.ServerMessage)
+	for _, message := range messages {
+		switch message.Kind {
+		case chitchat.ServerMessage_DELETED:
+			deleted[message.Target] = true
+		case chitchat.ServerMessage_EDITED:
+			lastEdit[message.Target] = message
+		case chitchat.ServerMessage_REACTIONS:
+			lastReactions[message.Target] = message
+		}
+	}
+	var entries []*chitchat.ServerMessage
+	for _, message := range messages {
+		id := targetOf(message)
+		switch {
+		case message.Kind == chitchat.ServerMessage_DELETED:
+		case deleted[id]:
+			continue
+		case message.Kind == chitchat.ServerMessage_EDITED && lastEdit[id] != message:
+			continue
+		case message.Kind == chitchat.ServerMessage_REACTIONS && lastReactions[id] != message:
+			continue
+		case message.Quote != nil && deleted[message.ReplyTo]:
+			message.Quote.Text = ""
+		}
+		entries = append(entries, message)
+	}
+	return entries
+}
+
+// current returns the entries of a room's history that say what its messages are now: the chat messages,
+// the latest edit and reactions of each, and the tombstones of deleted ones, for the messages include wants.
+// It must be called with the mutex held.
+func (s *Server) current(room string, include func(*chitchat.ServerMessage, *storedMessage) bool) []*chitchat.ServerMessage {
+	history, ok := s.history[room]
+	if !ok {
+		return nil
+	}
+	var entries []*chitchat.ServerMessage
+	for _, message := range history.log {
+		stored, ok := s.messages[targetOf(message)]
+		if !ok {
+			continue
+		}
+		if message.Kind == chitchat.ServerMessage_EDITED && (stored.lastEdit != message || stored.deletion != nil) {
+			continue
+		}
+		if message.Kind == chitchat.ServerMessage_REACTIONS && (stored.lastReactions != message || stored.deletion != nil) {
+			continue
+		}
+		if include(message, stored) {
+			entries = append(entries, message)
+		}
+	}
+	return entries
+}
+
+// changeable returns a message that name may edit or delete in a room. It must be called with the mutex held.
+func (s *Server) changeable(room string, messageID string, name string) (*storedMessage, error) {
+	stored, ok := s.messages[messageID]
+	if !ok || stored.room != room {
+		return nil, status.Errorf(codes.NotFound, "there is no message %q in room %q, or it is too old to change", messageID, room)
+	}
+	if stored.deletion != nil {
+		return nil, status.Errorf(codes.FailedPrecondition, "message %q was deleted", messageID)
+	}
+	if stored.author != name && !s.moderators[name] {
+		return nil, status.Error(codes.PermissionDenied, "only the author or a moderator can change a message")
+	}
+	return stored, nil
+}
+
+// Edit changes the text of a message. Everyone in the room is sent an EDITED message,
+// and the server keeps every version.
+func (s *Server) Edit(ctx context.Context, edit *chitchat.MessageEdit) (*chitchat.Confirmation, error) {
+	authorKey, err := s.authorKey(ctx, edit.Name)
+	if err != nil {
+		return nil, err
+	}
+	if !edit.Verify(authorKey) {
+		return nil, status.Errorf(codes.Unauthenticated, "edit signature does not match the key registered to %q", edit.Name)
+	}
+	if err := s.checkFresh(edit.Name, edit.Room, edit.Lamport, edit.Signature); err != nil {
+		return nil, err
+	}
+	if maxLength := s.currentLimits().MaxMessageLength; utf8.RuneCountInString(edit.Text) > maxLength {
+		return nil, status.Errorf(codes.InvalidArgument, "messages must be no longer than %d characters", maxLength)
+	}
+	if err := s.runTextHooks(ctx, edit.Name, edit.Room, edit.Text, edit.Lamport); err != nil {
+		return nil, err
+	}
+	room := edit.Room
+	if room == "" {
+		room = chitchat.DefaultRoom
+	}
+
+	s.mutex.Lock()
+	stored, err := s.changeable(room, edit.MessageId, edit.Name)
+	if err == nil && stored.attachment != nil {
+		err = status.Errorf(codes.FailedPrecondition, "message %q shares a file, which cannot be edited; delete it and share the file again", edit.MessageId)
+	}
+	if err != nil {
+		s.mutex.Unlock()
+		return nil, err
+	}
+	s.lamport = max(s.lamport, edit.Lamport)
+	//the edit goes along as its editor signed it, so clients can check who wrote the new text.
+	editMessage := s.stampServerMessage(&chitchat.ServerMessage{
+		Text:       edit.Text,
+		Room:       room,
+		Kind:       chitchat.ServerMessage_EDITED,
+		Subject:    edit.Name,
+		Target:     stored.id,
+		Edit:       edit,
+		SubjectKey: authorKey,
+	})
+	stored.versions = append(stored.versions, &chitchat.MessageVersion{
+		Text:    edit.Text,
+		Editor:  edit.Name,
+		Lamport: editMessage.Lamport,
+		At:      timestamppb.Now(),
+	})
+	s.remember(editMessage)
+	stored.lastEdit = s.history[room].log[len(s.history[room].log)-1]
+	s.mutex.Unlock()
+
+	loggerFrom(ctx, s.logger).Info("message edited", "id", stored.id, "author", stored.author, "editor", edit.Name, "room", room, "lamport", editMessage.Lamport)
+	s.sendAnnouncement(ctx, editMessage)
+	return &chitchat.Confirmation{MessageId: stored.id, Lamport: editMessage.Lamport}, nil
+}
+
+// Delete deletes a message. Everyone in the room is sent a DELETED message, which is all
+// the history keeps of it from then on.
+func (s *Server) Delete(ctx context.Context, deletion *chitchat.MessageDeletion) (*chitchat.Confirmation, error) {
+	authorKey, err := s.authorKey(ctx, deletion.Name)
+	if err != nil {
+		return nil, err
+	}
+	if !deletion.Verify(authorKey) {
+		return nil, status.Errorf(codes.Unauthenticated, "deletion signature does not match the key registered to %q", deletion.Name)
+	}
+	if err := s.checkFresh(deletion.Name, deletion.Room, deletion.Lamport, deletion.Signature); err != nil {
+		return nil, err
+	}
+	room := deletion.Room
+	if room == "" {
+		room = chitchat.DefaultRoom
+	}
+
+	s.mutex.Lock()
+	stored, err := s.changeable(room, deletion.MessageId, deletion.Name)
+	if err != nil {
+		s.mutex.Unlock()
+		return nil, err
+	}
+	s.lamport = max(s.lamport, deletion.Lamport)
+	text := "message deleted"
+	if deletion.Name != stored.author {
+		text = "message deleted by " + deletion.Name
+	}
+	deleteMessage := s.stampServerMessage(&chitchat.ServerMessage{
+		Text:    text,
+		Room:    room,
+		Kind:    chitchat.ServerMessage_DELETED,
+		Subject: deletion.Name,
+		Target:  stored.id,
+	})
+	s.erase(stored.id, deleteMessage)
+	s.mutex.Unlock()
+
+	loggerFrom(ctx, s.logger).Info("message deleted", "id", stored.id, "author", stored.author, "deleted_by", deletion.Name, "room", room, "lamport", deleteMessage.Lamport)
+	if deletion.Name != stored.author {
+		s.recordEvent(ctx, Event{Kind: EventDelete, Actor: deletion.Name, Subject: stored.author, Room: room, Lamport: deleteMessage.Lamport})
+	}
+	//a deleted file is gone for good, not just from the history.
+	if stored.attachment != nil && s.blobs != nil {
+		if err := s.blobs.remove(stored.id); err != nil {
+			loggerFrom(ctx, s.logger).Error("could not delete a shared file", "id", stored.id, "error", err)
+		}
+	}
+	s.sendAnnouncement(ctx, deleteMessage)
+	return &chitchat.Confirmation{MessageId: stored.id, Lamport: deleteMessage.Lamport}, nil
+}
+
+// GetEditHistory returns every version of a message the server still remembers.
+func (s *Server) GetEditHistory(ctx context.Context, request *chitchat.EditHistoryRequest) (*chitchat.EditHistory, error) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	stored, ok := s.messages[request.MessageId]
+	if !ok {
+		return nil, status.Errorf(codes.NotFound, "there is no message %q, or it is too old", request.MessageId)
+	}
+	history := &chitchat.EditHistory{
+		MessageId: stored.id,
+		Author:    stored.author,
+		Versions:  append([]*chitchat.MessageVersion(nil), stored.versions...),
+		Deleted:   stored.deletion != nil,
+	}
+	if stored.deletion != nil {
+		history.DeletedBy = stored.deletion.Subject
+	}
+	return history, nil
+}
@@ -0,0 +1,41 @@
+package chatserver
+
+import (
+	"slices"
+	"testing"
+
+	chitchat "homework3/chitchat"
+)
+
+func TestCurrentOf(t *testing.T) {
+	chat := func(id string, lamport int32) *chitchat.ServerMessage {
+		return &chitchat.ServerMessage{Id: id, Lamport: lamport, Kind: chitchat.ServerMessage_CHAT}
+	}
+	about := func(kind chitchat.ServerMessage_Kind, target string, lamport int32) *chitchat.ServerMessage {
+		return &chitchat.ServerMessage{Target: target, Lamport: lamport, Kind: kind}
+	}
+	reply := chat("c", 3)
+	reply.ReplyTo = "b"
+	reply.Quote = &chitchat.Quote{Author: "alice", Text: "deleted later"}
+	messages := []*chitchat.ServerMessage{
+		chat("a", 1),
+		chat("b", 2),
+		reply,
+		about(chitchat.ServerMessage_EDITED, "a", 4),
+		about(chitchat.ServerMessage_REACTIONS, "a", 5),
+		about(chitchat.ServerMessage_EDITED, "b", 6),
+		about(chitchat.ServerMessage_EDITED, "a", 7),
+		about(chitchat.ServerMessage_DELETED, "b", 8),
+		about(chitchat.ServerMessage_REACTIONS, "a", 9),
+	}
+	var lamports []int32
+	for _, message := range currentOf(messages) {
+		lamports = append(lamports, message.Lamport)
+	}
+	if want := []int32{1, 3, 7, 8, 9}; !slices.Equal(lamports, want) {
+		t.Errorf("currentOf kept the messages from Lamport times %v, want %v", lamports, want)
+	}
+	if reply.Quote.Text != "" {
+		t.Errorf("a reply to a deleted message still quotes %q", reply.Quote.Text)
+	}
+}
@@ -0,0 +1,88 @@
+package chatserver
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"log/slog"
+
+	chitchat "homework3/chitchat"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/metadata"
+)
+
+// newID returns a random id for requests that arrive without one.
+func newID() string {
+	id := make([]byte, 8)
+	rand.Read(id)
+	return hex.EncodeToString(id)
+}
+
+type loggerKey struct{}
+
+// loggerFrom returns the logger for a call, carrying its session and request ids,
+// or fallback for calls that did not go through the logging interceptors.
+func loggerFrom(ctx context.Context, fallback *slog.Logger) *slog.Logger {
+	if logger, ok := ctx.Value(loggerKey{}).(*slog.Logger); ok {
+		return logger
+	}
+	return fallback
+}
+
+// withCallLogger returns a context holding a logger tagged with the call's correlation ids,
+// taken from the incoming metadata when the client sent them.
+func (s *Server) withCallLogger(ctx context.Context, method string) context.Context {
+	sessionID, requestID := "", ""
+	if md, ok := metadata.FromIncomingContext(ctx); ok {
+		if values := md.Get(chitchat.SessionIDHeader); len(values) > 0 {
+			sessionID = values[0]
+		}
+		if values := md.Get(chitchat.RequestIDHeader); len(values) > 0 {
+			requestID = values[0]
+		}
+	}
+	if requestID == "" {
+		requestID = newID()
+	}
+	//echo the request id back so clients can find the server's log lines for their call.
+	grpc.SetHeader(ctx, metadata.Pairs(chitchat.RequestIDHeader, requestID))
+
+	logger := s.logger.With("method", method, "request_id", requestID)
+	if sessionID != "" {
+		logger = logger.With("session_id", sessionID)
+	}
+	return context.WithValue(ctx, loggerKey{}, logger)
+}
+
+func (s *Server) loggingUnaryInterceptor(ctx context.Context, request any, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (any, error) {
+	ctx = s.withCallLogger(ctx, info.FullMethod)
+	response, err := handler(ctx, request)
+	if err != nil {
+		loggerFrom(ctx, s.logger).Warn("call failed", "error", err)
+	} else {
+		loggerFrom(ctx, s.logger).Debug("call handled")
+	}
+	return response, err
+}
+
+func (s *Server) loggingStreamInterceptor(server any, stream grpc.ServerStream, info *grpc.StreamServerInfo, handler grpc.StreamHandler) error {
+	ctx := s.withCallLogger(stream.Context(), info.FullMethod)
+	err := handler(server, &loggedStream{ServerStream: stream, ctx: ctx})
+	if err != nil {
+		loggerFrom(ctx, s.logger).Info("stream ended", "error", err)
+	} else {
+		loggerFrom(ctx, s.logger).Debug("stream ended")
+	}
+	return err
+}
+
+// loggedStream is a server stream whose context carries the call's logger.
+type loggedStream struct {
+	grpc.ServerStream
+	ctx context.Context
+}
+
+func (stream *loggedStream) Context() context.Context {
+	return stream.ctx
+}
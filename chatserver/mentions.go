@@ -0,0 +1,62 @@
+package chatserver
+
+import (
+	"context"
+	"fmt"
+	"regexp"
+	"strings"
+
+	chitchat "homework3/chitchat"
+)
+
+// most users one message can mention
+const maxMentions = 10
+
+// mentionPattern finds @name at the start of a message or after anything that cannot be part
+// of a name, so e-mail addresses are not mentions. Names are letters, digits, _, . and -.
+var mentionPattern = regexp.MustCompile(`(?:^|[^\p{L}\p{N}_])@([\p{L}\p{N}_.\-]+)`)
+
+// mentionsIn returns the registered users a message mentions, in the order they are first mentioned.
+func (s *Server) mentionsIn(ctx context.Context, text string) []string {
+	var mentions []string
+	seen := make(map[string]bool)
+	for _, match := range mentionPattern.FindAllStringSubmatch(text, -1) {
+		//a sentence can end right after a name.
+		name := strings.TrimRight(match[1], ".-")
+		if name == "" || seen[name] || len(mentions) >= maxMentions {
+			continue
+		}
+		seen[name] = true
+		key, err := s.storage.PublicKey(name)
+		if err != nil {
+			loggerFrom(ctx, s.logger).Warn("could not look up a mentioned user", "user", name, "error", err)
+			continue
+		}
+		if key != nil {
+			mentions = append(mentions, name)
+		}
+	}
+	return mentions
+}
+
+// notifyMentions sends everyone a chat message mentions a MENTION message on every connection
+// they have, in whatever room. Mentions are not part of any room's history, so like receipts
+// they do not advance the Lamport time.
+func (s *Server) notifyMentions(ctx context.Context, message *chitchat.ServerMessage) {
+	for _, name := range message.Mentions {
+		if name == message.Name {
+			continue
+		}
+		s.mutex.Lock()
+		notification := s.signServerMessage(&chitchat.ServerMessage{
+			Lamport: s.lamport,
+			Text:    fmt.Sprintf("%s mentioned you in %s: %s", message.Name, message.Room, message.Text),
+			Room:    message.Room,
+			Kind:    chitchat.ServerMessage_MENTION,
+			Subject: message.Name,
+			Target:  message.Id,
+		}, s.lamport)
+		s.mutex.Unlock()
+		s.sendTo(ctx, notification, func(userStream *connectedUser) bool { return userStream.Name == name })
+	}
+}
@@ -0,0 +1,53 @@
+package chatserver
+
+import (
+	"context"
+	"crypto/ed25519"
+	"fmt"
+	"slices"
+	"strings"
+	"testing"
+)
+
+func TestMentionsIn(t *testing.T) {
+	storage := NewMemoryStorage()
+	names := []string{"alice", "bob", "jean-luc", "ana.b", "zoë"}
+	for i := 0; i < 12; i++ {
+		names = append(names, fmt.Sprintf("user%d", i))
+	}
+	for _, name := range names {
+		public, _, err := ed25519.GenerateKey(nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err := storage.RegisterPublicKey(name, public); err != nil {
+			t.Fatal(err)
+		}
+	}
+	s, err := New(WithStorage(storage))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer s.Stop(context.Background())
+
+	tests := []struct {
+		text string
+		want []string
+	}{
+		{"@alice, look", []string{"alice"}},
+		{"ask @bob or @alice, then @bob again", []string{"bob", "alice"}},
+		{"mail alice@example.com", nil},
+		{"thanks @bob.", []string{"bob"}},
+		{"(@bob) and @jean-luc", []string{"bob", "jean-luc"}},
+		{"cc @ana.b and @zoë", []string{"ana.b", "zoë"}},
+		{"@nobody is registered", nil},
+		{"an @ on its own", nil},
+		{strings.Repeat("@user0 ", 2) + "@user1 @user2 @user3 @user4 @user5 @user6 @user7 @user8 @user9 @user10 @user11",
+			[]string{"user0", "user1", "user2", "user3", "user4", "user5", "user6", "user7", "user8", "user9"}},
+	}
+	for _, test := range tests {
+		if got := s.mentionsIn(context.Background(), test.text); !slices.Equal(got, test.want) {
+			t.Errorf("%q mentions %q, want %q", test.text, got, test.want)
+		}
+	}
+}
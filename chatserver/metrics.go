@@ -0,0 +1,146 @@
+package chatserver
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"strconv"
+	"sync/atomic"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/collectors"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/status"
+)
+
+// serverMetrics are a Server's Prometheus metrics, kept in a registry of its own
+// so several servers can run in one process.
+type serverMetrics struct {
+	registry *prometheus.Registry
+
+	messagesBroadcast *prometheus.CounterVec
+	fanOutLatency     prometheus.Histogram
+	sendFailures      *prometheus.CounterVec
+	rpcLatency        *prometheus.HistogramVec
+
+	//running totals for the Admin service, which cannot read them back from Prometheus
+	messagesBroadcastCount atomic.Int64
+	sendFailureCount       atomic.Int64
+}
+
+func newServerMetrics(s *Server) *serverMetrics {
+	metrics := &serverMetrics{
+		registry: prometheus.NewRegistry(),
+
+		messagesBroadcast: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Name: "chitchat_messages_broadcast_total",
+			Help: "Messages accepted for fan-out, by whether a user or the server wrote them.",
+		}, []string{"author"}),
+
+		fanOutLatency: prometheus.NewHistogram(prometheus.HistogramOpts{
+			Name:    "chitchat_fanout_latency_seconds",
+			Help:    "Time from a message being queued for a recipient until it was sent on their stream.",
+			Buckets: prometheus.ExponentialBuckets(0.0005, 2, 14),
+		}),
+
+		sendFailures: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Name: "chitchat_send_failures_total",
+			Help: "Messages that never reached a recipient, by reason.",
+		}, []string{"reason"}),
+
+		rpcLatency: prometheus.NewHistogramVec(prometheus.HistogramOpts{
+			Name:    "chitchat_grpc_request_duration_seconds",
+			Help:    "Time spent handling gRPC calls, by method and status code. Join lasts as long as the user is connected.",
+			Buckets: prometheus.DefBuckets,
+		}, []string{"method", "code"}),
+	}
+	metrics.registry.MustRegister(
+		metrics.messagesBroadcast, metrics.fanOutLatency, metrics.sendFailures, metrics.rpcLatency,
+		stateCollector{server: s},
+		collectors.NewGoCollector(),
+		collectors.NewProcessCollector(collectors.ProcessCollectorOpts{}),
+	)
+	return metrics
+}
+
+// Gatherer returns the registry holding the server's metrics, for callers that serve metrics themselves.
+func (s *Server) Gatherer() prometheus.Gatherer {
+	return s.metrics.registry
+}
+
+func (metrics *serverMetrics) countBroadcast(author string) {
+	metrics.messagesBroadcast.WithLabelValues(author).Inc()
+	metrics.messagesBroadcastCount.Add(1)
+}
+
+func (metrics *serverMetrics) countSendFailure(reason string) {
+	metrics.sendFailures.WithLabelValues(reason).Inc()
+	metrics.sendFailureCount.Add(1)
+}
+
+// stateCollector reports the server's state as it is at scrape time.
+type stateCollector struct {
+	server *Server
+}
+
+var (
+	participantsDesc = prometheus.NewDesc("chitchat_participants", "Users currently connected.", nil, nil)
+	roomsDesc        = prometheus.NewDesc("chitchat_rooms", "Rooms with at least one user connected.", nil, nil)
+	queueDepthDesc   = prometheus.NewDesc("chitchat_stream_queue_depth", "Messages waiting to be sent to a user.", []string{"user_id", "room"}, nil)
+	lamportDesc      = prometheus.NewDesc("chitchat_lamport", "Current value of the server's Lamport clock.", nil, nil)
+)
+
+func (stateCollector) Describe(descs chan<- *prometheus.Desc) {
+	descs <- participantsDesc
+	descs <- roomsDesc
+	descs <- queueDepthDesc
+	descs <- lamportDesc
+}
+
+func (collector stateCollector) Collect(metrics chan<- prometheus.Metric) {
+	s := collector.server
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	rooms := make(map[string]bool)
+	for _, userStream := range s.userStreams {
+		rooms[userStream.Room] = true
+		metrics <- prometheus.MustNewConstMetric(queueDepthDesc, prometheus.GaugeValue,
+			float64(len(userStream.queue)), strconv.Itoa(int(userStream.UserId)), userStream.Room)
+	}
+	metrics <- prometheus.MustNewConstMetric(participantsDesc, prometheus.GaugeValue, float64(len(s.userStreams)))
+	metrics <- prometheus.MustNewConstMetric(roomsDesc, prometheus.GaugeValue, float64(len(rooms)))
+	metrics <- prometheus.MustNewConstMetric(lamportDesc, prometheus.GaugeValue, float64(s.lamport))
+}
+
+// unaryInterceptor records how long each unary call took.
+func (metrics *serverMetrics) unaryInterceptor(ctx context.Context, request any, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (any, error) {
+	start := time.Now()
+	response, err := handler(ctx, request)
+	metrics.rpcLatency.WithLabelValues(info.FullMethod, status.Code(err).String()).Observe(time.Since(start).Seconds())
+	return response, err
+}
+
+// streamInterceptor records how long each streaming call lasted.
+func (metrics *serverMetrics) streamInterceptor(server any, stream grpc.ServerStream, info *grpc.StreamServerInfo, handler grpc.StreamHandler) error {
+	start := time.Now()
+	err := handler(server, stream)
+	metrics.rpcLatency.WithLabelValues(info.FullMethod, status.Code(err).String()).Observe(time.Since(start).Seconds())
+	return err
+}
+
+// serveMetrics starts the /metrics endpoint. The returned server is shut down with the chat server.
+func (s *Server) serveMetrics(address string) *http.Server {
+	mux := http.NewServeMux()
+	mux.Handle("/metrics", promhttp.HandlerFor(s.metrics.registry, promhttp.HandlerOpts{}))
+	metricsServer := &http.Server{Addr: address, Handler: mux}
+	go func() {
+		err := metricsServer.ListenAndServe()
+		if err != nil && !errors.Is(err, http.ErrServerClosed) {
+			s.logger.Error("failed to serve metrics", "address", address, "error", err)
+		}
+	}()
+	return metricsServer
+}
@@ -0,0 +1,176 @@
+package chatserver
+
+import (
+	"crypto/ed25519"
+	"log/slog"
+	"net"
+	"time"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/credentials"
+	"google.golang.org/grpc/keepalive"
+)
+
+// Limits are the limits a Server enforces on its users. They can be changed
+// while the server runs with SetLimits.
+type Limits struct {
+	//longest message accepted, in characters
+	MaxMessageLength int
+	//most users connected at once, 0 for no limit
+	MaxParticipants int
+	//most users in a single room, 0 for no limit
+	MaxRoomParticipants int
+	//messages queued for a slow client before new ones are dropped
+	StreamQueueSize int
+	//how long a participant can do nothing before they show as idle, 0 to never
+	IdleTimeout time.Duration
+	//how many of a room's latest messages someone joining it is sent, 0 for none
+	HistoryReplay int
+	//how long direct messages wait for a recipient to acknowledge them, 0 for as long as it takes
+	MailboxRetention time.Duration
+	//most direct messages waiting for one recipient, 0 for no limit
+	MailboxSize int
+	//largest file users can share, in bytes, 0 to not let them share files
+	MaxAttachmentSize int64
+	//most messages search can find, the latest, 0 for no limit. Messages that do not last are
+	//found until they expire either way.
+	MaxIndexedMessages int
+	//how much of its history every room keeps, unless RoomRetention has one for it
+	Retention     Retention
+	RoomRetention map[string]Retention
+}
+
+// Retention is how much of a room's history the server keeps. Anything older is taken out of the
+// storage, the history and the search index by a background compactor. Zero values are no limit.
+type Retention struct {
+	//how long messages are kept after they were sent
+	MaxAge time.Duration
+	//how many of the latest messages are kept
+	MaxMessages int
+	//how many bytes the latest messages kept take up, with their edits, reactions and shared files
+	MaxBytes int64
+}
+
+// retentionFor returns how much of a room's history the server keeps.
+func (limits *Limits) retentionFor(room string) Retention {
+	if retention, ok := limits.RoomRetention[room]; ok {
+		return retention
+	}
+	return limits.Retention
+}
+
+// DefaultLimits are the limits a Server starts with unless WithLimits is given.
+var DefaultLimits = Limits{
+	MaxMessageLength:   128,
+	StreamQueueSize:    128,
+	IdleTimeout:        5 * time.Minute,
+	HistoryReplay:      50,
+	MailboxRetention:   30 * 24 * time.Hour,
+	MailboxSize:        100,
+	MaxAttachmentSize:  10 << 20,
+	MaxIndexedMessages: 100000,
+}
+
+// Option configures a Server built with New.
+type Option func(*options)
+
+type options struct {
+	addresses      []string
+	listeners      []net.Listener
+	adminAddress   string
+	metricsAddress string
+	serverOptions  []grpc.ServerOption
+	limits         Limits
+	logger         *slog.Logger
+	storage        Storage
+	signingKey     ed25519.PrivateKey
+	joinHooks      []JoinHook
+	broadcastHooks []BroadcastHook
+	reconnectDelay time.Duration
+	moderators     []string
+	blobDir        string
+}
+
+// WithAddresses makes Start listen for chat users on each of the TCP addresses.
+func WithAddresses(addresses ...string) Option {
+	return func(o *options) { o.addresses = append(o.addresses, addresses...) }
+}
+
+// WithListeners makes Start serve chat users on listeners the caller already opened.
+func WithListeners(listeners ...net.Listener) Option {
+	return func(o *options) { o.listeners = append(o.listeners, listeners...) }
+}
+
+// WithAdminAddress makes Start serve the Admin service on its own listener at address.
+func WithAdminAddress(address string) Option {
+	return func(o *options) { o.adminAddress = address }
+}
+
+// WithMetricsAddress makes Start serve Prometheus metrics at address/metrics.
+func WithMetricsAddress(address string) Option {
+	return func(o *options) { o.metricsAddress = address }
+}
+
+// WithTLS serves chat users over TLS with the given credentials.
+func WithTLS(creds credentials.TransportCredentials) Option {
+	return WithServerOptions(grpc.Creds(creds))
+}
+
+// WithKeepalive sets how the server pings idle clients and how often clients may ping it.
+func WithKeepalive(parameters keepalive.ServerParameters, policy keepalive.EnforcementPolicy) Option {
+	return WithServerOptions(grpc.KeepaliveParams(parameters), grpc.KeepaliveEnforcementPolicy(policy))
+}
+
+// WithServerOptions passes extra options to the gRPC server chat users connect to.
+func WithServerOptions(serverOptions ...grpc.ServerOption) Option {
+	return func(o *options) { o.serverOptions = append(o.serverOptions, serverOptions...) }
+}
+
+// WithLimits replaces DefaultLimits.
+func WithLimits(limits Limits) Option {
+	return func(o *options) { o.limits = limits }
+}
+
+// WithLogger sets the logger the server logs to. It defaults to slog.Default().
+func WithLogger(logger *slog.Logger) Option {
+	return func(o *options) { o.logger = logger }
+}
+
+// WithStorage sets where the server keeps registered keys, rooms, messages, receipts, undelivered
+// direct messages and its audit trail.
+// It defaults to a MemoryStorage. The server closes the storage when it stops.
+func WithStorage(storage Storage) Option {
+	return func(o *options) { o.storage = storage }
+}
+
+// WithSigningKey sets the key the server signs its own messages with.
+// A new key is generated if none is given.
+func WithSigningKey(key ed25519.PrivateKey) Option {
+	return func(o *options) { o.signingKey = key }
+}
+
+// WithJoinHook adds a hook every Join has to pass. Hooks run in the order they were added.
+func WithJoinHook(hook JoinHook) Option {
+	return func(o *options) { o.joinHooks = append(o.joinHooks, hook) }
+}
+
+// WithBroadcastHook adds a hook every Broadcast, Edit, reaction added, direct message and upload has to pass. Hooks run in the order they were added.
+func WithBroadcastHook(hook BroadcastHook) Option {
+	return func(o *options) { o.broadcastHooks = append(o.broadcastHooks, hook) }
+}
+
+// WithModerators lets the named users edit and delete anyone's messages, not just their own.
+// They can be changed while the server runs with SetModerators.
+func WithModerators(names ...string) Option {
+	return func(o *options) { o.moderators = append(o.moderators, names...) }
+}
+
+// WithBlobDir lets users share files, which the server keeps in dir. Without it the server refuses uploads.
+func WithBlobDir(dir string) Option {
+	return func(o *options) { o.blobDir = dir }
+}
+
+// WithReconnectDelay sets how long clients are told to wait before reconnecting after Stop.
+func WithReconnectDelay(delay time.Duration) Option {
+	return func(o *options) { o.reconnectDelay = delay }
+}
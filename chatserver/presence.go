@@ -0,0 +1,241 @@
+package chatserver
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+	"unicode/utf8"
+
+	chitchat "homework3/chitchat"
+
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/types/known/timestamppb"
+)
+
+// how often the idle watcher looks for participants who have gone quiet
+const idleCheckInterval = time.Second
+
+// participantState is what the server knows about a participant's presence in one room.
+type participantState struct {
+	presence chitchat.Presence
+	//what the participant said when they went away
+	status     string
+	lastActive time.Time
+}
+
+// roomPresence returns the state of a participant in a room, creating it if they have never been there.
+// It must be called with the mutex held.
+func (s *Server) roomPresence(room string, name string) *participantState {
+	inRoom, ok := s.presence[room]
+	if !ok {
+		inRoom = make(map[string]*participantState)
+		s.presence[room] = inRoom
+	}
+	state, ok := inRoom[name]
+	if !ok {
+		state = &participantState{presence: chitchat.Presence_OFFLINE}
+		inRoom[name] = state
+	}
+	return state
+}
+
+// markJoined makes a participant who just joined a room online, unless they are away
+// on another connection. It must be called with the mutex held.
+func (s *Server) markJoined(room string, name string) {
+	state := s.roomPresence(room, name)
+	if state.presence != chitchat.Presence_AWAY {
+		state.presence = chitchat.Presence_ONLINE
+		state.status = ""
+	}
+	state.lastActive = time.Now()
+}
+
+// markActive notes that a participant did something in a room. If that brings them back
+// from being idle it returns the PRESENCE message saying so, for the caller to send.
+// It must be called with the mutex held.
+func (s *Server) markActive(room string, name string) *chitchat.ServerMessage {
+	state, ok := s.presence[room][name]
+	if !ok || state.presence == chitchat.Presence_OFFLINE {
+		return nil
+	}
+	state.lastActive = time.Now()
+	if state.presence != chitchat.Presence_IDLE {
+		return nil
+	}
+	state.presence = chitchat.Presence_ONLINE
+	return s.presenceMessage(room, name)
+}
+
+// markGone makes a participant offline in the room of a connection that was just removed,
+// unless they are still connected to it from somewhere else. It returns true if they are offline now.
+// It must be called with the mutex held.
+func (s *Server) markGone(removed *connectedUser) bool {
+	for _, userStream := range s.userStreams {
+		if userStream.Room == removed.Room && userStream.Name == removed.Name {
+			return false
+		}
+	}
+	state := s.roomPresence(removed.Room, removed.Name)
+	state.presence = chitchat.Presence_OFFLINE
+	state.status = ""
+	return true
+}
+
+// presenceMessage stamps a PRESENCE message with a participant's current presence.
+// It must be called with the mutex held.
+func (s *Server) presenceMessage(room string, name string) *chitchat.ServerMessage {
+	state := s.roomPresence(room, name)
+	var text string
+	switch state.presence {
+	case chitchat.Presence_ONLINE:
+		text = fmt.Sprintf("%s is back", name)
+	case chitchat.Presence_AWAY:
+		text = fmt.Sprintf("%s is away", name)
+		if state.status != "" {
+			text += ": " + state.status
+		}
+	case chitchat.Presence_IDLE:
+		text = fmt.Sprintf("%s is idle", name)
+	case chitchat.Presence_OFFLINE:
+		text = fmt.Sprintf("%s went offline", name)
+	}
+	return s.stampServerMessage(&chitchat.ServerMessage{
+		Text:     text,
+		Room:     room,
+		Kind:     chitchat.ServerMessage_PRESENCE,
+		Subject:  name,
+		Presence: state.presence,
+		Status:   state.status,
+	})
+}
+
+// participants lists who is in a room, and who has been, sorted by name.
+// It must be called with the mutex held.
+func (s *Server) participants(room string, includeOffline bool) []*chitchat.Participant {
+	var participants []*chitchat.Participant
+	for name, state := range s.presence[room] {
+		if state.presence == chitchat.Presence_OFFLINE && !includeOffline {
+			continue
+		}
+		participants = append(participants, &chitchat.Participant{
+			Name:       name,
+			Presence:   state.presence,
+			Status:     state.status,
+			LastActive: timestamppb.New(state.lastActive),
+		})
+	}
+	sort.Slice(participants, func(i, j int) bool { return participants[i].Name < participants[j].Name })
+	return participants
+}
+
+// participantsMessage stamps the PARTICIPANTS message a user gets right after joining a room.
+// It must be called with the mutex held.
+func (s *Server) participantsMessage(room string) *chitchat.ServerMessage {
+	participants := s.participants(room, false)
+	var names []string
+	for _, participant := range participants {
+		if participant.Presence == chitchat.Presence_ONLINE {
+			names = append(names, participant.Name)
+		} else {
+			names = append(names, fmt.Sprintf("%s (%s)", participant.Name, strings.ToLower(participant.Presence.String())))
+		}
+	}
+	return s.stampServerMessage(&chitchat.ServerMessage{
+		Text:         fmt.Sprintf("In %s: %s", room, strings.Join(names, ", ")),
+		Room:         room,
+		Kind:         chitchat.ServerMessage_PARTICIPANTS,
+		Participants: participants,
+	})
+}
+
+// ListParticipants returns who is in a room and whether they are around.
+func (s *Server) ListParticipants(ctx context.Context, request *chitchat.ListParticipantsRequest) (*chitchat.ListParticipantsResponse, error) {
+	room := request.Room
+	if room == "" {
+		room = chitchat.DefaultRoom
+	}
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	return &chitchat.ListParticipantsResponse{Participants: s.participants(room, request.IncludeOffline)}, nil
+}
+
+// SetPresence lets a participant say they are away, or back. Everyone in the room is told.
+func (s *Server) SetPresence(ctx context.Context, update *chitchat.PresenceUpdate) (*chitchat.Confirmation, error) {
+	if update.Presence != chitchat.Presence_ONLINE && update.Presence != chitchat.Presence_AWAY {
+		return nil, status.Error(codes.InvalidArgument, "you can only set yourself online or away")
+	}
+	authorKey, err := s.authorKey(ctx, update.Name)
+	if err != nil {
+		return nil, err
+	}
+	if !update.Verify(authorKey) {
+		return nil, status.Errorf(codes.Unauthenticated, "presence signature does not match the key registered to %q", update.Name)
+	}
+	if err := s.checkFresh(update.Name, update.Room, update.Lamport, update.Signature); err != nil {
+		return nil, err
+	}
+	if maxLength := s.currentLimits().MaxMessageLength; utf8.RuneCountInString(update.Status) > maxLength {
+		return nil, status.Errorf(codes.InvalidArgument, "away messages must be no longer than %d characters", maxLength)
+	}
+	room := update.Room
+	if room == "" {
+		room = chitchat.DefaultRoom
+	}
+
+	s.mutex.Lock()
+	state, ok := s.presence[room][update.Name]
+	if !ok || state.presence == chitchat.Presence_OFFLINE {
+		s.mutex.Unlock()
+		return nil, status.Errorf(codes.FailedPrecondition, "%q is not in room %q", update.Name, room)
+	}
+	s.lamport = max(s.lamport, update.Lamport)
+	state.lastActive = time.Now()
+	if state.presence == update.Presence && state.status == update.Status {
+		s.mutex.Unlock()
+		return &chitchat.Confirmation{}, nil
+	}
+	state.presence = update.Presence
+	state.status = update.Status
+	presenceMessage := s.presenceMessage(room, update.Name)
+	s.mutex.Unlock()
+
+	loggerFrom(ctx, s.logger).Info("presence changed", "user", update.Name, "room", room, "presence", update.Presence.String())
+	s.sendAnnouncement(ctx, presenceMessage)
+	return &chitchat.Confirmation{}, nil
+}
+
+// watchIdle marks participants idle once they have done nothing for the idle timeout,
+// until Stop is called.
+func (s *Server) watchIdle() {
+	ticker := time.NewTicker(idleCheckInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-s.stopped:
+			return
+		case <-ticker.C:
+		}
+		idleTimeout := s.currentLimits().IdleTimeout
+		if idleTimeout <= 0 {
+			continue
+		}
+
+		var idleMessages []*chitchat.ServerMessage
+		s.mutex.Lock()
+		for room, inRoom := range s.presence {
+			for name, state := range inRoom {
+				if state.presence == chitchat.Presence_ONLINE && time.Since(state.lastActive) >= idleTimeout {
+					state.presence = chitchat.Presence_IDLE
+					idleMessages = append(idleMessages, s.presenceMessage(room, name))
+				}
+			}
+		}
+		s.mutex.Unlock()
+		for _, idleMessage := range idleMessages {
+			s.sendAnnouncement(context.Background(), idleMessage)
+		}
+	}
+}
@@ -0,0 +1,154 @@
+package chatserver
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"unicode"
+	"unicode/utf8"
+
+	chitchat "homework3/chitchat"
+
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+const (
+	// longest reaction accepted, in characters. Some emoji are several joined together.
+	maxEmojiLength = 16
+	// most different reactions one message can have
+	maxReactionKinds = 20
+)
+
+// reaction is everyone who reacted to a message with one emoji, in the order they did.
+type reaction struct {
+	emoji string
+	names []string
+}
+
+// react adds or takes back name's reaction to the message, returning false if that changes nothing.
+func (stored *storedMessage) react(emoji string, name string, remove bool) (bool, error) {
+	for i, existing := range stored.reactions {
+		if existing.emoji != emoji {
+			continue
+		}
+		for j, reacted := range existing.names {
+			if reacted != name {
+				continue
+			}
+			if !remove {
+				return false, nil
+			}
+			existing.names = append(existing.names[:j:j], existing.names[j+1:]...)
+			if len(existing.names) == 0 {
+				stored.reactions = append(stored.reactions[:i:i], stored.reactions[i+1:]...)
+			}
+			return true, nil
+		}
+		if remove {
+			return false, nil
+		}
+		existing.names = append(existing.names, name)
+		return true, nil
+	}
+	if remove {
+		return false, nil
+	}
+	if len(stored.reactions) >= maxReactionKinds {
+		return false, status.Errorf(codes.ResourceExhausted, "a message can have at most %d different reactions", maxReactionKinds)
+	}
+	stored.reactions = append(stored.reactions, &reaction{emoji: emoji, names: []string{name}})
+	return true, nil
+}
+
+// reactionsProto counts the message's reactions for a REACTIONS message.
+func (stored *storedMessage) reactionsProto() []*chitchat.Reaction {
+	reactions := make([]*chitchat.Reaction, 0, len(stored.reactions))
+	for _, existing := range stored.reactions {
+		reactions = append(reactions, &chitchat.Reaction{
+			Emoji: existing.emoji,
+			Count: int32(len(existing.names)),
+			Names: append([]string(nil), existing.names...),
+		})
+	}
+	return reactions
+}
+
+// reactionsFrom turns the reactions of a REACTIONS message back into the ones a message has.
+func reactionsFrom(reactions []*chitchat.Reaction) []*reaction {
+	restored := make([]*reaction, 0, len(reactions))
+	for _, existing := range reactions {
+		restored = append(restored, &reaction{emoji: existing.Emoji, names: append([]string(nil), existing.Names...)})
+	}
+	return restored
+}
+
+// React adds a reaction to a message, or takes one back. Everyone in the room is sent a REACTIONS
+// message with every reaction the message has now.
+func (s *Server) React(ctx context.Context, update *chitchat.ReactionUpdate) (*chitchat.Confirmation, error) {
+	if update.Emoji == "" || utf8.RuneCountInString(update.Emoji) > maxEmojiLength || strings.ContainsFunc(update.Emoji, unicode.IsSpace) {
+		return nil, status.Errorf(codes.InvalidArgument, "a reaction must be one emoji or word of at most %d characters", maxEmojiLength)
+	}
+	authorKey, err := s.authorKey(ctx, update.Name)
+	if err != nil {
+		return nil, err
+	}
+	if !update.Verify(authorKey) {
+		return nil, status.Errorf(codes.Unauthenticated, "reaction signature does not match the key registered to %q", update.Name)
+	}
+	if err := s.checkFresh(update.Name, update.Room, update.Lamport, update.Signature); err != nil {
+		return nil, err
+	}
+	if !update.Remove {
+		if err := s.runTextHooks(ctx, update.Name, update.Room, update.Emoji, update.Lamport); err != nil {
+			return nil, err
+		}
+	}
+	room := update.Room
+	if room == "" {
+		room = chitchat.DefaultRoom
+	}
+
+	s.mutex.Lock()
+	stored, ok := s.messages[update.MessageId]
+	if !ok || stored.room != room {
+		s.mutex.Unlock()
+		return nil, status.Errorf(codes.NotFound, "there is no message %q in room %q, or it is too old to react to", update.MessageId, room)
+	}
+	if stored.deletion != nil {
+		s.mutex.Unlock()
+		return nil, status.Errorf(codes.FailedPrecondition, "message %q was deleted", update.MessageId)
+	}
+	changed, err := stored.react(update.Emoji, update.Name, update.Remove)
+	if err != nil {
+		s.mutex.Unlock()
+		return nil, err
+	}
+	s.lamport = max(s.lamport, update.Lamport)
+	if !changed {
+		lamport := s.lamport
+		s.mutex.Unlock()
+		return &chitchat.Confirmation{MessageId: stored.id, Lamport: lamport}, nil
+	}
+	text := fmt.Sprintf("%s reacted with %s", update.Name, update.Emoji)
+	if update.Remove {
+		text = fmt.Sprintf("%s took back %s", update.Name, update.Emoji)
+	}
+	reactionsMessage := s.stampServerMessage(&chitchat.ServerMessage{
+		Text:      text,
+		Room:      room,
+		Kind:      chitchat.ServerMessage_REACTIONS,
+		Subject:   update.Name,
+		Target:    stored.id,
+		Reactions: stored.reactionsProto(),
+		Emoji:     update.Emoji,
+		Removed:   update.Remove,
+	})
+	s.remember(reactionsMessage)
+	stored.lastReactions = s.history[room].log[len(s.history[room].log)-1]
+	s.mutex.Unlock()
+
+	loggerFrom(ctx, s.logger).Info("reaction", "id", stored.id, "user", update.Name, "room", room, "emoji", update.Emoji, "removed", update.Remove, "lamport", reactionsMessage.Lamport)
+	s.sendAnnouncement(ctx, reactionsMessage)
+	return &chitchat.Confirmation{MessageId: stored.id, Lamport: reactionsMessage.Lamport}, nil
+}
@@ -0,0 +1,63 @@
+package chatserver
+
+import (
+	"fmt"
+	"reflect"
+	"testing"
+
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+func TestReact(t *testing.T) {
+	stored := &storedMessage{}
+	steps := []struct {
+		name    string
+		emoji   string
+		remove  bool
+		changed bool
+		want    map[string][]string
+	}{
+		{"alice", "👍", false, true, map[string][]string{"👍": {"alice"}}},
+		{"alice", "👍", false, false, map[string][]string{"👍": {"alice"}}},
+		{"bob", "👍", false, true, map[string][]string{"👍": {"alice", "bob"}}},
+		{"bob", "🎉", false, true, map[string][]string{"👍": {"alice", "bob"}, "🎉": {"bob"}}},
+		{"carol", "👍", true, false, map[string][]string{"👍": {"alice", "bob"}, "🎉": {"bob"}}},
+		{"alice", "👍", true, true, map[string][]string{"👍": {"bob"}, "🎉": {"bob"}}},
+		{"bob", "🎉", true, true, map[string][]string{"👍": {"bob"}}},
+		{"bob", "🎉", true, false, map[string][]string{"👍": {"bob"}}},
+	}
+	for i, step := range steps {
+		changed, err := stored.react(step.emoji, step.name, step.remove)
+		if err != nil {
+			t.Fatalf("step %d: %v", i, err)
+		}
+		got := make(map[string][]string)
+		for _, reaction := range stored.reactionsProto() {
+			if int(reaction.Count) != len(reaction.Names) {
+				t.Errorf("step %d: %s is counted %d times by %q", i, reaction.Emoji, reaction.Count, reaction.Names)
+			}
+			got[reaction.Emoji] = reaction.Names
+		}
+		if changed != step.changed || !reflect.DeepEqual(got, step.want) {
+			t.Errorf("step %d: %s reacting with %s (remove %v) changed %v and left %q, want %v and %q",
+				i, step.name, step.emoji, step.remove, changed, got, step.changed, step.want)
+		}
+	}
+}
+
+func TestReactionKindsAreLimited(t *testing.T) {
+	stored := &storedMessage{}
+	for i := 0; i < maxReactionKinds; i++ {
+		if _, err := stored.react(fmt.Sprint(i), "alice", false); err != nil {
+			t.Fatalf("reaction %d: %v", i, err)
+		}
+	}
+	if _, err := stored.react("one more", "alice", false); status.Code(err) != codes.ResourceExhausted {
+		t.Errorf("one reaction too many: %v, want ResourceExhausted", err)
+	}
+	//reacting like someone else already did is still fine.
+	if changed, err := stored.react("0", "bob", false); err != nil || !changed {
+		t.Errorf("joining an existing reaction: changed %v, %v", changed, err)
+	}
+}
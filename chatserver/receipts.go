@@ -0,0 +1,223 @@
+package chatserver
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"slices"
+	"sort"
+	"time"
+
+	chitchat "homework3/chitchat"
+
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/types/known/timestamppb"
+)
+
+const (
+	// how many recent messages the server keeps receipts for. Receipts for older ones are ignored.
+	receiptsKept = 10000
+	// most messages one Acknowledge call can acknowledge
+	maxReceiptBatch = 256
+	// how many random bytes a message id has, enough that nobody can guess the ids of messages
+	messageIDBytes = 16
+)
+
+// newMessageID makes up an id for a message that no message the server remembers has.
+// It must be called with the mutex held.
+func (s *Server) newMessageID() string {
+	for {
+		bytes := make([]byte, messageIDBytes)
+		rand.Read(bytes)
+		id := hex.EncodeToString(bytes)
+		_, tracked := s.receipts[id]
+		_, stored := s.messages[id]
+		_, indexed := s.index.documents[id]
+		if !tracked && !stored && !indexed {
+			return id
+		}
+	}
+}
+
+// trackReceipts starts collecting receipts for a message that is about to be sent,
+// forgetting the oldest message once receiptsKept are tracked. It must be called with the mutex held.
+func (s *Server) trackReceipts(message *chitchat.ServerMessage) {
+	recipients := []string{}
+	for _, userStream := range s.userStreams {
+		if userStream.Room == message.Room && userStream.Name != message.Name && !slices.Contains(recipients, userStream.Name) {
+			recipients = append(recipients, userStream.Name)
+		}
+	}
+	slices.Sort(recipients)
+	tracked := &Receipts{
+		MessageID:      message.Id,
+		Author:         message.Name,
+		Room:           message.Room,
+		Recipients:     len(recipients),
+		RecipientNames: recipients,
+		Delivered:      make(map[string]time.Time),
+		Read:           make(map[string]time.Time),
+	}
+	s.keepReceipts(tracked)
+	s.saveReceipts(tracked)
+}
+
+// keepReceipts adds receipts to the ones tracked, forgetting the oldest message once receiptsKept
+// are tracked. It must be called with the mutex held.
+func (s *Server) keepReceipts(tracked *Receipts) {
+	s.receipts[tracked.MessageID] = tracked
+	s.receiptOrder = append(s.receiptOrder, tracked.MessageID)
+	if len(s.receiptOrder) > receiptsKept {
+		oldest := s.receiptOrder[0]
+		delete(s.receipts, oldest)
+		s.receiptOrder = s.receiptOrder[1:]
+		if err := s.storage.RemoveReceipts([]string{oldest}); err != nil {
+			s.logger.Error("could not remove receipts", "id", oldest, "error", err)
+		}
+	}
+}
+
+// saveReceipts keeps the receipts of a message in the storage, so they outlast a restart.
+// A failure is only logged. It must be called with the mutex held, which keeps the saves in order.
+func (s *Server) saveReceipts(tracked *Receipts) {
+	if err := s.storage.SaveReceipts(*tracked); err != nil {
+		s.logger.Error("could not save receipts", "id", tracked.MessageID, "error", err)
+	}
+}
+
+// loadReceipts tracks the receipts the storage kept again.
+func (s *Server) loadReceipts() error {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	return s.storage.Receipts(func(receipts Receipts) bool {
+		s.keepReceipts(&receipts)
+		return true
+	})
+}
+
+// sentTo reports whether name was in the room when the message was sent. Receipts kept before the
+// recipients were named only say how many there were, so anyone counts until that many have it.
+func (tracked *Receipts) sentTo(name string) bool {
+	if tracked.RecipientNames == nil {
+		_, delivered := tracked.Delivered[name]
+		return delivered || len(tracked.Delivered) < tracked.Recipients
+	}
+	return slices.Contains(tracked.RecipientNames, name)
+}
+
+// record notes that name received or read the message, returning false if that was known already.
+// Reading a message means it was received too.
+func (tracked *Receipts) record(kind chitchat.ReceiptKind, name string, at time.Time) bool {
+	changed := false
+	if _, ok := tracked.Delivered[name]; !ok {
+		tracked.Delivered[name] = at
+		changed = true
+	}
+	if _, ok := tracked.Read[name]; !ok && kind == chitchat.ReceiptKind_READ {
+		tracked.Read[name] = at
+		changed = true
+	}
+	return changed
+}
+
+// proto turns the receipts into their message, oldest first.
+func (tracked *Receipts) proto() *chitchat.Receipts {
+	return &chitchat.Receipts{
+		MessageId:  tracked.MessageID,
+		Recipients: int32(tracked.Recipients),
+		Delivered:  receiptEntries(tracked.Delivered),
+		Read:       receiptEntries(tracked.Read),
+	}
+}
+
+func receiptEntries(receipts map[string]time.Time) []*chitchat.ReceiptEntry {
+	entries := make([]*chitchat.ReceiptEntry, 0, len(receipts))
+	for name, at := range receipts {
+		entries = append(entries, &chitchat.ReceiptEntry{Name: name, At: timestamppb.New(at)})
+	}
+	sort.Slice(entries, func(i, j int) bool { return entries[i].At.AsTime().Before(entries[j].At.AsTime()) })
+	return entries
+}
+
+// Acknowledge records that a participant received or read messages in a room, and tells the
+// authors. Messages the server does not remember, that are not in the room, or that were not sent
+// to the participant, like ones said before they joined, are skipped.
+func (s *Server) Acknowledge(ctx context.Context, receipt *chitchat.Receipt) (*chitchat.Confirmation, error) {
+	if receipt.Kind != chitchat.ReceiptKind_DELIVERED && receipt.Kind != chitchat.ReceiptKind_READ {
+		return nil, status.Error(codes.InvalidArgument, "unknown kind of receipt")
+	}
+	if len(receipt.MessageIds) > maxReceiptBatch {
+		return nil, status.Errorf(codes.InvalidArgument, "at most %d messages can be acknowledged at once", maxReceiptBatch)
+	}
+	authorKey, err := s.authorKey(ctx, receipt.Name)
+	if err != nil {
+		return nil, err
+	}
+	if !receipt.Verify(authorKey) {
+		return nil, status.Errorf(codes.Unauthenticated, "receipt signature does not match the key registered to %q", receipt.Name)
+	}
+	if err := s.checkFresh(receipt.Name, receipt.Room, receipt.Lamport, receipt.Signature); err != nil {
+		return nil, err
+	}
+	room := receipt.Room
+	if room == "" {
+		room = chitchat.DefaultRoom
+	}
+
+	//receipts are not part of the room's history, so like typing they do not advance the Lamport time.
+	type notice struct {
+		author  string
+		message *chitchat.ServerMessage
+	}
+	var notices []notice
+	now := time.Now()
+	s.mutex.Lock()
+	for _, id := range receipt.MessageIds {
+		tracked, ok := s.receipts[id]
+		if !ok || tracked.Room != room || tracked.Author == receipt.Name || !tracked.sentTo(receipt.Name) {
+			continue
+		}
+		if tracked.record(receipt.Kind, receipt.Name, now) {
+			s.saveReceipts(tracked)
+			notices = append(notices, notice{author: tracked.Author, message: s.signServerMessage(&chitchat.ServerMessage{
+				Lamport:  s.lamport,
+				Room:     room,
+				Kind:     chitchat.ServerMessage_RECEIPTS,
+				Subject:  receipt.Name,
+				Receipts: tracked.proto(),
+			}, s.lamport)})
+		}
+	}
+	s.mutex.Unlock()
+
+	loggerFrom(ctx, s.logger).Debug("receipt", "user", receipt.Name, "room", room, "kind", receipt.Kind.String(), "messages", len(receipt.MessageIds))
+	for _, notice := range notices {
+		s.sendToParticipant(ctx, notice.message, notice.author)
+	}
+	return &chitchat.Confirmation{}, nil
+}
+
+// GetReceipts tells the author of a message who has received and read it.
+func (s *Server) GetReceipts(ctx context.Context, request *chitchat.ReceiptsRequest) (*chitchat.Receipts, error) {
+	authorKey, err := s.authorKey(ctx, request.Name)
+	if err != nil {
+		return nil, err
+	}
+	if !request.Verify(authorKey) {
+		return nil, status.Errorf(codes.Unauthenticated, "request signature does not match the key registered to %q", request.Name)
+	}
+	if err := s.checkFresh(request.Name, "", request.Lamport, request.Signature); err != nil {
+		return nil, err
+	}
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	tracked, ok := s.receipts[request.MessageId]
+	if !ok {
+		return nil, status.Errorf(codes.NotFound, "no receipts for message %q", request.MessageId)
+	}
+	if tracked.Author != request.Name {
+		return nil, status.Error(codes.PermissionDenied, "only the author can see who has read a message")
+	}
+	return tracked.proto(), nil
+}
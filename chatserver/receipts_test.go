@@ -0,0 +1,96 @@
+package chatserver
+
+import (
+	"context"
+	"crypto/ed25519"
+	"reflect"
+	"sort"
+	"testing"
+	"time"
+
+	chitchat "homework3/chitchat"
+)
+
+func TestNewMessageID(t *testing.T) {
+	s, err := New(WithStorage(NewMemoryStorage()))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer s.Stop(context.Background())
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	seen := make(map[string]bool)
+	for i := 0; i < 1000; i++ {
+		id := s.newMessageID()
+		if len(id) != 2*messageIDBytes || !validBlobID(id) {
+			t.Fatalf("message id %q is not %d random bytes in hex", id, messageIDBytes)
+		}
+		if seen[id] {
+			t.Fatalf("message id %q was made up twice", id)
+		}
+		seen[id] = true
+	}
+}
+
+func TestOnlyRecipientsAcknowledge(t *testing.T) {
+	ctx := context.Background()
+	storage := NewMemoryStorage()
+	keys := make(map[string]ed25519.PrivateKey)
+	for _, name := range []string{"alice", "bob", "mallory"} {
+		public, key, err := ed25519.GenerateKey(nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err := storage.RegisterPublicKey(name, public); err != nil {
+			t.Fatal(err)
+		}
+		keys[name] = key
+	}
+	s, err := New(WithStorage(storage))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer s.Stop(ctx)
+
+	s.mutex.Lock()
+	//sent when only bob was in the room with alice.
+	s.keepReceipts(&Receipts{MessageID: "0a", Author: "alice", Room: chitchat.DefaultRoom, Recipients: 1, RecipientNames: []string{"bob"},
+		Delivered: make(map[string]time.Time), Read: make(map[string]time.Time)})
+	//kept before recipients were named, when one other was in the room.
+	s.keepReceipts(&Receipts{MessageID: "0b", Author: "alice", Room: chitchat.DefaultRoom, Recipients: 1,
+		Delivered: make(map[string]time.Time), Read: make(map[string]time.Time)})
+	s.mutex.Unlock()
+
+	lamport := int32(0)
+	acknowledge := func(name string, kind chitchat.ReceiptKind, ids ...string) {
+		t.Helper()
+		lamport++
+		receipt := &chitchat.Receipt{Name: name, Kind: kind, MessageIds: ids, Lamport: lamport}
+		receipt.Sign(keys[name])
+		if _, err := s.Acknowledge(ctx, receipt); err != nil {
+			t.Fatal(err)
+		}
+	}
+	acknowledge("mallory", chitchat.ReceiptKind_READ, "0a", "0b")
+	acknowledge("alice", chitchat.ReceiptKind_READ, "0a", "0b")
+	acknowledge("bob", chitchat.ReceiptKind_READ, "0a", "0b")
+	acknowledge("mallory", chitchat.ReceiptKind_READ, "0a", "0b")
+
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	readers := func(id string) []string {
+		var names []string
+		for name := range s.receipts[id].Read {
+			names = append(names, name)
+		}
+		sort.Strings(names)
+		return names
+	}
+	if got := readers("0a"); !reflect.DeepEqual(got, []string{"bob"}) {
+		t.Errorf("0a was read by %q, want only its recipient bob", got)
+	}
+	//the first to acknowledge the old message takes its one recipient's place.
+	if got := readers("0b"); !reflect.DeepEqual(got, []string{"mallory"}) || len(s.receipts["0b"].Delivered) != 1 {
+		t.Errorf("the old message was read by %q, want only mallory", got)
+	}
+}
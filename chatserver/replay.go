@@ -0,0 +1,67 @@
+package chatserver
+
+import (
+	chitchat "homework3/chitchat"
+
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+// how far, in Lamport time, a signed request may be behind the latest one accepted from its author
+// in the same room. Requests do not always arrive in the order they were signed: a client sends
+// receipts, typing updates and messages from different goroutines, and a user can be in a room
+// from more than one place.
+const replayWindow = 10000
+
+// signer is an author in a room, whose signed requests are checked against each other.
+type signer struct {
+	name string
+	room string
+}
+
+// signedRequests is what the server remembers of the signed requests it accepted from a signer.
+type signedRequests struct {
+	//the latest Lamport time accepted
+	latest int32
+	//the signatures accepted within replayWindow of latest, and the Lamport times they were signed at
+	signatures map[string]int32
+	//how many signatures were left the last time the old ones were dropped
+	kept int
+}
+
+// checkFresh turns away a signed request the server has accepted before, so nobody can send one
+// they saw again. Signatures are only remembered within replayWindow, so requests signed longer
+// before the latest one accepted from their author in the room are turned away too, as are
+// requests signed before the server started, since it does not know which of those it accepted.
+// room is the room the request is about, and empty for requests about no room.
+// It must be called once the signature is verified, and only once for each request.
+func (s *Server) checkFresh(name string, room string, lamport int32, signature []byte) error {
+	if room == "" {
+		room = chitchat.DefaultRoom
+	}
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	key := signer{name: name, room: room}
+	seen, ok := s.signedRequests[key]
+	if !ok {
+		seen = &signedRequests{latest: s.startLamport, signatures: make(map[string]int32)}
+		s.signedRequests[key] = seen
+	}
+	if lamport <= s.startLamport || lamport <= seen.latest-replayWindow {
+		return status.Errorf(codes.FailedPrecondition, "the request was signed at Lamport time %d, which is too long ago; sign it again", lamport)
+	}
+	if _, ok := seen.signatures[string(signature)]; ok {
+		return status.Error(codes.AlreadyExists, "the request was already accepted once")
+	}
+	seen.signatures[string(signature)] = lamport
+	seen.latest = max(seen.latest, lamport)
+	if len(seen.signatures) > 2*seen.kept+64 {
+		for signature, signed := range seen.signatures {
+			if signed <= seen.latest-replayWindow {
+				delete(seen.signatures, signature)
+			}
+		}
+		seen.kept = len(seen.signatures)
+	}
+	return nil
+}
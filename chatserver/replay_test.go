@@ -0,0 +1,57 @@
+package chatserver
+
+import (
+	"context"
+	"crypto/ed25519"
+	"testing"
+
+	chitchat "homework3/chitchat"
+
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+func TestReplayedRequests(t *testing.T) {
+	ctx := context.Background()
+	storage := NewMemoryStorage()
+	public, key, err := ed25519.GenerateKey(nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := storage.RegisterPublicKey("alice", public); err != nil {
+		t.Fatal(err)
+	}
+	//the server starts after something was said at Lamport time 5.
+	if err := storage.AppendMessage(&chitchat.ServerMessage{Id: "1", Room: chitchat.DefaultRoom, Lamport: 5, Kind: chitchat.ServerMessage_CHAT}); err != nil {
+		t.Fatal(err)
+	}
+	s, err := New(WithStorage(storage))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer s.Stop(ctx)
+
+	broadcast := func(text string, lamport int32) error {
+		message := &chitchat.ClientMessage{Name: "alice", Text: text, Lamport: lamport}
+		message.Sign(key)
+		_, err := s.Broadcast(ctx, message)
+		return err
+	}
+	for _, test := range []struct {
+		text    string
+		lamport int32
+		want    codes.Code
+	}{
+		{"from before the server started", 5, codes.FailedPrecondition},
+		{"hello", 20, codes.OK},
+		{"hello", 20, codes.AlreadyExists},
+		{"sent at the same time from elsewhere", 20, codes.OK},
+		{"a while later", 20 + replayWindow, codes.OK},
+		{"signed earlier, but not too long ago", 21, codes.OK},
+		{"signed too long ago", 20, codes.FailedPrecondition},
+	} {
+		if got := status.Code(broadcast(test.text, test.lamport)); got != test.want {
+			t.Errorf("broadcasting %q at Lamport time %d: %v, want %v", test.text, test.lamport, got, test.want)
+		}
+	}
+}
@@ -0,0 +1,218 @@
+package chatserver
+
+import (
+	"context"
+	"slices"
+	"time"
+
+	chitchat "homework3/chitchat"
+
+	"google.golang.org/protobuf/proto"
+)
+
+// how often the compactor enforces the rooms' retention
+const compactInterval = time.Minute
+
+// scheduleExpiry deletes a message that does not last once its time is up, right away if it is already.
+func (s *Server) scheduleExpiry(message *chitchat.ServerMessage) {
+	if message.ExpiresAt == nil {
+		return
+	}
+	id := message.Id
+	time.AfterFunc(time.Until(message.ExpiresAt.AsTime()), func() { s.expire(id) })
+}
+
+// scheduleExpiries schedules the deletion of every message kept that does not last, as if the server
+// had never stopped.
+func (s *Server) scheduleExpiries() {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	for _, doc := range s.index.documents {
+		s.scheduleExpiry(doc.message)
+	}
+}
+
+// expire deletes a message whose time is up for everyone in its room, unless it is gone already.
+func (s *Server) expire(id string) {
+	select {
+	case <-s.stopped:
+		return
+	default:
+	}
+	s.mutex.Lock()
+	doc, ok := s.index.documents[id]
+	if !ok {
+		s.mutex.Unlock()
+		return
+	}
+	deleteMessage := s.stampServerMessage(&chitchat.ServerMessage{
+		Text:    "message expired",
+		Room:    doc.message.Room,
+		Kind:    chitchat.ServerMessage_DELETED,
+		Subject: ServerName,
+		Target:  id,
+	})
+	s.erase(id, deleteMessage)
+	s.mutex.Unlock()
+
+	s.logger.Info("message expired", "id", id, "author", doc.message.Name, "room", deleteMessage.Room, "lamport", deleteMessage.Lamport)
+	if doc.message.Attachment != nil {
+		s.removeFiles([]string{id})
+	}
+	s.sendAnnouncement(context.Background(), deleteMessage)
+}
+
+// compactMessages enforces the rooms' retention every compactInterval, until Stop is called.
+func (s *Server) compactMessages() {
+	ticker := time.NewTicker(compactInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-s.stopped:
+			return
+		case <-ticker.C:
+		}
+		removed, err := s.compact(time.Now())
+		if err != nil {
+			s.logger.Error("could not compact the stored messages", "error", err)
+		} else if removed > 0 {
+			s.logger.Info("compacted the stored messages", "removed", removed)
+		}
+	}
+}
+
+// compact takes the messages the rooms' retention no longer allows out of the storage, the history
+// and the search index, with their edits, reactions and deletion, and deletes the files they share.
+// Nobody is told, since they were only history. It also drops from the storage what nobody can see
+// anymore: what deleted messages said and how they were edited, reactions newer ones replaced, and
+// files deleted messages shared that are still there, like ones that could not be deleted before.
+// It returns how many messages it took out.
+func (s *Server) compact(now time.Time) (int, error) {
+	limits := s.currentLimits()
+	var stored []*chitchat.ServerMessage
+	rooms := make(map[string][]*chitchat.ServerMessage)
+	//how many bytes each message takes up with everything about it, by id
+	sizes := make(map[string]int64)
+	deleted := make(map[string]bool)
+	latestReactions := make(map[string]int32)
+	err := s.storage.Messages(func(message *chitchat.ServerMessage) bool {
+		stored = append(stored, message)
+		id := targetOf(message)
+		sizes[id] += int64(proto.Size(message))
+		switch {
+		case isChat(message):
+			rooms[message.Room] = append(rooms[message.Room], message)
+			if message.Attachment != nil {
+				sizes[id] += message.Attachment.Size
+			}
+		case message.Kind == chitchat.ServerMessage_DELETED:
+			deleted[id] = true
+		case message.Kind == chitchat.ServerMessage_REACTIONS:
+			latestReactions[id] = message.Lamport
+		}
+		return true
+	})
+	if err != nil {
+		return 0, err
+	}
+
+	var deletedFiles []string
+	for _, messages := range rooms {
+		for _, message := range messages {
+			if message.Attachment != nil && deleted[message.Id] {
+				deletedFiles = append(deletedFiles, message.Id)
+			}
+		}
+	}
+	s.removeFiles(deletedFiles)
+
+	dropped := make(map[string]bool)
+	var files []string
+	for room, messages := range rooms {
+		retention := limits.retentionFor(room)
+		var count int
+		var bytes int64
+		//newest first, so the count and the bytes go to the latest messages.
+		for i := len(messages) - 1; i >= 0; i-- {
+			message := messages[i]
+			count++
+			bytes += sizes[message.Id]
+			//imported messages are as old as the import.
+			sentAt := message.SentAt
+			if message.ImportedAt != nil {
+				sentAt = message.ImportedAt
+			}
+			tooOld := retention.MaxAge > 0 && sentAt != nil && now.Sub(sentAt.AsTime()) > retention.MaxAge
+			//the server only expires the messages it loaded, so older ones that did not last go here.
+			expired := message.ExpiresAt != nil && now.Sub(message.ExpiresAt.AsTime()) > compactInterval
+			if tooOld || expired || (retention.MaxMessages > 0 && count > retention.MaxMessages) || (retention.MaxBytes > 0 && bytes > retention.MaxBytes) {
+				dropped[message.Id] = true
+				if message.Attachment != nil && !deleted[message.Id] {
+					files = append(files, message.Id)
+				}
+			}
+		}
+	}
+	//messages stored after the ones read above are kept as they are.
+	rewrite := func(message *chitchat.ServerMessage) *chitchat.ServerMessage {
+		id := targetOf(message)
+		switch {
+		case dropped[id]:
+			return nil
+		case message.Kind == chitchat.ServerMessage_EDITED && deleted[id]:
+			return nil
+		case message.Kind == chitchat.ServerMessage_REACTIONS && (deleted[id] || message.Lamport < latestReactions[id]):
+			return nil
+		case isChat(message) && deleted[id] && (message.Text != "" || message.Quote != nil):
+			blanked := proto.Clone(message).(*chitchat.ServerMessage)
+			blanked.Text = ""
+			blanked.Quote = nil
+			return blanked
+		}
+		return message
+	}
+	changes := 0
+	for _, message := range stored {
+		if rewrite(message) != message {
+			changes++
+		}
+	}
+	if changes == 0 {
+		return 0, nil
+	}
+
+	s.mutex.Lock()
+	if err := s.storage.RewriteMessages(rewrite); err != nil {
+		s.mutex.Unlock()
+		return 0, err
+	}
+	var ids []string
+	for id := range dropped {
+		ids = append(ids, id)
+		s.index.remove(id)
+		if message, ok := s.messages[id]; ok {
+			s.forget(message)
+			delete(s.messages, id)
+		}
+		delete(s.receipts, id)
+	}
+	s.receiptOrder = slices.DeleteFunc(s.receiptOrder, func(id string) bool { return dropped[id] })
+	if err := s.storage.RemoveReceipts(ids); err != nil {
+		s.logger.Error("could not remove the receipts of compacted messages", "error", err)
+	}
+	s.mutex.Unlock()
+	s.removeFiles(files)
+	return len(dropped), nil
+}
+
+// removeFiles deletes the shared files with the given ids, if the server keeps files.
+func (s *Server) removeFiles(ids []string) {
+	if s.blobs == nil {
+		return
+	}
+	for _, id := range ids {
+		if err := s.blobs.remove(id); err != nil {
+			s.logger.Error("could not delete a shared file", "id", id, "error", err)
+		}
+	}
+}
@@ -0,0 +1,127 @@
+package chatserver
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	chitchat "homework3/chitchat"
+
+	"google.golang.org/protobuf/types/known/timestamppb"
+)
+
+func TestExpiredOnRestart(t *testing.T) {
+	storage, err := OpenFileStorage(t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = storage.AppendMessage(&chitchat.ServerMessage{
+		Id:         "1",
+		Name:       "alice",
+		Room:       chitchat.DefaultRoom,
+		Text:       "gone soon",
+		Kind:       chitchat.ServerMessage_CHAT,
+		Lamport:    3,
+		TtlSeconds: 60,
+		SentAt:     timestamppb.New(time.Now().Add(-2 * time.Minute)),
+		ExpiresAt:  timestamppb.New(time.Now().Add(-time.Minute)),
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	s, err := New(WithStorage(storage))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer s.Stop(context.Background())
+
+	deadline := time.Now().Add(5 * time.Second)
+	for {
+		var deleted bool
+		s.mutex.Lock()
+		err := storage.Messages(func(message *chitchat.ServerMessage) bool {
+			deleted = message.Kind == chitchat.ServerMessage_DELETED && message.Target == "1"
+			return !deleted
+		})
+		s.mutex.Unlock()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if deleted {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatal("the expired message was not deleted")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	response, err := s.Search(context.Background(), &chitchat.SearchRequest{Query: "gone"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(response.Results) != 0 {
+		t.Errorf("search found %d expired messages", len(response.Results))
+	}
+}
+
+func TestFilesGoWithTheirMessages(t *testing.T) {
+	storage := NewMemoryStorage()
+	blobDir := t.TempDir()
+	share := func(id string, lamport int32, expiresAt *timestamppb.Timestamp) {
+		t.Helper()
+		err := storage.AppendMessage(&chitchat.ServerMessage{
+			Id:         id,
+			Name:       "alice",
+			Room:       chitchat.DefaultRoom,
+			Text:       id + ".txt",
+			Kind:       chitchat.ServerMessage_ATTACHMENT,
+			Lamport:    lamport,
+			Attachment: &chitchat.Attachment{Id: id, FileName: id + ".txt", ContentType: "text/plain", Size: 5},
+			SentAt:     timestamppb.Now(),
+			ExpiresAt:  expiresAt,
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+		for _, name := range []string{id, id + ".json"} {
+			if err := os.WriteFile(filepath.Join(blobDir, name), []byte("hello"), 0600); err != nil {
+				t.Fatal(err)
+			}
+		}
+	}
+	gone := func(id string) bool {
+		_, err := os.Stat(filepath.Join(blobDir, id))
+		return os.IsNotExist(err)
+	}
+	share("aa", 1, timestamppb.New(time.Now().Add(-time.Minute)))
+	//the file of a deleted message that was left behind, like when deleting it failed.
+	share("bb", 2, nil)
+	if err := storage.AppendMessage(&chitchat.ServerMessage{Room: chitchat.DefaultRoom, Kind: chitchat.ServerMessage_DELETED, Target: "bb", Lamport: 3}); err != nil {
+		t.Fatal(err)
+	}
+	share("cc", 4, nil)
+
+	s, err := New(WithStorage(storage), WithBlobDir(blobDir))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer s.Stop(context.Background())
+
+	for deadline := time.Now().Add(5 * time.Second); !gone("aa"); time.Sleep(10 * time.Millisecond) {
+		if time.Now().After(deadline) {
+			t.Fatal("the file of an expired message is still there")
+		}
+	}
+	if _, err := s.compact(time.Now()); err != nil {
+		t.Fatal(err)
+	}
+	if !gone("bb") || !gone("bb.json") {
+		t.Error("compacting left the file of a deleted message")
+	}
+	if gone("cc") {
+		t.Error("compacting deleted the file of a message that is still there")
+	}
+}
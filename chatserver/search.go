@@ -0,0 +1,324 @@
+package chatserver
+
+import (
+	"cmp"
+	"context"
+	"fmt"
+	"slices"
+	"strings"
+	"time"
+	"unicode"
+
+	chitchat "homework3/chitchat"
+
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/proto"
+)
+
+// how many results a page has when the request does not say, and at most
+const (
+	defaultSearchPage = 20
+	maxSearchPage     = 100
+)
+
+// searchIndex is an inverted index of the latest messages said in rooms and not deleted,
+// by the words in their current text.
+type searchIndex struct {
+	//the ids of the messages each word is in
+	postings map[string]map[string]struct{}
+	//every message indexed, by id
+	documents map[string]*document
+	//the ids of the messages indexed, oldest first, with some that were taken out since
+	order []string
+}
+
+// document is a message in the index.
+type document struct {
+	//the message as it was sent
+	message *chitchat.ServerMessage
+	//its current text, and the words in it in order
+	text   string
+	words  []string
+	edited bool
+}
+
+func newSearchIndex() *searchIndex {
+	return &searchIndex{postings: make(map[string]map[string]struct{}), documents: make(map[string]*document)}
+}
+
+// words splits text into lower case words, leaving out punctuation and spaces.
+func words(text string) []string {
+	return strings.FieldsFunc(strings.ToLower(text), func(r rune) bool {
+		return !unicode.IsLetter(r) && !unicode.IsDigit(r)
+	})
+}
+
+// add indexes a chat message, changes the text of the message an edit is about, or
+// takes the message a deletion is about out of the index. Other messages are left out.
+// Once more than limit messages are indexed the oldest are taken out, unless limit is 0.
+func (index *searchIndex) add(message *chitchat.ServerMessage, limit int) {
+	switch {
+	case isChat(message):
+		index.setText(&document{message: message}, message.Text)
+		index.order = append(index.order, message.Id)
+		index.trim(limit)
+	case message.Kind == chitchat.ServerMessage_EDITED:
+		if doc, ok := index.documents[message.Target]; ok {
+			index.setText(doc, message.Text)
+			doc.edited = true
+		}
+	case message.Kind == chitchat.ServerMessage_DELETED:
+		index.remove(message.Target)
+	}
+}
+
+// trim takes the oldest messages out of the index until at most limit are left, unless limit is 0.
+// Messages that do not last stay until they expire, as expiring them needs them indexed.
+func (index *searchIndex) trim(limit int) {
+	for limit > 0 && len(index.documents) > limit && len(index.order) > 0 {
+		id := index.order[0]
+		index.order = index.order[1:]
+		if doc, ok := index.documents[id]; ok && doc.message.ExpiresAt == nil {
+			index.remove(id)
+		}
+	}
+	//deleted messages are only dropped from the order now and then, so it does not grow without them.
+	if len(index.order) > 2*len(index.documents)+64 {
+		index.order = slices.DeleteFunc(index.order, func(id string) bool {
+			_, ok := index.documents[id]
+			return !ok
+		})
+	}
+}
+
+// remove takes a message out of the index.
+func (index *searchIndex) remove(id string) {
+	if doc, ok := index.documents[id]; ok {
+		index.setText(doc, "")
+		delete(index.documents, id)
+	}
+}
+
+// setText replaces the words a message is indexed by with the words of text.
+func (index *searchIndex) setText(doc *document, text string) {
+	id := doc.message.Id
+	for _, word := range doc.words {
+		if ids := index.postings[word]; ids != nil {
+			delete(ids, id)
+			if len(ids) == 0 {
+				delete(index.postings, word)
+			}
+		}
+	}
+	doc.text = text
+	doc.words = words(text)
+	for _, word := range doc.words {
+		ids, ok := index.postings[word]
+		if !ok {
+			ids = make(map[string]struct{})
+			index.postings[word] = ids
+		}
+		ids[id] = struct{}{}
+	}
+	index.documents[id] = doc
+}
+
+// searchQuery is a parsed query: the words a message must have, and the phrases it must have them in.
+type searchQuery struct {
+	words   []string
+	phrases [][]string
+}
+
+// parseQuery splits a query into words, and phrases between double quotes.
+// A quote that is never closed runs to the end of the query.
+func parseQuery(query string) searchQuery {
+	var parsed searchQuery
+	for i, part := range strings.Split(query, `"`) {
+		partWords := words(part)
+		parsed.words = append(parsed.words, partWords...)
+		//every other part is inside quotes.
+		if i%2 == 1 && len(partWords) > 1 {
+			parsed.phrases = append(parsed.phrases, partWords)
+		}
+	}
+	return parsed
+}
+
+// match returns the messages that have every word of the query, in its phrases where it has any.
+func (index *searchIndex) match(query searchQuery) []*document {
+	if len(query.words) == 0 {
+		matches := make([]*document, 0, len(index.documents))
+		for _, doc := range index.documents {
+			matches = append(matches, doc)
+		}
+		return matches
+	}
+	//start from the rarest word, so as few messages as possible are checked.
+	rarest := index.postings[query.words[0]]
+	for _, word := range query.words[1:] {
+		if len(index.postings[word]) < len(rarest) {
+			rarest = index.postings[word]
+		}
+	}
+	var matches []*document
+	for id := range rarest {
+		has := true
+		for _, word := range query.words {
+			if _, ok := index.postings[word][id]; !ok {
+				has = false
+				break
+			}
+		}
+		doc := index.documents[id]
+		for _, phrase := range query.phrases {
+			has = has && containsPhrase(doc.words, phrase)
+		}
+		if has {
+			matches = append(matches, doc)
+		}
+	}
+	return matches
+}
+
+// containsPhrase reports whether phrase appears in words, one word after the other.
+func containsPhrase(words []string, phrase []string) bool {
+	for i := 0; i+len(phrase) <= len(words); i++ {
+		if slices.Equal(words[i:i+len(phrase)], phrase) {
+			return true
+		}
+	}
+	return false
+}
+
+// loadMessages indexes the latest messages the storage kept and puts them back in their rooms'
+// history, so they are replayed and can be edited, deleted, reacted and replied to as before the
+// server stopped. It moves the Lamport time past them so what is said from now on comes after them.
+// When the index has a limit, only each room's latest messages are read. Otherwise, or for storage
+// from before rooms were, every message is, and the rooms they were said in are kept as rooms.
+func (s *Server) loadMessages() error {
+	limit := s.currentLimits().MaxIndexedMessages
+	kept, err := s.storage.Rooms()
+	if err != nil {
+		return err
+	}
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	if limit > 0 && len(kept) > 0 {
+		var messages []*chitchat.ServerMessage
+		for _, room := range kept {
+			//the history needs as many as it keeps, even when fewer can be searched.
+			latest, err := s.storage.RoomMessages(room.Name, 0, max(limit, historyKept))
+			if err != nil {
+				return err
+			}
+			messages = append(messages, latest...)
+		}
+		//the oldest are taken out of the index first, whichever room they were said in.
+		slices.SortStableFunc(messages, func(a, b *chitchat.ServerMessage) int {
+			return cmp.Compare(a.Lamport, b.Lamport)
+		})
+		for _, message := range messages {
+			s.index.add(message, limit)
+			s.restore(message)
+			s.lamport = max(s.lamport, message.Lamport)
+		}
+		return nil
+	}
+	//when the first message kept of each room was said
+	rooms := make(map[string]time.Time)
+	err = s.storage.Messages(func(message *chitchat.ServerMessage) bool {
+		s.index.add(message, limit)
+		s.restore(message)
+		s.lamport = max(s.lamport, message.Lamport)
+		if _, ok := rooms[message.Room]; !ok && message.SentAt != nil {
+			rooms[message.Room] = message.SentAt.AsTime()
+		}
+		return true
+	})
+	if err != nil {
+		return err
+	}
+	for room, created := range rooms {
+		if err := s.storage.AddRoom(room, created); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Search finds the messages said in rooms whose current text matches a query, the latest first.
+// Deleted messages are never found.
+func (s *Server) Search(ctx context.Context, request *chitchat.SearchRequest) (*chitchat.SearchResponse, error) {
+	pageSize := int(request.PageSize)
+	if pageSize <= 0 {
+		pageSize = defaultSearchPage
+	}
+	pageSize = min(pageSize, maxSearchPage)
+	var afterLamport int32
+	var afterID string
+	if request.PageToken != "" {
+		if _, err := fmt.Sscanf(request.PageToken, "%d-%s", &afterLamport, &afterID); err != nil {
+			return nil, status.Errorf(codes.InvalidArgument, "%q is not a page token from a previous search", request.PageToken)
+		}
+	}
+	var from, to time.Time
+	if request.FromTime != nil {
+		from = request.FromTime.AsTime()
+	}
+	if request.ToTime != nil {
+		to = request.ToTime.AsTime()
+	}
+
+	s.mutex.Lock()
+	var matches []*document
+	for _, doc := range s.index.match(parseQuery(request.Query)) {
+		message := doc.message
+		switch {
+		case request.Author != "" && message.Name != request.Author,
+			request.Room != "" && message.Room != request.Room,
+			request.FromLamport != 0 && message.Lamport < request.FromLamport,
+			request.ToLamport != 0 && message.Lamport > request.ToLamport,
+			!from.IsZero() && (message.SentAt == nil || message.SentAt.AsTime().Before(from)),
+			!to.IsZero() && (message.SentAt == nil || message.SentAt.AsTime().After(to)):
+			continue
+		}
+		matches = append(matches, doc)
+	}
+	slices.SortFunc(matches, func(a, b *document) int {
+		if a.message.Lamport != b.message.Lamport {
+			return cmp.Compare(b.message.Lamport, a.message.Lamport)
+		}
+		return strings.Compare(a.message.Id, b.message.Id)
+	})
+	response := &chitchat.SearchResponse{Total: int32(len(matches))}
+	start := 0
+	if request.PageToken != "" {
+		start, _ = slices.BinarySearchFunc(matches, afterLamport, func(doc *document, lamport int32) int {
+			if doc.message.Lamport != lamport {
+				return cmp.Compare(lamport, doc.message.Lamport)
+			}
+			//everything at the token's Lamport time up to and including its id came before.
+			if doc.message.Id <= afterID {
+				return -1
+			}
+			return 1
+		})
+	}
+	page := matches[start:min(start+pageSize, len(matches))]
+	for _, doc := range page {
+		response.Results = append(response.Results, &chitchat.SearchResult{
+			Message: proto.Clone(doc.message).(*chitchat.ServerMessage),
+			Text:    doc.text,
+			Edited:  doc.edited,
+		})
+	}
+	if start+len(page) < len(matches) {
+		last := page[len(page)-1].message
+		response.NextPageToken = fmt.Sprintf("%d-%s", last.Lamport, last.Id)
+	}
+	s.mutex.Unlock()
+
+	loggerFrom(ctx, s.logger).Debug("searched", "query", request.Query, "results", len(response.Results), "total", response.Total)
+	return response, nil
+}
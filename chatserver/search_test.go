@@ -0,0 +1,202 @@
+package chatserver
+
+import (
+	"context"
+	"fmt"
+	"reflect"
+	"testing"
+	"time"
+
+	chitchat "homework3/chitchat"
+
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/types/known/timestamppb"
+)
+
+func TestParseQuery(t *testing.T) {
+	tests := []struct {
+		query string
+		want  searchQuery
+	}{
+		{"", searchQuery{}},
+		{"Deploy", searchQuery{words: []string{"deploy"}}},
+		{"deploy, failed!", searchQuery{words: []string{"deploy", "failed"}}},
+		{`"build failed"`, searchQuery{words: []string{"build", "failed"}, phrases: [][]string{{"build", "failed"}}}},
+		{`ci "build failed" again`, searchQuery{words: []string{"ci", "build", "failed", "again"}, phrases: [][]string{{"build", "failed"}}}},
+		//a phrase of one word is just a word.
+		{`"build"`, searchQuery{words: []string{"build"}}},
+		{`"build failed`, searchQuery{words: []string{"build", "failed"}, phrases: [][]string{{"build", "failed"}}}},
+		{`"a b" "c d"`, searchQuery{words: []string{"a", "b", "c", "d"}, phrases: [][]string{{"a", "b"}, {"c", "d"}}}},
+		{`""`, searchQuery{}},
+	}
+	for _, test := range tests {
+		if got := parseQuery(test.query); !reflect.DeepEqual(got, test.want) {
+			t.Errorf("parseQuery(%q) = %+v, want %+v", test.query, got, test.want)
+		}
+	}
+}
+
+// searchServer starts a server whose storage kept messages, one for each text, said by alice and
+// bob in turn, alternating between the general and ops rooms.
+func searchServer(t *testing.T, limits Limits, texts ...string) *Server {
+	t.Helper()
+	storage := NewMemoryStorage()
+	sent := time.Date(2024, 5, 1, 9, 0, 0, 0, time.UTC)
+	for _, room := range []string{chitchat.DefaultRoom, "ops"} {
+		if err := storage.AddRoom(room, sent); err != nil {
+			t.Fatal(err)
+		}
+	}
+	for i, text := range texts {
+		err := storage.AppendMessage(&chitchat.ServerMessage{
+			Id:      fmt.Sprintf("%02d", i+1),
+			Name:    []string{"alice", "bob"}[i%2],
+			Room:    []string{chitchat.DefaultRoom, "ops"}[i/2%2],
+			Text:    text,
+			Kind:    chitchat.ServerMessage_CHAT,
+			Lamport: int32(i + 1),
+			SentAt:  timestamppb.New(sent.Add(time.Duration(i) * time.Hour)),
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	s, err := New(WithStorage(storage), WithLimits(limits))
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { s.Stop(context.Background()) })
+	return s
+}
+
+// ids returns the ids of the messages a search found, in order.
+func ids(response *chitchat.SearchResponse) []string {
+	var found []string
+	for _, result := range response.Results {
+		found = append(found, result.Message.Id)
+	}
+	return found
+}
+
+func TestSearch(t *testing.T) {
+	s := searchServer(t, Limits{MaxMessageLength: 100, StreamQueueSize: 10},
+		"the build failed",     //01 alice general
+		"build is green",       //02 bob general
+		"failed build on ops",  //03 alice ops
+		"ops build failed",     //04 bob ops
+		"nothing to see",       //05 alice general
+		"Build failed, again!", //06 bob general
+	)
+	tests := []struct {
+		name    string
+		request *chitchat.SearchRequest
+		want    []string
+	}{
+		{"word", &chitchat.SearchRequest{Query: "build"}, []string{"06", "04", "03", "02", "01"}},
+		{"every word", &chitchat.SearchRequest{Query: "build failed"}, []string{"06", "04", "03", "01"}},
+		{"phrase", &chitchat.SearchRequest{Query: `"build failed"`}, []string{"06", "04", "01"}},
+		{"phrase and word", &chitchat.SearchRequest{Query: `ops "build failed"`}, []string{"04"}},
+		{"no match", &chitchat.SearchRequest{Query: "deploy"}, nil},
+		{"everything", &chitchat.SearchRequest{}, []string{"06", "05", "04", "03", "02", "01"}},
+		{"from", &chitchat.SearchRequest{Query: "build", Author: "alice"}, []string{"03", "01"}},
+		{"room", &chitchat.SearchRequest{Query: "build", Room: "ops"}, []string{"04", "03"}},
+		{"from and room", &chitchat.SearchRequest{Query: "build", Author: "bob", Room: chitchat.DefaultRoom}, []string{"06", "02"}},
+		{"Lamport times", &chitchat.SearchRequest{Query: "build", FromLamport: 2, ToLamport: 4}, []string{"04", "03", "02"}},
+		{"times", &chitchat.SearchRequest{
+			Query:    "build",
+			FromTime: timestamppb.New(time.Date(2024, 5, 1, 10, 0, 0, 0, time.UTC)),
+			ToTime:   timestamppb.New(time.Date(2024, 5, 1, 12, 0, 0, 0, time.UTC)),
+		}, []string{"04", "03", "02"}},
+	}
+	for _, test := range tests {
+		response, err := s.Search(context.Background(), test.request)
+		if err != nil {
+			t.Errorf("%s: %v", test.name, err)
+			continue
+		}
+		if got := ids(response); !reflect.DeepEqual(got, test.want) {
+			t.Errorf("%s: found %q, want %q", test.name, got, test.want)
+		}
+		if int(response.Total) != len(test.want) || response.NextPageToken != "" {
+			t.Errorf("%s: %d found in all and a page token %q, want %d and none", test.name, response.Total, response.NextPageToken, len(test.want))
+		}
+	}
+}
+
+func TestSearchPages(t *testing.T) {
+	s := searchServer(t, Limits{MaxMessageLength: 100, StreamQueueSize: 10}, "a", "b", "c", "d", "e", "f", "g")
+	var pages [][]string
+	request := &chitchat.SearchRequest{PageSize: 3}
+	for {
+		response, err := s.Search(context.Background(), request)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if response.Total != 7 {
+			t.Errorf("%d found in all, want 7", response.Total)
+		}
+		pages = append(pages, ids(response))
+		if response.NextPageToken == "" {
+			break
+		}
+		request.PageToken = response.NextPageToken
+	}
+	want := [][]string{{"07", "06", "05"}, {"04", "03", "02"}, {"01"}}
+	if !reflect.DeepEqual(pages, want) {
+		t.Errorf("pages %q, want %q", pages, want)
+	}
+
+	//a message said after the first page does not move the pages after it.
+	first, err := s.Search(context.Background(), &chitchat.SearchRequest{PageSize: 3})
+	if err != nil {
+		t.Fatal(err)
+	}
+	s.mutex.Lock()
+	s.remember(&chitchat.ServerMessage{Id: "08", Name: "alice", Room: chitchat.DefaultRoom, Text: "h", Kind: chitchat.ServerMessage_CHAT, Lamport: 8})
+	s.mutex.Unlock()
+	second, err := s.Search(context.Background(), &chitchat.SearchRequest{PageSize: 3, PageToken: first.NextPageToken})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := ids(second); !reflect.DeepEqual(got, []string{"04", "03", "02"}) {
+		t.Errorf("the second page is %q after a new message, want it to stay [04 03 02]", got)
+	}
+
+	for _, token := range []string{"next", "-", "x-01"} {
+		if _, err := s.Search(context.Background(), &chitchat.SearchRequest{PageToken: token}); status.Code(err) != codes.InvalidArgument {
+			t.Errorf("searching with page token %q: %v, want InvalidArgument", token, err)
+		}
+	}
+}
+
+func TestSearchIndexLimit(t *testing.T) {
+	limits := Limits{MaxMessageLength: 100, StreamQueueSize: 10, MaxIndexedMessages: 3}
+	s := searchServer(t, limits, "a", "b", "c", "d", "e")
+	response, err := s.Search(context.Background(), &chitchat.SearchRequest{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := ids(response); !reflect.DeepEqual(got, []string{"05", "04", "03"}) {
+		t.Errorf("found %q after starting, want only the latest three", got)
+	}
+	if s.lamport != 5 {
+		t.Errorf("the Lamport time is %d after starting, want 5", s.lamport)
+	}
+
+	s.mutex.Lock()
+	//one that does not last stays until it expires, however many are said after it.
+	s.remember(&chitchat.ServerMessage{Id: "06", Name: "alice", Room: "ops", Text: "f", Kind: chitchat.ServerMessage_CHAT, Lamport: 6,
+		ExpiresAt: timestamppb.New(time.Now().Add(time.Hour))})
+	for i := 7; i <= 9; i++ {
+		s.remember(&chitchat.ServerMessage{Id: fmt.Sprintf("%02d", i), Name: "bob", Room: "ops", Text: "g", Kind: chitchat.ServerMessage_CHAT, Lamport: int32(i)})
+	}
+	s.mutex.Unlock()
+	response, err = s.Search(context.Background(), &chitchat.SearchRequest{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := ids(response); !reflect.DeepEqual(got, []string{"09", "08", "06"}) {
+		t.Errorf("found %q, want the one that does not last and the latest two", got)
+	}
+}
@@ -0,0 +1,772 @@
+// Package chatserver is the Chitty-Chat server as a library. A Server is built with New,
+// serves with Start and stops with Stop. Several Servers can run in one process, and a
+// Server can also be registered on a gRPC server the caller runs itself.
+package chatserver
+
+import (
+	"bytes"
+	"context"
+	"crypto/ed25519"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net"
+	"net/http"
+	"strconv"
+	"sync"
+	"sync/atomic"
+	"time"
+	"unicode/utf8"
+
+	chitchat "homework3/chitchat"
+	"homework3/tracing"
+
+	"go.opentelemetry.io/otel/attribute"
+	otelcodes "go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/trace"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/health"
+	healthpb "google.golang.org/grpc/health/grpc_health_v1"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/peer"
+	"google.golang.org/grpc/reflection"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/types/known/timestamppb"
+)
+
+// ServerName is the author of messages generated by the server itself. Users cannot join with it.
+const ServerName = "SERVER MESSAGE"
+
+// connectedUser is a user with an open Join stream.
+type connectedUser struct {
+	UserId int32
+	Name   string
+	Room   string
+	Stream chitchat.ChatService_JoinServer // The gRPC stream
+	//messages waiting to be sent on the stream. Only the user's Join call sends on the stream.
+	queue chan queuedMessage
+	//closed when the user is removed from userStreams, which ends their Join call.
+	done chan struct{}
+	//status the Join call ends with once done is closed. nil when the user left by themselves.
+	endStatus error
+
+	RemoteAddress  string
+	ConnectedSince time.Time
+	//latest Lamport timestamp seen from the user
+	Lamport int32
+
+	//logs lines tagged with the user and the correlation ids of their Join call
+	logger *slog.Logger
+}
+
+// queuedMessage is a message waiting in a user's queue, with the time it was put there
+// and the fan-out it is part of, for tracing.
+type queuedMessage struct {
+	message  *chitchat.ServerMessage
+	enqueued time.Time
+	fanOut   context.Context
+}
+
+// Server is a Chitty-Chat server. It implements chitchat.ChatServiceServer.
+type Server struct {
+	chitchat.UnimplementedChatServiceServer
+
+	options options
+	logger  *slog.Logger
+	storage Storage
+	//where shared files are kept, nil if users cannot share files
+	blobs *blobStore
+	//key the server signs its own messages with
+	serverKey ed25519.PrivateKey
+	limits    atomic.Pointer[Limits]
+	metrics   *serverMetrics
+	startedAt time.Time
+
+	//guards userStreams, presence, receipts, history, index, moderators, lamport, signedRequests, challenges and shuttingDown
+	mutex sync.Mutex
+	//all connected users by id
+	userStreams map[int32]*connectedUser
+	//presence of everyone who has been in each room, by room and then name
+	presence map[string]map[string]*participantState
+	//receipts for the most recent messages by id, and their ids oldest first
+	receipts     map[string]*Receipts
+	receiptOrder []string
+	//the latest messages of each room, and every message still in one of them by id
+	history  map[string]*roomHistory
+	messages map[string]*storedMessage
+	//every message said in a room that was not deleted, including those too old for the history
+	index *searchIndex
+	//users who may edit and delete anyone's messages
+	moderators map[string]bool
+	lamport    int32
+	//the Lamport time the server started at, and the signed requests accepted since
+	startLamport   int32
+	signedRequests map[signer]*signedRequests
+	//nonces handed out for joining and not used yet, and when they stop being accepted
+	challenges map[string]time.Time
+	//set once Stop has been called. New joins are refused from then on.
+	shuttingDown bool
+	//closed by Stop, to end the idle watcher, the mailbox expiry and the compactor
+	stopped chan struct{}
+	//Stop may be called more than once, but the storage is only closed the first time.
+	closeStorage sync.Once
+
+	//servers started by Start, nil for the ones that are not configured
+	health        *health.Server
+	chatServer    *grpc.Server
+	adminServer   *grpc.Server
+	metricsServer *http.Server
+	addresses     []net.Addr
+}
+
+// New builds a Server from the options. It does not accept users until Start is called,
+// or until it is registered on a gRPC server with Register. Call Stop when done with it,
+// even if it was never started.
+func New(opts ...Option) (*Server, error) {
+	s := &Server{
+		options:     options{limits: DefaultLimits, reconnectDelay: 5 * time.Second},
+		userStreams: make(map[int32]*connectedUser),
+		presence:    make(map[string]map[string]*participantState),
+		receipts:    make(map[string]*Receipts),
+		history:     make(map[string]*roomHistory),
+		messages:    make(map[string]*storedMessage),
+		index:       newSearchIndex(),
+		stopped:     make(chan struct{}),
+		health:      health.NewServer(),
+
+		signedRequests: make(map[signer]*signedRequests),
+		challenges:     make(map[string]time.Time),
+	}
+	for _, opt := range opts {
+		opt(&s.options)
+	}
+	if s.options.limits.MaxMessageLength <= 0 || s.options.limits.StreamQueueSize <= 0 {
+		return nil, errors.New("chatserver: MaxMessageLength and StreamQueueSize must be positive")
+	}
+	s.limits.Store(&s.options.limits)
+	s.SetModerators(s.options.moderators)
+
+	s.logger = s.options.logger
+	if s.logger == nil {
+		s.logger = slog.Default()
+	}
+	s.storage = s.options.storage
+	if s.storage == nil {
+		s.storage = NewMemoryStorage()
+	}
+	s.serverKey = s.options.signingKey
+	if s.serverKey == nil {
+		var err error
+		_, s.serverKey, err = ed25519.GenerateKey(nil)
+		if err != nil {
+			return nil, fmt.Errorf("chatserver: generating signing key: %w", err)
+		}
+	}
+	if s.options.blobDir != "" {
+		var err error
+		if s.blobs, err = openBlobStore(s.options.blobDir); err != nil {
+			return nil, fmt.Errorf("chatserver: opening the directory for shared files: %w", err)
+		}
+	}
+	s.metrics = newServerMetrics(s)
+	if err := s.loadMessages(); err != nil {
+		return nil, fmt.Errorf("chatserver: loading the messages kept: %w", err)
+	}
+	if err := s.loadReceipts(); err != nil {
+		return nil, fmt.Errorf("chatserver: loading the receipts kept: %w", err)
+	}
+	s.startLamport = s.lamport
+	//only once everything is loaded, as messages whose time ran out while the server was down go right away.
+	s.scheduleExpiries()
+	go s.watchIdle()
+	go s.expireMailboxes()
+	go s.compactMessages()
+	return s, nil
+}
+
+// SetLimits replaces the limits in effect. Users already connected keep their queue size.
+func (s *Server) SetLimits(limits Limits) {
+	s.limits.Store(&limits)
+}
+
+// SetModerators replaces who may edit and delete anyone's messages.
+func (s *Server) SetModerators(names []string) {
+	moderators := make(map[string]bool)
+	for _, name := range names {
+		moderators[name] = true
+	}
+	s.mutex.Lock()
+	s.moderators = moderators
+	s.mutex.Unlock()
+}
+
+func (s *Server) currentLimits() *Limits {
+	return s.limits.Load()
+}
+
+// ServerOptions returns the interceptors and stats handler the server's own gRPC servers use,
+// for callers that Register the server on a gRPC server of their own.
+func (s *Server) ServerOptions() []grpc.ServerOption {
+	return []grpc.ServerOption{
+		tracing.ServerOption(),
+		grpc.ChainUnaryInterceptor(s.loggingUnaryInterceptor, s.metrics.unaryInterceptor),
+		grpc.ChainStreamInterceptor(s.loggingStreamInterceptor, s.metrics.streamInterceptor),
+	}
+}
+
+// Register adds the chat service and health checking to a gRPC server.
+func (s *Server) Register(registrar grpc.ServiceRegistrar) {
+	chitchat.RegisterChatServiceServer(registrar, s)
+	healthpb.RegisterHealthServer(registrar, s.health)
+	s.health.SetServingStatus(chitchat.ChatService_ServiceDesc.ServiceName, healthpb.HealthCheckResponse_SERVING)
+}
+
+// Start listens on every configured address and listener and serves chat users, the Admin
+// service and metrics on them. It returns once everything is listening.
+func (s *Server) Start() error {
+	s.startedAt = time.Now()
+	listeners := append([]net.Listener(nil), s.options.listeners...)
+	for _, address := range s.options.addresses {
+		listen, err := net.Listen("tcp", address)
+		if err != nil {
+			closeAll(listeners)
+			return fmt.Errorf("chatserver: listening on %s: %w", address, err)
+		}
+		listeners = append(listeners, listen)
+	}
+	var adminListener net.Listener
+	if s.options.adminAddress != "" {
+		var err error
+		adminListener, err = net.Listen("tcp", s.options.adminAddress)
+		if err != nil {
+			closeAll(listeners)
+			return fmt.Errorf("chatserver: listening for admin requests on %s: %w", s.options.adminAddress, err)
+		}
+	}
+
+	s.chatServer = grpc.NewServer(append(s.ServerOptions(), s.options.serverOptions...)...)
+	s.Register(s.chatServer)
+	//Let tools like grpcurl ask what we serve.
+	reflection.Register(s.chatServer)
+	for _, listen := range listeners {
+		s.logger.Info("listening", "address", listen.Addr().String())
+		s.addresses = append(s.addresses, listen.Addr())
+		//The listener is closed by the gRPC server when it stops.
+		go func(listen net.Listener) {
+			if err := s.chatServer.Serve(listen); err != nil {
+				s.logger.Error("chat server stopped serving", "address", listen.Addr().String(), "error", err)
+			}
+		}(listen)
+	}
+
+	if adminListener != nil {
+		s.adminServer = s.serveAdmin(adminListener)
+	}
+	if s.options.metricsAddress != "" {
+		s.metricsServer = s.serveMetrics(s.options.metricsAddress)
+	}
+	return nil
+}
+
+// Addrs returns the addresses Start is serving chat users on, which is useful
+// when listening on port 0.
+func (s *Server) Addrs() []net.Addr {
+	return s.addresses
+}
+
+func closeAll(listeners []net.Listener) {
+	for _, listen := range listeners {
+		listen.Close()
+	}
+}
+
+// send sends a queued message on the user's stream and records how long it took to get there.
+func (s *Server) send(userStream *connectedUser, queued queuedMessage) error {
+	_, span := tracing.Tracer().Start(queued.fanOut, "chitchat.send", trace.WithAttributes(
+		attribute.Int("chitchat.user_id", int(userStream.UserId)),
+		attribute.Int64("chitchat.queue_wait_us", time.Since(queued.enqueued).Microseconds()),
+	))
+	defer span.End()
+
+	err := userStream.Stream.Send(queued.message)
+	if err != nil {
+		span.RecordError(err)
+		span.SetStatus(otelcodes.Error, "send failed")
+		s.metrics.countSendFailure("send_error")
+		userStream.logger.Warn("failed to send message", "lamport", queued.message.Lamport, "error", err)
+		return err
+	}
+	s.metrics.fanOutLatency.Observe(time.Since(queued.enqueued).Seconds())
+	return nil
+}
+
+func (s *Server) Broadcast(ctx context.Context, message *chitchat.ClientMessage) (*chitchat.Confirmation, error) {
+	//Only accept messages signed by the key registered to the author's name.
+	_, verifySpan := tracing.Tracer().Start(ctx, "chitchat.verify")
+	authorKey, err := s.authorKey(ctx, message.Name)
+	if err != nil {
+		verifySpan.End()
+		return nil, err
+	}
+	verified := message.Verify(authorKey)
+	verifySpan.End()
+	if !verified {
+		return nil, status.Errorf(codes.Unauthenticated, "message signature does not match the key registered to %q", message.Name)
+	}
+	if err := s.checkFresh(message.Name, message.Room, message.Lamport, message.Signature); err != nil {
+		return nil, err
+	}
+	if maxLength := s.currentLimits().MaxMessageLength; utf8.RuneCountInString(message.Text) > maxLength {
+		return nil, status.Errorf(codes.InvalidArgument, "messages must be no longer than %d characters", maxLength)
+	}
+	if message.TtlSeconds < 0 {
+		return nil, status.Error(codes.InvalidArgument, "a message cannot expire before it is sent")
+	}
+	if err := s.runBroadcastHooks(ctx, message); err != nil {
+		return nil, err
+	}
+	room := message.Room
+	if room == "" {
+		room = chitchat.DefaultRoom
+	}
+
+	mentions := s.mentionsIn(ctx, message.Text)
+
+	messageLamport := message.Lamport
+	//Use mutex to ensure consistency in the lamport timestamp across the server and all connected clients.
+	//The ordering span includes the time spent waiting for the mutex.
+	_, orderSpan := tracing.Tracer().Start(ctx, "chitchat.order")
+	s.mutex.Lock()
+	quote, err := s.checkReply(room, message)
+	if err != nil {
+		s.mutex.Unlock()
+		orderSpan.End()
+		return nil, err
+	}
+	s.lamport = max(s.lamport, messageLamport)
+	//writing brings the author back if they were idle, which everyone should see before the message.
+	backMessage := s.markActive(room, message.Name)
+	s.lamport++
+	for _, userStream := range s.userStreams {
+		if userStream.Name == message.Name {
+			userStream.Lamport = max(userStream.Lamport, messageLamport)
+		}
+	}
+	serverMessage := &chitchat.ServerMessage{
+		Name:          message.Name,
+		Text:          message.Text,
+		Lamport:       s.lamport,
+		Room:          room,
+		Signature:     message.Signature,
+		PublicKey:     authorKey,
+		SignedLamport: messageLamport,
+		Id:            s.newMessageID(),
+		ReplyTo:       message.ReplyTo,
+		Quote:         quote,
+		Mentions:      mentions,
+		TtlSeconds:    message.TtlSeconds,
+	}
+	if message.TtlSeconds > 0 {
+		serverMessage.ExpiresAt = timestamppb.New(time.Now().Add(time.Duration(message.TtlSeconds) * time.Second))
+	}
+	s.trackReceipts(serverMessage)
+	s.remember(serverMessage)
+	s.mutex.Unlock()
+	s.scheduleExpiry(serverMessage)
+	orderSpan.SetAttributes(attribute.Int("chitchat.lamport", int(serverMessage.Lamport)))
+	orderSpan.End()
+
+	if backMessage != nil {
+		s.sendAnnouncement(ctx, backMessage)
+	}
+	loggerFrom(ctx, s.logger).Info("message broadcast", "lamport", serverMessage.Lamport, "author", serverMessage.Name, "room", serverMessage.Room, "text", serverMessage.Text)
+	s.metrics.countBroadcast("user")
+	s.sendToRoom(ctx, serverMessage)
+	s.notifyMentions(ctx, serverMessage)
+
+	return &chitchat.Confirmation{MessageId: serverMessage.Id, Lamport: serverMessage.Lamport}, nil
+}
+
+// authorKey returns the key registered to name, or the status to refuse a signed request with
+// when there is none.
+func (s *Server) authorKey(ctx context.Context, name string) (ed25519.PublicKey, error) {
+	authorKey, err := s.storage.PublicKey(name)
+	if err != nil {
+		loggerFrom(ctx, s.logger).Error("could not look up the author's key", "author", name, "error", err)
+		return nil, status.Error(codes.Internal, "could not look up the author's key")
+	}
+	if authorKey == nil {
+		return nil, status.Errorf(codes.PermissionDenied, "%q has not joined the chat", name)
+	}
+	return authorKey, nil
+}
+
+// announce signs a message from the server and sends it to everyone in the room.
+// subject is the participant a JOINED or LEFT message is about.
+// ctx is the call that caused the announcement, for logging.
+func (s *Server) announce(ctx context.Context, room string, kind chitchat.ServerMessage_Kind, subject string, text string) {
+	s.mutex.Lock()
+	serverMessage := s.stampServerMessage(&chitchat.ServerMessage{
+		Text:    text,
+		Room:    room,
+		Kind:    kind,
+		Subject: subject,
+	})
+	s.mutex.Unlock()
+	s.sendAnnouncement(ctx, serverMessage)
+}
+
+// stampServerMessage makes a message come from the server: it gives it the next Lamport time
+// and signs it. It must be called with the mutex held.
+func (s *Server) stampServerMessage(serverMessage *chitchat.ServerMessage) *chitchat.ServerMessage {
+	signedLamport := s.lamport
+	s.lamport++
+	serverMessage.Lamport = s.lamport
+	return s.signServerMessage(serverMessage, signedLamport)
+}
+
+// signServerMessage signs a message as the server's, over the given Lamport time.
+// Everything the message says must be set before, since the signature covers it.
+func (s *Server) signServerMessage(serverMessage *chitchat.ServerMessage, signedLamport int32) *chitchat.ServerMessage {
+	serverMessage.Name = ServerName
+	serverMessage.SignedLamport = signedLamport
+	serverMessage.SignAsServer(s.serverKey)
+	return serverMessage
+}
+
+// sendAnnouncement sends a message stamped by stampServerMessage to everyone in its room.
+func (s *Server) sendAnnouncement(ctx context.Context, serverMessage *chitchat.ServerMessage) {
+	loggerFrom(ctx, s.logger).Info("message broadcast", "lamport", serverMessage.Lamport, "author", serverMessage.Name, "room", serverMessage.Room, "text", serverMessage.Text)
+	s.metrics.countBroadcast("server")
+	s.sendToRoom(ctx, serverMessage)
+}
+
+// sendToRoom sends the message to every connected user in the message's room.
+func (s *Server) sendToRoom(ctx context.Context, message *chitchat.ServerMessage) {
+	s.sendTo(ctx, message, func(userStream *connectedUser) bool { return userStream.Room == message.Room })
+}
+
+// sendToParticipant sends the message to every connection name has to the message's room.
+func (s *Server) sendToParticipant(ctx context.Context, message *chitchat.ServerMessage, name string) {
+	s.sendTo(ctx, message, func(userStream *connectedUser) bool {
+		return userStream.Room == message.Room && userStream.Name == name
+	})
+}
+
+// sendTo queues the message for every connected user it is for.
+func (s *Server) sendTo(ctx context.Context, message *chitchat.ServerMessage, isFor func(*connectedUser) bool) {
+	ctx, span := tracing.Tracer().Start(ctx, "chitchat.fanout")
+	defer span.End()
+	//recipients continue the trace from here when they display the message.
+	message.TraceContext = tracing.Inject(ctx)
+
+	s.mutex.Lock()
+	var recipients []*connectedUser
+	for _, userStream := range s.userStreams {
+		if isFor(userStream) {
+			recipients = append(recipients, userStream)
+		}
+	}
+	s.mutex.Unlock()
+
+	span.SetAttributes(attribute.Int("chitchat.recipients", len(recipients)))
+	//sends happen later on each recipient's own goroutine, so only keep the trace, not the call's deadline.
+	fanOut := trace.ContextWithSpanContext(context.Background(), span.SpanContext())
+	enqueued := time.Now()
+	for _, userStream := range recipients {
+		select {
+		case userStream.queue <- queuedMessage{message: message, enqueued: enqueued, fanOut: fanOut}:
+		default:
+			s.metrics.countSendFailure("queue_full")
+			userStream.logger.Warn("dropped message, queue is full", "lamport", message.Lamport)
+		}
+	}
+}
+
+// removeUserStream takes a user out of userStreams and ends their Join call with the given status.
+// It returns true if that was the user's last connection to the room, so they are offline now.
+// It must be called with the mutex held.
+func (s *Server) removeUserStream(userStream *connectedUser, endStatus error) bool {
+	if s.userStreams[userStream.UserId] != userStream {
+		return false
+	}
+	delete(s.userStreams, userStream.UserId)
+	userStream.endStatus = endStatus
+	close(userStream.done)
+	return s.markGone(userStream)
+}
+
+// recordEvent adds an event to the storage's audit trail, logging rather than failing
+// the call if it cannot be written.
+func (s *Server) recordEvent(ctx context.Context, event Event) {
+	_, span := tracing.Tracer().Start(ctx, "chitchat.persist", trace.WithAttributes(attribute.String("chitchat.audit_event", event.Kind)))
+	defer span.End()
+	if err := s.storage.RecordEvent(event); err != nil {
+		span.RecordError(err)
+		loggerFrom(ctx, s.logger).Error("failed to record event in the audit log", "event", event.Kind, "user", event.Actor, "error", err)
+	}
+}
+
+func (s *Server) Join(User *chitchat.User, userStream chitchat.ChatService_JoinServer) error {
+	if User.Name == ServerName {
+		return status.Errorf(codes.InvalidArgument, "the name %q is reserved", ServerName)
+	}
+	if len(User.PublicKey) != ed25519.PublicKeySize {
+		return status.Error(codes.InvalidArgument, "a valid Ed25519 public key is required to join")
+	}
+	if err := s.checkJoinSignature(User); err != nil {
+		return err
+	}
+	if err := s.checkRegisteredKey(userStream.Context(), User); err != nil {
+		return err
+	}
+	//hooks run once the name and key are checked, and before a new name is registered to the key.
+	if err := s.runJoinHooks(userStream.Context(), User); err != nil {
+		return err
+	}
+	room := User.Room
+	if room == "" {
+		room = chitchat.DefaultRoom
+	}
+
+	//Register the user's key to their name, or check it against the one registered before.
+	s.mutex.Lock()
+	if s.shuttingDown {
+		s.mutex.Unlock()
+		return s.shutdownStatus()
+	}
+	if err := s.checkCapacity(room); err != nil {
+		s.mutex.Unlock()
+		return err
+	}
+	if err := s.checkSessionID(User); err != nil {
+		s.mutex.Unlock()
+		return err
+	}
+	registeredKey, err := s.storage.PublicKey(User.Name)
+	if err == nil && registeredKey == nil {
+		err = s.storage.RegisterPublicKey(User.Name, ed25519.PublicKey(User.PublicKey))
+		registeredKey = User.PublicKey
+	}
+	s.mutex.Unlock()
+	if err != nil {
+		loggerFrom(userStream.Context(), s.logger).Error("could not register the user's key", "user", User.Name, "error", err)
+		return status.Error(codes.Internal, "could not register your key")
+	}
+	if !bytes.Equal(registeredKey, User.PublicKey) {
+		return status.Errorf(codes.PermissionDenied, "the name %q is registered to a different key", User.Name)
+	}
+
+	//Compare lamport timestamps and select the highest value, then increment to maintain lamport time stamp across chat room.
+	userLamport := User.Lamport
+	s.mutex.Lock()
+	s.lamport = max(s.lamport, userLamport)
+	s.lamport++
+	joinLamport := s.lamport
+	s.mutex.Unlock()
+	logger := loggerFrom(userStream.Context(), s.logger).With("user_id", User.Id, "user", User.Name)
+	logger.Info("user joined", "room", room, "lamport", joinLamport)
+	//a room exists from when someone first joins it, so administrators see it even once it is empty.
+	if err := s.storage.AddRoom(room, time.Now()); err != nil {
+		logger.Error("could not keep the room", "room", room, "error", err)
+	}
+	s.recordEvent(userStream.Context(), Event{Kind: EventJoin, Actor: User.Name, Room: room, Lamport: joinLamport})
+
+	// Send and broadcast a welcome message
+	s.announce(userStream.Context(), room, chitchat.ServerMessage_JOINED, User.Name, fmt.Sprintf("Participant %s joined Chitty-Chat at Lamport time %d", User.Name, joinLamport))
+
+	//Add user to map of userstreams.
+	newUserStream := &connectedUser{
+		UserId: User.Id,
+		Name:   User.Name,
+		Room:   room,
+		Stream: userStream,
+		queue:  make(chan queuedMessage, s.currentLimits().StreamQueueSize),
+		done:   make(chan struct{}),
+
+		ConnectedSince: time.Now(),
+		Lamport:        userLamport,
+		logger:         logger,
+	}
+	if remote, ok := peer.FromContext(userStream.Context()); ok {
+		newUserStream.RemoteAddress = remote.Addr.String()
+	}
+	//Use mutex to ensure consistency in shared resource userStreams.
+	s.mutex.Lock()
+	if err := s.checkSessionID(User); err != nil {
+		s.mutex.Unlock()
+		return err
+	}
+	previous, replaced := s.userStreams[User.Id]
+	var replacedLamport int32
+	if replaced {
+		s.removeUserStream(previous, status.Error(codes.AlreadyExists, "you joined again from somewhere else"))
+		s.lamport++
+		replacedLamport = s.lamport
+	}
+	s.userStreams[User.Id] = newUserStream
+	s.markJoined(room, User.Name)
+	//tell the new user who is here before anything else reaches them, then what they missed.
+	newUserStream.queue <- queuedMessage{message: s.participantsMessage(room), enqueued: time.Now(), fanOut: userStream.Context()}
+	s.replayHistory(newUserStream, userLamport)
+	mailbox := s.deliverMailbox(newUserStream)
+	s.mutex.Unlock()
+	if replaced {
+		s.recordEvent(userStream.Context(), Event{Kind: EventLeave, Actor: previous.Name, Room: previous.Room, Lamport: replacedLamport})
+	}
+	//Sending the headers tells the client it has joined; everything sent to the room from here on reaches it.
+	if err := userStream.SendHeader(metadata.Pairs(chitchat.JoinedHeader, strconv.Itoa(int(joinLamport)))); err != nil {
+		logger.Warn("could not confirm the join", "error", err)
+	}
+
+	//keep method running to keep the userstream open, sending queued messages until the user is removed.
+	for {
+		//direct messages that did not fit in the queue go out whenever nothing else is waiting.
+		if len(mailbox) > 0 && len(newUserStream.queue) == 0 && !isClosed(newUserStream.done) {
+			if err := s.send(newUserStream, queuedMessage{message: mailbox[0], enqueued: time.Now(), fanOut: userStream.Context()}); err != nil {
+				//they stay in the mailbox until they are acknowledged, so they come next time.
+				mailbox = nil
+			}
+			if len(mailbox) > 0 {
+				mailbox = mailbox[1:]
+			}
+			continue
+		}
+		select {
+		case queued := <-newUserStream.queue:
+			s.send(newUserStream, queued)
+		case <-userStream.Context().Done():
+			//the client went away without calling Leave, so nobody was told it left.
+			var offlineMessage *chitchat.ServerMessage
+			s.mutex.Lock()
+			connected := s.userStreams[User.Id] == newUserStream
+			if s.removeUserStream(newUserStream, nil) {
+				offlineMessage = s.presenceMessage(room, User.Name)
+			}
+			var goneLamport int32
+			if connected {
+				s.lamport++
+				goneLamport = s.lamport
+			}
+			s.mutex.Unlock()
+			if connected {
+				logger.Info("user disconnected", "room", room, "lamport", goneLamport)
+				s.recordEvent(userStream.Context(), Event{Kind: EventLeave, Actor: User.Name, Room: room, Lamport: goneLamport})
+			}
+			if offlineMessage != nil {
+				s.sendAnnouncement(userStream.Context(), offlineMessage)
+			}
+			return userStream.Context().Err()
+		case <-newUserStream.done:
+			s.drainQueue(newUserStream)
+			s.mutex.Lock()
+			endStatus := newUserStream.endStatus
+			s.mutex.Unlock()
+			return endStatus
+		}
+	}
+}
+
+// isClosed reports whether a channel that is only ever closed has been.
+func isClosed(channel chan struct{}) bool {
+	select {
+	case <-channel:
+		return true
+	default:
+		return false
+	}
+}
+
+// checkCapacity returns an error if the server or the room is already full.
+// It must be called with the mutex held.
+func (s *Server) checkCapacity(room string) error {
+	limits := s.currentLimits()
+	inRoom := 0
+	for _, userStream := range s.userStreams {
+		if userStream.Room == room {
+			inRoom++
+		}
+	}
+	if limits.MaxParticipants > 0 && len(s.userStreams) >= limits.MaxParticipants {
+		return status.Error(codes.ResourceExhausted, "Chitty-Chat is full, try again later")
+	}
+	if limits.MaxRoomParticipants > 0 && inRoom >= limits.MaxRoomParticipants {
+		return status.Errorf(codes.ResourceExhausted, "room %q is full, try again later", room)
+	}
+	return nil
+}
+
+// checkRegisteredKey returns an error if the name a user joins with is registered to a different key.
+// Join checks again when it registers the key, in case someone took the name in between.
+func (s *Server) checkRegisteredKey(ctx context.Context, user *chitchat.User) error {
+	registeredKey, err := s.storage.PublicKey(user.Name)
+	if err != nil {
+		loggerFrom(ctx, s.logger).Error("could not look up the user's key", "user", user.Name, "error", err)
+		return status.Error(codes.Internal, "could not look up your key")
+	}
+	if registeredKey != nil && !bytes.Equal(registeredKey, user.PublicKey) {
+		return status.Errorf(codes.PermissionDenied, "the name %q is registered to a different key", user.Name)
+	}
+	return nil
+}
+
+// checkSessionID returns an error if someone else is connected with the id a user joins with.
+// The user's own session with it is replaced, as they joined again from somewhere else.
+// It must be called with the mutex held.
+func (s *Server) checkSessionID(user *chitchat.User) error {
+	if previous, ok := s.userStreams[user.Id]; ok && previous.Name != user.Name {
+		return status.Errorf(codes.AlreadyExists, "someone else is connected with id %d, join with another", user.Id)
+	}
+	return nil
+}
+
+// drainQueue sends whatever is still queued for a user who is being removed.
+func (s *Server) drainQueue(userStream *connectedUser) {
+	for {
+		select {
+		case queued := <-userStream.queue:
+			if err := s.send(userStream, queued); err != nil {
+				return
+			}
+		default:
+			return
+		}
+	}
+}
+
+// Leave ends the session a user joined with. Only the user themselves can end it, so Leave is
+// signed with their key and names the id they joined with.
+func (s *Server) Leave(ctx context.Context, User *chitchat.User) (*chitchat.Confirmation, error) {
+	authorKey, err := s.authorKey(ctx, User.Name)
+	if err != nil {
+		return nil, err
+	}
+	if !User.VerifyLeave(authorKey) {
+		return nil, status.Errorf(codes.Unauthenticated, "leave signature does not match the key registered to %q", User.Name)
+	}
+	if err := s.checkFresh(User.Name, User.Room, User.Lamport, User.Signature); err != nil {
+		return nil, err
+	}
+
+	//delete the userstream mapped to the given id from the userstreams map.
+	//Use mutex to ensure consistency in shared resource userStreams and the lamport timestamp.
+	s.mutex.Lock()
+	userStream, ok := s.userStreams[User.Id]
+	if !ok || userStream.Name != User.Name {
+		s.mutex.Unlock()
+		return nil, status.Errorf(codes.NotFound, "%q is not connected with id %d", User.Name, User.Id)
+	}
+	room := userStream.Room
+	s.removeUserStream(userStream, nil)
+	s.lamport = max(s.lamport, User.Lamport)
+	s.lamport++
+	leaveLamport := s.lamport
+	s.mutex.Unlock()
+	loggerFrom(ctx, s.logger).Info("user left", "user_id", User.Id, "user", User.Name, "room", room, "lamport", leaveLamport)
+	s.recordEvent(ctx, Event{Kind: EventLeave, Actor: User.Name, Room: room, Lamport: leaveLamport})
+
+	//Broadcast leave message
+	s.announce(ctx, room, chitchat.ServerMessage_LEFT, User.Name, fmt.Sprintf("Participant %s left Chitty-Chat at Lamport time %d", User.Name, leaveLamport))
+	return &chitchat.Confirmation{}, nil
+}
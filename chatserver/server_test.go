@@ -0,0 +1,470 @@
+package chatserver_test
+
+import (
+	"context"
+	"crypto/ed25519"
+	"fmt"
+	"io"
+	"log/slog"
+	"net"
+	"strings"
+	"testing"
+	"time"
+
+	"homework3/chatclient"
+	"homework3/chatserver"
+	chitchat "homework3/chitchat"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/credentials/insecure"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/proto"
+)
+
+var quiet = slog.New(slog.NewTextHandler(io.Discard, nil))
+
+// startServer starts a server that keeps what it stores in dir, and returns it with the address
+// it serves chat users on. The server is stopped when the test is done, unless it was already.
+func startServer(t *testing.T, dir string, opts ...chatserver.Option) (*chatserver.Server, string) {
+	t.Helper()
+	storage, err := chatserver.OpenFileStorage(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	s, err := chatserver.New(append([]chatserver.Option{
+		chatserver.WithStorage(storage),
+		chatserver.WithListeners(listener),
+		chatserver.WithLogger(quiet),
+	}, opts...)...)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := s.Start(); err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { s.Stop(context.Background()) })
+	return s, listener.Addr().String()
+}
+
+func newKey(t *testing.T) ed25519.PrivateKey {
+	t.Helper()
+	_, key, err := ed25519.GenerateKey(nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return key
+}
+
+// connect joins the chat at address as name, and leaves when the test is done.
+func connect(t *testing.T, address string, name string, key ed25519.PrivateKey, opts ...chatclient.Option) *chatclient.Client {
+	t.Helper()
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	client, err := chatclient.Connect(ctx, address, name, key, append([]chatclient.Option{chatclient.WithLogger(quiet)}, opts...)...)
+	if err != nil {
+		t.Fatalf("connecting as %s: %v", name, err)
+	}
+	t.Cleanup(func() { client.Leave(context.Background()) })
+	return client
+}
+
+// waitFor returns the first event the client gets that match accepts, failing the test if none comes.
+func waitFor(t *testing.T, client *chatclient.Client, match func(chatclient.Event) bool) chatclient.Event {
+	t.Helper()
+	timeout := time.After(5 * time.Second)
+	for {
+		select {
+		case event, ok := <-client.Events():
+			if !ok {
+				t.Fatalf("%s's events ended: %v", client.Name(), client.Err())
+			}
+			if match(event) {
+				return event
+			}
+		case <-timeout:
+			t.Fatalf("%s did not get the event waited for", client.Name())
+		}
+	}
+}
+
+func TestRestartKeepsHistory(t *testing.T) {
+	ctx := context.Background()
+	dir := t.TempDir()
+	first, address := startServer(t, dir)
+	aliceKey := newKey(t)
+	alice := connect(t, address, "alice", aliceKey)
+	id, err := alice.Send(ctx, "said before the restart")
+	if err != nil {
+		t.Fatal(err)
+	}
+	replyID, err := alice.Reply(ctx, id, "and a reply")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := alice.Leave(ctx); err != nil {
+		t.Fatal(err)
+	}
+	if err := first.Stop(ctx); err != nil {
+		t.Fatal(err)
+	}
+
+	_, address = startServer(t, dir)
+	bob := connect(t, address, "bob", newKey(t))
+	replayed := waitFor(t, bob, func(event chatclient.Event) bool {
+		return event.Kind == chatclient.MessageEvent && event.Message.ID == id
+	})
+	if !replayed.Message.Replayed || replayed.Message.Text != "said before the restart" {
+		t.Errorf("replayed %q, replayed=%v", replayed.Message.Text, replayed.Message.Replayed)
+	}
+	reply := waitFor(t, bob, func(event chatclient.Event) bool {
+		return event.Kind == chatclient.MessageEvent && event.Message.ID == replyID
+	})
+	if reply.Message.Quote == nil || reply.Message.Quote.Text != "said before the restart" {
+		t.Errorf("the reply quotes %+v", reply.Message.Quote)
+	}
+
+	alice = connect(t, address, "alice", aliceKey)
+	if err := alice.Edit(ctx, id, "edited after the restart"); err != nil {
+		t.Fatalf("editing a message from before the restart: %v", err)
+	}
+	edit := waitFor(t, bob, func(event chatclient.Event) bool {
+		return event.Kind == chatclient.EditEvent && event.Target == id
+	})
+	if edit.Message.Text != "edited after the restart" {
+		t.Errorf("the edit says %q", edit.Message.Text)
+	}
+	history, err := alice.EditHistory(ctx, id)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(history.Versions) != 2 || history.Versions[0].Text != "said before the restart" {
+		t.Errorf("the message has versions %+v", history.Versions)
+	}
+}
+
+// dial connects to the chat service at address without joining.
+func dial(t *testing.T, address string) chitchat.ChatServiceClient {
+	t.Helper()
+	conn, err := grpc.Dial(address, grpc.WithTransportCredentials(insecure.NewCredentials()))
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { conn.Close() })
+	return chitchat.NewChatServiceClient(conn)
+}
+
+// join joins as user with a fresh challenge signed with key, and returns the status the stream ends
+// with, or OK once the server let the user in.
+func join(t *testing.T, service chitchat.ChatServiceClient, user *chitchat.User, key ed25519.PrivateKey) codes.Code {
+	t.Helper()
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	t.Cleanup(cancel)
+	challenge, err := service.GetJoinChallenge(ctx, &chitchat.JoinChallengeRequest{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	user.Nonce = challenge.Nonce
+	user.SignJoin(key)
+	stream, err := service.Join(ctx, user)
+	if err != nil {
+		return status.Code(err)
+	}
+	header, err := stream.Header()
+	if err == nil && len(header.Get(chitchat.JoinedHeader)) > 0 {
+		return codes.OK
+	}
+	_, err = stream.Recv()
+	return status.Code(err)
+}
+
+func TestJoinNeedsTheKey(t *testing.T) {
+	_, address := startServer(t, t.TempDir())
+	aliceKey := newKey(t)
+	alice := connect(t, address, "alice", aliceKey, chatclient.WithID(7))
+	bob := connect(t, address, "bob", newKey(t))
+	if _, err := bob.SendDirect(context.Background(), "alice", "for alice only"); err != nil {
+		t.Fatal(err)
+	}
+
+	service := dial(t, address)
+	alicePublic := aliceKey.Public().(ed25519.PublicKey)
+	impostor := newKey(t)
+	if code := join(t, service, &chitchat.User{Id: 8, Name: "alice", PublicKey: alicePublic}, impostor); code != codes.Unauthenticated {
+		t.Errorf("joining with alice's key signed by another: %v, want Unauthenticated", code)
+	}
+	replayed := &chitchat.User{Id: 8, Name: "alice", PublicKey: alicePublic, Nonce: []byte("made up")}
+	replayed.SignJoin(aliceKey)
+	stream, err := service.Join(context.Background(), replayed)
+	if err == nil {
+		_, err = stream.Recv()
+	}
+	if status.Code(err) != codes.Unauthenticated {
+		t.Errorf("joining over a nonce the server never handed out: %v, want Unauthenticated", err)
+	}
+	if code := join(t, service, &chitchat.User{Id: 7, Name: "mallory", PublicKey: impostor.Public().(ed25519.PublicKey)}, impostor); code != codes.AlreadyExists {
+		t.Errorf("joining with alice's id: %v, want AlreadyExists", code)
+	}
+
+	direct := waitFor(t, alice, func(event chatclient.Event) bool { return event.Kind == chatclient.DirectEvent })
+	if direct.Message.Text != "for alice only" {
+		t.Errorf("alice got %q", direct.Message.Text)
+	}
+	select {
+	case <-alice.Done():
+		t.Errorf("alice was disconnected: %v", alice.Err())
+	default:
+	}
+}
+
+func TestLeaveNeedsTheKey(t *testing.T) {
+	ctx := context.Background()
+	_, address := startServer(t, t.TempDir())
+	alice := connect(t, address, "alice", newKey(t), chatclient.WithID(7))
+	bobKey := newKey(t)
+	connect(t, address, "bob", bobKey)
+
+	service := dial(t, address)
+	forged := &chitchat.User{Id: 7, Name: "alice", Lamport: 1000}
+	forged.SignLeave(newKey(t))
+	if _, err := service.Leave(ctx, forged); status.Code(err) != codes.Unauthenticated {
+		t.Errorf("leaving as alice without her key: %v, want Unauthenticated", err)
+	}
+	unsigned := &chitchat.User{Id: 7, Name: "alice", Lamport: 1001}
+	if _, err := service.Leave(ctx, unsigned); status.Code(err) != codes.Unauthenticated {
+		t.Errorf("leaving as alice unsigned: %v, want Unauthenticated", err)
+	}
+	others := &chitchat.User{Id: 7, Name: "bob", Lamport: 1002}
+	others.SignLeave(bobKey)
+	if _, err := service.Leave(ctx, others); status.Code(err) != codes.NotFound {
+		t.Errorf("bob ending alice's session: %v, want NotFound", err)
+	}
+
+	select {
+	case <-alice.Done():
+		t.Errorf("alice was disconnected: %v", alice.Err())
+	case <-time.After(100 * time.Millisecond):
+	}
+}
+
+func TestJoinHooksRunAfterTheKeyCheck(t *testing.T) {
+	var hooked []string
+	_, address := startServer(t, t.TempDir(), chatserver.WithJoinHook(func(ctx context.Context, user *chitchat.User) error {
+		hooked = append(hooked, user.Name)
+		if len(hooked) == 2 {
+			return status.Error(codes.PermissionDenied, "not on the list")
+		}
+		return nil
+	}))
+	connect(t, address, "alice", newKey(t))
+
+	service := dial(t, address)
+	impostor := newKey(t)
+	if code := join(t, service, &chitchat.User{Id: 8, Name: "alice", PublicKey: impostor.Public().(ed25519.PublicKey)}, impostor); code != codes.PermissionDenied {
+		t.Errorf("joining as alice with another key: %v, want PermissionDenied", code)
+	}
+	if code := join(t, service, &chitchat.User{Id: 9, Name: "mallory", PublicKey: impostor.Public().(ed25519.PublicKey)}, impostor); code != codes.PermissionDenied {
+		t.Errorf("joining as mallory: %v, want PermissionDenied", code)
+	}
+	//mallory was turned away by the hook, so the name is still free for another key.
+	connect(t, address, "mallory", newKey(t))
+	if len(hooked) != 3 || hooked[0] != "alice" || hooked[1] != "mallory" || hooked[2] != "mallory" {
+		t.Errorf("the hook saw %v, want [alice mallory mallory]", hooked)
+	}
+}
+
+func TestBroadcastHooksSeeAllText(t *testing.T) {
+	ctx := context.Background()
+	var methods []string
+	_, address := startServer(t, t.TempDir(), chatserver.WithBlobDir(t.TempDir()), chatserver.WithBroadcastHook(func(ctx context.Context, message *chitchat.ClientMessage) error {
+		method, _ := grpc.Method(ctx)
+		methods = append(methods, method)
+		if strings.Contains(message.Text, "forbidden") {
+			return status.Error(codes.PermissionDenied, "not allowed here")
+		}
+		return nil
+	}))
+	alice := connect(t, address, "alice", newKey(t))
+	id, err := alice.Send(ctx, "allowed")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := alice.Send(ctx, "forbidden"); status.Code(err) != codes.PermissionDenied {
+		t.Errorf("sending forbidden text: %v, want PermissionDenied", err)
+	}
+	if err := alice.Edit(ctx, id, "forbidden after all"); status.Code(err) != codes.PermissionDenied {
+		t.Errorf("editing in forbidden text: %v, want PermissionDenied", err)
+	}
+	if err := alice.React(ctx, id, "forbidden"); status.Code(err) != codes.PermissionDenied {
+		t.Errorf("reacting with a forbidden word: %v, want PermissionDenied", err)
+	}
+	connect(t, address, "bob", newKey(t))
+	if _, err := alice.SendDirect(ctx, "bob", "forbidden in private too"); status.Code(err) != codes.PermissionDenied {
+		t.Errorf("sending forbidden text directly: %v, want PermissionDenied", err)
+	}
+	if _, err := alice.Share(ctx, "forbidden.txt", "text/plain", strings.NewReader("hello")); status.Code(err) != codes.PermissionDenied {
+		t.Errorf("sharing a file with a forbidden name: %v, want PermissionDenied", err)
+	}
+
+	want := []string{
+		"/chitchat.ChatService/Broadcast",
+		"/chitchat.ChatService/Broadcast",
+		"/chitchat.ChatService/Edit",
+		"/chitchat.ChatService/React",
+		"/chitchat.ChatService/SendDirect",
+		"/chitchat.ChatService/Upload",
+	}
+	if strings.Join(methods, " ") != strings.Join(want, " ") {
+		t.Errorf("the hook ran for %v, want %v", methods, want)
+	}
+}
+
+func TestEditsAreSignedByTheirEditor(t *testing.T) {
+	ctx := context.Background()
+	_, address := startServer(t, t.TempDir())
+	alice := connect(t, address, "alice", newKey(t))
+	bob := connect(t, address, "bob", newKey(t))
+	first, err := alice.Send(ctx, "first")
+	if err != nil {
+		t.Fatal(err)
+	}
+	second, err := alice.Send(ctx, "second")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := alice.Edit(ctx, first, "first, edited"); err != nil {
+		t.Fatal(err)
+	}
+	edit := waitFor(t, bob, func(event chatclient.Event) bool { return event.Kind == chatclient.EditEvent })
+	if !edit.Message.Verified {
+		t.Fatal("the edit did not verify")
+	}
+	if err := alice.React(ctx, second, "👍"); err != nil {
+		t.Fatal(err)
+	}
+	reaction := waitFor(t, bob, func(event chatclient.Event) bool { return event.Kind == chatclient.ReactionEvent })
+	if !reaction.Message.Verified {
+		t.Error("the reaction did not verify")
+	}
+
+	raw := edit.Message.Raw
+	for _, test := range []struct {
+		change string
+		tamper func(*chitchat.ServerMessage)
+	}{
+		{"pointed at another message", func(message *chitchat.ServerMessage) { message.Target = second }},
+		{"made a deletion", func(message *chitchat.ServerMessage) { message.Kind = chitchat.ServerMessage_DELETED }},
+		{"said to be by someone else", func(message *chitchat.ServerMessage) { message.Subject = "bob" }},
+		{"without the editor's signature", func(message *chitchat.ServerMessage) { message.Edit = nil }},
+	} {
+		tampered := proto.Clone(raw).(*chitchat.ServerMessage)
+		test.tamper(tampered)
+		if tampered.Verify() && tampered.VerifyEdit() {
+			t.Errorf("the edit %s still verifies", test.change)
+		}
+	}
+	//the server can sign whatever text it likes, but not the editor's signature over it.
+	tampered := proto.Clone(raw).(*chitchat.ServerMessage)
+	tampered.Text = "not what alice wrote"
+	tampered.SignAsServer(newKey(t))
+	if tampered.VerifyEdit() {
+		t.Error("an edit with other text verifies as alice's")
+	}
+}
+
+func TestEveryLeaveIsAudited(t *testing.T) {
+	ctx := context.Background()
+	storage := chatserver.NewMemoryStorage()
+	s, address := startServer(t, t.TempDir(), chatserver.WithStorage(storage))
+	service := dial(t, address)
+	for id, name := range []string{"alice", "bob", "carol"} {
+		key := newKey(t)
+		if code := join(t, service, &chitchat.User{Id: int32(id + 1), Name: name, PublicKey: key.Public().(ed25519.PublicKey)}, key); code != codes.OK {
+			t.Fatalf("joining as %s: %v", name, code)
+		}
+	}
+	//dave's client goes away without leaving.
+	daveKey := newKey(t)
+	challenge, err := service.GetJoinChallenge(ctx, &chitchat.JoinChallengeRequest{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	dave := &chitchat.User{Id: 4, Name: "dave", PublicKey: daveKey.Public().(ed25519.PublicKey), Nonce: challenge.Nonce}
+	dave.SignJoin(daveKey)
+	daveCtx, hangUp := context.WithCancel(ctx)
+	stream, err := service.Join(daveCtx, dave)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := stream.Header(); err != nil {
+		t.Fatal(err)
+	}
+	hangUp()
+	left := func(name string) bool {
+		for _, event := range storage.Events() {
+			if event.Kind == chatserver.EventLeave && event.Actor == name {
+				return true
+			}
+		}
+		return false
+	}
+	for deadline := time.Now().Add(5 * time.Second); !left("dave"); time.Sleep(10 * time.Millisecond) {
+		if time.Now().After(deadline) {
+			t.Fatal("dave's going away was not audited")
+		}
+	}
+
+	if _, err := s.AdminService().Disconnect(ctx, &chitchat.DisconnectRequest{Id: 2, Reason: "spam"}); err != nil {
+		t.Fatal(err)
+	}
+	var kick *chatserver.Event
+	for _, event := range storage.Events() {
+		if event.Kind == chatserver.EventKick {
+			recorded := event
+			kick = &recorded
+		}
+	}
+	if kick == nil || kick.Actor != "admin" || kick.Subject != "bob" {
+		t.Errorf("the kick was recorded as %+v, want it done by admin to bob", kick)
+	}
+	if err := s.Stop(ctx); err != nil {
+		t.Fatal(err)
+	}
+	for _, name := range []string{"alice", "bob", "carol", "dave"} {
+		if !left(name) {
+			t.Errorf("%s joined but never left, going by the audit log", name)
+		}
+	}
+}
+
+func TestLargeMailboxesAreDeliveredWhole(t *testing.T) {
+	ctx := context.Background()
+	limits := chatserver.DefaultLimits
+	limits.StreamQueueSize = 4
+	_, address := startServer(t, t.TempDir(), chatserver.WithLimits(limits))
+	aliceKey := newKey(t)
+	alice := connect(t, address, "alice", aliceKey)
+	if err := alice.Leave(ctx); err != nil {
+		t.Fatal(err)
+	}
+	bob := connect(t, address, "bob", newKey(t))
+	const sent = 20
+	for i := 0; i < sent; i++ {
+		if _, err := bob.SendDirect(ctx, "alice", fmt.Sprintf("direct %d", i)); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	alice = connect(t, address, "alice", aliceKey)
+	for i := 0; i < sent; i++ {
+		want := fmt.Sprintf("direct %d", i)
+		direct := waitFor(t, alice, func(event chatclient.Event) bool { return event.Kind == chatclient.DirectEvent })
+		if direct.Message.Text != want {
+			t.Fatalf("alice got %q, want %q", direct.Message.Text, want)
+		}
+	}
+}
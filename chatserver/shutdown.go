@@ -0,0 +1,82 @@
+package chatserver
+
+import (
+	"context"
+
+	chitchat "homework3/chitchat"
+
+	"google.golang.org/genproto/googleapis/rpc/errdetails"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/types/known/durationpb"
+)
+
+// shutdownStatus is the status Join streams end with when the server shuts down.
+// It carries a RetryInfo detail so clients know to reconnect later, or to another server.
+func (s *Server) shutdownStatus() error {
+	shutdown := status.New(codes.Unavailable, "Chitty-Chat server is shutting down, reconnect later")
+	withRetry, err := shutdown.WithDetails(&errdetails.RetryInfo{RetryDelay: durationpb.New(s.options.reconnectDelay)})
+	if err != nil {
+		return shutdown.Err()
+	}
+	return withRetry.Err()
+}
+
+// Stop tells every connected user that the server is going away, sends them what is
+// still queued for them, ends their streams and stops every server Start started.
+// Connections still open when ctx is done are closed. The storage is closed last.
+func (s *Server) Stop(ctx context.Context) error {
+	//health checks report NOT_SERVING from here on, so load balancers stop sending new users.
+	s.health.Shutdown()
+
+	s.mutex.Lock()
+	if !s.shuttingDown {
+		close(s.stopped)
+	}
+	s.shuttingDown = true
+	rooms := make(map[string]bool)
+	for _, userStream := range s.userStreams {
+		rooms[userStream.Room] = true
+	}
+	s.mutex.Unlock()
+
+	for room := range rooms {
+		s.announce(context.Background(), room, chitchat.ServerMessage_NOTICE, "", "Chitty-Chat is shutting down. Please reconnect later.")
+	}
+
+	//ending the streams lets each Join call drain its queue and return the shutdown status.
+	var left []Event
+	s.mutex.Lock()
+	for _, userStream := range s.userStreams {
+		s.removeUserStream(userStream, s.shutdownStatus())
+		s.lamport++
+		left = append(left, Event{Kind: EventLeave, Actor: userStream.Name, Room: userStream.Room, Lamport: s.lamport})
+	}
+	s.mutex.Unlock()
+	for _, event := range left {
+		s.recordEvent(context.Background(), event)
+	}
+
+	if s.chatServer != nil {
+		stopped := make(chan struct{})
+		go func() {
+			s.chatServer.GracefulStop()
+			close(stopped)
+		}()
+		select {
+		case <-stopped:
+		case <-ctx.Done():
+			s.logger.Warn("timed out waiting for clients, closing remaining connections")
+			s.chatServer.Stop()
+		}
+	}
+	if s.adminServer != nil {
+		s.adminServer.Stop()
+	}
+	if s.metricsServer != nil {
+		s.metricsServer.Close()
+	}
+	var err error
+	s.closeStorage.Do(func() { err = s.storage.Close() })
+	return err
+}
@@ -0,0 +1,23 @@
+package chatserver
+
+import (
+	"context"
+	"testing"
+)
+
+func TestStopTwice(t *testing.T) {
+	storage, err := OpenFileStorage(t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+	s, err := New(WithStorage(storage))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := s.Stop(context.Background()); err != nil {
+		t.Fatalf("first Stop: %v", err)
+	}
+	if err := s.Stop(context.Background()); err != nil {
+		t.Errorf("second Stop: %v", err)
+	}
+}
@@ -0,0 +1,1001 @@
+package chatserver
+
+import (
+	"bufio"
+	"bytes"
+	"crypto/ed25519"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"maps"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+
+	chitchat "homework3/chitchat"
+
+	"google.golang.org/protobuf/encoding/protojson"
+	"google.golang.org/protobuf/proto"
+)
+
+// Kinds of membership and moderation events the server records.
+const (
+	EventJoin  = "join"
+	EventLeave = "leave"
+	//an administrator disconnected the user
+	EventKick = "kick"
+	//a moderator deleted someone else's message
+	EventDelete = "delete"
+)
+
+// Event is something that happened to a user that the server keeps a record of.
+type Event struct {
+	Kind  string
+	Actor string
+	//who the actor did it to, if not themselves
+	Subject string
+	Room    string
+	Lamport int32
+}
+
+// MailboxEntry is a direct message waiting for its recipient, and when the server stored it.
+type MailboxEntry struct {
+	Message *chitchat.ServerMessage
+	Stored  time.Time
+}
+
+// Room is a room someone has joined, and when that first happened.
+type Room struct {
+	Name    string    `json:"name"`
+	Created time.Time `json:"created"`
+}
+
+// Receipts is who has received and read a message, and when.
+type Receipts struct {
+	MessageID string `json:"message_id"`
+	Author    string `json:"author"`
+	Room      string `json:"room"`
+	//how many others were in the room when the message was sent, and who, in alphabetical order.
+	//Receipts kept before recipients were named have no names.
+	Recipients     int                  `json:"recipients"`
+	RecipientNames []string             `json:"recipient_names"`
+	Delivered      map[string]time.Time `json:"delivered"`
+	Read           map[string]time.Time `json:"read"`
+}
+
+// clone copies the receipts, so what is kept does not change with the copy that was saved.
+func (receipts Receipts) clone() Receipts {
+	receipts.RecipientNames = slices.Clone(receipts.RecipientNames)
+	receipts.Delivered = maps.Clone(receipts.Delivered)
+	receipts.Read = maps.Clone(receipts.Read)
+	return receipts
+}
+
+// Storage is what a Server remembers beyond the users connected right now.
+// Implementations must be safe to call from several goroutines, and must not keep or hand out
+// anything a caller can change afterwards. storetest.Run checks an implementation does what
+// this interface asks of it.
+type Storage interface {
+	// PublicKey returns the key registered to a username, or nil if the name is free.
+	PublicKey(name string) (ed25519.PublicKey, error)
+	// RegisterPublicKey registers a key to a username.
+	RegisterPublicKey(name string, key ed25519.PublicKey) error
+	// Users returns every username a key is registered to, in alphabetical order.
+	Users() ([]string, error)
+	// AddRoom keeps a room, unless it is kept already: a room keeps the time it was first added.
+	AddRoom(name string, created time.Time) error
+	// Rooms returns every room kept, in alphabetical order.
+	Rooms() ([]Room, error)
+	// RecordEvent adds an event to the audit trail.
+	RecordEvent(event Event) error
+	// StoreDirect adds a direct message to the mailbox of its recipient, entry.Message.To.
+	StoreDirect(entry MailboxEntry) error
+	// Mailbox returns the direct messages waiting for a user, in the order they were stored.
+	Mailbox(name string) ([]MailboxEntry, error)
+	// RemoveDirect takes direct messages out of a user's mailbox. Ids that are not in it are ignored.
+	RemoveDirect(name string, messageIDs []string) error
+	// ExpireDirect takes every direct message stored before a time out of every mailbox,
+	// and returns how many it took out.
+	ExpireDirect(before time.Time) (int, error)
+	// AppendMessage keeps a message said in a room, or an edit, deletion or the reactions of one.
+	AppendMessage(message *chitchat.ServerMessage) error
+	// AppendMessages keeps several messages in the order given: all of them, or none if it fails.
+	AppendMessages(messages []*chitchat.ServerMessage) error
+	// Messages calls fn with every message kept, in the order they were appended, until fn returns false.
+	Messages(fn func(*chitchat.ServerMessage) bool) error
+	// RoomMessages returns the latest limit messages kept for a room with a Lamport time after
+	// afterLamport, or all of them if limit is 0, in the order they were appended. It should not have
+	// to go through the messages of other rooms, as the server calls it for everyone who joins.
+	RoomMessages(room string, afterLamport int32, limit int) ([]*chitchat.ServerMessage, error)
+	// RewriteMessages replaces every message kept with what rewrite returns for it, in the same order.
+	// rewrite returns nil to drop a message, and must not change the one it is given.
+	RewriteMessages(rewrite func(*chitchat.ServerMessage) *chitchat.ServerMessage) error
+	// SaveReceipts keeps the receipts of a message, replacing what was kept for it before.
+	SaveReceipts(receipts Receipts) error
+	// Receipts calls fn with the receipts of every message kept, in the order the messages were
+	// first saved, until fn returns false.
+	Receipts(fn func(Receipts) bool) error
+	// RemoveReceipts stops keeping the receipts of messages. Ids that are not kept are ignored.
+	RemoveReceipts(messageIDs []string) error
+	Close() error
+}
+
+// mailboxes are the direct messages waiting for each user, oldest first.
+type mailboxes map[string][]MailboxEntry
+
+func (boxes mailboxes) store(entry MailboxEntry) mailboxes {
+	changed := boxes.clone()
+	entry.Message = proto.Clone(entry.Message).(*chitchat.ServerMessage)
+	changed[entry.Message.To] = append(changed[entry.Message.To], entry)
+	return changed
+}
+
+func (boxes mailboxes) remove(name string, messageIDs []string) mailboxes {
+	changed := boxes.clone()
+	changed[name] = slices.DeleteFunc(slices.Clone(changed[name]), func(entry MailboxEntry) bool {
+		return slices.Contains(messageIDs, entry.Message.Id)
+	})
+	if len(changed[name]) == 0 {
+		delete(changed, name)
+	}
+	return changed
+}
+
+func (boxes mailboxes) expire(before time.Time) (mailboxes, int) {
+	changed := make(mailboxes, len(boxes))
+	expired := 0
+	for name, entries := range boxes {
+		kept := slices.DeleteFunc(slices.Clone(entries), func(entry MailboxEntry) bool { return entry.Stored.Before(before) })
+		expired += len(entries) - len(kept)
+		if len(kept) > 0 {
+			changed[name] = kept
+		}
+	}
+	return changed, expired
+}
+
+// clone copies the map but not the entries, which are never changed once stored,
+// so callers can work on it without affecting what is saved.
+func (boxes mailboxes) clone() mailboxes {
+	changed := make(mailboxes, len(boxes))
+	for name, entries := range boxes {
+		changed[name] = entries
+	}
+	return changed
+}
+
+// latest picks what RoomMessages returns from a room's messages, or where they are: the last limit
+// with a Lamport time after afterLamport, or all of them if limit is 0, in the order they were appended.
+func latest[T any](entries []T, lamport func(T) int32, afterLamport int32, limit int) []T {
+	var picked []T
+	for i := len(entries) - 1; i >= 0 && (limit <= 0 || len(picked) < limit); i-- {
+		if lamport(entries[i]) > afterLamport {
+			picked = append(picked, entries[i])
+		}
+	}
+	slices.Reverse(picked)
+	return picked
+}
+
+// sortedNames returns the names keys are registered to, in alphabetical order.
+func sortedNames(keys map[string]ed25519.PublicKey) []string {
+	names := make([]string, 0, len(keys))
+	for name := range keys {
+		names = append(names, name)
+	}
+	slices.Sort(names)
+	return names
+}
+
+// sortedRooms turns rooms and when they were created into Rooms, in alphabetical order.
+func sortedRooms(rooms map[string]time.Time) []Room {
+	sorted := make([]Room, 0, len(rooms))
+	for name, created := range rooms {
+		sorted = append(sorted, Room{Name: name, Created: created})
+	}
+	slices.SortFunc(sorted, func(a, b Room) int { return strings.Compare(a.Name, b.Name) })
+	return sorted
+}
+
+// receiptBook is the receipts of every message kept, and the ids of the messages in the order
+// they were first saved.
+type receiptBook struct {
+	receipts map[string]Receipts
+	order    []string
+}
+
+func newReceiptBook() *receiptBook {
+	return &receiptBook{receipts: make(map[string]Receipts)}
+}
+
+func (book *receiptBook) save(receipts Receipts) {
+	if _, ok := book.receipts[receipts.MessageID]; !ok {
+		book.order = append(book.order, receipts.MessageID)
+	}
+	book.receipts[receipts.MessageID] = receipts.clone()
+}
+
+// remove returns whether any of the messages had receipts.
+func (book *receiptBook) remove(messageIDs []string) bool {
+	removed := false
+	for _, id := range messageIDs {
+		if _, ok := book.receipts[id]; ok {
+			delete(book.receipts, id)
+			removed = true
+		}
+	}
+	if removed {
+		book.order = slices.DeleteFunc(slices.Clone(book.order), func(id string) bool {
+			_, ok := book.receipts[id]
+			return !ok
+		})
+	}
+	return removed
+}
+
+// all returns copies of every receipts kept, in the order they were first saved.
+func (book *receiptBook) all() []Receipts {
+	all := make([]Receipts, 0, len(book.order))
+	for _, id := range book.order {
+		all = append(all, book.receipts[id].clone())
+	}
+	return all
+}
+
+// MemoryStorage keeps everything in memory, so it is lost when the process exits.
+type MemoryStorage struct {
+	mutex     sync.Mutex
+	keys      map[string]ed25519.PublicKey
+	rooms     map[string]time.Time
+	events    []Event
+	mailboxes mailboxes
+	messages  []*chitchat.ServerMessage
+	//the same messages, by room
+	roomMessages map[string][]*chitchat.ServerMessage
+	receipts     *receiptBook
+}
+
+func NewMemoryStorage() *MemoryStorage {
+	return &MemoryStorage{
+		keys:      make(map[string]ed25519.PublicKey),
+		rooms:     make(map[string]time.Time),
+		mailboxes: make(mailboxes),
+
+		roomMessages: make(map[string][]*chitchat.ServerMessage),
+		receipts:     newReceiptBook(),
+	}
+}
+
+func (storage *MemoryStorage) PublicKey(name string) (ed25519.PublicKey, error) {
+	storage.mutex.Lock()
+	defer storage.mutex.Unlock()
+	return bytes.Clone(storage.keys[name]), nil
+}
+
+func (storage *MemoryStorage) RegisterPublicKey(name string, key ed25519.PublicKey) error {
+	storage.mutex.Lock()
+	defer storage.mutex.Unlock()
+	storage.keys[name] = bytes.Clone(key)
+	return nil
+}
+
+func (storage *MemoryStorage) Users() ([]string, error) {
+	storage.mutex.Lock()
+	defer storage.mutex.Unlock()
+	return sortedNames(storage.keys), nil
+}
+
+func (storage *MemoryStorage) AddRoom(name string, created time.Time) error {
+	storage.mutex.Lock()
+	defer storage.mutex.Unlock()
+	if _, ok := storage.rooms[name]; !ok {
+		storage.rooms[name] = created
+	}
+	return nil
+}
+
+func (storage *MemoryStorage) Rooms() ([]Room, error) {
+	storage.mutex.Lock()
+	defer storage.mutex.Unlock()
+	return sortedRooms(storage.rooms), nil
+}
+
+func (storage *MemoryStorage) RecordEvent(event Event) error {
+	storage.mutex.Lock()
+	defer storage.mutex.Unlock()
+	storage.events = append(storage.events, event)
+	return nil
+}
+
+// Events returns every event recorded so far, oldest first.
+func (storage *MemoryStorage) Events() []Event {
+	storage.mutex.Lock()
+	defer storage.mutex.Unlock()
+	return append([]Event(nil), storage.events...)
+}
+
+func (storage *MemoryStorage) StoreDirect(entry MailboxEntry) error {
+	storage.mutex.Lock()
+	defer storage.mutex.Unlock()
+	storage.mailboxes = storage.mailboxes.store(entry)
+	return nil
+}
+
+func (storage *MemoryStorage) Mailbox(name string) ([]MailboxEntry, error) {
+	storage.mutex.Lock()
+	defer storage.mutex.Unlock()
+	return slices.Clone(storage.mailboxes[name]), nil
+}
+
+func (storage *MemoryStorage) RemoveDirect(name string, messageIDs []string) error {
+	storage.mutex.Lock()
+	defer storage.mutex.Unlock()
+	storage.mailboxes = storage.mailboxes.remove(name, messageIDs)
+	return nil
+}
+
+func (storage *MemoryStorage) ExpireDirect(before time.Time) (int, error) {
+	storage.mutex.Lock()
+	defer storage.mutex.Unlock()
+	var expired int
+	storage.mailboxes, expired = storage.mailboxes.expire(before)
+	return expired, nil
+}
+
+func (storage *MemoryStorage) AppendMessage(message *chitchat.ServerMessage) error {
+	return storage.AppendMessages([]*chitchat.ServerMessage{message})
+}
+
+func (storage *MemoryStorage) AppendMessages(messages []*chitchat.ServerMessage) error {
+	storage.mutex.Lock()
+	defer storage.mutex.Unlock()
+	for _, message := range messages {
+		message = proto.Clone(message).(*chitchat.ServerMessage)
+		storage.messages = append(storage.messages, message)
+		storage.roomMessages[message.Room] = append(storage.roomMessages[message.Room], message)
+	}
+	return nil
+}
+
+func (storage *MemoryStorage) Messages(fn func(*chitchat.ServerMessage) bool) error {
+	storage.mutex.Lock()
+	messages := storage.messages
+	storage.mutex.Unlock()
+	for _, message := range messages {
+		if !fn(proto.Clone(message).(*chitchat.ServerMessage)) {
+			break
+		}
+	}
+	return nil
+}
+
+func (storage *MemoryStorage) RewriteMessages(rewrite func(*chitchat.ServerMessage) *chitchat.ServerMessage) error {
+	storage.mutex.Lock()
+	defer storage.mutex.Unlock()
+	//Messages may still be going through the old slice, so this makes a new one.
+	var kept []*chitchat.ServerMessage
+	rooms := make(map[string][]*chitchat.ServerMessage)
+	for _, message := range storage.messages {
+		if rewritten := rewrite(proto.Clone(message).(*chitchat.ServerMessage)); rewritten != nil {
+			rewritten = proto.Clone(rewritten).(*chitchat.ServerMessage)
+			kept = append(kept, rewritten)
+			rooms[rewritten.Room] = append(rooms[rewritten.Room], rewritten)
+		}
+	}
+	storage.messages = kept
+	storage.roomMessages = rooms
+	return nil
+}
+
+func (storage *MemoryStorage) RoomMessages(room string, afterLamport int32, limit int) ([]*chitchat.ServerMessage, error) {
+	storage.mutex.Lock()
+	defer storage.mutex.Unlock()
+	picked := latest(storage.roomMessages[room], (*chitchat.ServerMessage).GetLamport, afterLamport, limit)
+	for i, message := range picked {
+		picked[i] = proto.Clone(message).(*chitchat.ServerMessage)
+	}
+	return picked, nil
+}
+
+func (storage *MemoryStorage) SaveReceipts(receipts Receipts) error {
+	storage.mutex.Lock()
+	defer storage.mutex.Unlock()
+	storage.receipts.save(receipts)
+	return nil
+}
+
+func (storage *MemoryStorage) Receipts(fn func(Receipts) bool) error {
+	storage.mutex.Lock()
+	all := storage.receipts.all()
+	storage.mutex.Unlock()
+	for _, receipts := range all {
+		if !fn(receipts) {
+			break
+		}
+	}
+	return nil
+}
+
+func (storage *MemoryStorage) RemoveReceipts(messageIDs []string) error {
+	storage.mutex.Lock()
+	defer storage.mutex.Unlock()
+	storage.receipts.remove(messageIDs)
+	return nil
+}
+
+func (storage *MemoryStorage) Close() error {
+	return nil
+}
+
+// Files FileStorage keeps inside its directory.
+const (
+	AuditLogFile   = "audit.log"
+	KeysFile       = "keys.json"
+	SigningKeyFile = "server.key"
+	MailboxesFile  = "mailboxes.json"
+	MessagesFile   = "messages.jsonl"
+	RoomsFile      = "rooms.json"
+	ReceiptsFile   = "receipts.jsonl"
+)
+
+// how many more lines than messages with receipts the receipts file may have before it is
+// written anew, with only the latest line of each
+const receiptLinesSlack = 1000
+
+// FileStorage keeps registered keys, rooms and undelivered direct messages in JSON files, what was
+// said in rooms and the receipts of what was said in files of JSON lines and events in a hash-chained
+// audit log, all inside one directory.
+type FileStorage struct {
+	mutex         sync.Mutex
+	dir           string
+	keysPath      string
+	keys          map[string]ed25519.PublicKey
+	roomsPath     string
+	rooms         map[string]time.Time
+	mailboxesPath string
+	mailboxes     mailboxes
+	messagesPath  string
+	messagesFile  *os.File
+	//how long the messages file is, and where each room's messages are in it, so the latest of
+	//a room can be read without reading the rest
+	messagesSize int64
+	roomLines    map[string][]messageLine
+	//every save of receipts is a line of its own, so the file has more lines than receipts has messages.
+	receiptsPath string
+	receiptsFile *os.File
+	receipts     *receiptBook
+	receiptLines int
+	auditLog     *AuditLog
+}
+
+// messageLine is where a message is in the messages file, without the newline after it.
+type messageLine struct {
+	lamport int32
+	offset  int64
+	length  int
+}
+
+// fileMailboxEntry is how a MailboxEntry is saved, with the message in its wire format.
+type fileMailboxEntry struct {
+	Message []byte    `json:"message"`
+	Stored  time.Time `json:"stored"`
+}
+
+// OpenFileStorage opens the storage in dir, creating the directory if needed.
+func OpenFileStorage(dir string) (*FileStorage, error) {
+	if err := os.MkdirAll(dir, 0700); err != nil {
+		return nil, err
+	}
+	storage := &FileStorage{
+		dir:           dir,
+		keysPath:      filepath.Join(dir, KeysFile),
+		keys:          make(map[string]ed25519.PublicKey),
+		roomsPath:     filepath.Join(dir, RoomsFile),
+		rooms:         make(map[string]time.Time),
+		mailboxesPath: filepath.Join(dir, MailboxesFile),
+		messagesPath:  filepath.Join(dir, MessagesFile),
+		receiptsPath:  filepath.Join(dir, ReceiptsFile),
+		receipts:      newReceiptBook(),
+	}
+	contents, err := os.ReadFile(storage.keysPath)
+	if err == nil {
+		if err := json.Unmarshal(contents, &storage.keys); err != nil {
+			return nil, fmt.Errorf("%s: %w", storage.keysPath, err)
+		}
+	} else if !os.IsNotExist(err) {
+		return nil, err
+	}
+	contents, err = os.ReadFile(storage.roomsPath)
+	if err == nil {
+		var rooms []Room
+		if err := json.Unmarshal(contents, &rooms); err != nil {
+			return nil, fmt.Errorf("%s: %w", storage.roomsPath, err)
+		}
+		for _, room := range rooms {
+			storage.rooms[room.Name] = room.Created
+		}
+	} else if !os.IsNotExist(err) {
+		return nil, err
+	}
+	if storage.mailboxes, err = loadMailboxes(storage.mailboxesPath); err != nil {
+		return nil, err
+	}
+	if storage.messagesFile, err = os.OpenFile(storage.messagesPath, os.O_WRONLY|os.O_APPEND|os.O_CREATE, 0600); err != nil {
+		return nil, err
+	}
+	if err := storage.findRoomLines(); err != nil {
+		return nil, err
+	}
+	if err := storage.loadReceipts(); err != nil {
+		return nil, err
+	}
+	if storage.receiptsFile, err = os.OpenFile(storage.receiptsPath, os.O_WRONLY|os.O_APPEND|os.O_CREATE, 0600); err != nil {
+		return nil, err
+	}
+
+	storage.auditLog, err = OpenAuditLog(filepath.Join(dir, AuditLogFile))
+	if err != nil {
+		return nil, err
+	}
+	return storage, nil
+}
+
+func (storage *FileStorage) PublicKey(name string) (ed25519.PublicKey, error) {
+	storage.mutex.Lock()
+	defer storage.mutex.Unlock()
+	return bytes.Clone(storage.keys[name]), nil
+}
+
+// RegisterPublicKey saves the keys by writing a new file and renaming it over the old one,
+// so a crash never leaves half a file behind.
+func (storage *FileStorage) RegisterPublicKey(name string, key ed25519.PublicKey) error {
+	storage.mutex.Lock()
+	defer storage.mutex.Unlock()
+
+	keys := make(map[string]ed25519.PublicKey, len(storage.keys)+1)
+	for registered, registeredKey := range storage.keys {
+		keys[registered] = registeredKey
+	}
+	keys[name] = bytes.Clone(key)
+	contents, err := json.MarshalIndent(keys, "", "  ")
+	if err != nil {
+		return err
+	}
+	if err := replaceFile(storage.keysPath, contents); err != nil {
+		return err
+	}
+	storage.keys = keys
+	return nil
+}
+
+func (storage *FileStorage) Users() ([]string, error) {
+	storage.mutex.Lock()
+	defer storage.mutex.Unlock()
+	return sortedNames(storage.keys), nil
+}
+
+// AddRoom saves the rooms the way RegisterPublicKey saves the keys. Adding a room that is
+// kept already writes nothing.
+func (storage *FileStorage) AddRoom(name string, created time.Time) error {
+	storage.mutex.Lock()
+	defer storage.mutex.Unlock()
+	if _, ok := storage.rooms[name]; ok {
+		return nil
+	}
+	rooms := maps.Clone(storage.rooms)
+	rooms[name] = created
+	contents, err := json.MarshalIndent(sortedRooms(rooms), "", "  ")
+	if err != nil {
+		return err
+	}
+	if err := replaceFile(storage.roomsPath, contents); err != nil {
+		return err
+	}
+	storage.rooms = rooms
+	return nil
+}
+
+func (storage *FileStorage) Rooms() ([]Room, error) {
+	storage.mutex.Lock()
+	defer storage.mutex.Unlock()
+	return sortedRooms(storage.rooms), nil
+}
+
+// replaceFile writes a new file and renames it over the old one.
+func replaceFile(path string, contents []byte) error {
+	temporary := path + ".tmp"
+	if err := os.WriteFile(temporary, contents, 0600); err != nil {
+		return err
+	}
+	return os.Rename(temporary, path)
+}
+
+// replaceAppendFile is replaceFile for a file that is appended to, returning the new file opened
+// for appending. The new file is opened before it takes the old one's place, so if anything fails
+// the old file is still there, and whoever appends to it can go on with the handle they have.
+func replaceAppendFile(path string, contents []byte) (*os.File, error) {
+	temporary := path + ".tmp"
+	if err := os.WriteFile(temporary, contents, 0600); err != nil {
+		return nil, err
+	}
+	file, err := os.OpenFile(temporary, os.O_WRONLY|os.O_APPEND, 0600)
+	if err != nil {
+		os.Remove(temporary)
+		return nil, err
+	}
+	if err := os.Rename(temporary, path); err != nil {
+		file.Close()
+		os.Remove(temporary)
+		return nil, err
+	}
+	return file, nil
+}
+
+func loadMailboxes(path string) (mailboxes, error) {
+	boxes := make(mailboxes)
+	contents, err := os.ReadFile(path)
+	if os.IsNotExist(err) {
+		return boxes, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	var saved map[string][]fileMailboxEntry
+	if err := json.Unmarshal(contents, &saved); err != nil {
+		return nil, fmt.Errorf("%s: %w", path, err)
+	}
+	for name, entries := range saved {
+		for _, entry := range entries {
+			message := &chitchat.ServerMessage{}
+			if err := proto.Unmarshal(entry.Message, message); err != nil {
+				return nil, fmt.Errorf("%s: %w", path, err)
+			}
+			boxes[name] = append(boxes[name], MailboxEntry{Message: message, Stored: entry.Stored})
+		}
+	}
+	return boxes, nil
+}
+
+// saveMailboxes saves the mailboxes as they will be once changed, and keeps them if that worked.
+// It must be called with the mutex held.
+func (storage *FileStorage) saveMailboxes(changed mailboxes) error {
+	saved := make(map[string][]fileMailboxEntry, len(changed))
+	for name, entries := range changed {
+		for _, entry := range entries {
+			message, err := proto.Marshal(entry.Message)
+			if err != nil {
+				return err
+			}
+			saved[name] = append(saved[name], fileMailboxEntry{Message: message, Stored: entry.Stored})
+		}
+	}
+	contents, err := json.MarshalIndent(saved, "", "  ")
+	if err != nil {
+		return err
+	}
+	if err := replaceFile(storage.mailboxesPath, contents); err != nil {
+		return err
+	}
+	storage.mailboxes = changed
+	return nil
+}
+
+// SigningKey returns the key the server signs its own messages with, creating it the first
+// time. Keeping it lets clients recognise the server's messages after a restart.
+func (storage *FileStorage) SigningKey() (ed25519.PrivateKey, error) {
+	keyPath := filepath.Join(storage.dir, SigningKeyFile)
+	seed, err := os.ReadFile(keyPath)
+	if err == nil {
+		if len(seed) != ed25519.SeedSize {
+			return nil, fmt.Errorf("key file %s is corrupt", keyPath)
+		}
+		return ed25519.NewKeyFromSeed(seed), nil
+	}
+	if !os.IsNotExist(err) {
+		return nil, err
+	}
+	_, key, err := ed25519.GenerateKey(nil)
+	if err != nil {
+		return nil, err
+	}
+	if err := os.WriteFile(keyPath, key.Seed(), 0600); err != nil {
+		return nil, err
+	}
+	return key, nil
+}
+
+func (storage *FileStorage) RecordEvent(event Event) error {
+	return storage.auditLog.Append(event.Kind, event.Actor, event.Subject, event.Room, event.Lamport)
+}
+
+// StoreDirect, RemoveDirect and ExpireDirect save every mailbox the way RegisterPublicKey saves the keys.
+func (storage *FileStorage) StoreDirect(entry MailboxEntry) error {
+	storage.mutex.Lock()
+	defer storage.mutex.Unlock()
+	return storage.saveMailboxes(storage.mailboxes.store(entry))
+}
+
+func (storage *FileStorage) Mailbox(name string) ([]MailboxEntry, error) {
+	storage.mutex.Lock()
+	defer storage.mutex.Unlock()
+	return slices.Clone(storage.mailboxes[name]), nil
+}
+
+func (storage *FileStorage) RemoveDirect(name string, messageIDs []string) error {
+	storage.mutex.Lock()
+	defer storage.mutex.Unlock()
+	changed := storage.mailboxes.remove(name, messageIDs)
+	//every connection a user has acknowledges the same messages, so most have nothing left to remove.
+	if len(changed[name]) == len(storage.mailboxes[name]) {
+		return nil
+	}
+	return storage.saveMailboxes(changed)
+}
+
+func (storage *FileStorage) ExpireDirect(before time.Time) (int, error) {
+	storage.mutex.Lock()
+	defer storage.mutex.Unlock()
+	changed, expired := storage.mailboxes.expire(before)
+	if expired == 0 {
+		return 0, nil
+	}
+	return expired, storage.saveMailboxes(changed)
+}
+
+// AppendMessage writes the message as one line of JSON at the end of the messages file.
+func (storage *FileStorage) AppendMessage(message *chitchat.ServerMessage) error {
+	return storage.AppendMessages([]*chitchat.ServerMessage{message})
+}
+
+// AppendMessages writes the messages in one go, and cuts off what was written of them if that fails.
+func (storage *FileStorage) AppendMessages(messages []*chitchat.ServerMessage) error {
+	var contents bytes.Buffer
+	lines := make([]messageLine, len(messages))
+	for i, message := range messages {
+		//the trace context only mattered on the way out.
+		message = proto.Clone(message).(*chitchat.ServerMessage)
+		message.TraceContext = nil
+		line, err := protojson.Marshal(message)
+		if err != nil {
+			return err
+		}
+		lines[i] = messageLine{lamport: message.Lamport, offset: int64(contents.Len()), length: len(line)}
+		contents.Write(line)
+		contents.WriteByte('\n')
+	}
+	storage.mutex.Lock()
+	defer storage.mutex.Unlock()
+	start := storage.messagesSize
+	written, err := storage.messagesFile.Write(contents.Bytes())
+	if err != nil {
+		if truncateErr := storage.messagesFile.Truncate(start); truncateErr != nil {
+			storage.messagesSize += int64(written)
+			return errors.Join(err, truncateErr)
+		}
+		return err
+	}
+	storage.messagesSize += int64(written)
+	for i, message := range messages {
+		line := lines[i]
+		line.offset += start
+		storage.roomLines[message.Room] = append(storage.roomLines[message.Room], line)
+	}
+	return nil
+}
+
+func (storage *FileStorage) Messages(fn func(*chitchat.ServerMessage) bool) error {
+	//a line being appended must not be read half written.
+	storage.mutex.Lock()
+	defer storage.mutex.Unlock()
+	return storage.readMessages(fn)
+}
+
+// RewriteMessages writes the rewritten messages to a new file, which then takes the old one's place.
+func (storage *FileStorage) RewriteMessages(rewrite func(*chitchat.ServerMessage) *chitchat.ServerMessage) error {
+	storage.mutex.Lock()
+	defer storage.mutex.Unlock()
+	var contents bytes.Buffer
+	rooms := make(map[string][]messageLine)
+	var err error
+	readErr := storage.readMessages(func(message *chitchat.ServerMessage) bool {
+		rewritten := rewrite(message)
+		if rewritten == nil {
+			return true
+		}
+		var line []byte
+		if line, err = protojson.Marshal(rewritten); err != nil {
+			return false
+		}
+		rooms[rewritten.Room] = append(rooms[rewritten.Room], messageLine{lamport: rewritten.Lamport, offset: int64(contents.Len()), length: len(line)})
+		contents.Write(line)
+		contents.WriteByte('\n')
+		return true
+	})
+	if err = errors.Join(readErr, err); err != nil {
+		return err
+	}
+	file, err := replaceAppendFile(storage.messagesPath, contents.Bytes())
+	if err != nil {
+		return err
+	}
+	//the old file is gone, so appending goes on in the new one.
+	storage.messagesFile.Close()
+	storage.messagesFile = file
+	storage.messagesSize = int64(contents.Len())
+	storage.roomLines = rooms
+	return nil
+}
+
+// readMessages reads the messages file. It must be called with the mutex held.
+func (storage *FileStorage) readMessages(fn func(*chitchat.ServerMessage) bool) error {
+	return storage.readMessageLines(func(message *chitchat.ServerMessage, _ messageLine) bool {
+		return fn(message)
+	})
+}
+
+// readMessageLines reads the messages file, with where each message is in it.
+// It must be called with the mutex held.
+func (storage *FileStorage) readMessageLines(fn func(*chitchat.ServerMessage, messageLine) bool) error {
+	file, err := os.Open(storage.messagesPath)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+	lines := bufio.NewScanner(file)
+	lines.Buffer(nil, 1<<20)
+	var offset int64
+	for number := 1; lines.Scan(); number++ {
+		message := &chitchat.ServerMessage{}
+		if err := protojson.Unmarshal(lines.Bytes(), message); err != nil {
+			return fmt.Errorf("%s:%d: %w", storage.messagesPath, number, err)
+		}
+		line := messageLine{lamport: message.Lamport, offset: offset, length: len(lines.Bytes())}
+		offset += int64(line.length) + 1
+		if !fn(message, line) {
+			return nil
+		}
+	}
+	return lines.Err()
+}
+
+// findRoomLines finds where each room's messages are in the messages file. It is called when the storage is opened.
+func (storage *FileStorage) findRoomLines() error {
+	storage.roomLines = make(map[string][]messageLine)
+	storage.messagesSize = 0
+	return storage.readMessageLines(func(message *chitchat.ServerMessage, line messageLine) bool {
+		storage.roomLines[message.Room] = append(storage.roomLines[message.Room], line)
+		storage.messagesSize = line.offset + int64(line.length) + 1
+		return true
+	})
+}
+
+// RoomMessages reads only the lines of the messages file the room's latest messages are on.
+func (storage *FileStorage) RoomMessages(room string, afterLamport int32, limit int) ([]*chitchat.ServerMessage, error) {
+	storage.mutex.Lock()
+	defer storage.mutex.Unlock()
+	lines := latest(storage.roomLines[room], func(line messageLine) int32 { return line.lamport }, afterLamport, limit)
+	if len(lines) == 0 {
+		return nil, nil
+	}
+	file, err := os.Open(storage.messagesPath)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+	messages := make([]*chitchat.ServerMessage, 0, len(lines))
+	for _, line := range lines {
+		contents := make([]byte, line.length)
+		if _, err := file.ReadAt(contents, line.offset); err != nil {
+			return nil, fmt.Errorf("%s at %d: %w", storage.messagesPath, line.offset, err)
+		}
+		message := &chitchat.ServerMessage{}
+		if err := protojson.Unmarshal(contents, message); err != nil {
+			return nil, fmt.Errorf("%s at %d: %w", storage.messagesPath, line.offset, err)
+		}
+		messages = append(messages, message)
+	}
+	return messages, nil
+}
+
+// loadReceipts reads the receipts file, where later lines replace earlier ones about the same message.
+func (storage *FileStorage) loadReceipts() error {
+	file, err := os.Open(storage.receiptsPath)
+	if os.IsNotExist(err) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+	lines := bufio.NewScanner(file)
+	lines.Buffer(nil, 1<<20)
+	for number := 1; lines.Scan(); number++ {
+		var receipts Receipts
+		if err := json.Unmarshal(lines.Bytes(), &receipts); err != nil {
+			return fmt.Errorf("%s:%d: %w", storage.receiptsPath, number, err)
+		}
+		storage.receipts.save(receipts)
+		storage.receiptLines++
+	}
+	return lines.Err()
+}
+
+// SaveReceipts writes the receipts as one line of JSON at the end of the receipts file, and writes
+// the file anew once it has too many lines about messages it has newer lines for.
+func (storage *FileStorage) SaveReceipts(receipts Receipts) error {
+	line, err := json.Marshal(receipts)
+	if err != nil {
+		return err
+	}
+	storage.mutex.Lock()
+	defer storage.mutex.Unlock()
+	if _, err := storage.receiptsFile.Write(append(line, '\n')); err != nil {
+		return err
+	}
+	storage.receipts.save(receipts)
+	storage.receiptLines++
+	if storage.receiptLines > 2*len(storage.receipts.receipts)+receiptLinesSlack {
+		return storage.writeReceipts(storage.receipts.all())
+	}
+	return nil
+}
+
+func (storage *FileStorage) Receipts(fn func(Receipts) bool) error {
+	storage.mutex.Lock()
+	all := storage.receipts.all()
+	storage.mutex.Unlock()
+	for _, receipts := range all {
+		if !fn(receipts) {
+			break
+		}
+	}
+	return nil
+}
+
+func (storage *FileStorage) RemoveReceipts(messageIDs []string) error {
+	storage.mutex.Lock()
+	defer storage.mutex.Unlock()
+	removed := make(map[string]bool, len(messageIDs))
+	for _, id := range messageIDs {
+		removed[id] = true
+	}
+	kept := slices.DeleteFunc(storage.receipts.all(), func(receipts Receipts) bool { return removed[receipts.MessageID] })
+	if len(kept) == len(storage.receipts.order) {
+		return nil
+	}
+	if err := storage.writeReceipts(kept); err != nil {
+		return err
+	}
+	storage.receipts.remove(messageIDs)
+	return nil
+}
+
+// writeReceipts replaces the receipts file with one line for each of kept.
+// It must be called with the mutex held.
+func (storage *FileStorage) writeReceipts(kept []Receipts) error {
+	var contents bytes.Buffer
+	for _, receipts := range kept {
+		line, err := json.Marshal(receipts)
+		if err != nil {
+			return err
+		}
+		contents.Write(line)
+		contents.WriteByte('\n')
+	}
+	file, err := replaceAppendFile(storage.receiptsPath, contents.Bytes())
+	if err != nil {
+		return err
+	}
+	//the old file is gone, so appending goes on in the new one.
+	storage.receiptsFile.Close()
+	storage.receiptsFile = file
+	storage.receiptLines = len(kept)
+	return nil
+}
+
+func (storage *FileStorage) Close() error {
+	return errors.Join(storage.messagesFile.Close(), storage.receiptsFile.Close(), storage.auditLog.Close())
+}
@@ -0,0 +1,64 @@
+package chatserver_test
+
+import (
+	"fmt"
+	"slices"
+	"testing"
+
+	"homework3/chatserver"
+	"homework3/chatserver/storetest"
+	chitchat "homework3/chitchat"
+)
+
+func TestMemoryStorage(t *testing.T) {
+	storetest.Run(t, func(t *testing.T) chatserver.Storage {
+		return chatserver.NewMemoryStorage()
+	})
+}
+
+func TestFileStorage(t *testing.T) {
+	storetest.Run(t, func(t *testing.T) chatserver.Storage {
+		storage, err := chatserver.OpenFileStorage(t.TempDir())
+		if err != nil {
+			t.Fatal(err)
+		}
+		return storage
+	})
+}
+
+func TestFileStorageReopen(t *testing.T) {
+	dir := t.TempDir()
+	storage, err := chatserver.OpenFileStorage(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i, room := range []string{"general", "ops", "general", "general"} {
+		message := &chitchat.ServerMessage{Id: fmt.Sprint(i), Room: room, Text: "message " + fmt.Sprint(i), Lamport: int32(i + 1), Kind: chitchat.ServerMessage_CHAT}
+		if err := storage.AppendMessage(message); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := storage.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	storage, err = chatserver.OpenFileStorage(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer storage.Close()
+	if err := storage.AppendMessage(&chitchat.ServerMessage{Id: "4", Room: "general", Lamport: 5, Kind: chitchat.ServerMessage_CHAT}); err != nil {
+		t.Fatal(err)
+	}
+	messages, err := storage.RoomMessages("general", 0, 3)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var ids []string
+	for _, message := range messages {
+		ids = append(ids, message.Id)
+	}
+	if !slices.Equal(ids, []string{"2", "3", "4"}) {
+		t.Errorf("the latest messages of general after reopening are %v", ids)
+	}
+}
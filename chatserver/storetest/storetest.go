@@ -0,0 +1,494 @@
+// Package storetest checks that a chatserver.Storage does what the interface asks of it.
+// The tests of a storage call Run with a function that opens a new, empty one:
+//
+//	func TestStorage(t *testing.T) {
+//		storetest.Run(t, func(t *testing.T) chatserver.Storage {
+//			storage, err := chatserver.OpenFileStorage(t.TempDir())
+//			if err != nil {
+//				t.Fatal(err)
+//			}
+//			return storage
+//		})
+//	}
+package storetest
+
+import (
+	"bytes"
+	"crypto/ed25519"
+	"fmt"
+	"slices"
+	"sync"
+	"testing"
+	"time"
+
+	"homework3/chatserver"
+	chitchat "homework3/chitchat"
+
+	"google.golang.org/protobuf/proto"
+	"google.golang.org/protobuf/types/known/timestamppb"
+)
+
+// when the messages, mailbox entries, rooms and receipts of the tests were made
+var base = time.Date(2024, 5, 1, 9, 30, 0, 0, time.UTC)
+
+// Run runs every check against storages newStorage opens, a new one for each. Storages are closed
+// when their check is done.
+func Run(t *testing.T, newStorage func(t *testing.T) chatserver.Storage) {
+	checks := []struct {
+		name  string
+		check func(t *testing.T, storage chatserver.Storage)
+	}{
+		{"Keys", testKeys},
+		{"Users", testUsers},
+		{"Rooms", testRooms},
+		{"Mailboxes", testMailboxes},
+		{"Messages", testMessages},
+		{"AppendMessages", testAppendMessages},
+		{"RoomMessages", testRoomMessages},
+		{"RewriteMessages", testRewriteMessages},
+		{"Receipts", testReceipts},
+		{"Concurrent", testConcurrent},
+	}
+	for _, check := range checks {
+		t.Run(check.name, func(t *testing.T) {
+			storage := newStorage(t)
+			t.Cleanup(func() {
+				if err := storage.Close(); err != nil {
+					t.Errorf("Close: %v", err)
+				}
+			})
+			check.check(t, storage)
+		})
+	}
+}
+
+func newKey(t *testing.T) ed25519.PublicKey {
+	t.Helper()
+	key, _, err := ed25519.GenerateKey(nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return key
+}
+
+func testKeys(t *testing.T, storage chatserver.Storage) {
+	key, err := storage.PublicKey("alice")
+	if err != nil || key != nil {
+		t.Fatalf("PublicKey of a free name = %v, %v; want nil, nil", key, err)
+	}
+	registered := newKey(t)
+	want := bytes.Clone(registered)
+	if err := storage.RegisterPublicKey("alice", registered); err != nil {
+		t.Fatalf("RegisterPublicKey: %v", err)
+	}
+	//neither the key given nor the one handed out may be what is kept.
+	registered[0]++
+	key, err = storage.PublicKey("alice")
+	if err != nil || !bytes.Equal(key, want) {
+		t.Fatalf("PublicKey = %x, %v; want %x", key, err, want)
+	}
+	key[0]++
+	if key, _ := storage.PublicKey("alice"); !bytes.Equal(key, want) {
+		t.Errorf("changing the key PublicKey returned changed the one kept")
+	}
+	if key, _ := storage.PublicKey("bob"); key != nil {
+		t.Errorf("PublicKey of another name = %x, want nil", key)
+	}
+}
+
+func testUsers(t *testing.T, storage chatserver.Storage) {
+	users, err := storage.Users()
+	if err != nil || len(users) != 0 {
+		t.Fatalf("Users of a new storage = %v, %v; want none", users, err)
+	}
+	for _, name := range []string{"carol", "alice", "bob", "alice"} {
+		if err := storage.RegisterPublicKey(name, newKey(t)); err != nil {
+			t.Fatalf("RegisterPublicKey: %v", err)
+		}
+	}
+	users, err = storage.Users()
+	if want := []string{"alice", "bob", "carol"}; err != nil || !slices.Equal(users, want) {
+		t.Errorf("Users = %v, %v; want %v", users, err, want)
+	}
+}
+
+func testRooms(t *testing.T, storage chatserver.Storage) {
+	rooms, err := storage.Rooms()
+	if err != nil || len(rooms) != 0 {
+		t.Fatalf("Rooms of a new storage = %v, %v; want none", rooms, err)
+	}
+	added := []chatserver.Room{
+		{Name: "ops", Created: base.Add(time.Minute)},
+		{Name: "general", Created: base},
+		//a room keeps when it was first added.
+		{Name: "ops", Created: base.Add(time.Hour)},
+	}
+	for _, room := range added {
+		if err := storage.AddRoom(room.Name, room.Created); err != nil {
+			t.Fatalf("AddRoom(%q): %v", room.Name, err)
+		}
+	}
+	rooms, err = storage.Rooms()
+	if err != nil {
+		t.Fatalf("Rooms: %v", err)
+	}
+	want := []chatserver.Room{added[1], added[0]}
+	if len(rooms) != len(want) {
+		t.Fatalf("Rooms = %v, want %v", rooms, want)
+	}
+	for i := range want {
+		if rooms[i].Name != want[i].Name || !rooms[i].Created.Equal(want[i].Created) {
+			t.Errorf("Rooms()[%d] = %v, want %v", i, rooms[i], want[i])
+		}
+	}
+}
+
+func direct(id string, to string) *chitchat.ServerMessage {
+	return &chitchat.ServerMessage{Id: id, Name: "alice", To: to, Text: "psst " + id, Lamport: 1, Kind: chitchat.ServerMessage_DIRECT}
+}
+
+func testMailboxes(t *testing.T, storage chatserver.Storage) {
+	entries := []chatserver.MailboxEntry{
+		{Message: direct("1", "bob"), Stored: base},
+		{Message: direct("2", "carol"), Stored: base.Add(time.Minute)},
+		{Message: direct("3", "bob"), Stored: base.Add(time.Hour)},
+	}
+	for _, entry := range entries {
+		if err := storage.StoreDirect(entry); err != nil {
+			t.Fatalf("StoreDirect: %v", err)
+		}
+	}
+	entries[0].Message.Text = "changed"
+	checkMailbox(t, storage, "bob", direct("1", "bob"), direct("3", "bob"))
+	checkMailbox(t, storage, "dave")
+
+	if err := storage.RemoveDirect("bob", []string{"1", "unknown"}); err != nil {
+		t.Fatalf("RemoveDirect: %v", err)
+	}
+	checkMailbox(t, storage, "bob", direct("3", "bob"))
+	checkMailbox(t, storage, "carol", direct("2", "carol"))
+
+	expired, err := storage.ExpireDirect(base.Add(30 * time.Minute))
+	if err != nil || expired != 1 {
+		t.Fatalf("ExpireDirect = %d, %v; want 1", expired, err)
+	}
+	checkMailbox(t, storage, "carol")
+	checkMailbox(t, storage, "bob", direct("3", "bob"))
+}
+
+func checkMailbox(t *testing.T, storage chatserver.Storage, name string, want ...*chitchat.ServerMessage) {
+	t.Helper()
+	entries, err := storage.Mailbox(name)
+	if err != nil {
+		t.Fatalf("Mailbox(%q): %v", name, err)
+	}
+	if len(entries) != len(want) {
+		t.Fatalf("Mailbox(%q) has %d entries, want %d", name, len(entries), len(want))
+	}
+	for i, entry := range entries {
+		if !proto.Equal(entry.Message, want[i]) {
+			t.Errorf("Mailbox(%q)[%d] = %v, want %v", name, i, entry.Message, want[i])
+		}
+		if entry.Stored.IsZero() {
+			t.Errorf("Mailbox(%q)[%d] lost when it was stored", name, i)
+		}
+	}
+}
+
+// chat makes the message said in room at a Lamport time.
+func chat(room string, lamport int32) *chitchat.ServerMessage {
+	return &chitchat.ServerMessage{
+		Id:      fmt.Sprintf("%08x", lamport),
+		Name:    "alice",
+		Text:    fmt.Sprintf("message %d", lamport),
+		Room:    room,
+		Lamport: lamport,
+		Kind:    chitchat.ServerMessage_CHAT,
+		SentAt:  timestamppb.New(base.Add(time.Duration(lamport) * time.Second)),
+	}
+}
+
+// appendMessages appends the messages, and returns what the storage should keep of them.
+func appendMessages(t *testing.T, storage chatserver.Storage, messages ...*chitchat.ServerMessage) []*chitchat.ServerMessage {
+	t.Helper()
+	var appended []*chitchat.ServerMessage
+	for _, message := range messages {
+		appended = append(appended, proto.Clone(message).(*chitchat.ServerMessage))
+		if err := storage.AppendMessage(message); err != nil {
+			t.Fatalf("AppendMessage: %v", err)
+		}
+		message.Text = "changed after it was appended"
+	}
+	return appended
+}
+
+func allMessages(t *testing.T, storage chatserver.Storage) []*chitchat.ServerMessage {
+	t.Helper()
+	var messages []*chitchat.ServerMessage
+	if err := storage.Messages(func(message *chitchat.ServerMessage) bool {
+		messages = append(messages, message)
+		return true
+	}); err != nil {
+		t.Fatalf("Messages: %v", err)
+	}
+	return messages
+}
+
+func checkMessages(t *testing.T, what string, got []*chitchat.ServerMessage, want []*chitchat.ServerMessage) {
+	t.Helper()
+	if len(got) != len(want) {
+		t.Fatalf("%s has %d messages, want %d", what, len(got), len(want))
+	}
+	for i := range want {
+		if !proto.Equal(got[i], want[i]) {
+			t.Errorf("%s[%d] = %v, want %v", what, i, got[i], want[i])
+		}
+	}
+}
+
+func testMessages(t *testing.T, storage chatserver.Storage) {
+	checkMessages(t, "Messages of a new storage", allMessages(t, storage), nil)
+	edit := &chitchat.ServerMessage{Name: "SERVER MESSAGE", Text: "better", Room: "general", Lamport: 3,
+		Kind: chitchat.ServerMessage_EDITED, Subject: "alice", Target: chat("general", 1).Id}
+	want := appendMessages(t, storage, chat("general", 1), chat("ops", 2), edit, chat("general", 4))
+	got := allMessages(t, storage)
+	checkMessages(t, "Messages", got, want)
+
+	got[0].Text = "changed after it was read"
+	checkMessages(t, "Messages after changing what it returned", allMessages(t, storage), want)
+
+	var read int
+	if err := storage.Messages(func(*chitchat.ServerMessage) bool {
+		read++
+		return read < 2
+	}); err != nil || read != 2 {
+		t.Errorf("Messages went on to message %d after being told to stop at 2, %v", read, err)
+	}
+}
+
+func testAppendMessages(t *testing.T, storage chatserver.Storage) {
+	want := appendMessages(t, storage, chat("general", 1))
+	batch := []*chitchat.ServerMessage{chat("ops", 2), chat("general", 3), chat("general", 4)}
+	for _, message := range batch {
+		want = append(want, proto.Clone(message).(*chitchat.ServerMessage))
+	}
+	if err := storage.AppendMessages(batch); err != nil {
+		t.Fatalf("AppendMessages: %v", err)
+	}
+	batch[0].Text = "changed after it was appended"
+	checkMessages(t, "Messages", allMessages(t, storage), want)
+	got, err := storage.RoomMessages("general", 0, 0)
+	if err != nil {
+		t.Fatalf("RoomMessages: %v", err)
+	}
+	checkMessages(t, "RoomMessages after AppendMessages", got, []*chitchat.ServerMessage{want[0], want[2], want[3]})
+}
+
+func testRoomMessages(t *testing.T, storage chatserver.Storage) {
+	messages := appendMessages(t, storage, chat("general", 1), chat("ops", 2), chat("general", 3), chat("general", 4), chat("ops", 5), chat("general", 6))
+	general := []*chitchat.ServerMessage{messages[0], messages[2], messages[3], messages[5]}
+	for _, test := range []struct {
+		room         string
+		afterLamport int32
+		limit        int
+		want         []*chitchat.ServerMessage
+	}{
+		{"general", 0, 0, general},
+		{"ops", 0, 0, []*chitchat.ServerMessage{messages[1], messages[4]}},
+		{"general", 3, 0, general[2:]},
+		{"general", 0, 3, general[1:]},
+		{"general", 1, 2, general[2:]},
+		{"general", 4, 5, general[3:]},
+		{"general", 6, 0, nil},
+		{"elsewhere", 0, 0, nil},
+	} {
+		got, err := storage.RoomMessages(test.room, test.afterLamport, test.limit)
+		if err != nil {
+			t.Fatalf("RoomMessages: %v", err)
+		}
+		checkMessages(t, fmt.Sprintf("RoomMessages(%q, %d, %d)", test.room, test.afterLamport, test.limit), got, test.want)
+	}
+}
+
+func testRewriteMessages(t *testing.T, storage chatserver.Storage) {
+	messages := appendMessages(t, storage, chat("general", 1), chat("general", 2), chat("ops", 3))
+	changed := proto.Clone(messages[2]).(*chitchat.ServerMessage)
+	changed.Text = ""
+	err := storage.RewriteMessages(func(message *chitchat.ServerMessage) *chitchat.ServerMessage {
+		switch message.Id {
+		case messages[0].Id:
+			return nil
+		case messages[2].Id:
+			rewritten := proto.Clone(message).(*chitchat.ServerMessage)
+			rewritten.Text = ""
+			return rewritten
+		}
+		return message
+	})
+	if err != nil {
+		t.Fatalf("RewriteMessages: %v", err)
+	}
+	checkMessages(t, "Messages after RewriteMessages", allMessages(t, storage), []*chitchat.ServerMessage{messages[1], changed})
+
+	//appending goes on after what was rewritten.
+	later := appendMessages(t, storage, chat("general", 4))
+	checkMessages(t, "Messages after appending to what was rewritten", allMessages(t, storage), []*chitchat.ServerMessage{messages[1], changed, later[0]})
+	got, err := storage.RoomMessages("general", 0, 0)
+	if err != nil {
+		t.Fatalf("RoomMessages: %v", err)
+	}
+	checkMessages(t, "RoomMessages after RewriteMessages", got, []*chitchat.ServerMessage{messages[1], later[0]})
+
+	if err := storage.RewriteMessages(func(*chitchat.ServerMessage) *chitchat.ServerMessage { return nil }); err != nil {
+		t.Fatalf("RewriteMessages dropping everything: %v", err)
+	}
+	checkMessages(t, "Messages after dropping everything", allMessages(t, storage), nil)
+}
+
+func receipts(id string, delivered ...string) chatserver.Receipts {
+	receipts := chatserver.Receipts{
+		MessageID:      id,
+		Author:         "alice",
+		Room:           "general",
+		Recipients:     3,
+		RecipientNames: []string{"bob", "carol", "dave"},
+		Delivered:      make(map[string]time.Time),
+		Read:           make(map[string]time.Time),
+	}
+	for i, name := range delivered {
+		receipts.Delivered[name] = base.Add(time.Duration(i) * time.Second)
+	}
+	return receipts
+}
+
+func allReceipts(t *testing.T, storage chatserver.Storage) []chatserver.Receipts {
+	t.Helper()
+	var all []chatserver.Receipts
+	if err := storage.Receipts(func(receipts chatserver.Receipts) bool {
+		all = append(all, receipts)
+		return true
+	}); err != nil {
+		t.Fatalf("Receipts: %v", err)
+	}
+	return all
+}
+
+func checkReceipts(t *testing.T, what string, got []chatserver.Receipts, want ...chatserver.Receipts) {
+	t.Helper()
+	sameTimes := func(a, b map[string]time.Time) bool {
+		if len(a) != len(b) {
+			return false
+		}
+		for name, at := range a {
+			if other, ok := b[name]; !ok || !other.Equal(at) {
+				return false
+			}
+		}
+		return true
+	}
+	if len(got) != len(want) {
+		t.Fatalf("%s has receipts for %d messages, want %d", what, len(got), len(want))
+	}
+	for i := range want {
+		if got[i].MessageID != want[i].MessageID || got[i].Author != want[i].Author || got[i].Room != want[i].Room ||
+			got[i].Recipients != want[i].Recipients || !slices.Equal(got[i].RecipientNames, want[i].RecipientNames) || !sameTimes(got[i].Delivered, want[i].Delivered) || !sameTimes(got[i].Read, want[i].Read) {
+			t.Errorf("%s[%d] = %+v, want %+v", what, i, got[i], want[i])
+		}
+	}
+}
+
+func testReceipts(t *testing.T, storage chatserver.Storage) {
+	checkReceipts(t, "Receipts of a new storage", allReceipts(t, storage))
+	first := receipts("1", "bob")
+	second := receipts("2")
+	for _, saved := range []chatserver.Receipts{first, second} {
+		if err := storage.SaveReceipts(saved); err != nil {
+			t.Fatalf("SaveReceipts: %v", err)
+		}
+	}
+	//a message's receipts are replaced, and stay where they were first saved.
+	updated := receipts("1", "bob", "carol")
+	updated.Read["bob"] = base.Add(time.Minute)
+	if err := storage.SaveReceipts(updated); err != nil {
+		t.Fatalf("SaveReceipts: %v", err)
+	}
+	want := receipts("1", "bob", "carol")
+	want.Read["bob"] = base.Add(time.Minute)
+	updated.Delivered["dave"] = base
+	checkReceipts(t, "Receipts", allReceipts(t, storage), want, second)
+
+	got := allReceipts(t, storage)
+	got[0].Read["carol"] = base
+	checkReceipts(t, "Receipts after changing what it returned", allReceipts(t, storage), want, second)
+
+	var read int
+	if err := storage.Receipts(func(chatserver.Receipts) bool {
+		read++
+		return false
+	}); err != nil || read != 1 {
+		t.Errorf("Receipts went on to message %d after being told to stop at 1, %v", read, err)
+	}
+
+	if err := storage.RemoveReceipts([]string{"1", "unknown"}); err != nil {
+		t.Fatalf("RemoveReceipts: %v", err)
+	}
+	checkReceipts(t, "Receipts after RemoveReceipts", allReceipts(t, storage), second)
+	if err := storage.RemoveReceipts(nil); err != nil {
+		t.Fatalf("RemoveReceipts of nothing: %v", err)
+	}
+	checkReceipts(t, "Receipts after removing nothing", allReceipts(t, storage), second)
+}
+
+func testConcurrent(t *testing.T, storage chatserver.Storage) {
+	const (
+		writers = 8
+		each    = 25
+	)
+	var wait sync.WaitGroup
+	errs := make(chan error, writers*each*3)
+	for writer := 0; writer < writers; writer++ {
+		wait.Add(1)
+		go func(writer int) {
+			defer wait.Done()
+			room := fmt.Sprintf("room%d", writer)
+			if err := storage.AddRoom(room, base); err != nil {
+				errs <- err
+			}
+			for i := 1; i <= each; i++ {
+				message := chat(room, int32(writer*each+i))
+				if err := storage.AppendMessage(message); err != nil {
+					errs <- err
+				}
+				if err := storage.SaveReceipts(receipts(message.Id, "bob")); err != nil {
+					errs <- err
+				}
+				if _, err := storage.RoomMessages(room, 0, 0); err != nil {
+					errs <- err
+				}
+			}
+		}(writer)
+	}
+	wait.Wait()
+	close(errs)
+	for err := range errs {
+		t.Fatal(err)
+	}
+	if got := len(allMessages(t, storage)); got != writers*each {
+		t.Errorf("%d messages were appended at once, but %d are kept", writers*each, got)
+	}
+	if got := len(allReceipts(t, storage)); got != writers*each {
+		t.Errorf("receipts for %d messages were saved at once, but %d are kept", writers*each, got)
+	}
+	for writer := 0; writer < writers; writer++ {
+		messages, err := storage.RoomMessages(fmt.Sprintf("room%d", writer), 0, 0)
+		if err != nil {
+			t.Fatalf("RoomMessages: %v", err)
+		}
+		if !slices.IsSortedFunc(messages, func(a, b *chitchat.ServerMessage) int { return int(a.Lamport - b.Lamport) }) || len(messages) != each {
+			t.Errorf("room%d has %d messages, not the %d appended to it in order", writer, len(messages), each)
+		}
+	}
+	if rooms, err := storage.Rooms(); err != nil || len(rooms) != writers {
+		t.Errorf("Rooms = %v, %v; want %d rooms", rooms, err, writers)
+	}
+}
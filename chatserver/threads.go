@@ -0,0 +1,115 @@
+package chatserver
+
+import (
+	"context"
+	"sort"
+
+	chitchat "homework3/chitchat"
+
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/proto"
+)
+
+// checkReply checks that the message a reply replies to exists in the room and came before it,
+// and returns the quote to send the reply with. Messages that are not replies pass with no quote.
+// It must be called with the mutex held.
+func (s *Server) checkReply(room string, message *chitchat.ClientMessage) (*chitchat.Quote, error) {
+	if message.ReplyTo == "" {
+		return nil, nil
+	}
+	parent, ok := s.messages[message.ReplyTo]
+	if !ok || parent.room != room {
+		return nil, status.Errorf(codes.FailedPrecondition, "there is no message %q in room %q to reply to, or it is too old", message.ReplyTo, room)
+	}
+	if parent.deletion != nil {
+		return nil, status.Errorf(codes.FailedPrecondition, "message %q was deleted", message.ReplyTo)
+	}
+	//the author must have seen the message to reply to it, so their clock has passed it.
+	if message.Lamport <= parent.lamport {
+		return nil, status.Errorf(codes.FailedPrecondition, "a reply at Lamport time %d cannot answer message %q from Lamport time %d", message.Lamport, message.ReplyTo, parent.lamport)
+	}
+	return &chitchat.Quote{Author: parent.author, Text: parent.versions[len(parent.versions)-1].Text}, nil
+}
+
+// addToThread adds a reply to the thread of the message it replies to. It must be called with the mutex held.
+func (s *Server) addToThread(reply *storedMessage) {
+	parent, ok := s.messages[reply.replyTo]
+	if !ok {
+		return
+	}
+	reply.thread = parent.thread
+	if reply.thread == "" {
+		reply.thread = parent.id
+	}
+	if root, ok := s.messages[reply.thread]; ok {
+		root.replies = append(root.replies, reply.id)
+	}
+}
+
+// GetThread returns a message and every reply in its thread.
+func (s *Server) GetThread(ctx context.Context, request *chitchat.ThreadRequest) (*chitchat.Thread, error) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	stored, ok := s.messages[request.MessageId]
+	if !ok {
+		return nil, status.Errorf(codes.NotFound, "there is no message %q, or it is too old", request.MessageId)
+	}
+	rootID := stored.thread
+	if rootID == "" {
+		rootID = stored.id
+	}
+	entries := s.current(stored.room, func(_ *chitchat.ServerMessage, message *storedMessage) bool {
+		return message.id == rootID || message.thread == rootID
+	})
+	//tombstones are as new as the deletion, but belong where the message was.
+	sort.SliceStable(entries, func(i, j int) bool {
+		return s.messages[targetOf(entries[i])].lamport < s.messages[targetOf(entries[j])].lamport
+	})
+	thread := &chitchat.Thread{RootId: rootID}
+	for _, entry := range entries {
+		thread.Messages = append(thread.Messages, proto.Clone(entry).(*chitchat.ServerMessage))
+	}
+	return thread, nil
+}
+
+// ListThreads lists the messages in a room that have replies, the ones replied to last first.
+func (s *Server) ListThreads(ctx context.Context, request *chitchat.ListThreadsRequest) (*chitchat.ListThreadsResponse, error) {
+	room := request.Room
+	if room == "" {
+		room = chitchat.DefaultRoom
+	}
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	var threads []*chitchat.ThreadSummary
+	for _, root := range s.messages {
+		if root.room != room || root.thread != "" {
+			continue
+		}
+		summary := &chitchat.ThreadSummary{RootId: root.id, Author: root.author}
+		if len(root.versions) > 0 {
+			summary.Text = root.versions[len(root.versions)-1].Text
+		}
+		seen := make(map[string]bool)
+		for _, id := range root.replies {
+			reply, ok := s.messages[id]
+			if !ok || reply.deletion != nil {
+				continue
+			}
+			summary.Replies++
+			summary.LastLamport = max(summary.LastLamport, reply.lamport)
+			if !seen[reply.author] {
+				seen[reply.author] = true
+				summary.Participants = append(summary.Participants, reply.author)
+			}
+		}
+		if summary.Replies > 0 {
+			threads = append(threads, summary)
+		}
+	}
+	sort.Slice(threads, func(i, j int) bool { return threads[i].LastLamport > threads[j].LastLamport })
+	if request.Limit > 0 && len(threads) > int(request.Limit) {
+		threads = threads[:request.Limit]
+	}
+	return &chitchat.ListThreadsResponse{Threads: threads}, nil
+}
@@ -0,0 +1,192 @@
+package chatserver_test
+
+import (
+	"context"
+	"slices"
+	"testing"
+
+	"homework3/chatclient"
+
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+// sawMessage waits until client has the message with the given id, so its clock has passed it.
+func sawMessage(t *testing.T, client *chatclient.Client, id string) *chatclient.Message {
+	t.Helper()
+	return waitFor(t, client, func(event chatclient.Event) bool {
+		return event.Kind == chatclient.MessageEvent && event.Message.ID == id
+	}).Message
+}
+
+func TestReplies(t *testing.T) {
+	ctx := context.Background()
+	_, address := startServer(t, t.TempDir())
+	alice := connect(t, address, "alice", newKey(t))
+	bob := connect(t, address, "bob", newKey(t))
+	elsewhere := connect(t, address, "carol", newKey(t), chatclient.WithRoom("ops"))
+
+	root, err := alice.Send(ctx, "lunch?")
+	if err != nil {
+		t.Fatal(err)
+	}
+	sawMessage(t, bob, root)
+	reply, err := bob.Reply(ctx, root, "yes")
+	if err != nil {
+		t.Fatal(err)
+	}
+	received := sawMessage(t, alice, reply)
+	if received.ReplyTo != root || received.Quote == nil || received.Quote.Author != "alice" || received.Quote.Text != "lunch?" {
+		t.Errorf("the reply came with reply_to %q and quote %+v", received.ReplyTo, received.Quote)
+	}
+	//replying to a reply stays in the thread of the message that started it.
+	nested, err := alice.Reply(ctx, reply, "noon then")
+	if err != nil {
+		t.Fatal(err)
+	}
+	thread, err := bob.Thread(ctx, nested)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var texts []string
+	for _, message := range thread.Messages {
+		texts = append(texts, message.Text)
+	}
+	if want := []string{"lunch?", "yes", "noon then"}; thread.RootID != root || !slices.Equal(texts, want) {
+		t.Errorf("the thread of the nested reply is %s with %q, want %s with %q", thread.RootID, texts, root, want)
+	}
+
+	other, err := elsewhere.Send(ctx, "in ops")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := bob.Delete(ctx, reply); err != nil {
+		t.Fatal(err)
+	}
+	tests := []struct {
+		name    string
+		replyTo string
+	}{
+		{"no such message", "0000"},
+		{"another room", other},
+		{"deleted", reply},
+	}
+	for _, test := range tests {
+		if _, err := alice.Reply(ctx, test.replyTo, "hm?"); status.Code(err) != codes.FailedPrecondition {
+			t.Errorf("%s: replying got %v, want FailedPrecondition", test.name, err)
+		}
+	}
+}
+
+func TestThreadShowsEditsAndDeletions(t *testing.T) {
+	ctx := context.Background()
+	_, address := startServer(t, t.TempDir())
+	alice := connect(t, address, "alice", newKey(t))
+	root, err := alice.Send(ctx, "who is on call?")
+	if err != nil {
+		t.Fatal(err)
+	}
+	first, err := alice.Reply(ctx, root, "me")
+	if err != nil {
+		t.Fatal(err)
+	}
+	second, err := alice.Reply(ctx, root, "not me")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := alice.Edit(ctx, first, "me, until noon"); err != nil {
+		t.Fatal(err)
+	}
+	if err := alice.Delete(ctx, second); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := alice.Send(ctx, "not in the thread"); err != nil {
+		t.Fatal(err)
+	}
+
+	thread, err := alice.Thread(ctx, root)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(thread.Messages) != 3 {
+		t.Fatalf("the thread has %d messages, want the root and two replies", len(thread.Messages))
+	}
+	edited, deleted := thread.Messages[1], thread.Messages[2]
+	if edited.ID != first || edited.Text != "me, until noon" || !edited.Edited || !edited.Verified {
+		t.Errorf("the edited reply is %+v", edited)
+	}
+	//the tombstone stays where the reply was, not where it was deleted.
+	if deleted.ID != second || !deleted.Deleted {
+		t.Errorf("the deleted reply is %+v", deleted)
+	}
+	if _, err := alice.Thread(ctx, "0000"); status.Code(err) != codes.NotFound {
+		t.Errorf("the thread of a message that does not exist: %v, want NotFound", err)
+	}
+}
+
+func TestListThreads(t *testing.T) {
+	ctx := context.Background()
+	_, address := startServer(t, t.TempDir())
+	alice := connect(t, address, "alice", newKey(t))
+	bob := connect(t, address, "bob", newKey(t))
+
+	unanswered, err := alice.Send(ctx, "nobody answers this")
+	if err != nil {
+		t.Fatal(err)
+	}
+	older, err := alice.Send(ctx, "first question")
+	if err != nil {
+		t.Fatal(err)
+	}
+	newer, err := alice.Send(ctx, "second question")
+	if err != nil {
+		t.Fatal(err)
+	}
+	sawMessage(t, bob, newer)
+	replies := []struct {
+		client  *chatclient.Client
+		replyTo string
+	}{
+		{bob, older},
+		{alice, older},
+		{bob, older},
+		{bob, newer},
+		//the latest reply decides which thread comes first.
+		{alice, older},
+	}
+	var last string
+	for _, reply := range replies {
+		if last, err = reply.client.Reply(ctx, reply.replyTo, "an answer"); err != nil {
+			t.Fatal(err)
+		}
+		sawMessage(t, alice, last)
+		sawMessage(t, bob, last)
+	}
+	//deleted replies do not count.
+	if err := alice.Delete(ctx, last); err != nil {
+		t.Fatal(err)
+	}
+
+	threads, err := alice.Threads(ctx, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(threads) != 2 {
+		t.Fatalf("listed %+v, want the two questions with replies", threads)
+	}
+	if threads[0].RootID != newer || threads[1].RootID != older {
+		t.Errorf("listed %s then %s, want the thread replied to last first", threads[0].RootID, threads[1].RootID)
+	}
+	if summary := threads[1]; summary.Author != "alice" || summary.Text != "first question" || summary.Replies != 3 ||
+		!slices.Equal(summary.Participants, []string{"bob", "alice"}) {
+		t.Errorf("the first question's thread is %+v", summary)
+	}
+	for _, summary := range threads {
+		if summary.RootID == unanswered {
+			t.Error("a message with no replies was listed")
+		}
+	}
+	if threads, err := alice.Threads(ctx, 1); err != nil || len(threads) != 1 || threads[0].RootID != newer {
+		t.Errorf("listing one thread got %+v, %v", threads, err)
+	}
+}
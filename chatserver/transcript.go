@@ -0,0 +1,480 @@
+package chatserver
+
+import (
+	"bufio"
+	"cmp"
+	"encoding/csv"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+
+	chitchat "homework3/chitchat"
+
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/types/known/timestamppb"
+)
+
+// kinds of transcript entries
+const (
+	EntryMessage   = "message"
+	EntryFile      = "file"
+	EntryEdit      = "edit"
+	EntryReactions = "reactions"
+	EntryDeletion  = "deletion"
+)
+
+// TranscriptEntry is one event of an exported room history: a message or shared file, an edit of
+// one, the reactions it has, or its deletion. Edits, reactions and deletions are about the message with their ID.
+type TranscriptEntry struct {
+	Kind string `json:"kind"`
+	ID   string `json:"id"`
+	Room string `json:"room"`
+	//who wrote, edited or deleted the message, empty for reactions
+	Author  string    `json:"author,omitempty"`
+	Lamport int32     `json:"lamport"`
+	Time    time.Time `json:"time"`
+	//the message, the text an edit gave it, or the name of a shared file
+	Text    string          `json:"text,omitempty"`
+	ReplyTo string          `json:"reply_to,omitempty"`
+	File    *TranscriptFile `json:"file,omitempty"`
+	//every reaction the message has from then on
+	Reactions []TranscriptReaction `json:"reactions,omitempty"`
+}
+
+// TranscriptFile is what a transcript says about a shared file. The file itself is not part of it.
+type TranscriptFile struct {
+	ContentType string `json:"content_type"`
+	Size        int64  `json:"size"`
+	SHA256      string `json:"sha256"`
+}
+
+// TranscriptReaction is everyone who reacted to a message with one emoji, in the order they did.
+type TranscriptReaction struct {
+	Emoji string   `json:"emoji"`
+	Names []string `json:"names"`
+}
+
+var transcriptColumns = []string{"kind", "id", "room", "author", "lamport", "time", "text", "reply_to", "content_type", "size", "sha256", "reactions"}
+
+// WriteTranscript writes a room history in the given format: jsonl, csv or markdown.
+// The first two can be read back with ReadTranscript; markdown is for people.
+func WriteTranscript(writer io.Writer, format string, entries []TranscriptEntry) error {
+	switch format {
+	case "jsonl":
+		encoder := json.NewEncoder(writer)
+		for _, entry := range entries {
+			if err := encoder.Encode(entry); err != nil {
+				return err
+			}
+		}
+		return nil
+	case "csv":
+		csvWriter := csv.NewWriter(writer)
+		csvWriter.Write(transcriptColumns)
+		for _, entry := range entries {
+			var contentType, size, sha256, reactions string
+			if entry.File != nil {
+				contentType, size, sha256 = entry.File.ContentType, strconv.FormatInt(entry.File.Size, 10), entry.File.SHA256
+			}
+			if entry.Kind == EntryReactions {
+				//names can have any character in them, so the reactions are a cell of JSON.
+				encoded, err := json.Marshal(entry.Reactions)
+				if err != nil {
+					return err
+				}
+				reactions = string(encoded)
+			}
+			csvWriter.Write([]string{
+				entry.Kind,
+				entry.ID,
+				entry.Room,
+				entry.Author,
+				strconv.FormatInt(int64(entry.Lamport), 10),
+				entry.Time.UTC().Format(time.RFC3339Nano),
+				entry.Text,
+				entry.ReplyTo,
+				contentType,
+				size,
+				sha256,
+				reactions,
+			})
+		}
+		csvWriter.Flush()
+		return csvWriter.Error()
+	case "markdown":
+		return writeMarkdown(writer, entries)
+	default:
+		return errors.New("unknown format " + strconv.Quote(format))
+	}
+}
+
+// writeMarkdown writes one list item per message, with what happened to it since nested below.
+func writeMarkdown(writer io.Writer, entries []TranscriptEntry) error {
+	buffered := bufio.NewWriter(writer)
+	changes := make(map[string][]TranscriptEntry)
+	rooms := make([]string, 0, 1)
+	for _, entry := range entries {
+		if entry.Kind != EntryMessage && entry.Kind != EntryFile {
+			changes[entry.ID] = append(changes[entry.ID], entry)
+		}
+		if !slices.Contains(rooms, entry.Room) {
+			rooms = append(rooms, entry.Room)
+		}
+	}
+	fmt.Fprintf(buffered, "# %s\n\n", markdownEscape(strings.Join(rooms, ", ")))
+	for _, entry := range entries {
+		if entry.Kind != EntryMessage && entry.Kind != EntryFile {
+			continue
+		}
+		text := markdownEscape(entry.Text)
+		if slices.ContainsFunc(changes[entry.ID], func(change TranscriptEntry) bool { return change.Kind == EntryDeletion }) {
+			text = "_(deleted)_"
+		} else if entry.File != nil {
+			text = fmt.Sprintf("shared %s (%s, %d bytes)", text, entry.File.ContentType, entry.File.Size)
+		}
+		if entry.ReplyTo != "" {
+			text = fmt.Sprintf("↪ `#%s` %s", entry.ReplyTo, text)
+		}
+		fmt.Fprintf(buffered, "- **%s** %s (Lamport %d, `#%s`): %s\n", markdownEscape(entry.Author), markdownTime(entry.Time), entry.Lamport, entry.ID, text)
+		for _, change := range changes[entry.ID] {
+			switch change.Kind {
+			case EntryEdit:
+				fmt.Fprintf(buffered, "  - edited by %s %s (Lamport %d): %s\n", markdownEscape(change.Author), markdownTime(change.Time), change.Lamport, markdownEscape(change.Text))
+			case EntryReactions:
+				var reactions []string
+				for _, reaction := range change.Reactions {
+					reactions = append(reactions, fmt.Sprintf("%s %d (%s)", markdownEscape(reaction.Emoji), len(reaction.Names), markdownEscape(strings.Join(reaction.Names, ", "))))
+				}
+				if len(reactions) == 0 {
+					reactions = append(reactions, "none")
+				}
+				fmt.Fprintf(buffered, "  - reactions: %s\n", strings.Join(reactions, " · "))
+			case EntryDeletion:
+				fmt.Fprintf(buffered, "  - deleted by %s %s (Lamport %d)\n", markdownEscape(change.Author), markdownTime(change.Time), change.Lamport)
+			}
+		}
+	}
+	return buffered.Flush()
+}
+
+func markdownTime(at time.Time) string {
+	if at.IsZero() {
+		return "at an unknown time"
+	}
+	return "at " + at.UTC().Format("2006-01-02 15:04:05 UTC")
+}
+
+// markdownEscape keeps chat text from being read as Markdown.
+var markdownEscape = strings.NewReplacer(
+	`\`, `\\`, "`", "\\`", "*", `\*`, "_", `\_`, "[", `\[`, "]", `\]`, "<", `\<`, ">", `\>`, "#", `\#`, "|", `\|`, "~", `\~`,
+	"\n", " ", "\r", " ",
+).Replace
+
+// ReadTranscript reads a room history WriteTranscript wrote as jsonl or csv.
+func ReadTranscript(reader io.Reader, format string) ([]TranscriptEntry, error) {
+	var entries []TranscriptEntry
+	switch format {
+	case "jsonl":
+		lines := bufio.NewScanner(reader)
+		lines.Buffer(nil, 1<<20)
+		for number := 1; lines.Scan(); number++ {
+			if strings.TrimSpace(lines.Text()) == "" {
+				continue
+			}
+			var entry TranscriptEntry
+			if err := json.Unmarshal(lines.Bytes(), &entry); err != nil {
+				return nil, fmt.Errorf("line %d: %w", number, err)
+			}
+			entries = append(entries, entry)
+		}
+		return entries, lines.Err()
+	case "csv":
+		csvReader := csv.NewReader(reader)
+		csvReader.FieldsPerRecord = len(transcriptColumns)
+		header, err := csvReader.Read()
+		if err != nil {
+			return nil, err
+		}
+		if !slices.Equal(header, transcriptColumns) {
+			return nil, fmt.Errorf("line 1: the columns must be %s", strings.Join(transcriptColumns, ","))
+		}
+		for {
+			record, err := csvReader.Read()
+			if err == io.EOF {
+				return entries, nil
+			}
+			if err != nil {
+				return nil, err
+			}
+			line, _ := csvReader.FieldPos(0)
+			entry, err := transcriptEntryFrom(record)
+			if err != nil {
+				return nil, fmt.Errorf("line %d: %w", line, err)
+			}
+			entries = append(entries, entry)
+		}
+	case "markdown":
+		return nil, errors.New("markdown transcripts are for reading, export as jsonl or csv to import again")
+	default:
+		return nil, errors.New("unknown format " + strconv.Quote(format))
+	}
+}
+
+// transcriptEntryFrom reads a CSV record in the order of transcriptColumns.
+func transcriptEntryFrom(record []string) (TranscriptEntry, error) {
+	entry := TranscriptEntry{
+		Kind:    record[0],
+		ID:      record[1],
+		Room:    record[2],
+		Author:  record[3],
+		Text:    record[6],
+		ReplyTo: record[7],
+	}
+	lamport, err := strconv.ParseInt(record[4], 10, 32)
+	if err != nil {
+		return entry, fmt.Errorf("lamport: %w", err)
+	}
+	entry.Lamport = int32(lamport)
+	if record[5] != "" {
+		if entry.Time, err = time.Parse(time.RFC3339Nano, record[5]); err != nil {
+			return entry, fmt.Errorf("time: %w", err)
+		}
+	}
+	if record[8] != "" || record[9] != "" || record[10] != "" {
+		entry.File = &TranscriptFile{ContentType: record[8], SHA256: record[10]}
+		if entry.File.Size, err = strconv.ParseInt(record[9], 10, 64); err != nil {
+			return entry, fmt.Errorf("size: %w", err)
+		}
+	}
+	if record[11] != "" {
+		if err := json.Unmarshal([]byte(record[11]), &entry.Reactions); err != nil {
+			return entry, fmt.Errorf("reactions: %w", err)
+		}
+	}
+	return entry, nil
+}
+
+// transcript returns what the storage keeps of a room, in Lamport order. Deleted messages are only
+// their entry, without the text, and their deletion; only the latest reactions of each message are in it.
+func (s *Server) transcript(room string) ([]TranscriptEntry, error) {
+	messages, err := s.storage.RoomMessages(room, 0, 0)
+	if err != nil {
+		return nil, err
+	}
+	deleted := make(map[string]bool)
+	latestReactions := make(map[string]int32)
+	for _, message := range messages {
+		switch message.Kind {
+		case chitchat.ServerMessage_DELETED:
+			deleted[message.Target] = true
+		case chitchat.ServerMessage_REACTIONS:
+			latestReactions[message.Target] = message.Lamport
+		}
+	}
+
+	var entries []TranscriptEntry
+	for _, message := range messages {
+		id := targetOf(message)
+		entry := TranscriptEntry{ID: id, Room: message.Room, Lamport: message.Lamport}
+		if message.SentAt != nil {
+			entry.Time = message.SentAt.AsTime()
+		}
+		switch message.Kind {
+		case chitchat.ServerMessage_CHAT, chitchat.ServerMessage_ATTACHMENT:
+			entry.Kind = EntryMessage
+			entry.Author = message.Name
+			entry.ReplyTo = message.ReplyTo
+			if !deleted[id] {
+				entry.Text = message.Text
+			}
+			if attachment := message.Attachment; attachment != nil {
+				entry.Kind = EntryFile
+				entry.File = &TranscriptFile{ContentType: attachment.ContentType, Size: attachment.Size, SHA256: attachment.Sha256}
+			}
+		case chitchat.ServerMessage_EDITED:
+			if deleted[id] {
+				continue
+			}
+			entry.Kind = EntryEdit
+			entry.Author = message.Subject
+			entry.Text = message.Text
+		case chitchat.ServerMessage_REACTIONS:
+			if deleted[id] || latestReactions[id] != message.Lamport {
+				continue
+			}
+			entry.Kind = EntryReactions
+			for _, reaction := range message.Reactions {
+				entry.Reactions = append(entry.Reactions, TranscriptReaction{Emoji: reaction.Emoji, Names: reaction.Names})
+			}
+		case chitchat.ServerMessage_DELETED:
+			entry.Kind = EntryDeletion
+			entry.Author = message.Subject
+		default:
+			continue
+		}
+		entries = append(entries, entry)
+	}
+	return entries, nil
+}
+
+// importTranscript keeps the entries of an exported history as if they were said just now, one after
+// the other in the order of their Lamport times, in room unless it is empty. Messages keep their ids
+// unless the server already has them. Imported messages are stored, found by Search and part of their room's
+// history like any other, but have no signature since their authors' ones were over other Lamport times.
+// They keep the times they were sent at, but retention counts their age from now. Shared files are only
+// imported as files if the server keeps the same file under the same id; the others become messages
+// saying which file was shared. The entries are stored in one go, so they are imported whole or not at
+// all, and it returns the Lamport times the first and last entry were given.
+func (s *Server) importTranscript(entries []TranscriptEntry, room string) (int32, int32, error) {
+	entries = slices.Clone(entries)
+	slices.SortStableFunc(entries, func(a, b TranscriptEntry) int { return cmp.Compare(a.Lamport, b.Lamport) })
+	//check everything first, so nothing is kept of a file that is not right.
+	seen := make(map[string]bool)
+	for i, entry := range entries {
+		if entry.Lamport <= 0 {
+			return 0, 0, status.Errorf(codes.InvalidArgument, "entry %d: Lamport times start at 1", i+1)
+		}
+		switch entry.Kind {
+		case EntryMessage, EntryFile:
+			if entry.ID == "" || seen[entry.ID] {
+				return 0, 0, status.Errorf(codes.InvalidArgument, "entry %d: every message needs an id of its own", i+1)
+			}
+			if entry.Kind == EntryFile && entry.File == nil {
+				return 0, 0, status.Errorf(codes.InvalidArgument, "entry %d: a shared file needs its content type, size and checksum", i+1)
+			}
+			seen[entry.ID] = true
+		case EntryEdit, EntryReactions, EntryDeletion:
+			if !seen[entry.ID] {
+				return 0, 0, status.Errorf(codes.InvalidArgument, "entry %d: the %s is about #%s, which does not come before it", i+1, entry.Kind, entry.ID)
+			}
+		default:
+			return 0, 0, status.Errorf(codes.InvalidArgument, "entry %d: unknown kind %q", i+1, entry.Kind)
+		}
+	}
+	if len(entries) == 0 {
+		return 0, 0, status.Error(codes.InvalidArgument, "there is nothing to import")
+	}
+	//looking for the files reads them, so it is done before taking the mutex.
+	files := make(map[string]bool)
+	for _, entry := range entries {
+		if entry.Kind == EntryFile && s.keepsFile(entry.ID, entry.File) {
+			files[entry.ID] = true
+		}
+	}
+
+	importedAt := timestamppb.Now()
+	s.mutex.Lock()
+	ids := make(map[string]string)
+	taken := make(map[string]bool)
+	messages := make([]*chitchat.ServerMessage, 0, len(entries))
+	rooms := make(map[string]time.Time)
+	lamport := s.lamport
+	for _, entry := range entries {
+		entryRoom := room
+		if entryRoom == "" {
+			entryRoom = entry.Room
+		}
+		if entryRoom == "" {
+			entryRoom = chitchat.DefaultRoom
+		}
+		lamport++
+		message := &chitchat.ServerMessage{Room: entryRoom, Lamport: lamport, ImportedAt: importedAt}
+		if !entry.Time.IsZero() {
+			message.SentAt = timestamppb.New(entry.Time)
+		}
+		switch entry.Kind {
+		case EntryMessage, EntryFile:
+			id := entry.ID
+			_, tracked := s.receipts[id]
+			_, stored := s.messages[id]
+			_, indexed := s.index.documents[id]
+			if tracked || stored || indexed || !validBlobID(id) {
+				id = s.newMessageID()
+			}
+			//the entries are only remembered once they are all stored, so newMessageID does not know of them.
+			for taken[id] {
+				id = s.newMessageID()
+			}
+			ids[entry.ID] = id
+			taken[id] = true
+			message.Kind = chitchat.ServerMessage_CHAT
+			message.Id = id
+			message.Name = entry.Author
+			message.Text = entry.Text
+			message.ReplyTo = ids[entry.ReplyTo]
+			switch {
+			case entry.File != nil && files[entry.ID] && id == entry.ID:
+				message.Kind = chitchat.ServerMessage_ATTACHMENT
+				message.Attachment = &chitchat.Attachment{Id: id, FileName: entry.Text, ContentType: entry.File.ContentType, Size: entry.File.Size, Sha256: entry.File.SHA256}
+			case entry.File != nil:
+				message.Text = fmt.Sprintf("shared %s, which was not imported", entry.Text)
+			}
+		case EntryEdit:
+			message.Kind = chitchat.ServerMessage_EDITED
+			message.Subject = entry.Author
+			message.Target = ids[entry.ID]
+			message.Text = entry.Text
+		case EntryReactions:
+			message.Kind = chitchat.ServerMessage_REACTIONS
+			message.Target = ids[entry.ID]
+			message.Text = "reactions imported"
+			for _, reaction := range entry.Reactions {
+				message.Reactions = append(message.Reactions, &chitchat.Reaction{Emoji: reaction.Emoji, Count: int32(len(reaction.Names)), Names: reaction.Names})
+			}
+		case EntryDeletion:
+			message.Kind = chitchat.ServerMessage_DELETED
+			message.Subject = entry.Author
+			message.Target = ids[entry.ID]
+			message.Text = "message deleted by " + entry.Author
+		}
+		if !isChat(message) {
+			s.signServerMessage(message, message.Lamport-1)
+		}
+		created := entry.Time
+		if created.IsZero() {
+			created = importedAt.AsTime()
+		}
+		if earliest, ok := rooms[entryRoom]; !ok || created.Before(earliest) {
+			rooms[entryRoom] = created
+		}
+		messages = append(messages, message)
+	}
+	//the mutex keeps the storage in Lamport order, so it is held while the entries are stored,
+	//but that is one write rather than one for each entry.
+	if err := s.storage.AppendMessages(messages); err != nil {
+		s.mutex.Unlock()
+		return 0, 0, fmt.Errorf("storing the entries: %w", err)
+	}
+	s.lamport = lamport
+	for _, message := range messages {
+		s.index.add(message, s.currentLimits().MaxIndexedMessages)
+		s.restore(message)
+	}
+	s.mutex.Unlock()
+
+	for entryRoom, created := range rooms {
+		if err := s.storage.AddRoom(entryRoom, created); err != nil {
+			s.logger.Error("could not keep the room", "room", entryRoom, "error", err)
+		}
+	}
+	return messages[0].Lamport, lamport, nil
+}
+
+// keepsFile reports whether the server keeps the file a transcript says was shared under id.
+func (s *Server) keepsFile(id string, file *TranscriptFile) bool {
+	if s.blobs == nil || file == nil {
+		return false
+	}
+	kept, attachment, err := s.blobs.open(id)
+	if err != nil {
+		return false
+	}
+	kept.Close()
+	return attachment.Size == file.Size && attachment.Sha256 == file.SHA256
+}
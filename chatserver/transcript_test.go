@@ -0,0 +1,151 @@
+package chatserver
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"math"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+	"time"
+
+	chitchat "homework3/chitchat"
+
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/encoding/protojson"
+)
+
+// newTranscriptServer starts a server on its own storage that keeps files in blobDir.
+func newTranscriptServer(t *testing.T, storage Storage, blobDir string, opts ...Option) *Server {
+	t.Helper()
+	s, err := New(append([]Option{WithStorage(storage), WithBlobDir(blobDir)}, opts...)...)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { s.Stop(context.Background()) })
+	return s
+}
+
+func TestTranscriptRoundTrip(t *testing.T) {
+	blobDir := t.TempDir()
+	//the server still keeps the file that was shared.
+	attachment := &chitchat.Attachment{Id: "0c", FileName: "notes.txt", ContentType: "text/plain", Size: 5, Sha256: "abc"}
+	metadata, err := protojson.Marshal(attachment)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(filepath.Join(blobDir, "0c.json"), metadata, 0600); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(filepath.Join(blobDir, "0c"), []byte("hello"), 0600); err != nil {
+		t.Fatal(err)
+	}
+	sent := time.Date(2024, 5, 1, 9, 30, 0, 0, time.UTC)
+	history := []TranscriptEntry{
+		{Kind: EntryMessage, ID: "0a", Room: "ops", Author: "alice", Lamport: 3, Time: sent, Text: "hello, \"ops\"\nsecond line"},
+		{Kind: EntryMessage, ID: "0b", Room: "ops", Author: "bob", Lamport: 5, Time: sent.Add(time.Minute), Text: "hi", ReplyTo: "0a"},
+		{Kind: EntryFile, ID: "0c", Room: "ops", Author: "alice", Lamport: 6, Time: sent.Add(2 * time.Minute), Text: "notes.txt",
+			File: &TranscriptFile{ContentType: "text/plain", Size: 5, SHA256: "abc"}},
+		{Kind: EntryMessage, ID: "0d", Room: "ops", Author: "bob", Lamport: 7, Time: sent.Add(3 * time.Minute), Text: "oops"},
+		{Kind: EntryEdit, ID: "0a", Room: "ops", Author: "alice", Lamport: 8, Time: sent.Add(4 * time.Minute), Text: "hello, ops"},
+		{Kind: EntryReactions, ID: "0b", Room: "ops", Lamport: 9, Time: sent.Add(5 * time.Minute),
+			Reactions: []TranscriptReaction{{Emoji: "👍", Names: []string{"alice", "carol"}}}},
+		{Kind: EntryDeletion, ID: "0d", Room: "ops", Author: "bob", Lamport: 10, Time: sent.Add(6 * time.Minute)},
+	}
+	first := newTranscriptServer(t, NewMemoryStorage(), blobDir)
+	if _, _, err := first.importTranscript(history, ""); err != nil {
+		t.Fatal(err)
+	}
+	exported, err := first.transcript("ops")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(exported) != len(history) {
+		t.Fatalf("exported %d entries, want %d: %+v", len(exported), len(history), exported)
+	}
+
+	for _, format := range []string{"jsonl", "csv"} {
+		t.Run(format, func(t *testing.T) {
+			var written bytes.Buffer
+			if err := WriteTranscript(&written, format, exported); err != nil {
+				t.Fatal(err)
+			}
+			read, err := ReadTranscript(&written, format)
+			if err != nil {
+				t.Fatal(err)
+			}
+			second := newTranscriptServer(t, NewMemoryStorage(), blobDir)
+			if _, _, err := second.importTranscript(read, ""); err != nil {
+				t.Fatal(err)
+			}
+			again, err := second.transcript("ops")
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !reflect.DeepEqual(again, exported) {
+				t.Errorf("exported again\n%+v\nwant\n%+v", again, exported)
+			}
+		})
+	}
+}
+
+// failingStorage cannot append messages.
+type failingStorage struct {
+	*MemoryStorage
+}
+
+func (failingStorage) AppendMessages([]*chitchat.ServerMessage) error {
+	return errors.New("disk full")
+}
+
+func TestImportTranscript(t *testing.T) {
+	sent := time.Now().Add(-48 * time.Hour)
+	entries := []TranscriptEntry{
+		{Kind: EntryMessage, ID: "0b", Author: "bob", Lamport: math.MaxInt32, Time: sent, Text: "last"},
+		{Kind: EntryMessage, ID: "0a", Author: "alice", Lamport: 1, Time: sent, Text: "first"},
+		{Kind: EntryFile, ID: "0c", Author: "alice", Lamport: 2, Time: sent, Text: "gone.txt", File: &TranscriptFile{ContentType: "text/plain", Size: 5}},
+	}
+
+	failing := newTranscriptServer(t, failingStorage{NewMemoryStorage()}, t.TempDir())
+	if _, _, err := failing.importTranscript(entries, ""); err == nil {
+		t.Error("importing into a storage that cannot keep it worked")
+	}
+	if len(failing.index.documents) != 0 || len(failing.messages) != 0 || failing.lamport != 0 {
+		t.Error("a failed import left some of its entries behind")
+	}
+
+	day := Retention{MaxAge: 24 * time.Hour}
+	s := newTranscriptServer(t, NewMemoryStorage(), t.TempDir(), WithLimits(Limits{MaxMessageLength: 100, StreamQueueSize: 10, Retention: day}))
+	for _, lamport := range []int32{0, -1} {
+		invalid := []TranscriptEntry{{Kind: EntryMessage, ID: "0a", Author: "alice", Lamport: lamport, Text: "when?"}}
+		if _, _, err := s.importTranscript(invalid, ""); status.Code(err) != codes.InvalidArgument {
+			t.Errorf("importing an entry at Lamport time %d: %v, want InvalidArgument", lamport, err)
+		}
+	}
+	if _, _, err := s.importTranscript(entries, ""); err != nil {
+		t.Fatal(err)
+	}
+	imported, err := s.transcript(chitchat.DefaultRoom)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var texts []string
+	for _, entry := range imported {
+		texts = append(texts, entry.Kind+": "+entry.Text)
+	}
+	want := []string{"message: first", "message: shared gone.txt, which was not imported", "message: last"}
+	if !reflect.DeepEqual(texts, want) {
+		t.Errorf("imported %q, want %q", texts, want)
+	}
+
+	//the messages were sent two days ago, but only imported now.
+	if removed, err := s.compact(time.Now()); err != nil || removed != 0 {
+		t.Errorf("compacting right after the import removed %d messages, %v", removed, err)
+	}
+	if removed, err := s.compact(time.Now().Add(25 * time.Hour)); err != nil || removed != 3 {
+		t.Errorf("compacting a day after the import removed %d messages, %v; want 3", removed, err)
+	}
+}
@@ -0,0 +1,63 @@
+package chatserver
+
+import (
+	"context"
+	"fmt"
+
+	chitchat "homework3/chitchat"
+
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+// SetTyping tells everyone in the room that a participant started or stopped typing.
+// Typing indicators are not kept anywhere and do not advance the Lamport time; a slow
+// client whose queue is full simply misses them.
+func (s *Server) SetTyping(ctx context.Context, update *chitchat.TypingUpdate) (*chitchat.Confirmation, error) {
+	authorKey, err := s.authorKey(ctx, update.Name)
+	if err != nil {
+		return nil, err
+	}
+	if !update.Verify(authorKey) {
+		return nil, status.Errorf(codes.Unauthenticated, "typing signature does not match the key registered to %q", update.Name)
+	}
+	if err := s.checkFresh(update.Name, update.Room, update.Lamport, update.Signature); err != nil {
+		return nil, err
+	}
+	room := update.Room
+	if room == "" {
+		room = chitchat.DefaultRoom
+	}
+	text := fmt.Sprintf("%s stopped typing", update.Name)
+	if update.Typing {
+		text = fmt.Sprintf("%s is typing", update.Name)
+	}
+
+	s.mutex.Lock()
+	state, ok := s.presence[room][update.Name]
+	if !ok || state.presence == chitchat.Presence_OFFLINE {
+		s.mutex.Unlock()
+		return nil, status.Errorf(codes.FailedPrecondition, "%q is not in room %q", update.Name, room)
+	}
+	//typing counts as doing something, so it brings an idle participant back.
+	var backMessage *chitchat.ServerMessage
+	if update.Typing {
+		backMessage = s.markActive(room, update.Name)
+	}
+	typingMessage := s.signServerMessage(&chitchat.ServerMessage{
+		Text:    text,
+		Lamport: s.lamport,
+		Room:    room,
+		Kind:    chitchat.ServerMessage_TYPING,
+		Subject: update.Name,
+		Typing:  update.Typing,
+	}, s.lamport)
+	s.mutex.Unlock()
+
+	if backMessage != nil {
+		s.sendAnnouncement(ctx, backMessage)
+	}
+	loggerFrom(ctx, s.logger).Debug("typing", "user", update.Name, "room", room, "typing", update.Typing)
+	s.sendToRoom(ctx, typingMessage)
+	return &chitchat.Confirmation{}, nil
+}
@@ -22,9 +22,26 @@ const _ = grpc.SupportPackageIsVersion7
 //
 // For semantics around ctx use and closing/ending streaming RPCs, please refer to https://pkg.go.dev/google.golang.org/grpc/?tab=doc#ClientConn.NewStream.
 type ChatServiceClient interface {
+	GetJoinChallenge(ctx context.Context, in *JoinChallengeRequest, opts ...grpc.CallOption) (*JoinChallenge, error)
 	Join(ctx context.Context, in *User, opts ...grpc.CallOption) (ChatService_JoinClient, error)
 	Leave(ctx context.Context, in *User, opts ...grpc.CallOption) (*Confirmation, error)
 	Broadcast(ctx context.Context, in *ClientMessage, opts ...grpc.CallOption) (*Confirmation, error)
+	ListParticipants(ctx context.Context, in *ListParticipantsRequest, opts ...grpc.CallOption) (*ListParticipantsResponse, error)
+	SetPresence(ctx context.Context, in *PresenceUpdate, opts ...grpc.CallOption) (*Confirmation, error)
+	SetTyping(ctx context.Context, in *TypingUpdate, opts ...grpc.CallOption) (*Confirmation, error)
+	Acknowledge(ctx context.Context, in *Receipt, opts ...grpc.CallOption) (*Confirmation, error)
+	GetReceipts(ctx context.Context, in *ReceiptsRequest, opts ...grpc.CallOption) (*Receipts, error)
+	Edit(ctx context.Context, in *MessageEdit, opts ...grpc.CallOption) (*Confirmation, error)
+	Delete(ctx context.Context, in *MessageDeletion, opts ...grpc.CallOption) (*Confirmation, error)
+	GetEditHistory(ctx context.Context, in *EditHistoryRequest, opts ...grpc.CallOption) (*EditHistory, error)
+	GetThread(ctx context.Context, in *ThreadRequest, opts ...grpc.CallOption) (*Thread, error)
+	ListThreads(ctx context.Context, in *ListThreadsRequest, opts ...grpc.CallOption) (*ListThreadsResponse, error)
+	React(ctx context.Context, in *ReactionUpdate, opts ...grpc.CallOption) (*Confirmation, error)
+	SendDirect(ctx context.Context, in *DirectMessage, opts ...grpc.CallOption) (*Confirmation, error)
+	AcknowledgeDirect(ctx context.Context, in *MailboxAck, opts ...grpc.CallOption) (*Confirmation, error)
+	Upload(ctx context.Context, opts ...grpc.CallOption) (ChatService_UploadClient, error)
+	Download(ctx context.Context, in *DownloadRequest, opts ...grpc.CallOption) (ChatService_DownloadClient, error)
+	Search(ctx context.Context, in *SearchRequest, opts ...grpc.CallOption) (*SearchResponse, error)
 }
 
 type chatServiceClient struct {
@@ -35,6 +52,15 @@ func NewChatServiceClient(cc grpc.ClientConnInterface) ChatServiceClient {
 	return &chatServiceClient{cc}
 }
 
+func (c *chatServiceClient) GetJoinChallenge(ctx context.Context, in *JoinChallengeRequest, opts ...grpc.CallOption) (*JoinChallenge, error) {
+	out := new(JoinChallenge)
+	err := c.cc.Invoke(ctx, "/chitchat.ChatService/GetJoinChallenge", in, out, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
 func (c *chatServiceClient) Join(ctx context.Context, in *User, opts ...grpc.CallOption) (ChatService_JoinClient, error) {
 	stream, err := c.cc.NewStream(ctx, &ChatService_ServiceDesc.Streams[0], "/chitchat.ChatService/Join", opts...)
 	if err != nil {
@@ -85,13 +111,222 @@ func (c *chatServiceClient) Broadcast(ctx context.Context, in *ClientMessage, op
 	return out, nil
 }
 
+func (c *chatServiceClient) ListParticipants(ctx context.Context, in *ListParticipantsRequest, opts ...grpc.CallOption) (*ListParticipantsResponse, error) {
+	out := new(ListParticipantsResponse)
+	err := c.cc.Invoke(ctx, "/chitchat.ChatService/ListParticipants", in, out, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *chatServiceClient) SetPresence(ctx context.Context, in *PresenceUpdate, opts ...grpc.CallOption) (*Confirmation, error) {
+	out := new(Confirmation)
+	err := c.cc.Invoke(ctx, "/chitchat.ChatService/SetPresence", in, out, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *chatServiceClient) SetTyping(ctx context.Context, in *TypingUpdate, opts ...grpc.CallOption) (*Confirmation, error) {
+	out := new(Confirmation)
+	err := c.cc.Invoke(ctx, "/chitchat.ChatService/SetTyping", in, out, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *chatServiceClient) Acknowledge(ctx context.Context, in *Receipt, opts ...grpc.CallOption) (*Confirmation, error) {
+	out := new(Confirmation)
+	err := c.cc.Invoke(ctx, "/chitchat.ChatService/Acknowledge", in, out, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *chatServiceClient) GetReceipts(ctx context.Context, in *ReceiptsRequest, opts ...grpc.CallOption) (*Receipts, error) {
+	out := new(Receipts)
+	err := c.cc.Invoke(ctx, "/chitchat.ChatService/GetReceipts", in, out, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *chatServiceClient) Edit(ctx context.Context, in *MessageEdit, opts ...grpc.CallOption) (*Confirmation, error) {
+	out := new(Confirmation)
+	err := c.cc.Invoke(ctx, "/chitchat.ChatService/Edit", in, out, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *chatServiceClient) Delete(ctx context.Context, in *MessageDeletion, opts ...grpc.CallOption) (*Confirmation, error) {
+	out := new(Confirmation)
+	err := c.cc.Invoke(ctx, "/chitchat.ChatService/Delete", in, out, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *chatServiceClient) GetEditHistory(ctx context.Context, in *EditHistoryRequest, opts ...grpc.CallOption) (*EditHistory, error) {
+	out := new(EditHistory)
+	err := c.cc.Invoke(ctx, "/chitchat.ChatService/GetEditHistory", in, out, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *chatServiceClient) GetThread(ctx context.Context, in *ThreadRequest, opts ...grpc.CallOption) (*Thread, error) {
+	out := new(Thread)
+	err := c.cc.Invoke(ctx, "/chitchat.ChatService/GetThread", in, out, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *chatServiceClient) ListThreads(ctx context.Context, in *ListThreadsRequest, opts ...grpc.CallOption) (*ListThreadsResponse, error) {
+	out := new(ListThreadsResponse)
+	err := c.cc.Invoke(ctx, "/chitchat.ChatService/ListThreads", in, out, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *chatServiceClient) React(ctx context.Context, in *ReactionUpdate, opts ...grpc.CallOption) (*Confirmation, error) {
+	out := new(Confirmation)
+	err := c.cc.Invoke(ctx, "/chitchat.ChatService/React", in, out, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *chatServiceClient) SendDirect(ctx context.Context, in *DirectMessage, opts ...grpc.CallOption) (*Confirmation, error) {
+	out := new(Confirmation)
+	err := c.cc.Invoke(ctx, "/chitchat.ChatService/SendDirect", in, out, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *chatServiceClient) AcknowledgeDirect(ctx context.Context, in *MailboxAck, opts ...grpc.CallOption) (*Confirmation, error) {
+	out := new(Confirmation)
+	err := c.cc.Invoke(ctx, "/chitchat.ChatService/AcknowledgeDirect", in, out, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *chatServiceClient) Upload(ctx context.Context, opts ...grpc.CallOption) (ChatService_UploadClient, error) {
+	stream, err := c.cc.NewStream(ctx, &ChatService_ServiceDesc.Streams[1], "/chitchat.ChatService/Upload", opts...)
+	if err != nil {
+		return nil, err
+	}
+	x := &chatServiceUploadClient{stream}
+	return x, nil
+}
+
+type ChatService_UploadClient interface {
+	Send(*UploadChunk) error
+	CloseAndRecv() (*Confirmation, error)
+	grpc.ClientStream
+}
+
+type chatServiceUploadClient struct {
+	grpc.ClientStream
+}
+
+func (x *chatServiceUploadClient) Send(m *UploadChunk) error {
+	return x.ClientStream.SendMsg(m)
+}
+
+func (x *chatServiceUploadClient) CloseAndRecv() (*Confirmation, error) {
+	if err := x.ClientStream.CloseSend(); err != nil {
+		return nil, err
+	}
+	m := new(Confirmation)
+	if err := x.ClientStream.RecvMsg(m); err != nil {
+		return nil, err
+	}
+	return m, nil
+}
+
+func (c *chatServiceClient) Download(ctx context.Context, in *DownloadRequest, opts ...grpc.CallOption) (ChatService_DownloadClient, error) {
+	stream, err := c.cc.NewStream(ctx, &ChatService_ServiceDesc.Streams[2], "/chitchat.ChatService/Download", opts...)
+	if err != nil {
+		return nil, err
+	}
+	x := &chatServiceDownloadClient{stream}
+	if err := x.ClientStream.SendMsg(in); err != nil {
+		return nil, err
+	}
+	if err := x.ClientStream.CloseSend(); err != nil {
+		return nil, err
+	}
+	return x, nil
+}
+
+type ChatService_DownloadClient interface {
+	Recv() (*DownloadChunk, error)
+	grpc.ClientStream
+}
+
+type chatServiceDownloadClient struct {
+	grpc.ClientStream
+}
+
+func (x *chatServiceDownloadClient) Recv() (*DownloadChunk, error) {
+	m := new(DownloadChunk)
+	if err := x.ClientStream.RecvMsg(m); err != nil {
+		return nil, err
+	}
+	return m, nil
+}
+
+func (c *chatServiceClient) Search(ctx context.Context, in *SearchRequest, opts ...grpc.CallOption) (*SearchResponse, error) {
+	out := new(SearchResponse)
+	err := c.cc.Invoke(ctx, "/chitchat.ChatService/Search", in, out, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
 // ChatServiceServer is the server API for ChatService service.
 // All implementations must embed UnimplementedChatServiceServer
 // for forward compatibility
 type ChatServiceServer interface {
+	GetJoinChallenge(context.Context, *JoinChallengeRequest) (*JoinChallenge, error)
 	Join(*User, ChatService_JoinServer) error
 	Leave(context.Context, *User) (*Confirmation, error)
 	Broadcast(context.Context, *ClientMessage) (*Confirmation, error)
+	ListParticipants(context.Context, *ListParticipantsRequest) (*ListParticipantsResponse, error)
+	SetPresence(context.Context, *PresenceUpdate) (*Confirmation, error)
+	SetTyping(context.Context, *TypingUpdate) (*Confirmation, error)
+	Acknowledge(context.Context, *Receipt) (*Confirmation, error)
+	GetReceipts(context.Context, *ReceiptsRequest) (*Receipts, error)
+	Edit(context.Context, *MessageEdit) (*Confirmation, error)
+	Delete(context.Context, *MessageDeletion) (*Confirmation, error)
+	GetEditHistory(context.Context, *EditHistoryRequest) (*EditHistory, error)
+	GetThread(context.Context, *ThreadRequest) (*Thread, error)
+	ListThreads(context.Context, *ListThreadsRequest) (*ListThreadsResponse, error)
+	React(context.Context, *ReactionUpdate) (*Confirmation, error)
+	SendDirect(context.Context, *DirectMessage) (*Confirmation, error)
+	AcknowledgeDirect(context.Context, *MailboxAck) (*Confirmation, error)
+	Upload(ChatService_UploadServer) error
+	Download(*DownloadRequest, ChatService_DownloadServer) error
+	Search(context.Context, *SearchRequest) (*SearchResponse, error)
 	mustEmbedUnimplementedChatServiceServer()
 }
 
@@ -99,6 +334,9 @@ type ChatServiceServer interface {
 type UnimplementedChatServiceServer struct {
 }
 
+func (UnimplementedChatServiceServer) GetJoinChallenge(context.Context, *JoinChallengeRequest) (*JoinChallenge, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method GetJoinChallenge not implemented")
+}
 func (UnimplementedChatServiceServer) Join(*User, ChatService_JoinServer) error {
 	return status.Errorf(codes.Unimplemented, "method Join not implemented")
 }
@@ -108,6 +346,54 @@ func (UnimplementedChatServiceServer) Leave(context.Context, *User) (*Confirmati
 func (UnimplementedChatServiceServer) Broadcast(context.Context, *ClientMessage) (*Confirmation, error) {
 	return nil, status.Errorf(codes.Unimplemented, "method Broadcast not implemented")
 }
+func (UnimplementedChatServiceServer) ListParticipants(context.Context, *ListParticipantsRequest) (*ListParticipantsResponse, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method ListParticipants not implemented")
+}
+func (UnimplementedChatServiceServer) SetPresence(context.Context, *PresenceUpdate) (*Confirmation, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method SetPresence not implemented")
+}
+func (UnimplementedChatServiceServer) SetTyping(context.Context, *TypingUpdate) (*Confirmation, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method SetTyping not implemented")
+}
+func (UnimplementedChatServiceServer) Acknowledge(context.Context, *Receipt) (*Confirmation, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method Acknowledge not implemented")
+}
+func (UnimplementedChatServiceServer) GetReceipts(context.Context, *ReceiptsRequest) (*Receipts, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method GetReceipts not implemented")
+}
+func (UnimplementedChatServiceServer) Edit(context.Context, *MessageEdit) (*Confirmation, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method Edit not implemented")
+}
+func (UnimplementedChatServiceServer) Delete(context.Context, *MessageDeletion) (*Confirmation, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method Delete not implemented")
+}
+func (UnimplementedChatServiceServer) GetEditHistory(context.Context, *EditHistoryRequest) (*EditHistory, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method GetEditHistory not implemented")
+}
+func (UnimplementedChatServiceServer) GetThread(context.Context, *ThreadRequest) (*Thread, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method GetThread not implemented")
+}
+func (UnimplementedChatServiceServer) ListThreads(context.Context, *ListThreadsRequest) (*ListThreadsResponse, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method ListThreads not implemented")
+}
+func (UnimplementedChatServiceServer) React(context.Context, *ReactionUpdate) (*Confirmation, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method React not implemented")
+}
+func (UnimplementedChatServiceServer) SendDirect(context.Context, *DirectMessage) (*Confirmation, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method SendDirect not implemented")
+}
+func (UnimplementedChatServiceServer) AcknowledgeDirect(context.Context, *MailboxAck) (*Confirmation, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method AcknowledgeDirect not implemented")
+}
+func (UnimplementedChatServiceServer) Upload(ChatService_UploadServer) error {
+	return status.Errorf(codes.Unimplemented, "method Upload not implemented")
+}
+func (UnimplementedChatServiceServer) Download(*DownloadRequest, ChatService_DownloadServer) error {
+	return status.Errorf(codes.Unimplemented, "method Download not implemented")
+}
+func (UnimplementedChatServiceServer) Search(context.Context, *SearchRequest) (*SearchResponse, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method Search not implemented")
+}
 func (UnimplementedChatServiceServer) mustEmbedUnimplementedChatServiceServer() {}
 
 // UnsafeChatServiceServer may be embedded to opt out of forward compatibility for this service.
@@ -121,6 +407,24 @@ func RegisterChatServiceServer(s grpc.ServiceRegistrar, srv ChatServiceServer) {
 	s.RegisterService(&ChatService_ServiceDesc, srv)
 }
 
+func _ChatService_GetJoinChallenge_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(JoinChallengeRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(ChatServiceServer).GetJoinChallenge(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: "/chitchat.ChatService/GetJoinChallenge",
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(ChatServiceServer).GetJoinChallenge(ctx, req.(*JoinChallengeRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
 func _ChatService_Join_Handler(srv interface{}, stream grpc.ServerStream) error {
 	m := new(User)
 	if err := stream.RecvMsg(m); err != nil {
@@ -178,27 +482,724 @@ func _ChatService_Broadcast_Handler(srv interface{}, ctx context.Context, dec fu
 	return interceptor(ctx, in, info, handler)
 }
 
-// ChatService_ServiceDesc is the grpc.ServiceDesc for ChatService service.
-// It's only intended for direct use with grpc.RegisterService,
-// and not to be introspected or modified (even as a copy)
-var ChatService_ServiceDesc = grpc.ServiceDesc{
-	ServiceName: "chitchat.ChatService",
-	HandlerType: (*ChatServiceServer)(nil),
-	Methods: []grpc.MethodDesc{
-		{
-			MethodName: "Leave",
-			Handler:    _ChatService_Leave_Handler,
-		},
-		{
-			MethodName: "Broadcast",
-			Handler:    _ChatService_Broadcast_Handler,
-		},
-	},
-	Streams: []grpc.StreamDesc{
-		{
-			StreamName:    "Join",
-			Handler:       _ChatService_Join_Handler,
-			ServerStreams: true,
+func _ChatService_ListParticipants_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(ListParticipantsRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(ChatServiceServer).ListParticipants(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: "/chitchat.ChatService/ListParticipants",
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(ChatServiceServer).ListParticipants(ctx, req.(*ListParticipantsRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _ChatService_SetPresence_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(PresenceUpdate)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(ChatServiceServer).SetPresence(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: "/chitchat.ChatService/SetPresence",
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(ChatServiceServer).SetPresence(ctx, req.(*PresenceUpdate))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _ChatService_SetTyping_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(TypingUpdate)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(ChatServiceServer).SetTyping(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: "/chitchat.ChatService/SetTyping",
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(ChatServiceServer).SetTyping(ctx, req.(*TypingUpdate))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _ChatService_Acknowledge_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(Receipt)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(ChatServiceServer).Acknowledge(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: "/chitchat.ChatService/Acknowledge",
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(ChatServiceServer).Acknowledge(ctx, req.(*Receipt))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _ChatService_GetReceipts_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(ReceiptsRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(ChatServiceServer).GetReceipts(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: "/chitchat.ChatService/GetReceipts",
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(ChatServiceServer).GetReceipts(ctx, req.(*ReceiptsRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _ChatService_Edit_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(MessageEdit)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(ChatServiceServer).Edit(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: "/chitchat.ChatService/Edit",
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(ChatServiceServer).Edit(ctx, req.(*MessageEdit))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _ChatService_Delete_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(MessageDeletion)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(ChatServiceServer).Delete(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: "/chitchat.ChatService/Delete",
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(ChatServiceServer).Delete(ctx, req.(*MessageDeletion))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _ChatService_GetEditHistory_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(EditHistoryRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(ChatServiceServer).GetEditHistory(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: "/chitchat.ChatService/GetEditHistory",
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(ChatServiceServer).GetEditHistory(ctx, req.(*EditHistoryRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _ChatService_GetThread_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(ThreadRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(ChatServiceServer).GetThread(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: "/chitchat.ChatService/GetThread",
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(ChatServiceServer).GetThread(ctx, req.(*ThreadRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _ChatService_ListThreads_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(ListThreadsRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(ChatServiceServer).ListThreads(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: "/chitchat.ChatService/ListThreads",
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(ChatServiceServer).ListThreads(ctx, req.(*ListThreadsRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _ChatService_React_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(ReactionUpdate)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(ChatServiceServer).React(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: "/chitchat.ChatService/React",
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(ChatServiceServer).React(ctx, req.(*ReactionUpdate))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _ChatService_SendDirect_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(DirectMessage)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(ChatServiceServer).SendDirect(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: "/chitchat.ChatService/SendDirect",
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(ChatServiceServer).SendDirect(ctx, req.(*DirectMessage))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _ChatService_AcknowledgeDirect_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(MailboxAck)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(ChatServiceServer).AcknowledgeDirect(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: "/chitchat.ChatService/AcknowledgeDirect",
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(ChatServiceServer).AcknowledgeDirect(ctx, req.(*MailboxAck))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _ChatService_Upload_Handler(srv interface{}, stream grpc.ServerStream) error {
+	return srv.(ChatServiceServer).Upload(&chatServiceUploadServer{stream})
+}
+
+type ChatService_UploadServer interface {
+	SendAndClose(*Confirmation) error
+	Recv() (*UploadChunk, error)
+	grpc.ServerStream
+}
+
+type chatServiceUploadServer struct {
+	grpc.ServerStream
+}
+
+func (x *chatServiceUploadServer) SendAndClose(m *Confirmation) error {
+	return x.ServerStream.SendMsg(m)
+}
+
+func (x *chatServiceUploadServer) Recv() (*UploadChunk, error) {
+	m := new(UploadChunk)
+	if err := x.ServerStream.RecvMsg(m); err != nil {
+		return nil, err
+	}
+	return m, nil
+}
+
+func _ChatService_Download_Handler(srv interface{}, stream grpc.ServerStream) error {
+	m := new(DownloadRequest)
+	if err := stream.RecvMsg(m); err != nil {
+		return err
+	}
+	return srv.(ChatServiceServer).Download(m, &chatServiceDownloadServer{stream})
+}
+
+type ChatService_DownloadServer interface {
+	Send(*DownloadChunk) error
+	grpc.ServerStream
+}
+
+type chatServiceDownloadServer struct {
+	grpc.ServerStream
+}
+
+func (x *chatServiceDownloadServer) Send(m *DownloadChunk) error {
+	return x.ServerStream.SendMsg(m)
+}
+
+func _ChatService_Search_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(SearchRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(ChatServiceServer).Search(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: "/chitchat.ChatService/Search",
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(ChatServiceServer).Search(ctx, req.(*SearchRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+// ChatService_ServiceDesc is the grpc.ServiceDesc for ChatService service.
+// It's only intended for direct use with grpc.RegisterService,
+// and not to be introspected or modified (even as a copy)
+var ChatService_ServiceDesc = grpc.ServiceDesc{
+	ServiceName: "chitchat.ChatService",
+	HandlerType: (*ChatServiceServer)(nil),
+	Methods: []grpc.MethodDesc{
+		{
+			MethodName: "GetJoinChallenge",
+			Handler:    _ChatService_GetJoinChallenge_Handler,
+		},
+		{
+			MethodName: "Leave",
+			Handler:    _ChatService_Leave_Handler,
+		},
+		{
+			MethodName: "Broadcast",
+			Handler:    _ChatService_Broadcast_Handler,
+		},
+		{
+			MethodName: "ListParticipants",
+			Handler:    _ChatService_ListParticipants_Handler,
+		},
+		{
+			MethodName: "SetPresence",
+			Handler:    _ChatService_SetPresence_Handler,
+		},
+		{
+			MethodName: "SetTyping",
+			Handler:    _ChatService_SetTyping_Handler,
+		},
+		{
+			MethodName: "Acknowledge",
+			Handler:    _ChatService_Acknowledge_Handler,
+		},
+		{
+			MethodName: "GetReceipts",
+			Handler:    _ChatService_GetReceipts_Handler,
+		},
+		{
+			MethodName: "Edit",
+			Handler:    _ChatService_Edit_Handler,
+		},
+		{
+			MethodName: "Delete",
+			Handler:    _ChatService_Delete_Handler,
+		},
+		{
+			MethodName: "GetEditHistory",
+			Handler:    _ChatService_GetEditHistory_Handler,
+		},
+		{
+			MethodName: "GetThread",
+			Handler:    _ChatService_GetThread_Handler,
+		},
+		{
+			MethodName: "ListThreads",
+			Handler:    _ChatService_ListThreads_Handler,
+		},
+		{
+			MethodName: "React",
+			Handler:    _ChatService_React_Handler,
+		},
+		{
+			MethodName: "SendDirect",
+			Handler:    _ChatService_SendDirect_Handler,
+		},
+		{
+			MethodName: "AcknowledgeDirect",
+			Handler:    _ChatService_AcknowledgeDirect_Handler,
+		},
+		{
+			MethodName: "Search",
+			Handler:    _ChatService_Search_Handler,
+		},
+	},
+	Streams: []grpc.StreamDesc{
+		{
+			StreamName:    "Join",
+			Handler:       _ChatService_Join_Handler,
+			ServerStreams: true,
+		},
+		{
+			StreamName:    "Upload",
+			Handler:       _ChatService_Upload_Handler,
+			ClientStreams: true,
+		},
+		{
+			StreamName:    "Download",
+			Handler:       _ChatService_Download_Handler,
+			ServerStreams: true,
+		},
+	},
+	Metadata: "chitchat/chitchat.proto",
+}
+
+// AdminClient is the client API for Admin service.
+//
+// For semantics around ctx use and closing/ending streaming RPCs, please refer to https://pkg.go.dev/google.golang.org/grpc/?tab=doc#ClientConn.NewStream.
+type AdminClient interface {
+	ListSessions(ctx context.Context, in *ListSessionsRequest, opts ...grpc.CallOption) (*ListSessionsResponse, error)
+	ListRooms(ctx context.Context, in *ListRoomsRequest, opts ...grpc.CallOption) (*ListRoomsResponse, error)
+	GetStats(ctx context.Context, in *StatsRequest, opts ...grpc.CallOption) (*Stats, error)
+	Disconnect(ctx context.Context, in *DisconnectRequest, opts ...grpc.CallOption) (*Confirmation, error)
+	ExportHistory(ctx context.Context, in *ExportHistoryRequest, opts ...grpc.CallOption) (Admin_ExportHistoryClient, error)
+	ImportHistory(ctx context.Context, opts ...grpc.CallOption) (Admin_ImportHistoryClient, error)
+}
+
+type adminClient struct {
+	cc grpc.ClientConnInterface
+}
+
+func NewAdminClient(cc grpc.ClientConnInterface) AdminClient {
+	return &adminClient{cc}
+}
+
+func (c *adminClient) ListSessions(ctx context.Context, in *ListSessionsRequest, opts ...grpc.CallOption) (*ListSessionsResponse, error) {
+	out := new(ListSessionsResponse)
+	err := c.cc.Invoke(ctx, "/chitchat.Admin/ListSessions", in, out, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *adminClient) ListRooms(ctx context.Context, in *ListRoomsRequest, opts ...grpc.CallOption) (*ListRoomsResponse, error) {
+	out := new(ListRoomsResponse)
+	err := c.cc.Invoke(ctx, "/chitchat.Admin/ListRooms", in, out, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *adminClient) GetStats(ctx context.Context, in *StatsRequest, opts ...grpc.CallOption) (*Stats, error) {
+	out := new(Stats)
+	err := c.cc.Invoke(ctx, "/chitchat.Admin/GetStats", in, out, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *adminClient) Disconnect(ctx context.Context, in *DisconnectRequest, opts ...grpc.CallOption) (*Confirmation, error) {
+	out := new(Confirmation)
+	err := c.cc.Invoke(ctx, "/chitchat.Admin/Disconnect", in, out, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *adminClient) ExportHistory(ctx context.Context, in *ExportHistoryRequest, opts ...grpc.CallOption) (Admin_ExportHistoryClient, error) {
+	stream, err := c.cc.NewStream(ctx, &Admin_ServiceDesc.Streams[0], "/chitchat.Admin/ExportHistory", opts...)
+	if err != nil {
+		return nil, err
+	}
+	x := &adminExportHistoryClient{stream}
+	if err := x.ClientStream.SendMsg(in); err != nil {
+		return nil, err
+	}
+	if err := x.ClientStream.CloseSend(); err != nil {
+		return nil, err
+	}
+	return x, nil
+}
+
+type Admin_ExportHistoryClient interface {
+	Recv() (*HistoryChunk, error)
+	grpc.ClientStream
+}
+
+type adminExportHistoryClient struct {
+	grpc.ClientStream
+}
+
+func (x *adminExportHistoryClient) Recv() (*HistoryChunk, error) {
+	m := new(HistoryChunk)
+	if err := x.ClientStream.RecvMsg(m); err != nil {
+		return nil, err
+	}
+	return m, nil
+}
+
+func (c *adminClient) ImportHistory(ctx context.Context, opts ...grpc.CallOption) (Admin_ImportHistoryClient, error) {
+	stream, err := c.cc.NewStream(ctx, &Admin_ServiceDesc.Streams[1], "/chitchat.Admin/ImportHistory", opts...)
+	if err != nil {
+		return nil, err
+	}
+	x := &adminImportHistoryClient{stream}
+	return x, nil
+}
+
+type Admin_ImportHistoryClient interface {
+	Send(*ImportHistoryChunk) error
+	CloseAndRecv() (*ImportHistoryResponse, error)
+	grpc.ClientStream
+}
+
+type adminImportHistoryClient struct {
+	grpc.ClientStream
+}
+
+func (x *adminImportHistoryClient) Send(m *ImportHistoryChunk) error {
+	return x.ClientStream.SendMsg(m)
+}
+
+func (x *adminImportHistoryClient) CloseAndRecv() (*ImportHistoryResponse, error) {
+	if err := x.ClientStream.CloseSend(); err != nil {
+		return nil, err
+	}
+	m := new(ImportHistoryResponse)
+	if err := x.ClientStream.RecvMsg(m); err != nil {
+		return nil, err
+	}
+	return m, nil
+}
+
+// AdminServer is the server API for Admin service.
+// All implementations must embed UnimplementedAdminServer
+// for forward compatibility
+type AdminServer interface {
+	ListSessions(context.Context, *ListSessionsRequest) (*ListSessionsResponse, error)
+	ListRooms(context.Context, *ListRoomsRequest) (*ListRoomsResponse, error)
+	GetStats(context.Context, *StatsRequest) (*Stats, error)
+	Disconnect(context.Context, *DisconnectRequest) (*Confirmation, error)
+	ExportHistory(*ExportHistoryRequest, Admin_ExportHistoryServer) error
+	ImportHistory(Admin_ImportHistoryServer) error
+	mustEmbedUnimplementedAdminServer()
+}
+
+// UnimplementedAdminServer must be embedded to have forward compatible implementations.
+type UnimplementedAdminServer struct {
+}
+
+func (UnimplementedAdminServer) ListSessions(context.Context, *ListSessionsRequest) (*ListSessionsResponse, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method ListSessions not implemented")
+}
+func (UnimplementedAdminServer) ListRooms(context.Context, *ListRoomsRequest) (*ListRoomsResponse, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method ListRooms not implemented")
+}
+func (UnimplementedAdminServer) GetStats(context.Context, *StatsRequest) (*Stats, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method GetStats not implemented")
+}
+func (UnimplementedAdminServer) Disconnect(context.Context, *DisconnectRequest) (*Confirmation, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method Disconnect not implemented")
+}
+func (UnimplementedAdminServer) ExportHistory(*ExportHistoryRequest, Admin_ExportHistoryServer) error {
+	return status.Errorf(codes.Unimplemented, "method ExportHistory not implemented")
+}
+func (UnimplementedAdminServer) ImportHistory(Admin_ImportHistoryServer) error {
+	return status.Errorf(codes.Unimplemented, "method ImportHistory not implemented")
+}
+func (UnimplementedAdminServer) mustEmbedUnimplementedAdminServer() {}
+
+// UnsafeAdminServer may be embedded to opt out of forward compatibility for this service.
+// Use of this interface is not recommended, as added methods to AdminServer will
+// result in compilation errors.
+type UnsafeAdminServer interface {
+	mustEmbedUnimplementedAdminServer()
+}
+
+func RegisterAdminServer(s grpc.ServiceRegistrar, srv AdminServer) {
+	s.RegisterService(&Admin_ServiceDesc, srv)
+}
+
+func _Admin_ListSessions_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(ListSessionsRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(AdminServer).ListSessions(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: "/chitchat.Admin/ListSessions",
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(AdminServer).ListSessions(ctx, req.(*ListSessionsRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _Admin_ListRooms_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(ListRoomsRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(AdminServer).ListRooms(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: "/chitchat.Admin/ListRooms",
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(AdminServer).ListRooms(ctx, req.(*ListRoomsRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _Admin_GetStats_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(StatsRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(AdminServer).GetStats(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: "/chitchat.Admin/GetStats",
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(AdminServer).GetStats(ctx, req.(*StatsRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _Admin_Disconnect_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(DisconnectRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(AdminServer).Disconnect(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: "/chitchat.Admin/Disconnect",
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(AdminServer).Disconnect(ctx, req.(*DisconnectRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _Admin_ExportHistory_Handler(srv interface{}, stream grpc.ServerStream) error {
+	m := new(ExportHistoryRequest)
+	if err := stream.RecvMsg(m); err != nil {
+		return err
+	}
+	return srv.(AdminServer).ExportHistory(m, &adminExportHistoryServer{stream})
+}
+
+type Admin_ExportHistoryServer interface {
+	Send(*HistoryChunk) error
+	grpc.ServerStream
+}
+
+type adminExportHistoryServer struct {
+	grpc.ServerStream
+}
+
+func (x *adminExportHistoryServer) Send(m *HistoryChunk) error {
+	return x.ServerStream.SendMsg(m)
+}
+
+func _Admin_ImportHistory_Handler(srv interface{}, stream grpc.ServerStream) error {
+	return srv.(AdminServer).ImportHistory(&adminImportHistoryServer{stream})
+}
+
+type Admin_ImportHistoryServer interface {
+	SendAndClose(*ImportHistoryResponse) error
+	Recv() (*ImportHistoryChunk, error)
+	grpc.ServerStream
+}
+
+type adminImportHistoryServer struct {
+	grpc.ServerStream
+}
+
+func (x *adminImportHistoryServer) SendAndClose(m *ImportHistoryResponse) error {
+	return x.ServerStream.SendMsg(m)
+}
+
+func (x *adminImportHistoryServer) Recv() (*ImportHistoryChunk, error) {
+	m := new(ImportHistoryChunk)
+	if err := x.ServerStream.RecvMsg(m); err != nil {
+		return nil, err
+	}
+	return m, nil
+}
+
+// Admin_ServiceDesc is the grpc.ServiceDesc for Admin service.
+// It's only intended for direct use with grpc.RegisterService,
+// and not to be introspected or modified (even as a copy)
+var Admin_ServiceDesc = grpc.ServiceDesc{
+	ServiceName: "chitchat.Admin",
+	HandlerType: (*AdminServer)(nil),
+	Methods: []grpc.MethodDesc{
+		{
+			MethodName: "ListSessions",
+			Handler:    _Admin_ListSessions_Handler,
+		},
+		{
+			MethodName: "ListRooms",
+			Handler:    _Admin_ListRooms_Handler,
+		},
+		{
+			MethodName: "GetStats",
+			Handler:    _Admin_GetStats_Handler,
+		},
+		{
+			MethodName: "Disconnect",
+			Handler:    _Admin_Disconnect_Handler,
+		},
+	},
+	Streams: []grpc.StreamDesc{
+		{
+			StreamName:    "ExportHistory",
+			Handler:       _Admin_ExportHistory_Handler,
+			ServerStreams: true,
+		},
+		{
+			StreamName:    "ImportHistory",
+			Handler:       _Admin_ImportHistory_Handler,
+			ClientStreams: true,
 		},
 	},
 	Metadata: "chitchat/chitchat.proto",
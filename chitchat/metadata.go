@@ -0,0 +1,13 @@
+package chitchat
+
+// gRPC metadata keys used to correlate log lines between clients and the server.
+const (
+	// SessionIDHeader identifies one run of a client. It is sent with every call the client makes.
+	SessionIDHeader = "x-chitchat-session-id"
+	// RequestIDHeader identifies a single call. The server makes one up if the client sent none.
+	RequestIDHeader = "x-request-id"
+)
+
+// JoinedHeader is sent in the headers of a Join stream once the user is in the room.
+// Its value is the Lamport time of the join. A stream that ends without it was turned away.
+const JoinedHeader = "x-chitchat-joined"
@@ -0,0 +1,349 @@
+package chitchat
+
+import (
+	"crypto/ed25519"
+	"encoding/binary"
+	"strconv"
+)
+
+// DefaultRoom is the room users end up in when they do not ask for one.
+const DefaultRoom = "general"
+
+// SigningPayload returns the bytes an author signs for a chat message.
+// Every field is length-prefixed so that no two distinct messages share a payload.
+// An empty room means the default room, so it signs the same as DefaultRoom.
+// replyTo is only part of the payload for replies, and ttlSeconds for messages that do not last,
+// so other messages sign as they always have.
+func SigningPayload(name string, room string, text string, replyTo string, ttlSeconds int32, lamport int32) []byte {
+	if room == "" {
+		room = DefaultRoom
+	}
+	if ttlSeconds != 0 {
+		return signingPayload("chitchat-message-v1", lamport, name, room, text, replyTo, "ttl="+strconv.Itoa(int(ttlSeconds)))
+	}
+	if replyTo == "" {
+		return signingPayload("chitchat-message-v1", lamport, name, room, text)
+	}
+	return signingPayload("chitchat-message-v1", lamport, name, room, text, replyTo)
+}
+
+// PresenceSigningPayload returns the bytes a participant signs to change their presence.
+// It starts differently from SigningPayload, so a signed message cannot pass for a presence update.
+func PresenceSigningPayload(name string, room string, presence Presence, status string, lamport int32) []byte {
+	if room == "" {
+		room = DefaultRoom
+	}
+	return signingPayload("chitchat-presence-v1", lamport, name, room, presence.String(), status)
+}
+
+// TypingSigningPayload returns the bytes a participant signs to say they started or stopped typing.
+func TypingSigningPayload(name string, room string, typing bool, lamport int32) []byte {
+	if room == "" {
+		room = DefaultRoom
+	}
+	state := "stopped"
+	if typing {
+		state = "started"
+	}
+	return signingPayload("chitchat-typing-v1", lamport, name, room, state)
+}
+
+// ReceiptSigningPayload returns the bytes a participant signs to acknowledge messages.
+func ReceiptSigningPayload(name string, room string, kind ReceiptKind, messageIDs []string, lamport int32) []byte {
+	if room == "" {
+		room = DefaultRoom
+	}
+	return signingPayload("chitchat-receipt-v1", lamport, append([]string{name, room, kind.String()}, messageIDs...)...)
+}
+
+// ReceiptsRequestSigningPayload returns the bytes an author signs to ask who has received and read their message.
+func ReceiptsRequestSigningPayload(name string, messageID string, lamport int32) []byte {
+	return signingPayload("chitchat-receipts-request-v1", lamport, name, messageID)
+}
+
+// EditSigningPayload returns the bytes the author of a message, or a moderator, signs to change its text.
+func EditSigningPayload(name string, room string, messageID string, text string, lamport int32) []byte {
+	if room == "" {
+		room = DefaultRoom
+	}
+	return signingPayload("chitchat-edit-v1", lamport, name, room, messageID, text)
+}
+
+// DeletionSigningPayload returns the bytes the author of a message, or a moderator, signs to delete it.
+func DeletionSigningPayload(name string, room string, messageID string, lamport int32) []byte {
+	if room == "" {
+		room = DefaultRoom
+	}
+	return signingPayload("chitchat-deletion-v1", lamport, name, room, messageID)
+}
+
+// ReactionSigningPayload returns the bytes a participant signs to add or take back a reaction to a message.
+func ReactionSigningPayload(name string, room string, messageID string, emoji string, remove bool, lamport int32) []byte {
+	if room == "" {
+		room = DefaultRoom
+	}
+	action := "added"
+	if remove {
+		action = "removed"
+	}
+	return signingPayload("chitchat-reaction-v1", lamport, name, room, messageID, emoji, action)
+}
+
+// DirectSigningPayload returns the bytes a user signs to send a direct message.
+// It names the recipient, so the message cannot be passed on to someone else.
+func DirectSigningPayload(name string, to string, text string, lamport int32) []byte {
+	return signingPayload("chitchat-direct-v1", lamport, name, to, text)
+}
+
+// MailboxAckSigningPayload returns the bytes a user signs to acknowledge direct messages.
+func MailboxAckSigningPayload(name string, messageIDs []string, lamport int32) []byte {
+	return signingPayload("chitchat-mailbox-ack-v1", lamport, append([]string{name}, messageIDs...)...)
+}
+
+// AttachmentSigningPayload returns the bytes a participant signs to share a file. The checksum
+// makes the signature cover the file's contents too.
+func AttachmentSigningPayload(name string, room string, fileName string, contentType string, size int64, sha256 string, lamport int32) []byte {
+	if room == "" {
+		room = DefaultRoom
+	}
+	return signingPayload("chitchat-attachment-v1", lamport, name, room, fileName, contentType, strconv.FormatInt(size, 10), sha256)
+}
+
+// JoinSigningPayload returns the bytes a user signs to join, over a nonce from GetJoinChallenge.
+// Signing it shows the server the user holds the key they join with.
+func JoinSigningPayload(id int32, name string, room string, publicKey []byte, nonce []byte, lamport int32) []byte {
+	if room == "" {
+		room = DefaultRoom
+	}
+	return signingPayload("chitchat-join-v1", lamport, strconv.Itoa(int(id)), name, room, string(publicKey), string(nonce))
+}
+
+// LeaveSigningPayload returns the bytes a user signs to end their session with the given id.
+func LeaveSigningPayload(id int32, name string, room string, lamport int32) []byte {
+	if room == "" {
+		room = DefaultRoom
+	}
+	return signingPayload("chitchat-leave-v1", lamport, strconv.Itoa(int(id)), name, room)
+}
+
+// ServerSigningPayload returns the bytes the server signs for a message of its own: what kind of
+// message it is, who and what it is about, and everything else it says for that kind, so none of it
+// can be changed on the way. Times are not part of it. Lists start with how long they are.
+func ServerSigningPayload(x *ServerMessage) []byte {
+	fields := []string{x.Name, x.Room, x.Kind.String(), x.Text, x.Subject, x.Target, x.Id}
+	switch x.Kind {
+	case ServerMessage_PRESENCE:
+		fields = append(fields, x.Presence.String(), x.Status)
+	case ServerMessage_PARTICIPANTS:
+		fields = append(fields, strconv.Itoa(len(x.Participants)))
+		for _, participant := range x.Participants {
+			fields = append(fields, participant.Name, participant.Presence.String(), participant.Status)
+		}
+	case ServerMessage_TYPING:
+		fields = append(fields, strconv.FormatBool(x.Typing))
+	case ServerMessage_RECEIPTS:
+		receipts := x.Receipts
+		if receipts == nil {
+			receipts = &Receipts{}
+		}
+		fields = append(fields, receipts.MessageId, strconv.Itoa(int(receipts.Recipients)))
+		for _, entries := range [][]*ReceiptEntry{receipts.Delivered, receipts.Read} {
+			fields = append(fields, strconv.Itoa(len(entries)))
+			for _, entry := range entries {
+				fields = append(fields, entry.Name)
+			}
+		}
+	case ServerMessage_EDITED:
+		fields = append(fields, string(x.SubjectKey), string(x.GetEdit().GetSignature()))
+	case ServerMessage_REACTIONS:
+		fields = append(fields, x.Emoji, strconv.FormatBool(x.Removed), strconv.Itoa(len(x.Reactions)))
+		for _, reaction := range x.Reactions {
+			fields = append(fields, reaction.Emoji, strconv.Itoa(int(reaction.Count)), strconv.Itoa(len(reaction.Names)))
+			fields = append(fields, reaction.Names...)
+		}
+	}
+	return signingPayload("chitchat-server-v1", x.SignedLamport, fields...)
+}
+
+// signingPayload length-prefixes every field after the kind of payload, and ends with the Lamport time.
+func signingPayload(kind string, lamport int32, fields ...string) []byte {
+	payload := []byte(kind)
+	for _, field := range fields {
+		payload = binary.BigEndian.AppendUint32(payload, uint32(len(field)))
+		payload = append(payload, field...)
+	}
+	return binary.BigEndian.AppendUint32(payload, uint32(lamport))
+}
+
+// Sign signs the message with the given private key and stores the signature on it.
+func (x *ClientMessage) Sign(key ed25519.PrivateKey) {
+	x.Signature = ed25519.Sign(key, SigningPayload(x.Name, x.Room, x.Text, x.ReplyTo, x.TtlSeconds, x.Lamport))
+}
+
+// Verify reports whether the message carries a valid signature by the given public key.
+func (x *ClientMessage) Verify(key ed25519.PublicKey) bool {
+	return len(key) == ed25519.PublicKeySize && ed25519.Verify(key, SigningPayload(x.Name, x.Room, x.Text, x.ReplyTo, x.TtlSeconds, x.Lamport), x.Signature)
+}
+
+// Verify reports whether the message carries a valid signature by its attached public key.
+// Note that this only proves the message is intact; callers must decide whether to trust the key.
+// Direct messages and attachments carry their sender's signature over DirectSigningPayload
+// and AttachmentSigningPayload, and everything but chat messages the server's over ServerSigningPayload.
+// An edit also carries its editor's signature, which VerifyEdit checks.
+func (x *ServerMessage) Verify() bool {
+	key := ed25519.PublicKey(x.PublicKey)
+	payload := SigningPayload(x.Name, x.Room, x.Text, x.ReplyTo, x.TtlSeconds, x.SignedLamport)
+	switch {
+	case x.Kind != ServerMessage_CHAT && x.Kind != ServerMessage_DIRECT && x.Kind != ServerMessage_ATTACHMENT:
+		payload = ServerSigningPayload(x)
+	case x.Kind == ServerMessage_DIRECT:
+		payload = DirectSigningPayload(x.Name, x.To, x.Text, x.SignedLamport)
+	case x.Kind == ServerMessage_ATTACHMENT && x.Attachment != nil:
+		a := x.Attachment
+		payload = AttachmentSigningPayload(x.Name, x.Room, a.FileName, a.ContentType, a.Size, a.Sha256, x.SignedLamport)
+	}
+	return len(key) == ed25519.PublicKeySize && ed25519.Verify(key, payload, x.Signature)
+}
+
+// Sign signs the presence update with the given private key and stores the signature on it.
+func (x *PresenceUpdate) Sign(key ed25519.PrivateKey) {
+	x.Signature = ed25519.Sign(key, PresenceSigningPayload(x.Name, x.Room, x.Presence, x.Status, x.Lamport))
+}
+
+// Verify reports whether the presence update carries a valid signature by the given public key.
+func (x *PresenceUpdate) Verify(key ed25519.PublicKey) bool {
+	return len(key) == ed25519.PublicKeySize && ed25519.Verify(key, PresenceSigningPayload(x.Name, x.Room, x.Presence, x.Status, x.Lamport), x.Signature)
+}
+
+// Sign signs the typing update with the given private key and stores the signature on it.
+func (x *TypingUpdate) Sign(key ed25519.PrivateKey) {
+	x.Signature = ed25519.Sign(key, TypingSigningPayload(x.Name, x.Room, x.Typing, x.Lamport))
+}
+
+// Verify reports whether the typing update carries a valid signature by the given public key.
+func (x *TypingUpdate) Verify(key ed25519.PublicKey) bool {
+	return len(key) == ed25519.PublicKeySize && ed25519.Verify(key, TypingSigningPayload(x.Name, x.Room, x.Typing, x.Lamport), x.Signature)
+}
+
+// Sign signs the receipt with the given private key and stores the signature on it.
+func (x *Receipt) Sign(key ed25519.PrivateKey) {
+	x.Signature = ed25519.Sign(key, ReceiptSigningPayload(x.Name, x.Room, x.Kind, x.MessageIds, x.Lamport))
+}
+
+// Verify reports whether the receipt carries a valid signature by the given public key.
+func (x *Receipt) Verify(key ed25519.PublicKey) bool {
+	return len(key) == ed25519.PublicKeySize && ed25519.Verify(key, ReceiptSigningPayload(x.Name, x.Room, x.Kind, x.MessageIds, x.Lamport), x.Signature)
+}
+
+// Sign signs the request with the given private key and stores the signature on it.
+func (x *ReceiptsRequest) Sign(key ed25519.PrivateKey) {
+	x.Signature = ed25519.Sign(key, ReceiptsRequestSigningPayload(x.Name, x.MessageId, x.Lamport))
+}
+
+// Verify reports whether the request carries a valid signature by the given public key.
+func (x *ReceiptsRequest) Verify(key ed25519.PublicKey) bool {
+	return len(key) == ed25519.PublicKeySize && ed25519.Verify(key, ReceiptsRequestSigningPayload(x.Name, x.MessageId, x.Lamport), x.Signature)
+}
+
+// Sign signs the edit with the given private key and stores the signature on it.
+func (x *MessageEdit) Sign(key ed25519.PrivateKey) {
+	x.Signature = ed25519.Sign(key, EditSigningPayload(x.Name, x.Room, x.MessageId, x.Text, x.Lamport))
+}
+
+// Verify reports whether the edit carries a valid signature by the given public key.
+func (x *MessageEdit) Verify(key ed25519.PublicKey) bool {
+	return len(key) == ed25519.PublicKeySize && ed25519.Verify(key, EditSigningPayload(x.Name, x.Room, x.MessageId, x.Text, x.Lamport), x.Signature)
+}
+
+// Sign signs the deletion with the given private key and stores the signature on it.
+func (x *MessageDeletion) Sign(key ed25519.PrivateKey) {
+	x.Signature = ed25519.Sign(key, DeletionSigningPayload(x.Name, x.Room, x.MessageId, x.Lamport))
+}
+
+// Verify reports whether the deletion carries a valid signature by the given public key.
+func (x *MessageDeletion) Verify(key ed25519.PublicKey) bool {
+	return len(key) == ed25519.PublicKeySize && ed25519.Verify(key, DeletionSigningPayload(x.Name, x.Room, x.MessageId, x.Lamport), x.Signature)
+}
+
+// Sign signs the reaction with the given private key and stores the signature on it.
+func (x *ReactionUpdate) Sign(key ed25519.PrivateKey) {
+	x.Signature = ed25519.Sign(key, ReactionSigningPayload(x.Name, x.Room, x.MessageId, x.Emoji, x.Remove, x.Lamport))
+}
+
+// Verify reports whether the reaction carries a valid signature by the given public key.
+func (x *ReactionUpdate) Verify(key ed25519.PublicKey) bool {
+	return len(key) == ed25519.PublicKeySize && ed25519.Verify(key, ReactionSigningPayload(x.Name, x.Room, x.MessageId, x.Emoji, x.Remove, x.Lamport), x.Signature)
+}
+
+// Sign signs the direct message with the given private key and stores the signature on it.
+func (x *DirectMessage) Sign(key ed25519.PrivateKey) {
+	x.Signature = ed25519.Sign(key, DirectSigningPayload(x.Name, x.To, x.Text, x.Lamport))
+}
+
+// Verify reports whether the direct message carries a valid signature by the given public key.
+func (x *DirectMessage) Verify(key ed25519.PublicKey) bool {
+	return len(key) == ed25519.PublicKeySize && ed25519.Verify(key, DirectSigningPayload(x.Name, x.To, x.Text, x.Lamport), x.Signature)
+}
+
+// Sign signs the acknowledgement with the given private key and stores the signature on it.
+func (x *MailboxAck) Sign(key ed25519.PrivateKey) {
+	x.Signature = ed25519.Sign(key, MailboxAckSigningPayload(x.Name, x.MessageIds, x.Lamport))
+}
+
+// Verify reports whether the acknowledgement carries a valid signature by the given public key.
+func (x *MailboxAck) Verify(key ed25519.PublicKey) bool {
+	return len(key) == ed25519.PublicKeySize && ed25519.Verify(key, MailboxAckSigningPayload(x.Name, x.MessageIds, x.Lamport), x.Signature)
+}
+
+// Sign signs the upload header with the given private key and stores the signature on it.
+func (x *UploadHeader) Sign(key ed25519.PrivateKey) {
+	x.Signature = ed25519.Sign(key, AttachmentSigningPayload(x.Name, x.Room, x.FileName, x.ContentType, x.Size, x.Sha256, x.Lamport))
+}
+
+// Verify reports whether the upload header carries a valid signature by the given public key.
+func (x *UploadHeader) Verify(key ed25519.PublicKey) bool {
+	return len(key) == ed25519.PublicKeySize && ed25519.Verify(key, AttachmentSigningPayload(x.Name, x.Room, x.FileName, x.ContentType, x.Size, x.Sha256, x.Lamport), x.Signature)
+}
+
+// SignJoin signs what the user joins with, and the nonce on it, with the given private key and stores the signature on it.
+func (x *User) SignJoin(key ed25519.PrivateKey) {
+	x.Signature = ed25519.Sign(key, JoinSigningPayload(x.Id, x.Name, x.Room, x.PublicKey, x.Nonce, x.Lamport))
+}
+
+// VerifyJoin reports whether the user signed what they join with using the key they join with.
+func (x *User) VerifyJoin() bool {
+	key := ed25519.PublicKey(x.PublicKey)
+	return len(key) == ed25519.PublicKeySize && ed25519.Verify(key, JoinSigningPayload(x.Id, x.Name, x.Room, x.PublicKey, x.Nonce, x.Lamport), x.Signature)
+}
+
+// SignLeave signs the user's leaving with the given private key and stores the signature on it.
+func (x *User) SignLeave(key ed25519.PrivateKey) {
+	x.Signature = ed25519.Sign(key, LeaveSigningPayload(x.Id, x.Name, x.Room, x.Lamport))
+}
+
+// VerifyLeave reports whether the user's leaving carries a valid signature by the given public key.
+func (x *User) VerifyLeave(key ed25519.PublicKey) bool {
+	return len(key) == ed25519.PublicKeySize && ed25519.Verify(key, LeaveSigningPayload(x.Id, x.Name, x.Room, x.Lamport), x.Signature)
+}
+
+// SignAsServer signs a message of the server's own over ServerSigningPayload with the given private key,
+// and stores the signature and the matching public key on it.
+func (x *ServerMessage) SignAsServer(key ed25519.PrivateKey) {
+	x.PublicKey = key.Public().(ed25519.PublicKey)
+	x.Signature = ed25519.Sign(key, ServerSigningPayload(x))
+}
+
+// VerifyEdit reports whether an EDITED message carries its editor's signed edit, by the key the
+// message says is theirs, and the edit is the one the message is about. Like Verify, it leaves
+// whether to trust the key to the caller.
+func (x *ServerMessage) VerifyEdit() bool {
+	edit := x.Edit
+	if x.Kind != ServerMessage_EDITED || edit == nil {
+		return false
+	}
+	room := edit.Room
+	if room == "" {
+		room = DefaultRoom
+	}
+	return edit.Name == x.Subject && room == x.Room && edit.MessageId == x.Target && edit.Text == x.Text && edit.Verify(x.SubjectKey)
+}
@@ -0,0 +1,165 @@
+package chitchat
+
+import (
+	"bytes"
+	"crypto/ed25519"
+	"testing"
+)
+
+func newKey(t *testing.T) ed25519.PrivateKey {
+	t.Helper()
+	_, key, err := ed25519.GenerateKey(nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return key
+}
+
+func TestSigningPayloadsAreUnambiguous(t *testing.T) {
+	tests := []struct {
+		name string
+		a, b []byte
+	}{
+		{"name and room", SigningPayload("ab", "c", "hi", "", 0, 1), SigningPayload("a", "bc", "hi", "", 0, 1)},
+		{"room and text", SigningPayload("alice", "ops", "x", "", 0, 1), SigningPayload("alice", "op", "sx", "", 0, 1)},
+		{"text and reply", SigningPayload("alice", "ops", "hi0a", "", 0, 1), SigningPayload("alice", "ops", "hi", "0a", 0, 1)},
+		{"text and lifetime", SigningPayload("alice", "ops", "hittl=60", "", 0, 1), SigningPayload("alice", "ops", "hi", "", 60, 1)},
+		{"text and Lamport time", SigningPayload("alice", "ops", "hi\x00\x00\x00\x01", "", 0, 2), SigningPayload("alice", "ops", "hi", "", 0, 1)},
+		{"sender and recipient", DirectSigningPayload("al", "icebob", "hi", 1), DirectSigningPayload("alice", "bob", "hi", 1)},
+		{"message ids", ReceiptSigningPayload("alice", "ops", ReceiptKind_READ, []string{"0a0b"}, 1), ReceiptSigningPayload("alice", "ops", ReceiptKind_READ, []string{"0a", "0b"}, 1)},
+		{"message and edit", SigningPayload("alice", "ops", "0a", "", 0, 1), EditSigningPayload("alice", "ops", "0a", "", 1)},
+		{"edit and deletion", EditSigningPayload("alice", "ops", "0a", "", 1), DeletionSigningPayload("alice", "ops", "0a", 1)},
+	}
+	for _, test := range tests {
+		if bytes.Equal(test.a, test.b) {
+			t.Errorf("%s: two different requests sign the same payload %q", test.name, test.a)
+		}
+	}
+}
+
+func TestDefaultRoomSignsLikeNoRoom(t *testing.T) {
+	if !bytes.Equal(SigningPayload("alice", "", "hi", "", 0, 1), SigningPayload("alice", DefaultRoom, "hi", "", 0, 1)) {
+		t.Error("a message to no room does not sign like one to the default room")
+	}
+	key := newKey(t)
+	message := &ClientMessage{Name: "alice", Text: "hi", Lamport: 1}
+	message.Sign(key)
+	message.Room = DefaultRoom
+	if !message.Verify(key.Public().(ed25519.PublicKey)) {
+		t.Error("a message signed for no room does not verify in the default room")
+	}
+}
+
+func TestChangedMessagesFailVerification(t *testing.T) {
+	key := newKey(t)
+	public := key.Public().(ed25519.PublicKey)
+	tests := []struct {
+		name   string
+		change func(*ClientMessage)
+	}{
+		{"room", func(m *ClientMessage) { m.Room = "random" }},
+		{"Lamport time", func(m *ClientMessage) { m.Lamport++ }},
+		{"name", func(m *ClientMessage) { m.Name = "mallory" }},
+		{"text", func(m *ClientMessage) { m.Text = "bye" }},
+		{"reply", func(m *ClientMessage) { m.ReplyTo = "0b" }},
+		{"lifetime", func(m *ClientMessage) { m.TtlSeconds = 60 }},
+	}
+	for _, test := range tests {
+		message := &ClientMessage{Name: "alice", Room: "ops", Text: "hi", Lamport: 5}
+		message.Sign(key)
+		if !message.Verify(public) {
+			t.Fatal("a signed message does not verify")
+		}
+		test.change(message)
+		if message.Verify(public) {
+			t.Errorf("the message still verifies with another %s", test.name)
+		}
+	}
+	message := &ClientMessage{Name: "alice", Room: "ops", Text: "hi", Lamport: 5}
+	message.Sign(key)
+	if message.Verify(newKey(t).Public().(ed25519.PublicKey)) {
+		t.Error("the message verifies with someone else's key")
+	}
+}
+
+func TestChangedServerMessagesFailVerification(t *testing.T) {
+	key := newKey(t)
+	tests := []struct {
+		name   string
+		change func(*ServerMessage)
+	}{
+		{"room", func(m *ServerMessage) { m.Room = "random" }},
+		{"Lamport time", func(m *ServerMessage) { m.SignedLamport++ }},
+		{"kind", func(m *ServerMessage) { m.Kind = ServerMessage_DELETED }},
+		{"target", func(m *ServerMessage) { m.Target = "0b" }},
+		{"subject", func(m *ServerMessage) { m.Subject = "mallory" }},
+		{"reaction", func(m *ServerMessage) { m.Reactions[0].Names = []string{"mallory"} }},
+		{"reaction count", func(m *ServerMessage) { m.Reactions[0].Count = 2 }},
+		{"emoji", func(m *ServerMessage) { m.Emoji = "👎" }},
+		{"removal", func(m *ServerMessage) { m.Removed = true }},
+	}
+	for _, test := range tests {
+		message := &ServerMessage{
+			Room:          "ops",
+			Kind:          ServerMessage_REACTIONS,
+			Text:          "bob reacted with 👍",
+			Subject:       "bob",
+			Target:        "0a",
+			Emoji:         "👍",
+			Reactions:     []*Reaction{{Emoji: "👍", Count: 1, Names: []string{"bob"}}},
+			SignedLamport: 7,
+		}
+		message.SignAsServer(key)
+		if !message.Verify() {
+			t.Fatal("a signed server message does not verify")
+		}
+		test.change(message)
+		if message.Verify() {
+			t.Errorf("the server message still verifies with another %s", test.name)
+		}
+	}
+}
+
+func TestVerifyEdit(t *testing.T) {
+	editor := newKey(t)
+	server := newKey(t)
+	edit := &MessageEdit{Name: "alice", MessageId: "0a", Text: "hello", Lamport: 4}
+	edit.Sign(editor)
+	edited := func() *ServerMessage {
+		message := &ServerMessage{
+			Room:          DefaultRoom,
+			Kind:          ServerMessage_EDITED,
+			Text:          "hello",
+			Subject:       "alice",
+			Target:        "0a",
+			Edit:          edit,
+			SubjectKey:    editor.Public().(ed25519.PublicKey),
+			SignedLamport: 5,
+		}
+		message.SignAsServer(server)
+		return message
+	}
+	if message := edited(); !message.Verify() || !message.VerifyEdit() {
+		t.Fatal("an edit forwarded as it was signed does not verify")
+	}
+	tests := []struct {
+		name   string
+		change func(*ServerMessage)
+	}{
+		{"text", func(m *ServerMessage) { m.Text = "goodbye" }},
+		{"message", func(m *ServerMessage) { m.Target = "0b" }},
+		{"room", func(m *ServerMessage) { m.Room = "ops" }},
+		{"editor", func(m *ServerMessage) { m.Subject = "bob" }},
+		{"editor's key", func(m *ServerMessage) { m.SubjectKey = server.Public().(ed25519.PublicKey) }},
+		{"missing edit", func(m *ServerMessage) { m.Edit = nil }},
+	}
+	for _, test := range tests {
+		message := edited()
+		test.change(message)
+		//the server signs whatever it changed, but cannot sign for the editor.
+		message.SignAsServer(server)
+		if message.VerifyEdit() {
+			t.Errorf("the edit still verifies with another %s", test.name)
+		}
+	}
+}
@@ -3,70 +3,85 @@ package main
 import (
 	"bufio"
 	"context"
+	"crypto/ed25519"
+	"crypto/tls"
 	"fmt"
-	"log"
-	"math/rand"
+	"io"
+	"log/slog"
 	"os"
 	"os/signal"
 	"strings"
 	"time"
 	"unicode/utf8"
 
+	"homework3/chatclient"
 	"homework3/chitchat"
+	"homework3/tracing"
+
+	"golang.org/x/term"
 
 	"google.golang.org/grpc"
+	"google.golang.org/grpc/credentials"
 	"google.golang.org/grpc/credentials/insecure"
+	"google.golang.org/grpc/keepalive"
+	"google.golang.org/grpc/status"
 )
 
 type chatClientStruct struct {
-	stream chitchat.ChatService_JoinClient
-	id     int32
+	client *chatclient.Client
 	name   string
+	key    ed25519.PrivateKey
+	//set while the user has said they are away
+	away bool
+	//id of the last message the user sent, for /receipts
+	lastSent string
+	//the rest of the last search, for /more
+	nextSearch *chatclient.SearchQuery
 }
 
-var lamport int32
-var user *chitchat.User
-
-func main() {
+var clientSettings *Settings
 
-	//Server address where gRPC server is running
-	const serverAddress = "localhost:5678"
+// how much of the message a reply replies to is quoted
+const quoteLength = 30
 
-	//We initialize lamport clock
-	lamport = 0
+// sends buffered spans to the trace exporter, see exit
+var flushTraces = func(context.Context) error { return nil }
 
-	//we create insecure transport credentials (in the context of this assignment we choose not to worry about security):
-	transportCreds := insecure.NewCredentials()
-	//Establish a grpc connection to the server using addres and tansport credentials
-	conn, err := grpc.Dial(serverAddress, grpc.WithTransportCredentials(transportCreds))
-	if err != nil {
-		log.Fatalf("Failed to connect to gRPC server ... : %v\n", err)
+func main() {
+	//'client send ...' and 'client tail ...' are for scripts: they never prompt, do one thing and exit.
+	if len(os.Args) > 1 && os.Args[1] == "send" {
+		exit(runSendCommand(os.Args[2:]))
 	}
-	//defer call to conn.Close() to ensure connection is closed when main method exits.
-	defer conn.Close()
+	if len(os.Args) > 1 && os.Args[1] == "tail" {
+		exit(runTailCommand(os.Args[2:]))
+	}
+
+	clientSettings, _ = loadSettings("client", os.Args[1:], false)
+	fullScreenMode := useFullScreen(clientSettings.UI)
+	dialOptions := setup(fullScreenMode)
 
-	//create client and user
-	client := chitchat.NewChatServiceClient(conn)
+	//ask for the username, unless it is configured, and load their key
 	chatClient := chatClientStruct{}
-	user = chatClient.CreateUser(client)
+	chatClient.CreateUser()
 
-	//sleep to simulate wait time for connection to be established...
-	log.Println("Connecting to the gRPC server at ... : " + serverAddress)
-	time.Sleep(time.Millisecond * time.Duration(1000))
+	display("Connecting to the gRPC server at ... : " + clientSettings.Address)
+	if fullScreenMode {
+		runFullScreen(chatClient.name, chatClient.key, clientSettings.Room, dialOptions)
+		exit(0)
+	}
 
-	//Initialize a join stream and set the join stream in chatClient
-	joinStream, err := client.Join(context.Background(), user)
+	//Connect to the server and join the chat. Connect returns once the server has let us in.
+	client, err := connect(chatClient.name, chatClient.key, clientSettings.Room, dialOptions)
 	if err != nil {
-		log.Fatalf("Ouch. Failed to join the chat: %v\n", err)
+		fatal("Failed to join the chat", err)
 	}
-	chatClient.stream = joinStream
+	chatClient.client = client
 
 	//print welcome message.
-	log.Printf("\n\nHello, %s. \nYou can disconnect with '/disconnect' \n\nWrite a message ...\n", user.Name)
+	display("\n\nHello, %s. \nYou can disconnect with '/disconnect', see who is here with '/who' and say you are away with '/away [message]' \n\nWrite a message ...\n", chatClient.name)
 
-	//We start go routines for sending and recieving messages.
-	go chatClient.SendChatMessage(client)
-	go chatClient.ReceiveMessage(client, user)
+	//We start a go routine for sending messages, and show what arrives until the chat ends.
+	go chatClient.SendChatMessage()
 
 	//create channel for listening for client closing down unexpectedly (for instance ctrl+c)
 	c := make(chan os.Signal, 1)
@@ -74,83 +89,604 @@ func main() {
 	go func() {
 		<-c
 		//Disconnect the user and print "Disconnected", then exit.
-		log.Println("Disconnected")
-		client.Leave(context.Background(), user)
-		os.Exit(0)
+		display("Disconnected")
+		chatClient.leave()
+		exit(0)
 	}()
 
-	//keep the main function running
+	chatClient.ReceiveMessage()
+	//we left; wait for whoever called leave to exit.
 	select {}
 }
 
-func (chatClient *chatClientStruct) SendChatMessage(client chitchat.ChatServiceClient) {
+// setup starts logging and tracing from the loaded settings and returns the options for dialing the server.
+func setup(fullScreen bool) []grpc.DialOption {
+	if err := setupLogging(clientSettings, fullScreen); err != nil {
+		fmt.Fprintf(os.Stderr, "Could not open log file: %v\n", err)
+		os.Exit(1)
+	}
+	var err error
+	flushTraces, err = tracing.Setup(context.Background(), "chitchat-client", clientSettings.Tracing)
+	if err != nil {
+		fatal("Failed to set up tracing", err)
+	}
+
+	//Use TLS if configured, otherwise insecure transport credentials.
+	transportCreds, err := transportCredentials(&clientSettings.TLS)
+	if err != nil {
+		fatal("Failed to set up TLS", err)
+	}
+	dialOptions := []grpc.DialOption{grpc.WithTransportCredentials(transportCreds)}
+	if clientSettings.Keepalive.Time > 0 {
+		dialOptions = append(dialOptions, grpc.WithKeepaliveParams(keepalive.ClientParameters{
+			Time:                clientSettings.Keepalive.Time,
+			Timeout:             clientSettings.Keepalive.Timeout,
+			PermitWithoutStream: true,
+		}))
+	}
+	return dialOptions
+}
+
+// how long to wait for the server to let us in
+const connectTimeout = 30 * time.Second
+
+// connect joins a room as the user, returning once the server has let us in.
+func connect(name string, key ed25519.PrivateKey, room string, dialOptions []grpc.DialOption) (*chatclient.Client, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), connectTimeout)
+	defer cancel()
+	return chatclient.Connect(ctx, clientSettings.Address, name, key,
+		chatclient.WithRoom(room),
+		chatclient.WithDialOptions(dialOptions...),
+		chatclient.WithSessionID(sessionID),
+	)
+}
+
+// useFullScreen decides between the full-screen interface and the line mode.
+// In auto mode the full-screen interface is only used when both stdin and stdout are terminals.
+func useFullScreen(mode string) bool {
+	switch mode {
+	case "tui":
+		return true
+	case "plain":
+		return false
+	}
+	return term.IsTerminal(int(os.Stdin.Fd())) && term.IsTerminal(int(os.Stdout.Fd()))
+}
+
+func (chatClient *chatClientStruct) SendChatMessage() {
 	for {
 		//read user message from the console and decide what to do
 		message, err := readUserInput()
+		if err == io.EOF {
+			//stdin ended, for instance because a file was piped in: send what was left of it and leave
+			if message != "" {
+				if _, err := chatClient.client.Send(context.Background(), message); err != nil {
+					fatal("Failed to send the clientMessage to server", err)
+				}
+			}
+			message, err = "/disconnect", nil
+		}
 		if utf8.RuneCountInString(message) > 128 {
-			log.Println("Your message must be no longer than 128 characters!")
+			display("Your message must be no longer than 128 characters!")
 		} else if err != nil {
-			log.Fatalf("Ouch. Failed to read your chat message from the console: %v ", err)
+			fatal("Failed to read your chat message from the console", err)
+		} else if message == "/who" {
+			chatClient.who()
+		} else if message == "/receipts" || strings.HasPrefix(message, "/receipts ") {
+			chatClient.showReceipts(strings.TrimPrefix(strings.TrimSpace(strings.TrimPrefix(message, "/receipts")), "#"))
+		} else if message == "/reply" || strings.HasPrefix(message, "/reply ") {
+			id, text, _ := strings.Cut(strings.TrimSpace(strings.TrimPrefix(message, "/reply")), " ")
+			chatClient.reply(strings.TrimPrefix(id, "#"), strings.TrimSpace(text))
+		} else if message == "/ephemeral" || strings.HasPrefix(message, "/ephemeral ") {
+			ttl, text, _ := strings.Cut(strings.TrimSpace(strings.TrimPrefix(message, "/ephemeral")), " ")
+			chatClient.sendEphemeral(ttl, strings.TrimSpace(text))
+		} else if message == "/thread" || strings.HasPrefix(message, "/thread ") {
+			chatClient.showThread(strings.TrimPrefix(strings.TrimSpace(strings.TrimPrefix(message, "/thread")), "#"))
+		} else if message == "/threads" {
+			chatClient.showThreads()
+		} else if message == "/react" || strings.HasPrefix(message, "/react ") || message == "/unreact" || strings.HasPrefix(message, "/unreact ") {
+			command, argument, _ := strings.Cut(message, " ")
+			id, emoji, _ := strings.Cut(strings.TrimSpace(argument), " ")
+			chatClient.react(strings.TrimPrefix(id, "#"), strings.TrimSpace(emoji), command == "/unreact")
+		} else if message == "/edit" || strings.HasPrefix(message, "/edit ") {
+			id, text, _ := strings.Cut(strings.TrimSpace(strings.TrimPrefix(message, "/edit")), " ")
+			chatClient.edit(strings.TrimPrefix(id, "#"), strings.TrimSpace(text))
+		} else if message == "/delete" || strings.HasPrefix(message, "/delete ") {
+			chatClient.delete(strings.TrimPrefix(strings.TrimSpace(strings.TrimPrefix(message, "/delete")), "#"))
+		} else if message == "/history" || strings.HasPrefix(message, "/history ") {
+			chatClient.showHistory(strings.TrimPrefix(strings.TrimSpace(strings.TrimPrefix(message, "/history")), "#"))
+		} else if message == "/search" || strings.HasPrefix(message, "/search ") {
+			chatClient.search(strings.TrimSpace(strings.TrimPrefix(message, "/search")))
+		} else if message == "/more" {
+			chatClient.more()
+		} else if message == "/share" || strings.HasPrefix(message, "/share ") {
+			chatClient.share(strings.TrimSpace(strings.TrimPrefix(message, "/share")))
+		} else if message == "/get" || strings.HasPrefix(message, "/get ") {
+			id, path, _ := strings.Cut(strings.TrimSpace(strings.TrimPrefix(message, "/get")), " ")
+			chatClient.get(strings.TrimPrefix(id, "#"), strings.TrimSpace(path))
+		} else if message == "/msg" || strings.HasPrefix(message, "/msg ") {
+			to, text, _ := strings.Cut(strings.TrimSpace(strings.TrimPrefix(message, "/msg")), " ")
+			chatClient.sendDirect(to, strings.TrimSpace(text))
+		} else if message == "/away" || strings.HasPrefix(message, "/away ") {
+			chatClient.toggleAway(strings.TrimSpace(strings.TrimPrefix(message, "/away")))
 		} else if message == "/disconnect" {
-			//increment lamport and call Leave method to disconnect.
-			lamport++
-			user.Lamport = lamport
-			client.Leave(context.Background(), user)
+			chatClient.leave()
 			//since the user won't recieve the broadcast leave message from the server after disconnecting we print a leave message for the client.
-			log.Print("You have left the chat!")
-			os.Exit(0)
+			display("You have left the chat!")
+			exit(0)
 		} else {
-			lamport++
-			//Create new clientMessage and send it to the server by calling BroadcastChatmessage.
-			clientMessage := &chitchat.ClientMessage{
-				Name:    chatClient.name,
-				Text:    message,
-				Lamport: lamport,
-			}
 			//If no error, the confirmation message from the server has been recieved
-			_, err2 := client.Broadcast(context.Background(), clientMessage)
+			id, err2 := chatClient.client.Send(context.Background(), message)
 			if err2 != nil {
-				log.Fatalf("Failed to send the clientMessage to server: %v\n", err2)
+				fatal("Failed to send the clientMessage to server", err2)
 			}
+			chatClient.lastSent = id
 		}
 	}
 }
 
-func (chatClient *chatClientStruct) ReceiveMessage(client chitchat.ChatServiceClient, user *chitchat.User) {
-	for {
+// who shows everyone in the room and whether they are around.
+func (chatClient *chatClientStruct) who() {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	participants, err := chatClient.client.ListParticipants(ctx, false)
+	if err != nil {
+		display("Could not ask who is here: %s", describe(err))
+		return
+	}
+	var names []string
+	for _, participant := range participants {
+		names = append(names, describeParticipant(participant))
+	}
+	display("In %s: %s", chatClient.client.Room(), strings.Join(names, ", "))
+}
 
-		//recieve a message from the server
-		userStreamServerMessage, err := chatClient.stream.Recv()
-		if err != nil {
-			log.Fatalf("Failed to recieve message from server: %v\n", err)
+// showReceipts shows who has received and read one of the user's messages, the last one if id is empty.
+func (chatClient *chatClientStruct) showReceipts(id string) {
+	if id == "" {
+		id = chatClient.lastSent
+	}
+	if id == "" {
+		display("Usage: /receipts [message id]")
+		return
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	receipts, err := chatClient.client.Receipts(ctx, id)
+	if err != nil {
+		display("Could not get the receipts for #%s: %s", id, describe(err))
+		return
+	}
+	display("#%s: %s", id, describeReceipts(receipts))
+}
+
+// describeReceipts says who has received and read a message.
+func describeReceipts(receipts *chatclient.Receipts) string {
+	names := func(list []chatclient.Receipt) string {
+		var names []string
+		for _, receipt := range list {
+			names = append(names, receipt.Name)
+		}
+		return strings.Join(names, ", ")
+	}
+	described := fmt.Sprintf("delivered to %d of %d", len(receipts.Delivered), receipts.Recipients)
+	if len(receipts.Delivered) > 0 {
+		described += " (" + names(receipts.Delivered) + ")"
+	}
+	described += fmt.Sprintf(", read by %d", len(receipts.Read))
+	if len(receipts.Read) > 0 {
+		described += " (" + names(receipts.Read) + ")"
+	}
+	return described
+}
+
+// reply sends a message as a reply to another one.
+func (chatClient *chatClientStruct) reply(id string, text string) {
+	if id == "" || text == "" {
+		display("Usage: /reply <message id> <text>")
+		return
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	sent, err := chatClient.client.Reply(ctx, id, text)
+	if err != nil {
+		display("Could not reply to #%s: %s", id, describe(err))
+		return
+	}
+	chatClient.lastSent = sent
+}
+
+// sendEphemeral sends a message that disappears once ttl, a duration like 10m, has passed.
+func (chatClient *chatClientStruct) sendEphemeral(ttl string, text string) {
+	lasting, err := time.ParseDuration(ttl)
+	if err != nil || lasting <= 0 || text == "" {
+		display("Usage: /ephemeral <how long, like 30s or 10m> <text>")
+		return
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	sent, err := chatClient.client.SendEphemeral(ctx, text, lasting)
+	if err != nil {
+		display("Could not send the message: %s", describe(err))
+		return
+	}
+	chatClient.lastSent = sent
+}
+
+// showThread shows the thread a message is in, with the text each message has now.
+func (chatClient *chatClientStruct) showThread(id string) {
+	if id == "" {
+		display("Usage: /thread <message id>")
+		return
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	thread, err := chatClient.client.Thread(ctx, id)
+	if err != nil {
+		display("Could not get the thread of #%s: %s", id, describe(err))
+		return
+	}
+	for _, line := range describeThread(thread) {
+		display("%s", line)
+	}
+}
+
+// showThreads lists the messages in the room that have replies.
+func (chatClient *chatClientStruct) showThreads() {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	threads, err := chatClient.client.Threads(ctx, 10)
+	if err != nil {
+		display("Could not list the threads: %s", describe(err))
+		return
+	}
+	if len(threads) == 0 {
+		display("Nobody has replied to anything in %s yet", chatClient.client.Room())
+	}
+	for _, thread := range threads {
+		display("%s", describeThreadSummary(thread))
+	}
+}
+
+// quoteOf is a short reminder of the message a reply replies to, empty for messages that are not replies.
+func quoteOf(message *chatclient.Message) string {
+	if message.ReplyTo == "" {
+		return ""
+	}
+	if message.Quote == nil || message.Quote.Text == "" {
+		return fmt.Sprintf("↪ #%s", message.ReplyTo)
+	}
+	return fmt.Sprintf("↪ #%s %s: %q", message.ReplyTo, message.Quote.Author, shorten(message.Quote.Text, quoteLength))
+}
+
+// expiryOf says when a message that does not last disappears, empty for messages that last.
+func expiryOf(message *chatclient.Message) string {
+	if message.ExpiresAt.IsZero() {
+		return ""
+	}
+	return "until " + message.ExpiresAt.Local().Format(time.TimeOnly)
+}
+
+// shorten cuts text down to at most length characters, marking that it did.
+func shorten(text string, length int) string {
+	if utf8.RuneCountInString(text) <= length {
+		return text
+	}
+	return string([]rune(text)[:length-1]) + "…"
+}
+
+// describeThread is one line per message in a thread, with the replies indented.
+func describeThread(thread *chatclient.Thread) []string {
+	var lines []string
+	for _, message := range thread.Messages {
+		indent := ""
+		if message.ID != thread.RootID {
+			indent = "  ↪ "
+		}
+		switch {
+		case message.Deleted:
+			lines = append(lines, fmt.Sprintf("%s[%d] #%s: %s", indent, message.Lamport, message.ID, message.Text))
+		case message.Edited:
+			lines = append(lines, fmt.Sprintf("%s[%d] #%s %s: %s (edited)", indent, message.Lamport, message.ID, message.Author, message.Text))
+		default:
+			lines = append(lines, fmt.Sprintf("%s[%d] #%s %s: %s", indent, message.Lamport, message.ID, message.Author, message.Text))
+		}
+		if len(message.Reactions) > 0 {
+			lines[len(lines)-1] += "  [" + describeReactions(message.Reactions) + "]"
+		}
+	}
+	return lines
+}
+
+// describeThreadSummary says what a thread started with and who replied.
+func describeThreadSummary(thread chatclient.ThreadSummary) string {
+	text := shorten(thread.Text, quoteLength)
+	if thread.Text == "" {
+		text = "(deleted)"
+	}
+	replies := "replies"
+	if thread.Replies == 1 {
+		replies = "reply"
+	}
+	return fmt.Sprintf("#%s %s: %s — %d %s from %s", thread.RootID, thread.Author, text, thread.Replies, replies, strings.Join(thread.Participants, ", "))
+}
+
+// react reacts to a message with an emoji or a shortcode like :tada:, or takes the reaction back.
+func (chatClient *chatClientStruct) react(id string, emoji string, remove bool) {
+	if id == "" || emoji == "" {
+		display("Usage: /react <message id> <emoji or :shortcode:>, or /unreact to take it back")
+		return
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	react := chatClient.client.React
+	if remove {
+		react = chatClient.client.Unreact
+	}
+	if err := react(ctx, id, emoji); err != nil {
+		display("Could not react to #%s: %s", id, describe(err))
+	}
+}
+
+// share shares a file in the room.
+func (chatClient *chatClientStruct) share(path string) {
+	if path == "" {
+		display("Usage: /share <file>")
+		return
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), transferTimeout)
+	defer cancel()
+	if _, err := chatClient.client.ShareFile(ctx, path); err != nil {
+		display("Could not share %s: %s", path, describe(err))
+	}
+}
+
+// get fetches a shared file to path, or into the current directory.
+func (chatClient *chatClientStruct) get(id string, path string) {
+	if id == "" {
+		display("Usage: /get <message id> [where to save it]")
+		return
+	}
+	saved, err := download(chatClient.client, id, path)
+	if err != nil {
+		display("Could not fetch #%s: %s", id, describe(err))
+		return
+	}
+	display("Saved #%s to %s", id, saved)
+}
+
+// sendDirect sends a private message to one user, which reaches them even if they are offline now.
+func (chatClient *chatClientStruct) sendDirect(to string, text string) {
+	if to == "" || text == "" {
+		display("Usage: /msg <name> <text>")
+		return
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	if _, err := chatClient.client.SendDirect(ctx, to, text); err != nil {
+		display("Could not send to %s: %s", to, describe(err))
+	}
+}
+
+// directLabel says who a direct message is from and who it is for, from the user's side.
+func directLabel(message *chatclient.Message, name string) string {
+	if message.Author == name {
+		return "you → " + message.To
+	}
+	return message.Author + " → you"
+}
+
+// describeReactions counts the reactions a message has, like "👍 2  🎉 1".
+func describeReactions(reactions []chatclient.Reaction) string {
+	if len(reactions) == 0 {
+		return "no reactions"
+	}
+	var counts []string
+	for _, reaction := range reactions {
+		counts = append(counts, fmt.Sprintf("%s %d", reaction.Emoji, len(reaction.Names)))
+	}
+	return strings.Join(counts, "  ")
+}
+
+// edit changes the text of a message. Everyone sees the change once the server accepts it.
+func (chatClient *chatClientStruct) edit(id string, text string) {
+	if id == "" || text == "" {
+		display("Usage: /edit <message id> <new text>")
+		return
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	if err := chatClient.client.Edit(ctx, id, text); err != nil {
+		display("Could not edit #%s: %s", id, describe(err))
+	}
+}
+
+// delete deletes a message. Everyone sees it go once the server accepts it.
+func (chatClient *chatClientStruct) delete(id string) {
+	if id == "" {
+		display("Usage: /delete <message id>")
+		return
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	if err := chatClient.client.Delete(ctx, id); err != nil {
+		display("Could not delete #%s: %s", id, describe(err))
+	}
+}
+
+// showHistory shows every version of a message.
+func (chatClient *chatClientStruct) showHistory(id string) {
+	if id == "" {
+		display("Usage: /history <message id>")
+		return
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	history, err := chatClient.client.EditHistory(ctx, id)
+	if err != nil {
+		display("Could not get the history of #%s: %s", id, describe(err))
+		return
+	}
+	for _, line := range describeHistory(history) {
+		display("%s", line)
+	}
+}
+
+// describeHistory is one line per version of a message.
+func describeHistory(history *chatclient.EditHistory) []string {
+	if history.Deleted {
+		return []string{fmt.Sprintf("#%s by %s was deleted by %s", history.MessageID, history.Author, history.DeletedBy)}
+	}
+	var lines []string
+	for i, version := range history.Versions {
+		action := "written"
+		if i > 0 {
+			action = "edited"
 		}
-		if userStreamServerMessage == nil {
-			log.Fatalf("serverMessage returned nil")
+		lines = append(lines, fmt.Sprintf("#%s [%d] %s by %s at %s: %s", history.MessageID, version.Lamport, action, version.Editor, version.At.Local().Format(time.TimeOnly), version.Text))
+	}
+	return lines
+}
+
+// toggleAway tells the room the user is away, with an optional message, or back if they were away
+// and gave no new message.
+func (chatClient *chatClientStruct) toggleAway(status string) {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	presence := chitchat.Presence_AWAY
+	if chatClient.away && status == "" {
+		presence = chitchat.Presence_ONLINE
+	}
+	if err := chatClient.client.SetPresence(ctx, presence, status); err != nil {
+		display("Could not change your presence: %s", describe(err))
+		return
+	}
+	chatClient.away = presence == chitchat.Presence_AWAY
+}
+
+// describeParticipant is a participant's name, with how they are doing unless they are simply online.
+func describeParticipant(participant chatclient.Participant) string {
+	switch participant.Presence {
+	case chitchat.Presence_AWAY:
+		if participant.Status != "" {
+			return fmt.Sprintf("%s (away: %s)", participant.Name, participant.Status)
 		}
+		return participant.Name + " (away)"
+	case chitchat.Presence_IDLE:
+		return fmt.Sprintf("%s (idle %dm)", participant.Name, int(time.Since(participant.LastActive).Minutes()))
+	case chitchat.Presence_OFFLINE:
+		return participant.Name + " (offline)"
+	}
+	return participant.Name
+}
 
-		//Find lamport timestamp of incoming message, select the highest and increment.
-		incomingLamport := userStreamServerMessage.Lamport
-		lamport = max(lamport, incomingLamport)
-		lamport++
+// leave tells the server we are leaving, giving it a few seconds to answer.
+func (chatClient *chatClientStruct) leave() {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	if err := chatClient.client.Leave(ctx); err != nil {
+		slog.Warn("could not leave cleanly", "error", err)
+	}
+}
 
-		//Displaying the recieved chat message with lamport time stamp:
-		log.Printf(" - [%d] %s: %s", lamport, userStreamServerMessage.Name, userStreamServerMessage.Text)
+// ReceiveMessage shows everything that happens in the chat until it ends.
+// It exits if the client gave up on the connection, and returns if we left.
+func (chatClient *chatClientStruct) ReceiveMessage() {
+	for event := range chatClient.client.Events() {
+		switch event.Kind {
+		case chatclient.MessageEvent, chatclient.JoinEvent, chatclient.LeaveEvent, chatclient.NoticeEvent,
+			chatclient.PresenceEvent, chatclient.ParticipantsEvent:
+			//Displaying the recieved chat message with lamport time stamp, marking it if we cannot verify who wrote it:
+			//chat messages show the id the server gave them, so they can be referred to.
+			author := event.Message.Author
+			if event.Message.ID != "" {
+				author = "#" + event.Message.ID + " " + author
+			}
+			//replies remind of what they answer.
+			if quote := quoteOf(event.Message); quote != "" {
+				author += " (" + quote + ")"
+			}
+			//and messages that disappear say when.
+			if expiry := expiryOf(event.Message); expiry != "" {
+				author += " (" + expiry + ")"
+			}
+			//messages that mention us stand out with a star.
+			marker := "-"
+			if event.Message.Mentioned {
+				marker = "*"
+			}
+			text := event.Message.Text
+			if event.Message.Attachment != nil {
+				text = fmt.Sprintf("shared %s, /get %s to fetch it", describeAttachment(event.Message.Attachment), event.Message.ID)
+			}
+			if event.Message.Verified {
+				display(" %s [%d] %s: %s", marker, event.Lamport, author, text)
+			} else {
+				display(" %s [%d] [UNVERIFIED] %s: %s", marker, event.Lamport, author, text)
+			}
+			//showing a message is as close to reading it as the line mode gets.
+			if event.Message.ID != "" && event.Message.Author != chatClient.name {
+				chatClient.client.MarkRead(event.Message.ID)
+			}
+		case chatclient.EditEvent, chatclient.DeleteEvent:
+			//the line mode cannot change what it printed, so it says what changed.
+			if event.Kind == chatclient.EditEvent {
+				display(" - [%d] #%s edited by %s: %s", event.Lamport, event.Target, event.Participant, event.Message.Text)
+			} else {
+				display(" - [%d] #%s: %s", event.Lamport, event.Target, event.Message.Text)
+			}
+		case chatclient.ReactionEvent:
+			display(" - [%d] #%s %s: %s", event.Lamport, event.Target, event.Message.Text, describeReactions(event.Reactions))
+		case chatclient.DirectEvent:
+			//private messages stand out with a >.
+			label := directLabel(event.Message, chatClient.name)
+			if !event.Message.Verified {
+				label = "[UNVERIFIED] " + label
+			}
+			display(" > [%d] #%s (private) %s: %s", event.Lamport, event.Message.ID, label, event.Message.Text)
+		case chatclient.MentionEvent:
+			bell()
+			//mentions here are starred as they arrive, mentions elsewhere only come as this.
+			if event.Message.Room != chatClient.client.Room() {
+				display(" * %s", event.Message.Text)
+			}
+		case chatclient.TypingEvent:
+			//the line mode cannot take a line back, so it only says when someone starts.
+			if event.Typing {
+				display(" - %s is typing…", event.Participant)
+			}
+		case chatclient.ReconnectingEvent:
+			display("Connection lost: %s. Reconnecting in %v (attempt %d)", describe(event.Err), event.Delay, event.Attempt)
+		case chatclient.ReconnectedEvent:
+			display("Reconnected to the chat")
+		}
+	}
+	if err := chatClient.client.Err(); err != nil {
+		slog.Info("gave up on the connection", "error", err)
+		display("Disconnected: %s", describe(err))
+		exit(1)
 	}
 }
 
-func (chatClient *chatClientStruct) CreateUser(client chitchat.ChatServiceClient) *chitchat.User {
-	//Generate a random id:
-	randSrc := rand.NewSource(time.Now().UnixNano())
-	randGen := rand.New(randSrc)
-	id := int32(randGen.Intn(999999)) // Generate a random integer and cast to int32
-	chatClient.id = id
+// describe explains why the server ended our stream.
+func describe(err error) string {
+	if err == io.EOF {
+		return "the server closed the connection"
+	}
+	return status.Convert(err).Message()
+}
 
+// CreateUser asks for the username and loads the key registered to it,
+// or creates one if this is a new user.
+func (chatClient *chatClientStruct) CreateUser() {
+	//The username can come from the settings, so scripts never have to answer the prompt.
+	chatClient.name = clientSettings.Name
 	//Ask client for username:
-	for {
+	for chatClient.name == "" {
 		fmt.Println("Please enter your username and press 'enter'!")
 		username, err := readUserInput()
 		if err != nil {
-			log.Fatalf("Failed to read username: %v", err)
+			fatal("Failed to read username", err)
 			continue //prompt the user to enter username againc if username not accepted
 		}
 		chatClient.name = username
@@ -158,17 +694,29 @@ func (chatClient *chatClientStruct) CreateUser(client chitchat.ChatServiceClient
 		break
 	}
 
-	//Generate new user:
-	var user = &chitchat.User{
-		Id:      chatClient.id,
-		Name:    chatClient.name,
-		Lamport: lamport,
+	key, err := chatclient.LoadOrCreateKey(clientSettings.KeyDir, chatClient.name)
+	if err != nil {
+		fatal("Failed to load signing key", err)
+	}
+	chatClient.key = key
+}
+
+// transportCredentials returns TLS credentials if TLS is enabled, and insecure ones otherwise.
+func transportCredentials(tlsSettings *TLSSettings) (credentials.TransportCredentials, error) {
+	if !tlsSettings.Enabled {
+		return insecure.NewCredentials(), nil
+	}
+	if tlsSettings.CAFile != "" {
+		return credentials.NewClientTLSFromFile(tlsSettings.CAFile, tlsSettings.ServerName)
 	}
-	return user
+	return credentials.NewTLS(&tls.Config{ServerName: tlsSettings.ServerName}), nil
 }
+
+// stdin is shared by every read, so lines it has buffered are not lost between them
+var stdin = bufio.NewReader(os.Stdin)
+
 func readUserInput() (string, error) {
-	reader := bufio.NewReader(os.Stdin)
-	userInput, err := reader.ReadString('\n')
+	userInput, err := stdin.ReadString('\n')
 	//Trim message spaces from beginning and end.
 	userInput = strings.TrimSpace(userInput)
 	return userInput, err
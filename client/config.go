@@ -0,0 +1,110 @@
+package main
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"time"
+
+	"homework3/chitchat"
+	"homework3/config"
+	"homework3/tracing"
+)
+
+// Settings holds everything about the client that can be configured.
+type Settings struct {
+	Address   string            `yaml:"address" usage:"host:port of the Chitty-Chat server"`
+	Name      string            `yaml:"name" usage:"username to chat as, instead of being asked for one"`
+	Room      string            `yaml:"room" usage:"room to join"`
+	KeyDir    string            `yaml:"key_dir" usage:"directory signing keys are kept in"`
+	UI        string            `yaml:"ui" usage:"tui for the full-screen interface, plain for one line per message, auto for tui on a terminal"`
+	LogLevel  string            `yaml:"log_level" usage:"least severe diagnostics to log: debug, info, warn or error"`
+	LogFormat string            `yaml:"log_format" usage:"how diagnostic log lines are written: text or json"`
+	LogFile   string            `yaml:"log_file" usage:"file to write diagnostics to instead of stderr"`
+	TLS       TLSSettings       `yaml:"tls"`
+	Keepalive KeepaliveSettings `yaml:"keepalive"`
+	Tracing   tracing.Settings  `yaml:"tracing"`
+}
+
+type TLSSettings struct {
+	Enabled    bool   `yaml:"enabled" usage:"connect to the server over TLS"`
+	CAFile     string `yaml:"ca_file" usage:"PEM certificate authority to trust instead of the system roots"`
+	ServerName string `yaml:"server_name" usage:"name to expect on the server's certificate, if not the host in address"`
+}
+
+type KeepaliveSettings struct {
+	Time    time.Duration `yaml:"time" usage:"idle time before the client pings the server, 0 to never ping"`
+	Timeout time.Duration `yaml:"timeout" usage:"time to wait for a ping reply before giving up on the connection"`
+}
+
+var defaultSettings = Settings{
+	Address:   "localhost:5678",
+	Room:      chitchat.DefaultRoom,
+	UI:        "auto",
+	LogLevel:  "warn",
+	LogFormat: "text",
+	Keepalive: KeepaliveSettings{
+		Timeout: 20 * time.Second,
+	},
+	Tracing: tracing.DefaultSettings,
+}
+
+func (settings *Settings) Validate() error {
+	var problems []error
+	if settings.Address == "" {
+		problems = append(problems, errors.New("address: must not be empty"))
+	}
+	if settings.Room == "" {
+		problems = append(problems, errors.New("room: must not be empty"))
+	}
+	if settings.UI != "auto" && settings.UI != "tui" && settings.UI != "plain" {
+		problems = append(problems, errors.New("ui: must be auto, tui or plain"))
+	}
+	var level slog.Level
+	if err := level.UnmarshalText([]byte(settings.LogLevel)); err != nil {
+		problems = append(problems, fmt.Errorf("log_level: %w", err))
+	}
+	if settings.LogFormat != "text" && settings.LogFormat != "json" {
+		problems = append(problems, errors.New("log_format: must be text or json"))
+	}
+	if !settings.TLS.Enabled && (settings.TLS.CAFile != "" || settings.TLS.ServerName != "") {
+		problems = append(problems, errors.New("tls: ca_file and server_name need tls.enabled"))
+	}
+	if settings.Keepalive.Time < 0 || settings.Keepalive.Timeout <= 0 {
+		problems = append(problems, errors.New("keepalive: time must not be negative and timeout must be positive"))
+	}
+	if err := settings.Tracing.Validate(); err != nil {
+		problems = append(problems, err)
+	}
+	return errors.Join(problems...)
+}
+
+// loadSettings loads the client settings from the command line, environment and config file,
+// exiting with a usage error if they are invalid. command names the program in usage messages.
+// When withArgs is set the command line may end in arguments, which are returned with the settings.
+func loadSettings(command string, args []string, withArgs bool) (*Settings, []string) {
+	loader := config.NewLoader(command, args, defaultSettings)
+	if withArgs {
+		loader.WithArgs()
+	}
+	loaded, err := loader.Load()
+	if errors.Is(err, flag.ErrHelp) {
+		os.Exit(0)
+	}
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(2)
+	}
+	if loaded.KeyDir == "" {
+		configDir, err := os.UserConfigDir()
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "key_dir is not set and there is no user config directory: %v\n", err)
+			os.Exit(2)
+		}
+		loaded.KeyDir = filepath.Join(configDir, "chitchat", "keys")
+	}
+	return loaded, loader.Args()
+}
@@ -0,0 +1,49 @@
+package main
+
+import (
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestClientSettings(t *testing.T) {
+	t.Setenv("CHITCHAT_ADDRESS", "chat.example.com:5678")
+	loaded, args := loadSettings("send", []string{"-name", "ci", "-key-dir", t.TempDir(), "build", "done"}, true)
+	if loaded.Address != "chat.example.com:5678" || loaded.Name != "ci" || strings.Join(args, " ") != "build done" {
+		t.Errorf("loaded address %q, name %q and arguments %q", loaded.Address, loaded.Name, args)
+	}
+
+	home := t.TempDir()
+	t.Setenv("HOME", home)
+	t.Setenv("XDG_CONFIG_HOME", filepath.Join(home, ".config"))
+	loaded, _ = loadSettings("client", nil, false)
+	if want := filepath.Join(home, ".config", "chitchat", "keys"); loaded.KeyDir != want {
+		t.Errorf("keys are kept in %q by default, want %q", loaded.KeyDir, want)
+	}
+}
+
+func TestClientValidate(t *testing.T) {
+	tests := []struct {
+		name   string
+		change func(*Settings)
+		want   string
+	}{
+		{"no address", func(s *Settings) { s.Address = "" }, "address:"},
+		{"no room", func(s *Settings) { s.Room = "" }, "room:"},
+		{"interface", func(s *Settings) { s.UI = "gui" }, "ui:"},
+		{"log level", func(s *Settings) { s.LogLevel = "loud" }, "log_level:"},
+		{"TLS settings without TLS", func(s *Settings) { s.TLS.CAFile = "ca.pem" }, "tls:"},
+		{"keepalive", func(s *Settings) { s.Keepalive.Timeout = 0 }, "keepalive:"},
+	}
+	defaults := defaultSettings
+	if err := defaults.Validate(); err != nil {
+		t.Fatalf("the default settings are invalid: %v", err)
+	}
+	for _, test := range tests {
+		settings := defaultSettings
+		test.change(&settings)
+		if err := settings.Validate(); err == nil || !strings.Contains(err.Error(), test.want) {
+			t.Errorf("%s: got %v, want an error about %s", test.name, err, test.want)
+		}
+	}
+}
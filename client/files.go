@@ -0,0 +1,64 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"time"
+
+	"homework3/chatclient"
+)
+
+// how long sharing or fetching a file may take
+const transferTimeout = 5 * time.Minute
+
+// download fetches a shared file to path, or into the current directory under the name it was
+// shared with if path is empty, and returns where it went. It never overwrites a file.
+func download(client *chatclient.Client, id string, path string) (string, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), transferTimeout)
+	defer cancel()
+	//the file is fetched next to where it goes, and only put there once it checks out.
+	temporary, err := os.CreateTemp(filepath.Dir(path), ".chitchat-download-*")
+	if err != nil {
+		return "", err
+	}
+	defer os.Remove(temporary.Name())
+	defer temporary.Close()
+	attachment, err := client.Download(ctx, id, temporary)
+	if err != nil {
+		return "", err
+	}
+	if err := temporary.Close(); err != nil {
+		return "", err
+	}
+	if path == "" {
+		path = filepath.Base(attachment.FileName)
+	}
+	if _, err := os.Stat(path); err == nil {
+		return "", fmt.Errorf("%s already exists", path)
+	} else if !errors.Is(err, os.ErrNotExist) {
+		return "", err
+	}
+	if err := os.Rename(temporary.Name(), path); err != nil {
+		return "", err
+	}
+	return path, nil
+}
+
+// describeAttachment says what a shared file is, like "notes.txt (text/plain, 1.2 KB)".
+func describeAttachment(attachment *chatclient.Attachment) string {
+	return fmt.Sprintf("%s (%s, %s)", attachment.FileName, attachment.ContentType, describeSize(attachment.Size))
+}
+
+// describeSize writes a number of bytes the way people read them.
+func describeSize(size int64) string {
+	switch {
+	case size >= 1<<20:
+		return fmt.Sprintf("%.1f MB", float64(size)/(1<<20))
+	case size >= 1<<10:
+		return fmt.Sprintf("%.1f KB", float64(size)/(1<<10))
+	}
+	return fmt.Sprintf("%d bytes", size)
+}
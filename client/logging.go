@@ -0,0 +1,85 @@
+package main
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"log/slog"
+	"os"
+	"time"
+
+	"golang.org/x/term"
+)
+
+// identifies this run of the client in every call it makes, so its log lines
+// can be matched up with the server's
+var sessionID = newID()
+
+// true when diagnostic logs already end up on the terminal, so fatal errors are not shown twice
+var logsToStderr bool
+
+// setupLogging sends diagnostic logs to the configured file, or to stderr.
+// Chat itself is shown on stdout by display and never goes through the logger.
+// The full-screen interface owns the whole terminal, so without a log file its logs are dropped.
+func setupLogging(settings *Settings, fullScreen bool) error {
+	var output io.Writer = os.Stderr
+	logsToStderr = settings.LogFile == "" && !fullScreen
+	if settings.LogFile == "" && fullScreen {
+		output = io.Discard
+	} else if !logsToStderr {
+		file, err := os.OpenFile(settings.LogFile, os.O_WRONLY|os.O_APPEND|os.O_CREATE, 0600)
+		if err != nil {
+			return err
+		}
+		output = file
+	}
+
+	var level slog.Level
+	level.UnmarshalText([]byte(settings.LogLevel))
+	options := &slog.HandlerOptions{Level: level}
+	var handler slog.Handler = slog.NewTextHandler(output, options)
+	if settings.LogFormat == "json" {
+		handler = slog.NewJSONHandler(output, options)
+	}
+	slog.SetDefault(slog.New(handler).With("session_id", sessionID))
+	return nil
+}
+
+// display shows a line of chat, or a note about the chat, to the user.
+func display(format string, args ...any) {
+	fmt.Printf(format+"\n", args...)
+}
+
+// bell rings the terminal bell, if there is a terminal to ring.
+func bell() {
+	if term.IsTerminal(int(os.Stdout.Fd())) {
+		fmt.Print("\a")
+	}
+}
+
+// fatal logs why the client cannot go on, tells the user and exits.
+func fatal(msg string, err error) {
+	slog.Error(msg, "error", err)
+	if !logsToStderr {
+		fmt.Fprintf(os.Stderr, "Ouch. %s: %v\n", msg, err)
+	}
+	exit(1)
+}
+
+// exit flushes buffered traces before the process ends.
+func exit(code int) {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	if err := flushTraces(ctx); err != nil {
+		slog.Error("failed to flush traces", "error", err)
+	}
+	os.Exit(code)
+}
+
+func newID() string {
+	id := make([]byte, 8)
+	rand.Read(id)
+	return hex.EncodeToString(id)
+}
@@ -0,0 +1,288 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"os"
+	"os/signal"
+	"strings"
+	"syscall"
+	"time"
+
+	"homework3/chatclient"
+	"homework3/config"
+)
+
+// exit statuses of the send and tail commands, so scripts can tell what went wrong
+const (
+	//every message was sent, or tail was stopped
+	exitOK = 0
+	//the server refused a message, or the connection was lost
+	exitFailed = 1
+	//the command line or the settings are wrong
+	exitUsage = 2
+	//the server could not be reached, or did not let us in
+	exitUnavailable = 3
+)
+
+// joinForScript loads the settings for a send or tail command and joins the configured room.
+// The username must be configured, since scripts cannot answer the prompt.
+func joinForScript(command string, args []string, withArgs bool) (*chatclient.Client, []string, int) {
+	var rest []string
+	clientSettings, rest = loadSettings("client "+command, args, withArgs)
+	if clientSettings.Name == "" {
+		fmt.Fprintf(os.Stderr, "client %s: set the username with -name or %sNAME\n", command, config.EnvPrefix)
+		return nil, nil, exitUsage
+	}
+	dialOptions := setup(false)
+	key, err := chatclient.LoadOrCreateKey(clientSettings.KeyDir, clientSettings.Name)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "client %s: could not load the signing key: %v\n", command, err)
+		return nil, nil, exitUsage
+	}
+	client, err := connect(clientSettings.Name, key, clientSettings.Room, dialOptions)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "client %s: could not join %s: %s\n", command, clientSettings.Room, describe(err))
+		return nil, nil, exitUnavailable
+	}
+	return client, rest, exitOK
+}
+
+// runSendCommand handles 'client send [flags] [message ...]'. The arguments are sent as one
+// message, or each line of stdin is sent as a message of its own when there are none.
+func runSendCommand(args []string) int {
+	client, words, code := joinForScript("send", args, true)
+	if client == nil {
+		return code
+	}
+	//nobody reads what the room says meanwhile
+	go func() {
+		for range client.Events() {
+		}
+	}()
+
+	send := func(text string) {
+		if _, err := client.Send(context.Background(), text); err != nil {
+			fmt.Fprintf(os.Stderr, "client send: could not send %q: %s\n", text, describe(err))
+			code = exitFailed
+		}
+	}
+	if len(words) > 0 {
+		send(strings.Join(words, " "))
+	} else {
+		lines := bufio.NewScanner(os.Stdin)
+		for lines.Scan() {
+			if line := strings.TrimSpace(lines.Text()); line != "" {
+				send(line)
+			}
+		}
+		if err := lines.Err(); err != nil {
+			fmt.Fprintf(os.Stderr, "client send: could not read stdin: %v\n", err)
+			code = exitFailed
+		}
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	if err := client.Leave(ctx); err != nil {
+		slog.Warn("could not leave cleanly", "error", err)
+	}
+	return code
+}
+
+// tailLine is one event as printed by 'client tail'.
+type tailLine struct {
+	Kind string    `json:"kind"`
+	Time time.Time `json:"time"`
+	//the client's Lamport time once it had the event, as shown in the chat
+	Lamport int32  `json:"lamport"`
+	Room    string `json:"room,omitempty"`
+	//the Lamport time the server gave the message
+	ServerLamport int32  `json:"server_lamport,omitempty"`
+	ID            string `json:"id,omitempty"`
+	//who a direct message is for
+	To       string `json:"to,omitempty"`
+	Author   string `json:"author,omitempty"`
+	Text     string `json:"text,omitempty"`
+	Verified *bool  `json:"verified,omitempty"`
+	//the message this one replies to, and what it said
+	ReplyTo string     `json:"reply_to,omitempty"`
+	Quote   *tailQuote `json:"quote,omitempty"`
+	//when the message disappears, for messages that do not last
+	ExpiresAt *time.Time `json:"expires_at,omitempty"`
+	//the file the message shares
+	Attachment *tailAttachment `json:"attachment,omitempty"`
+	//who the message mentions, and whether that includes us
+	Mentions  []string `json:"mentions,omitempty"`
+	Mentioned bool     `json:"mentioned,omitempty"`
+	//true for messages from before we joined
+	Replayed    bool   `json:"replayed,omitempty"`
+	Participant string `json:"participant,omitempty"`
+	//the message an edit, delete, reaction or mention event is about
+	Target   string `json:"target,omitempty"`
+	Presence string `json:"presence,omitempty"`
+	Status   string `json:"status,omitempty"`
+	Typing   *bool  `json:"typing,omitempty"`
+	//the emoji of a reaction event, whether it was taken back, and every reaction the message has now
+	Emoji     string         `json:"emoji,omitempty"`
+	Removed   bool           `json:"removed,omitempty"`
+	Reactions []tailReaction `json:"reactions,omitempty"`
+	//everyone in the room, for participants events
+	Participants []tailParticipant `json:"participants,omitempty"`
+	//who has received and read one of our messages, for receipt events
+	Receipts *tailReceipts `json:"receipts,omitempty"`
+	Error    string        `json:"error,omitempty"`
+	Attempt  int           `json:"attempt,omitempty"`
+	Delay    string        `json:"delay,omitempty"`
+}
+
+type tailParticipant struct {
+	Name       string    `json:"name"`
+	Presence   string    `json:"presence"`
+	Status     string    `json:"status,omitempty"`
+	LastActive time.Time `json:"last_active"`
+}
+
+type tailReaction struct {
+	Emoji string   `json:"emoji"`
+	Count int      `json:"count"`
+	Names []string `json:"names"`
+}
+
+type tailAttachment struct {
+	ID          string `json:"id"`
+	FileName    string `json:"file_name"`
+	ContentType string `json:"content_type"`
+	Size        int64  `json:"size"`
+	SHA256      string `json:"sha256"`
+}
+
+type tailQuote struct {
+	Author string `json:"author"`
+	Text   string `json:"text"`
+}
+
+type tailReceipts struct {
+	MessageID  string   `json:"message_id"`
+	Recipients int      `json:"recipients"`
+	Delivered  []string `json:"delivered"`
+	Read       []string `json:"read"`
+}
+
+// runTailCommand handles 'client tail [flags]': it joins the room and prints everything that
+// happens in it as JSON lines on stdout, until interrupted or until stdout is closed.
+func runTailCommand(args []string) int {
+	client, _, code := joinForScript("tail", args, false)
+	if client == nil {
+		return code
+	}
+	leave := func() {
+		//Leave waits for the events still on their way, which nobody else may be reading
+		go func() {
+			for range client.Events() {
+			}
+		}()
+		ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+		defer cancel()
+		if err := client.Leave(ctx); err != nil {
+			slog.Warn("could not leave cleanly", "error", err)
+		}
+	}
+
+	//asking for SIGPIPE makes writes to a closed pipe fail instead of killing us,
+	//so 'client tail | head' still leaves the room.
+	signals := make(chan os.Signal, 1)
+	signal.Notify(signals, os.Interrupt, syscall.SIGTERM, syscall.SIGPIPE)
+	go func() {
+		for received := range signals {
+			if received != syscall.SIGPIPE {
+				leave()
+				exit(exitOK)
+			}
+		}
+	}()
+
+	encoder := json.NewEncoder(os.Stdout)
+	for event := range client.Events() {
+		line := tailLine{
+			Kind:        event.Kind.String(),
+			Time:        time.Now(),
+			Lamport:     event.Lamport,
+			Participant: event.Participant,
+			Target:      event.Target,
+			Emoji:       event.Emoji,
+			Removed:     event.Removed,
+			Attempt:     event.Attempt,
+		}
+		if event.Message != nil {
+			verified := event.Message.Verified
+			line.Room = event.Message.Room
+			line.ServerLamport = event.Message.Lamport
+			line.ID = event.Message.ID
+			line.To = event.Message.To
+			line.Author = event.Message.Author
+			line.Text = event.Message.Text
+			line.Verified = &verified
+			line.Replayed = event.Message.Replayed
+			line.ReplyTo = event.Message.ReplyTo
+			if quote := event.Message.Quote; quote != nil {
+				line.Quote = &tailQuote{Author: quote.Author, Text: quote.Text}
+			}
+			if expiresAt := event.Message.ExpiresAt; !expiresAt.IsZero() {
+				line.ExpiresAt = &expiresAt
+			}
+			if attachment := event.Message.Attachment; attachment != nil {
+				line.Attachment = &tailAttachment{ID: attachment.ID, FileName: attachment.FileName, ContentType: attachment.ContentType, Size: attachment.Size, SHA256: attachment.SHA256}
+			}
+			line.Mentions = event.Message.Mentions
+			line.Mentioned = event.Message.Mentioned
+		}
+		if event.Kind == chatclient.TypingEvent {
+			typing := event.Typing
+			line.Typing = &typing
+		}
+		if event.Kind == chatclient.PresenceEvent {
+			line.Presence = strings.ToLower(event.Presence.String())
+			line.Status = event.Status
+		}
+		for _, participant := range event.Participants {
+			line.Participants = append(line.Participants, tailParticipant{
+				Name:       participant.Name,
+				Presence:   strings.ToLower(participant.Presence.String()),
+				Status:     participant.Status,
+				LastActive: participant.LastActive,
+			})
+		}
+		for _, reaction := range event.Reactions {
+			line.Reactions = append(line.Reactions, tailReaction{Emoji: reaction.Emoji, Count: len(reaction.Names), Names: reaction.Names})
+		}
+		if event.Receipts != nil {
+			line.Receipts = &tailReceipts{MessageID: event.Receipts.MessageID, Recipients: event.Receipts.Recipients, Delivered: []string{}, Read: []string{}}
+			for _, receipt := range event.Receipts.Delivered {
+				line.Receipts.Delivered = append(line.Receipts.Delivered, receipt.Name)
+			}
+			for _, receipt := range event.Receipts.Read {
+				line.Receipts.Read = append(line.Receipts.Read, receipt.Name)
+			}
+		}
+		if event.Err != nil {
+			line.Error = describe(event.Err)
+		}
+		if event.Delay > 0 {
+			line.Delay = event.Delay.String()
+		}
+		if err := encoder.Encode(line); err != nil {
+			//whoever read our output has gone away
+			slog.Info("stopped writing events", "error", err)
+			leave()
+			return exitOK
+		}
+	}
+	if client.Err() != nil {
+		return exitFailed
+	}
+	return exitOK
+}
@@ -0,0 +1,214 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"crypto/ed25519"
+	"encoding/json"
+	"io"
+	"log/slog"
+	"net"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"homework3/chatclient"
+	"homework3/chatserver"
+)
+
+var quiet = slog.New(slog.NewTextHandler(io.Discard, nil))
+
+// startServer starts a server for the test and returns the address it listens on.
+func startServer(t *testing.T) string {
+	t.Helper()
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	s, err := chatserver.New(chatserver.WithListeners(listener), chatserver.WithLogger(quiet))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := s.Start(); err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { s.Stop(context.Background()) })
+	return listener.Addr().String()
+}
+
+// watch joins room on the server as bob, to see what the commands do.
+func watch(t *testing.T, address string, room string) *chatclient.Client {
+	t.Helper()
+	_, key, err := ed25519.GenerateKey(nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	bob, err := chatclient.Connect(ctx, address, "bob", key, chatclient.WithRoom(room), chatclient.WithLogger(quiet))
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { bob.Leave(context.Background()) })
+	return bob
+}
+
+// next returns the next event of the given kind bob gets, failing the test if none comes.
+func next(t *testing.T, bob *chatclient.Client, kind chatclient.EventKind) chatclient.Event {
+	t.Helper()
+	timeout := time.After(5 * time.Second)
+	for {
+		select {
+		case event := <-bob.Events():
+			if event.Kind == kind {
+				return event
+			}
+		case <-timeout:
+			t.Fatalf("bob got no %s event", kind)
+		}
+	}
+}
+
+// scriptArgs returns the flags a script would run the commands with, followed by extra.
+// Its key and log are kept in dir.
+func scriptArgs(dir string, address string, extra ...string) []string {
+	return append([]string{
+		"-address", address,
+		"-name", "ci",
+		"-room", "builds",
+		"-key-dir", filepath.Join(dir, "keys"),
+		"-log-file", filepath.Join(dir, "client.log"),
+	}, extra...)
+}
+
+// withStdin runs f with stdin reading input.
+func withStdin(t *testing.T, input string, f func()) {
+	t.Helper()
+	file := filepath.Join(t.TempDir(), "stdin")
+	if err := os.WriteFile(file, []byte(input), 0600); err != nil {
+		t.Fatal(err)
+	}
+	stdin, err := os.Open(file)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer stdin.Close()
+	saved := os.Stdin
+	os.Stdin = stdin
+	defer func() { os.Stdin = saved }()
+	f()
+}
+
+func TestSendCommand(t *testing.T) {
+	address := startServer(t)
+	dir := t.TempDir()
+	bob := watch(t, address, "builds")
+
+	if code := runSendCommand(scriptArgs(dir, address, "build", "42", "passed")); code != exitOK {
+		t.Fatalf("send exited with %d", code)
+	}
+	if message := next(t, bob, chatclient.MessageEvent).Message; message.Author != "ci" || message.Text != "build 42 passed" {
+		t.Errorf("bob got %q from %s, want the arguments as one message from ci", message.Text, message.Author)
+	}
+
+	//without arguments, every line that is not blank is a message.
+	var code int
+	withStdin(t, "first\n\n  second  \n", func() { code = runSendCommand(scriptArgs(dir, address)) })
+	if code != exitOK {
+		t.Fatalf("send exited with %d reading stdin", code)
+	}
+	for _, want := range []string{"first", "second"} {
+		if message := next(t, bob, chatclient.MessageEvent).Message; message.Text != want {
+			t.Errorf("bob got %q, want %q", message.Text, want)
+		}
+	}
+}
+
+func TestSendCommandFailures(t *testing.T) {
+	address := startServer(t)
+	dir := t.TempDir()
+	closed, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	nowhere := closed.Addr().String()
+	closed.Close()
+
+	tests := []struct {
+		name string
+		args []string
+		want int
+	}{
+		{"no name", append(scriptArgs(dir, address), "-name=", "hello"), exitUsage},
+		{"no server", scriptArgs(dir, nowhere, "hello"), exitUnavailable},
+		{"refused message", scriptArgs(dir, address, strings.Repeat("x", chatserver.DefaultLimits.MaxMessageLength+1)), exitFailed},
+	}
+	for _, test := range tests {
+		if code := runSendCommand(test.args); code != test.want {
+			t.Errorf("%s: send exited with %d, want %d", test.name, code, test.want)
+		}
+	}
+}
+
+func TestTailCommand(t *testing.T) {
+	address := startServer(t)
+	dir := t.TempDir()
+	bob := watch(t, address, "builds")
+
+	output, stdout, err := os.Pipe()
+	if err != nil {
+		t.Fatal(err)
+	}
+	saved := os.Stdout
+	os.Stdout = stdout
+	defer func() { os.Stdout = saved }()
+	done := make(chan int)
+	go func() { done <- runTailCommand(scriptArgs(dir, address)) }()
+
+	lines := bufio.NewScanner(output)
+	read := func(kind string) tailLine {
+		t.Helper()
+		for lines.Scan() {
+			var line tailLine
+			if err := json.Unmarshal(lines.Bytes(), &line); err != nil {
+				t.Fatalf("tail printed %q: %v", lines.Text(), err)
+			}
+			if line.Kind == kind {
+				return line
+			}
+		}
+		t.Fatalf("tail stopped before printing a %s line: %v", kind, lines.Err())
+		return tailLine{}
+	}
+	if line := read(chatclient.ParticipantsEvent.String()); len(line.Participants) != 2 {
+		t.Errorf("tail printed participants %+v, want bob and ci", line.Participants)
+	}
+	next(t, bob, chatclient.JoinEvent)
+	id, err := bob.Send(context.Background(), "deploying")
+	if err != nil {
+		t.Fatal(err)
+	}
+	line := read(chatclient.MessageEvent.String())
+	if line.ID != id || line.Author != "bob" || line.Text != "deploying" || line.Room != "builds" || line.Verified == nil || !*line.Verified {
+		t.Errorf("tail printed %+v for bob's message", line)
+	}
+
+	//'client tail | head' stops once head has what it wants, and leaves the room.
+	output.Close()
+	if _, err := bob.Send(context.Background(), "deployed"); err != nil {
+		t.Fatal(err)
+	}
+	select {
+	case code := <-done:
+		if code != exitOK {
+			t.Errorf("tail exited with %d once its output was closed", code)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("tail went on after its output was closed")
+	}
+	if left := next(t, bob, chatclient.LeaveEvent); left.Participant != "ci" {
+		t.Errorf("%s left, want ci", left.Participant)
+	}
+}
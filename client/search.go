@@ -0,0 +1,161 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	"homework3/chatclient"
+)
+
+// how many results /search and /more show at a time
+const searchPage = 10
+
+const searchUsage = `Usage: /search [from:<name>] [in:<room>|in:*] [after:<lamport>] [before:<lamport>] [since:<when>] [until:<when>] words "a phrase"`
+
+// parseSearch reads the arguments of /search: filters like from:alice and the words and
+// phrases to look for. Without in:, only room is searched.
+func parseSearch(arguments string, room string) (chatclient.SearchQuery, error) {
+	query := chatclient.SearchQuery{Room: room, Limit: searchPage}
+	var text []string
+	quoted := false
+	for _, field := range strings.Fields(arguments) {
+		key, value, ok := strings.Cut(field, ":")
+		//filters only count outside quotes, and everything else is something to look for.
+		filter := !quoted && ok && value != ""
+		if filter {
+			var err error
+			switch key {
+			case "from":
+				query.Author = value
+			case "in":
+				query.Room = strings.TrimPrefix(value, "#")
+				if value == "*" {
+					query.Room = ""
+				}
+			case "after", "before":
+				var lamport int64
+				if lamport, err = strconv.ParseInt(value, 10, 32); err != nil || lamport <= 0 {
+					return query, fmt.Errorf("%s: wants a Lamport time, like %s:120", field, key)
+				}
+				if key == "after" {
+					query.FromLamport = int32(lamport)
+				} else {
+					query.ToLamport = int32(lamport)
+				}
+			case "since":
+				query.From, err = parseWhen(value, false)
+			case "until":
+				query.To, err = parseWhen(value, true)
+			default:
+				filter = false
+			}
+			if err != nil {
+				return query, fmt.Errorf("%s: %w", field, err)
+			}
+		}
+		if !filter {
+			text = append(text, field)
+		}
+		if strings.Count(field, `"`)%2 == 1 {
+			quoted = !quoted
+		}
+	}
+	query.Text = strings.Join(text, " ")
+	return query, nil
+}
+
+// parseWhen reads a time as a duration ago, like 2h or 30m, or as a date or date and time.
+// A date on its own until a time means the end of that day.
+func parseWhen(value string, end bool) (time.Time, error) {
+	if ago, err := time.ParseDuration(value); err == nil {
+		return time.Now().Add(-ago), nil
+	}
+	if day, err := time.ParseInLocation(time.DateOnly, value, time.Local); err == nil {
+		if end {
+			return day.AddDate(0, 0, 1).Add(-time.Nanosecond), nil
+		}
+		return day, nil
+	}
+	if at, err := time.Parse(time.RFC3339, value); err == nil {
+		return at, nil
+	}
+	return time.Time{}, fmt.Errorf("wants a duration ago like 2h, a date like 2006-01-02 or a time like 2006-01-02T15:04:05Z")
+}
+
+// search runs a search, and returns the lines to show for its results and what /more should search next,
+// nil once there is nothing more.
+func search(client *chatclient.Client, query chatclient.SearchQuery) ([]string, *chatclient.SearchQuery, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	page, err := client.Search(ctx, query)
+	if err != nil {
+		return nil, nil, err
+	}
+	if page.Total == 0 {
+		return []string{"Nothing matches"}, nil, nil
+	}
+	var lines []string
+	for _, result := range page.Results {
+		lines = append(lines, describeSearchResult(result))
+	}
+	if page.NextPageToken == "" {
+		return lines, nil, nil
+	}
+	lines = append(lines, fmt.Sprintf("%d messages match, /more for the next ones", page.Total))
+	query.PageToken = page.NextPageToken
+	return lines, &query, nil
+}
+
+// describeSearchResult is one line about a message that matched a search.
+func describeSearchResult(result chatclient.SearchResult) string {
+	text := result.Text
+	if result.Attachment != nil {
+		text = "shared " + describeAttachment(result.Attachment)
+	}
+	if result.Edited {
+		text += " (edited)"
+	}
+	when := ""
+	if !result.SentAt.IsZero() {
+		when = result.SentAt.Local().Format(time.DateTime) + " "
+	}
+	return fmt.Sprintf("%s[%d] #%s in %s, %s: %s", when, result.Lamport, result.ID, result.Room, result.Author, text)
+}
+
+// search looks for messages and shows the first page of what matches.
+func (chatClient *chatClientStruct) search(arguments string) {
+	if arguments == "" {
+		display(searchUsage)
+		return
+	}
+	query, err := parseSearch(arguments, chatClient.client.Room())
+	if err != nil {
+		display("%s", err)
+		return
+	}
+	chatClient.showSearch(query)
+}
+
+// more shows the next page of the last search.
+func (chatClient *chatClientStruct) more() {
+	if chatClient.nextSearch == nil {
+		display("There is nothing more to show, /search first")
+		return
+	}
+	chatClient.showSearch(*chatClient.nextSearch)
+}
+
+func (chatClient *chatClientStruct) showSearch(query chatclient.SearchQuery) {
+	lines, next, err := search(chatClient.client, query)
+	if err != nil {
+		display("Could not search: %s", describe(err))
+		return
+	}
+	chatClient.nextSearch = next
+	for _, line := range lines {
+		display("%s", line)
+	}
+}
@@ -0,0 +1,910 @@
+package main
+
+import (
+	"context"
+	"crypto/ed25519"
+	"fmt"
+	"log/slog"
+	"sort"
+	"strings"
+	"time"
+	"unicode/utf8"
+
+	"homework3/chatclient"
+	"homework3/chitchat"
+
+	"github.com/gdamore/tcell/v2"
+	"github.com/rivo/tview"
+	"google.golang.org/grpc"
+)
+
+// roomTab is one room in the full-screen interface, with its own connection to the server.
+type roomTab struct {
+	room         string
+	client       *chatclient.Client
+	messages     *tview.TextView
+	participants map[string]chatclient.Participant
+	unread       int
+	//set once the connection is gone for good
+	closed bool
+	//set while the user has said they are away in this room
+	away bool
+	//who else is typing in the room
+	typing map[string]bool
+	//ids of messages that arrived while the tab was in the background, to mark read once it is shown
+	unseen []string
+	//the last message the user sent here and who has received and read it
+	lastSent     string
+	lastReceipts *chatclient.Receipts
+	//the rest of the last search made here, for /more
+	nextSearch *chatclient.SearchQuery
+}
+
+// fullScreen is the full-screen interface: a tab per room, a scrollable message pane,
+// the participants of the current room on the side and an input line with history.
+type fullScreen struct {
+	app         *tview.Application
+	terminal    tcell.Screen
+	name        string
+	key         ed25519.PrivateKey
+	dialOptions []grpc.DialOption
+
+	tabBar  *tview.TextView
+	pages   *tview.Pages
+	sidebar *tview.TextView
+	//says who is typing in the current room
+	status *tview.TextView
+	input  *tview.InputField
+
+	tabs    []*roomTab
+	current int
+
+	//lines entered so far, and where Up and Down are in them
+	history  []string
+	position int
+
+	//messages we were notified of mentioning us. Every tab's connection is notified, but one ring will do.
+	mentioned map[string]bool
+	//direct messages shown already. Like mentions, every tab's connection gets them.
+	directShown map[string]bool
+}
+
+// colours of the different kinds of lines
+const (
+	ownColour     = "green"
+	systemColour  = "yellow"
+	authorColour  = "aqua"
+	warningColour = "red"
+	mentionColour = "fuchsia"
+	directColour  = "orange"
+)
+
+// runFullScreen joins the first room and shows the chat full-screen until the user quits.
+func runFullScreen(name string, key ed25519.PrivateKey, room string, dialOptions []grpc.DialOption) {
+	screen := &fullScreen{
+		app:         tview.NewApplication(),
+		name:        name,
+		key:         key,
+		dialOptions: dialOptions,
+		tabBar:      tview.NewTextView().SetDynamicColors(true).SetWrap(false),
+		pages:       tview.NewPages(),
+		sidebar:     tview.NewTextView().SetDynamicColors(true),
+		status:      tview.NewTextView().SetDynamicColors(true).SetWrap(false),
+		input:       tview.NewInputField().SetLabel("> "),
+		mentioned:   make(map[string]bool),
+		directShown: make(map[string]bool),
+	}
+	//the application makes its own screen otherwise, and we need ours to ring the bell.
+	terminal, err := tcell.NewScreen()
+	if err != nil {
+		fatal("Failed to open the terminal", err)
+	}
+	screen.terminal = terminal
+	screen.app.SetScreen(terminal)
+	screen.sidebar.SetBorder(true).SetTitle(" participants ")
+	screen.input.SetFieldBackgroundColor(tcell.ColorDefault)
+	screen.input.SetDoneFunc(screen.submit)
+	screen.input.SetInputCapture(screen.handleKey)
+	screen.input.SetChangedFunc(screen.edited)
+
+	body := tview.NewFlex().
+		AddItem(screen.pages, 0, 1, false).
+		AddItem(screen.sidebar, 24, 0, false)
+	layout := tview.NewFlex().SetDirection(tview.FlexRow).
+		AddItem(screen.tabBar, 1, 0, false).
+		AddItem(body, 0, 1, false).
+		AddItem(screen.status, 1, 0, false).
+		AddItem(screen.input, 1, 0, true)
+	screen.app.SetRoot(layout, true)
+
+	client, err := connect(name, key, room, dialOptions)
+	if err != nil {
+		fatal("Failed to join the chat", err)
+	}
+	screen.addTab(room, client)
+	if err := screen.app.Run(); err != nil {
+		fatal("Failed to run the full-screen interface", err)
+	}
+	screen.leaveAll()
+}
+
+// join switches to the room's tab, or connects to the room and opens a tab for it.
+// Connecting waits for the server, so it happens off the drawing goroutine.
+func (screen *fullScreen) join(room string, from *roomTab) {
+	for i, tab := range screen.tabs {
+		if tab.room == room && !tab.closed {
+			screen.switchTo(i)
+			return
+		}
+	}
+	screen.printTo(from, systemColour, "Joining %s ...", room)
+	go func() {
+		client, err := connect(screen.name, screen.key, room, screen.dialOptions)
+		screen.app.QueueUpdateDraw(func() {
+			if err != nil {
+				screen.printTo(from, warningColour, "Could not join %s: %s", room, describe(err))
+				return
+			}
+			screen.addTab(room, client)
+		})
+	}()
+}
+
+// addTab opens a tab for a room we have joined and switches to it.
+func (screen *fullScreen) addTab(room string, client *chatclient.Client) {
+	tab := &roomTab{
+		room:         room,
+		client:       client,
+		messages:     tview.NewTextView().SetDynamicColors(true).SetScrollable(true).SetWordWrap(true),
+		participants: map[string]chatclient.Participant{screen.name: {Name: screen.name}},
+		typing:       make(map[string]bool),
+	}
+	tab.messages.SetBorder(true).SetTitle(" " + room + " ")
+	screen.tabs = append(screen.tabs, tab)
+	screen.pages.AddPage(pageName(tab), tab.messages, true, false)
+	screen.printTo(tab, systemColour, "You joined %s. /join <room> opens another room, /part leaves this one, /quit leaves them all.", room)
+	screen.printTo(tab, systemColour, "/who lists who is here, /away [message] tells them you are away.")
+	screen.printTo(tab, systemColour, "Ctrl-N and Ctrl-P switch rooms, PgUp and PgDn scroll.")
+	screen.switchTo(len(screen.tabs) - 1)
+
+	go func() {
+		for event := range client.Events() {
+			event := event
+			screen.app.QueueUpdateDraw(func() { screen.show(tab, event) })
+		}
+		screen.app.QueueUpdateDraw(func() {
+			tab.closed = true
+			if err := client.Err(); err != nil {
+				screen.printTo(tab, warningColour, "Disconnected: %s", describe(err))
+			}
+			screen.refresh()
+		})
+	}()
+}
+
+func pageName(tab *roomTab) string {
+	return fmt.Sprintf("%p", tab)
+}
+
+// show adds an event to its room's tab.
+func (screen *fullScreen) show(tab *roomTab, event chatclient.Event) {
+	switch event.Kind {
+	case chatclient.MessageEvent:
+		colour := authorColour
+		if event.Message.Author == screen.name {
+			colour = ownColour
+		}
+		marker := ""
+		if !event.Message.Verified {
+			marker = fmt.Sprintf("[%s]%s[-] ", warningColour, tview.Escape("[UNVERIFIED]"))
+		}
+		if quote := quoteOf(event.Message); quote != "" {
+			fmt.Fprintf(tab.messages, "[gray]%s[-]\n", tview.Escape("        "+quote))
+		}
+		text := tview.Escape(event.Message.Text)
+		if attachment := event.Message.Attachment; attachment != nil {
+			text = fmt.Sprintf("[%s]shared %s, /get %s to fetch it[-]", systemColour, tview.Escape(describeAttachment(attachment)), attachment.ID)
+		}
+		if event.Message.Mentioned {
+			text = fmt.Sprintf("[%s]%s[-]", mentionColour, text)
+		}
+		if expiry := expiryOf(event.Message); expiry != "" {
+			text = fmt.Sprintf("[gray]%s[-] %s", tview.Escape("("+expiry+")"), text)
+		}
+		fmt.Fprintf(tab.messages, "[gray]%s #%s[-] %s[%s]%s[-]: %s\n", stamp(event.Lamport), event.Message.ID, marker, colour,
+			tview.Escape(event.Message.Author), text)
+		if event.Message.Author != screen.name && event.Message.ID != "" {
+			tab.unseen = append(tab.unseen, event.Message.ID)
+			if screen.tabs[screen.current] == tab {
+				screen.markSeen(tab)
+			}
+		}
+	case chatclient.EditEvent:
+		fmt.Fprintf(tab.messages, "[gray]%s #%s[-] [%s]edited by %s:[-] %s\n", stamp(event.Lamport), event.Target, systemColour,
+			tview.Escape(event.Participant), tview.Escape(event.Message.Text))
+	case chatclient.ReactionEvent:
+		fmt.Fprintf(tab.messages, "[gray]%s #%s[-] [%s]%s: %s[-]\n", stamp(event.Lamport), event.Target, systemColour,
+			tview.Escape(event.Message.Text), tview.Escape(describeReactions(event.Reactions)))
+	case chatclient.DeleteEvent:
+		tombstone := fmt.Sprintf("[gray]%s #%s[-] [%s]%s[-]", stamp(event.Lamport), event.Target, systemColour, tview.Escape(event.Message.Text))
+		//what the message said goes from the screen too, unless it scrolled out of what we kept.
+		if !replaceLines(tab.messages, event.Target, tombstone) {
+			fmt.Fprintln(tab.messages, tombstone)
+		}
+	case chatclient.DirectEvent:
+		//direct messages are not about any room, so they go wherever the user is looking.
+		if screen.directShown[event.Message.ID] {
+			return
+		}
+		screen.directShown[event.Message.ID] = true
+		marker := ""
+		if !event.Message.Verified {
+			marker = fmt.Sprintf("[%s]%s[-] ", warningColour, tview.Escape("[UNVERIFIED]"))
+		}
+		fmt.Fprintf(screen.tabs[screen.current].messages, "[gray]%s #%s[-] %s[%s]private %s: %s[-]\n", stamp(event.Lamport), event.Message.ID, marker,
+			directColour, tview.Escape(directLabel(event.Message, screen.name)), tview.Escape(event.Message.Text))
+		return
+	case chatclient.MentionEvent:
+		if screen.mentioned[event.Target] {
+			return
+		}
+		screen.mentioned[event.Target] = true
+		screen.terminal.Beep()
+		//the message itself shows up in the room's tab, if we are in the room.
+		for _, other := range screen.tabs {
+			if other.room == event.Message.Room && !other.closed {
+				return
+			}
+		}
+		screen.printTo(screen.tabs[screen.current], mentionColour, "%s", event.Message.Text)
+		return
+	case chatclient.ReceiptEvent:
+		if event.Receipts.MessageID == tab.lastSent {
+			tab.lastReceipts = event.Receipts
+			screen.refresh()
+		}
+		return
+	case chatclient.TypingEvent:
+		if event.Typing {
+			tab.typing[event.Participant] = true
+		} else {
+			delete(tab.typing, event.Participant)
+		}
+		screen.refresh()
+		return
+	case chatclient.ParticipantsEvent:
+		//the server's list replaces whatever we worked out ourselves, and is not worth a line.
+		tab.participants = make(map[string]chatclient.Participant)
+		for _, participant := range event.Participants {
+			tab.participants[participant.Name] = participant
+		}
+		screen.refresh()
+		return
+	case chatclient.JoinEvent, chatclient.LeaveEvent, chatclient.NoticeEvent, chatclient.PresenceEvent:
+		switch event.Kind {
+		case chatclient.JoinEvent:
+			tab.participants[event.Participant] = chatclient.Participant{Name: event.Participant, LastActive: time.Now()}
+		case chatclient.LeaveEvent:
+			delete(tab.participants, event.Participant)
+		case chatclient.PresenceEvent:
+			if event.Presence == chitchat.Presence_OFFLINE {
+				delete(tab.participants, event.Participant)
+			} else {
+				tab.participants[event.Participant] = chatclient.Participant{Name: event.Participant, Presence: event.Presence, Status: event.Status, LastActive: time.Now()}
+			}
+		}
+		colour := systemColour
+		if !event.Message.Verified {
+			colour = warningColour
+		}
+		fmt.Fprintf(tab.messages, "[gray]%s[-] [%s]%s[-]\n", stamp(event.Lamport), colour, tview.Escape(event.Message.Text))
+	case chatclient.ReconnectingEvent:
+		screen.printTo(tab, warningColour, "Connection lost: %s. Reconnecting in %v (attempt %d)", describe(event.Err), event.Delay, event.Attempt)
+	case chatclient.ReconnectedEvent:
+		screen.printTo(tab, systemColour, "Reconnected to the chat")
+	default:
+		return
+	}
+	if screen.tabs[screen.current] != tab {
+		tab.unread++
+	}
+	screen.refresh()
+}
+
+// replaceLines replaces the line a message was shown on with line, and drops the lines about its
+// edits and reactions. It reports whether the message was on the screen.
+func replaceLines(messages *tview.TextView, id string, line string) bool {
+	//lines about a message start with its Lamport time and id; the quotes of replies end with the id instead.
+	about := " #" + id + "[-] "
+	lines := strings.Split(messages.GetText(false), "\n")
+	kept := lines[:0]
+	replaced := false
+	for _, shown := range lines {
+		if !strings.Contains(shown, about) {
+			kept = append(kept, shown)
+		} else if !replaced {
+			kept = append(kept, line)
+			replaced = true
+		}
+	}
+	if replaced {
+		messages.SetText(strings.Join(kept, "\n"))
+	}
+	return replaced
+}
+
+// stamp shows a Lamport time the way the line mode does, escaped so it is not read as a colour tag.
+func stamp(lamport int32) string {
+	return tview.Escape(fmt.Sprintf("[%d]", lamport))
+}
+
+// printTo adds a line from the interface itself to a tab.
+func (screen *fullScreen) printTo(tab *roomTab, colour string, format string, args ...any) {
+	fmt.Fprintf(tab.messages, "[%s]%s[-]\n", colour, tview.Escape(fmt.Sprintf(format, args...)))
+}
+
+// switchTo makes the tab at index the current one.
+func (screen *fullScreen) switchTo(index int) {
+	screen.current = index
+	tab := screen.tabs[index]
+	tab.unread = 0
+	screen.markSeen(tab)
+	screen.pages.SwitchToPage(pageName(tab))
+	screen.refresh()
+}
+
+// markSeen tells the authors of the messages that arrived in a tab that the user has seen them.
+func (screen *fullScreen) markSeen(tab *roomTab) {
+	if len(tab.unseen) > 0 && !tab.closed {
+		tab.client.MarkRead(tab.unseen...)
+	}
+	tab.unseen = nil
+}
+
+// refresh redraws the tab bar and the sidebar.
+func (screen *fullScreen) refresh() {
+	var bar strings.Builder
+	for i, tab := range screen.tabs {
+		label := tview.Escape(tab.room)
+		if tab.unread > 0 {
+			label += fmt.Sprintf(" (%d)", tab.unread)
+		}
+		if tab.closed {
+			label += " (disconnected)"
+		}
+		if i == screen.current {
+			fmt.Fprintf(&bar, "[black:white] %s [-:-] ", label)
+		} else if tab.unread > 0 {
+			fmt.Fprintf(&bar, "[%s] %s [-] ", ownColour, label)
+		} else {
+			fmt.Fprintf(&bar, " %s  ", label)
+		}
+	}
+	screen.tabBar.SetText(bar.String())
+
+	tab := screen.tabs[screen.current]
+	//who is typing matters more than who has read what
+	if status := typingLine(tab.typing); status != "" || tab.lastReceipts == nil {
+		screen.status.SetText(status)
+	} else {
+		screen.status.SetText(fmt.Sprintf("[gray]#%s %s[-]", tab.lastSent, tview.Escape(describeReceipts(tab.lastReceipts))))
+	}
+	names := make([]string, 0, len(tab.participants))
+	for name := range tab.participants {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	screen.sidebar.Clear()
+	for _, name := range names {
+		participant := tab.participants[name]
+		label := tview.Escape(name)
+		if participant.Presence != chitchat.Presence_ONLINE {
+			//away and idle participants are greyed out
+			label = fmt.Sprintf("[gray]%s (%s)[-]", label, strings.ToLower(participant.Presence.String()))
+		}
+		if name == screen.name {
+			fmt.Fprintf(screen.sidebar, "[%s]%s[-]\n", ownColour, label)
+		} else {
+			fmt.Fprintln(screen.sidebar, label)
+		}
+	}
+}
+
+// typingLine says who is typing, like "alice and bob are typing…".
+func typingLine(typing map[string]bool) string {
+	names := make([]string, 0, len(typing))
+	for name := range typing {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	var who string
+	switch len(names) {
+	case 0:
+		return ""
+	case 1:
+		who = names[0] + " is"
+	case 2:
+		who = names[0] + " and " + names[1] + " are"
+	default:
+		who = fmt.Sprintf("%s and %d others are", names[0], len(names)-1)
+	}
+	return fmt.Sprintf("[gray]%s typing…[-]", tview.Escape(who))
+}
+
+// edited tells the current room the user is typing, unless they are typing a command.
+func (screen *fullScreen) edited(text string) {
+	tab := screen.tabs[screen.current]
+	if text == "" || strings.HasPrefix(text, "/") || tab.closed {
+		return
+	}
+	tab.client.Typing()
+}
+
+// handleKey handles the keys that do not edit the input line.
+func (screen *fullScreen) handleKey(key *tcell.EventKey) *tcell.EventKey {
+	messages := screen.tabs[screen.current].messages
+	switch key.Key() {
+	case tcell.KeyCtrlN:
+		screen.switchTo((screen.current + 1) % len(screen.tabs))
+	case tcell.KeyCtrlP:
+		screen.switchTo((screen.current + len(screen.tabs) - 1) % len(screen.tabs))
+	case tcell.KeyPgUp, tcell.KeyPgDn:
+		//let the message pane scroll, as if it had focus
+		messages.InputHandler()(key, func(tview.Primitive) {})
+	case tcell.KeyUp:
+		if screen.position > 0 {
+			screen.position--
+			screen.input.SetText(screen.history[screen.position])
+		}
+	case tcell.KeyDown:
+		if screen.position < len(screen.history)-1 {
+			screen.position++
+			screen.input.SetText(screen.history[screen.position])
+		} else {
+			screen.position = len(screen.history)
+			screen.input.SetText("")
+		}
+	default:
+		return key
+	}
+	return nil
+}
+
+// submit handles a line entered on the input line: a command, or a message to the current room.
+func (screen *fullScreen) submit(key tcell.Key) {
+	if key != tcell.KeyEnter {
+		return
+	}
+	line := strings.TrimSpace(screen.input.GetText())
+	screen.input.SetText("")
+	if line == "" {
+		return
+	}
+	screen.history = append(screen.history, line)
+	screen.position = len(screen.history)
+
+	tab := screen.tabs[screen.current]
+	command, argument, _ := strings.Cut(line, " ")
+	switch command {
+	case "/quit", "/disconnect":
+		screen.app.Stop()
+	case "/join":
+		room := strings.TrimSpace(argument)
+		if room == "" {
+			screen.printTo(tab, warningColour, "Usage: /join <room>")
+			return
+		}
+		screen.join(room, tab)
+	case "/part":
+		screen.part(tab)
+	case "/who":
+		screen.who(tab)
+	case "/receipts":
+		screen.showReceipts(tab, strings.TrimPrefix(strings.TrimSpace(argument), "#"))
+	case "/away":
+		screen.toggleAway(tab, strings.TrimSpace(argument))
+	case "/share":
+		screen.share(tab, strings.TrimSpace(argument))
+	case "/get":
+		id, path, _ := strings.Cut(strings.TrimSpace(argument), " ")
+		screen.get(tab, strings.TrimPrefix(id, "#"), strings.TrimSpace(path))
+	case "/msg":
+		to, text, _ := strings.Cut(strings.TrimSpace(argument), " ")
+		screen.sendDirect(tab, to, strings.TrimSpace(text))
+	case "/reply":
+		id, text, _ := strings.Cut(strings.TrimSpace(argument), " ")
+		screen.reply(tab, strings.TrimPrefix(id, "#"), strings.TrimSpace(text))
+	case "/ephemeral":
+		ttl, text, _ := strings.Cut(strings.TrimSpace(argument), " ")
+		screen.sendEphemeral(tab, ttl, strings.TrimSpace(text))
+	case "/thread":
+		screen.showThread(tab, strings.TrimPrefix(strings.TrimSpace(argument), "#"))
+	case "/threads":
+		screen.showThreads(tab)
+	case "/react", "/unreact":
+		id, emoji, _ := strings.Cut(strings.TrimSpace(argument), " ")
+		screen.react(tab, strings.TrimPrefix(id, "#"), strings.TrimSpace(emoji), command == "/unreact")
+	case "/edit":
+		id, text, _ := strings.Cut(strings.TrimSpace(argument), " ")
+		screen.edit(tab, strings.TrimPrefix(id, "#"), strings.TrimSpace(text))
+	case "/delete":
+		screen.delete(tab, strings.TrimPrefix(strings.TrimSpace(argument), "#"))
+	case "/history":
+		screen.showHistory(tab, strings.TrimPrefix(strings.TrimSpace(argument), "#"))
+	case "/search":
+		screen.search(tab, strings.TrimSpace(argument))
+	case "/more":
+		if tab.nextSearch == nil {
+			screen.printTo(tab, warningColour, "There is nothing more to show, /search first")
+			return
+		}
+		screen.showSearch(tab, *tab.nextSearch)
+	default:
+		if utf8.RuneCountInString(line) > 128 {
+			screen.printTo(tab, warningColour, "Your message must be no longer than 128 characters!")
+			return
+		}
+		if tab.closed {
+			screen.printTo(tab, warningColour, "You are no longer connected to %s", tab.room)
+			return
+		}
+		go func() {
+			id, err := tab.client.Send(context.Background(), line)
+			screen.app.QueueUpdateDraw(func() {
+				if err != nil {
+					screen.printTo(tab, warningColour, "Could not send your message: %s", describe(err))
+					return
+				}
+				tab.lastSent = id
+				tab.lastReceipts = nil
+				screen.refresh()
+			})
+		}()
+	}
+}
+
+// who lists everyone in the tab's room, as the server sees them.
+func (screen *fullScreen) who(tab *roomTab) {
+	go func() {
+		ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+		defer cancel()
+		participants, err := tab.client.ListParticipants(ctx, false)
+		screen.app.QueueUpdateDraw(func() {
+			if err != nil {
+				screen.printTo(tab, warningColour, "Could not ask who is here: %s", describe(err))
+				return
+			}
+			var names []string
+			for _, participant := range participants {
+				names = append(names, describeParticipant(participant))
+			}
+			screen.printTo(tab, systemColour, "In %s: %s", tab.room, strings.Join(names, ", "))
+		})
+	}()
+}
+
+// showReceipts shows who has received and read one of the user's messages, the last one if id is empty.
+func (screen *fullScreen) showReceipts(tab *roomTab, id string) {
+	if id == "" {
+		id = tab.lastSent
+	}
+	if id == "" {
+		screen.printTo(tab, warningColour, "Usage: /receipts [message id]")
+		return
+	}
+	go func() {
+		ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+		defer cancel()
+		receipts, err := tab.client.Receipts(ctx, id)
+		screen.app.QueueUpdateDraw(func() {
+			if err != nil {
+				screen.printTo(tab, warningColour, "Could not get the receipts for #%s: %s", id, describe(err))
+				return
+			}
+			screen.printTo(tab, systemColour, "#%s: %s", id, describeReceipts(receipts))
+		})
+	}()
+}
+
+// reply sends a message to the tab's room as a reply to another one.
+func (screen *fullScreen) reply(tab *roomTab, id string, text string) {
+	if id == "" || text == "" {
+		screen.printTo(tab, warningColour, "Usage: /reply <message id> <text>")
+		return
+	}
+	go func() {
+		ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+		defer cancel()
+		sent, err := tab.client.Reply(ctx, id, text)
+		screen.app.QueueUpdateDraw(func() {
+			if err != nil {
+				screen.printTo(tab, warningColour, "Could not reply to #%s: %s", id, describe(err))
+				return
+			}
+			tab.lastSent = sent
+			tab.lastReceipts = nil
+			screen.refresh()
+		})
+	}()
+}
+
+// sendEphemeral sends a message to the tab's room that disappears once ttl, a duration like 10m, has passed.
+func (screen *fullScreen) sendEphemeral(tab *roomTab, ttl string, text string) {
+	lasting, err := time.ParseDuration(ttl)
+	if err != nil || lasting <= 0 || text == "" {
+		screen.printTo(tab, warningColour, "Usage: /ephemeral <how long, like 30s or 10m> <text>")
+		return
+	}
+	go func() {
+		ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+		defer cancel()
+		sent, err := tab.client.SendEphemeral(ctx, text, lasting)
+		screen.app.QueueUpdateDraw(func() {
+			if err != nil {
+				screen.printTo(tab, warningColour, "Could not send the message: %s", describe(err))
+				return
+			}
+			tab.lastSent = sent
+			tab.lastReceipts = nil
+			screen.refresh()
+		})
+	}()
+}
+
+// showThread shows the thread a message in the tab's room is in.
+func (screen *fullScreen) showThread(tab *roomTab, id string) {
+	if id == "" {
+		screen.printTo(tab, warningColour, "Usage: /thread <message id>")
+		return
+	}
+	go func() {
+		ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+		defer cancel()
+		thread, err := tab.client.Thread(ctx, id)
+		screen.app.QueueUpdateDraw(func() {
+			if err != nil {
+				screen.printTo(tab, warningColour, "Could not get the thread of #%s: %s", id, describe(err))
+				return
+			}
+			for _, line := range describeThread(thread) {
+				screen.printTo(tab, systemColour, "%s", line)
+			}
+		})
+	}()
+}
+
+// search looks for messages, in the tab's room unless in: says otherwise, and shows the first page of what matches.
+func (screen *fullScreen) search(tab *roomTab, arguments string) {
+	if arguments == "" {
+		screen.printTo(tab, warningColour, searchUsage)
+		return
+	}
+	query, err := parseSearch(arguments, tab.room)
+	if err != nil {
+		screen.printTo(tab, warningColour, "%s", err)
+		return
+	}
+	screen.showSearch(tab, query)
+}
+
+func (screen *fullScreen) showSearch(tab *roomTab, query chatclient.SearchQuery) {
+	go func() {
+		lines, next, err := search(tab.client, query)
+		screen.app.QueueUpdateDraw(func() {
+			if err != nil {
+				screen.printTo(tab, warningColour, "Could not search: %s", describe(err))
+				return
+			}
+			tab.nextSearch = next
+			for _, line := range lines {
+				screen.printTo(tab, systemColour, "%s", line)
+			}
+		})
+	}()
+}
+
+// showThreads lists the messages in the tab's room that have replies.
+func (screen *fullScreen) showThreads(tab *roomTab) {
+	go func() {
+		ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+		defer cancel()
+		threads, err := tab.client.Threads(ctx, 10)
+		screen.app.QueueUpdateDraw(func() {
+			if err != nil {
+				screen.printTo(tab, warningColour, "Could not list the threads: %s", describe(err))
+				return
+			}
+			if len(threads) == 0 {
+				screen.printTo(tab, systemColour, "Nobody has replied to anything in %s yet", tab.room)
+			}
+			for _, thread := range threads {
+				screen.printTo(tab, systemColour, "%s", describeThreadSummary(thread))
+			}
+		})
+	}()
+}
+
+// react reacts to a message in the tab's room, or takes the reaction back.
+func (screen *fullScreen) react(tab *roomTab, id string, emoji string, remove bool) {
+	if id == "" || emoji == "" {
+		screen.printTo(tab, warningColour, "Usage: /react <message id> <emoji or :shortcode:>, or /unreact to take it back")
+		return
+	}
+	react := tab.client.React
+	if remove {
+		react = tab.client.Unreact
+	}
+	go func() {
+		ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+		defer cancel()
+		if err := react(ctx, id, emoji); err != nil {
+			screen.app.QueueUpdateDraw(func() {
+				screen.printTo(tab, warningColour, "Could not react to #%s: %s", id, describe(err))
+			})
+		}
+	}()
+}
+
+// share shares a file in the tab's room.
+func (screen *fullScreen) share(tab *roomTab, path string) {
+	if path == "" {
+		screen.printTo(tab, warningColour, "Usage: /share <file>")
+		return
+	}
+	screen.printTo(tab, systemColour, "Sharing %s ...", path)
+	go func() {
+		ctx, cancel := context.WithTimeout(context.Background(), transferTimeout)
+		defer cancel()
+		if _, err := tab.client.ShareFile(ctx, path); err != nil {
+			screen.app.QueueUpdateDraw(func() {
+				screen.printTo(tab, warningColour, "Could not share %s: %s", path, describe(err))
+			})
+		}
+	}()
+}
+
+// get fetches a shared file to path, or into the current directory.
+func (screen *fullScreen) get(tab *roomTab, id string, path string) {
+	if id == "" {
+		screen.printTo(tab, warningColour, "Usage: /get <message id> [where to save it]")
+		return
+	}
+	go func() {
+		saved, err := download(tab.client, id, path)
+		screen.app.QueueUpdateDraw(func() {
+			if err != nil {
+				screen.printTo(tab, warningColour, "Could not fetch #%s: %s", id, describe(err))
+				return
+			}
+			screen.printTo(tab, systemColour, "Saved #%s to %s", id, saved)
+		})
+	}()
+}
+
+// sendDirect sends a private message to one user, which reaches them even if they are offline now.
+func (screen *fullScreen) sendDirect(tab *roomTab, to string, text string) {
+	if to == "" || text == "" {
+		screen.printTo(tab, warningColour, "Usage: /msg <name> <text>")
+		return
+	}
+	go func() {
+		ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+		defer cancel()
+		if _, err := tab.client.SendDirect(ctx, to, text); err != nil {
+			screen.app.QueueUpdateDraw(func() {
+				screen.printTo(tab, warningColour, "Could not send to %s: %s", to, describe(err))
+			})
+		}
+	}()
+}
+
+// edit changes the text of a message in the tab's room.
+func (screen *fullScreen) edit(tab *roomTab, id string, text string) {
+	if id == "" || text == "" {
+		screen.printTo(tab, warningColour, "Usage: /edit <message id> <new text>")
+		return
+	}
+	go func() {
+		ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+		defer cancel()
+		if err := tab.client.Edit(ctx, id, text); err != nil {
+			screen.app.QueueUpdateDraw(func() {
+				screen.printTo(tab, warningColour, "Could not edit #%s: %s", id, describe(err))
+			})
+		}
+	}()
+}
+
+// delete deletes a message in the tab's room.
+func (screen *fullScreen) delete(tab *roomTab, id string) {
+	if id == "" {
+		screen.printTo(tab, warningColour, "Usage: /delete <message id>")
+		return
+	}
+	go func() {
+		ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+		defer cancel()
+		if err := tab.client.Delete(ctx, id); err != nil {
+			screen.app.QueueUpdateDraw(func() {
+				screen.printTo(tab, warningColour, "Could not delete #%s: %s", id, describe(err))
+			})
+		}
+	}()
+}
+
+// showHistory shows every version of a message in the tab's room.
+func (screen *fullScreen) showHistory(tab *roomTab, id string) {
+	if id == "" {
+		screen.printTo(tab, warningColour, "Usage: /history <message id>")
+		return
+	}
+	go func() {
+		ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+		defer cancel()
+		history, err := tab.client.EditHistory(ctx, id)
+		screen.app.QueueUpdateDraw(func() {
+			if err != nil {
+				screen.printTo(tab, warningColour, "Could not get the history of #%s: %s", id, describe(err))
+				return
+			}
+			for _, line := range describeHistory(history) {
+				screen.printTo(tab, systemColour, "%s", line)
+			}
+		})
+	}()
+}
+
+// toggleAway tells the tab's room the user is away, or back if they were away and gave no new message.
+func (screen *fullScreen) toggleAway(tab *roomTab, status string) {
+	presence := chitchat.Presence_AWAY
+	if tab.away && status == "" {
+		presence = chitchat.Presence_ONLINE
+	}
+	go func() {
+		ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+		defer cancel()
+		err := tab.client.SetPresence(ctx, presence, status)
+		screen.app.QueueUpdateDraw(func() {
+			if err != nil {
+				screen.printTo(tab, warningColour, "Could not change your presence: %s", describe(err))
+				return
+			}
+			tab.away = presence == chitchat.Presence_AWAY
+		})
+	}()
+}
+
+// part leaves a room and closes its tab, quitting when it was the last one.
+func (screen *fullScreen) part(tab *roomTab) {
+	if len(screen.tabs) == 1 {
+		screen.app.Stop()
+		return
+	}
+	go leaveRoom(tab)
+	for i, open := range screen.tabs {
+		if open == tab {
+			screen.tabs = append(screen.tabs[:i], screen.tabs[i+1:]...)
+			break
+		}
+	}
+	screen.pages.RemovePage(pageName(tab))
+	screen.switchTo(min(screen.current, len(screen.tabs)-1))
+}
+
+// leaveAll leaves every room still open, once the interface has stopped.
+func (screen *fullScreen) leaveAll() {
+	for _, tab := range screen.tabs {
+		leaveRoom(tab)
+	}
+}
+
+func leaveRoom(tab *roomTab) {
+	if tab.closed {
+		return
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	if err := tab.client.Leave(ctx); err != nil {
+		slog.Warn("could not leave cleanly", "room", tab.room, "error", err)
+	}
+}
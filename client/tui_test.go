@@ -0,0 +1,200 @@
+package main
+
+import (
+	"strings"
+	"testing"
+
+	"homework3/chatclient"
+	"homework3/chitchat"
+
+	"github.com/gdamore/tcell/v2"
+	"github.com/rivo/tview"
+)
+
+// newTestScreen returns the full-screen interface for alice with a tab for each room, without a
+// terminal or connections. The first tab is the current one.
+func newTestScreen(rooms ...string) *fullScreen {
+	screen := &fullScreen{
+		name:        "alice",
+		tabBar:      tview.NewTextView().SetDynamicColors(true),
+		pages:       tview.NewPages(),
+		sidebar:     tview.NewTextView().SetDynamicColors(true),
+		status:      tview.NewTextView().SetDynamicColors(true),
+		input:       tview.NewInputField(),
+		mentioned:   make(map[string]bool),
+		directShown: make(map[string]bool),
+	}
+	for _, room := range rooms {
+		screen.tabs = append(screen.tabs, &roomTab{
+			room:         room,
+			messages:     tview.NewTextView().SetDynamicColors(true),
+			participants: make(map[string]chatclient.Participant),
+			typing:       make(map[string]bool),
+		})
+	}
+	return screen
+}
+
+// said is an event for a message without an id, so showing it does not mark anything read.
+func said(author string, text string, lamport int32) chatclient.Event {
+	return chatclient.Event{Kind: chatclient.MessageEvent, Lamport: lamport, Message: &chatclient.Message{Author: author, Text: text, Verified: true}}
+}
+
+func TestUnreadCounters(t *testing.T) {
+	screen := newTestScreen("general", "ops")
+	general, ops := screen.tabs[0], screen.tabs[1]
+	screen.show(ops, said("bob", "one", 1))
+	screen.show(ops, said("bob", "two", 2))
+	screen.show(general, said("bob", "here", 3))
+	if ops.unread != 2 || general.unread != 0 {
+		t.Errorf("%d unread in ops and %d in the current tab, want 2 and 0", ops.unread, general.unread)
+	}
+	if bar := screen.tabBar.GetText(true); !strings.Contains(bar, "ops (2)") || strings.Contains(bar, "general (") {
+		t.Errorf("the tab bar says %q", bar)
+	}
+	//typing indicators and receipts are not worth a count.
+	screen.show(ops, chatclient.Event{Kind: chatclient.TypingEvent, Participant: "bob", Typing: true})
+	if ops.unread != 2 {
+		t.Errorf("a typing indicator made %d unread", ops.unread)
+	}
+	screen.switchTo(1)
+	if ops.unread != 0 || strings.Contains(screen.tabBar.GetText(true), "(2)") {
+		t.Errorf("%d still unread after switching to ops: %q", ops.unread, screen.tabBar.GetText(true))
+	}
+}
+
+func TestColours(t *testing.T) {
+	screen := newTestScreen("general")
+	tab := screen.tabs[0]
+	screen.show(tab, said("alice", "mine", 1))
+	screen.show(tab, said("bob", "[red]theirs", 2))
+	forged := said("mallory", "forged", 3)
+	forged.Message.Verified = false
+	screen.show(tab, forged)
+	screen.show(tab, chatclient.Event{Kind: chatclient.JoinEvent, Participant: "carol", Lamport: 4,
+		Message: &chatclient.Message{Text: "carol joined", Verified: true}})
+	screen.show(tab, chatclient.Event{Kind: chatclient.DirectEvent, Lamport: 5,
+		Message: &chatclient.Message{ID: "0d", Author: "bob", To: "alice", Text: "psst", Verified: true}})
+
+	lines := strings.Split(strings.TrimSpace(tab.messages.GetText(false)), "\n")
+	want := []string{
+		"[" + ownColour + "]alice[-]: mine",
+		"[" + authorColour + "]bob[-]: [red[]theirs",
+		"[" + warningColour + "][UNVERIFIED[][-] [" + authorColour + "]mallory",
+		"[" + systemColour + "]carol joined[-]",
+		"[" + directColour + "]private bob → you: psst[-]",
+	}
+	if len(lines) != len(want) {
+		t.Fatalf("showed %q", lines)
+	}
+	for i := range want {
+		if !strings.Contains(lines[i], want[i]) {
+			t.Errorf("line %d is %q, want it to contain %q", i, lines[i], want[i])
+		}
+	}
+
+	//every tab's connection gets a direct message, but it is only shown once.
+	screen.show(tab, chatclient.Event{Kind: chatclient.DirectEvent, Message: &chatclient.Message{ID: "0d", Author: "bob", To: "alice", Text: "psst"}})
+	if got := strings.Count(tab.messages.GetText(true), "psst"); got != 1 {
+		t.Errorf("the direct message was shown %d times", got)
+	}
+}
+
+func TestSidebar(t *testing.T) {
+	screen := newTestScreen("general")
+	tab := screen.tabs[0]
+	screen.show(tab, chatclient.Event{Kind: chatclient.ParticipantsEvent, Participants: []chatclient.Participant{
+		{Name: "alice"}, {Name: "dave", Presence: chitchat.Presence_AWAY},
+	}})
+	notice := func(text string) *chatclient.Message { return &chatclient.Message{Text: text, Verified: true} }
+	screen.show(tab, chatclient.Event{Kind: chatclient.JoinEvent, Participant: "bob", Message: notice("bob joined")})
+	screen.show(tab, chatclient.Event{Kind: chatclient.JoinEvent, Participant: "carol", Message: notice("carol joined")})
+	screen.show(tab, chatclient.Event{Kind: chatclient.LeaveEvent, Participant: "carol", Message: notice("carol left")})
+	want := "[" + ownColour + "]alice[-]\nbob\n[gray]dave (away)[-]\n"
+	if got := screen.sidebar.GetText(false); got != want {
+		t.Errorf("the sidebar shows %q, want %q", got, want)
+	}
+}
+
+func TestDeletedMessagesLeaveTheScreen(t *testing.T) {
+	screen := newTestScreen("general")
+	tab := screen.tabs[0]
+	message := said("alice", "oops", 1)
+	message.Message.ID = "0a"
+	screen.show(tab, message)
+	screen.show(tab, chatclient.Event{Kind: chatclient.EditEvent, Target: "0a", Participant: "alice", Lamport: 2, Message: &chatclient.Message{Text: "oops!"}})
+	screen.show(tab, said("bob", "what?", 3))
+	screen.show(tab, chatclient.Event{Kind: chatclient.DeleteEvent, Target: "0a", Participant: "alice", Lamport: 4, Message: &chatclient.Message{Text: "deleted by alice"}})
+	text := tab.messages.GetText(true)
+	if strings.Contains(text, "oops") || strings.Count(text, "deleted by alice") != 1 || !strings.Contains(text, "what?") {
+		t.Errorf("after deleting, the tab shows\n%s", text)
+	}
+}
+
+func TestInputHistory(t *testing.T) {
+	screen := newTestScreen("general")
+	//nothing entered here goes to the server: the tab is closed, and nothing was searched for.
+	screen.tabs[0].closed = true
+	long := strings.Repeat("x", 129)
+	for _, line := range []string{"/join", "  /more  ", long, "hello"} {
+		screen.input.SetText(line)
+		screen.submit(tcell.KeyEnter)
+	}
+	up := tcell.NewEventKey(tcell.KeyUp, 0, tcell.ModNone)
+	down := tcell.NewEventKey(tcell.KeyDown, 0, tcell.ModNone)
+	steps := []struct {
+		key  *tcell.EventKey
+		want string
+	}{
+		{up, "hello"},
+		{up, long},
+		{up, "/more"},
+		{up, "/join"},
+		{up, "/join"},
+		{down, "/more"},
+		{down, long},
+		{down, "hello"},
+		{down, ""},
+	}
+	for i, step := range steps {
+		if screen.handleKey(step.key) != nil {
+			t.Fatalf("step %d: the key was left to the input line", i)
+		}
+		if got := screen.input.GetText(); got != step.want {
+			t.Errorf("step %d: the input line says %q, want %q", i, got, step.want)
+		}
+	}
+	shown := screen.tabs[0].messages.GetText(true)
+	for _, warning := range []string{
+		"Usage: /join <room>",
+		"There is nothing more to show",
+		"must be no longer than 128 characters",
+		"You are no longer connected to general",
+	} {
+		if !strings.Contains(shown, warning) {
+			t.Errorf("the tab does not warn %q:\n%s", warning, shown)
+		}
+	}
+}
+
+func TestTypingLine(t *testing.T) {
+	tests := []struct {
+		typing []string
+		want   string
+	}{
+		{nil, ""},
+		{[]string{"bob"}, "bob is typing…"},
+		{[]string{"carol", "bob"}, "bob and carol are typing…"},
+		{[]string{"dave", "carol", "bob"}, "bob and 2 others are typing…"},
+	}
+	for _, test := range tests {
+		typing := make(map[string]bool)
+		for _, name := range test.typing {
+			typing[name] = true
+		}
+		line := typingLine(typing)
+		if test.want == "" && line != "" || test.want != "" && !strings.Contains(line, test.want) {
+			t.Errorf("typingLine(%q) = %q, want %q", test.typing, line, test.want)
+		}
+	}
+}
@@ -0,0 +1,236 @@
+// Package config loads settings for the Chitty-Chat binaries from a YAML file,
+// environment variables and command line flags, in increasing order of precedence.
+//
+// Settings are described by a struct whose fields carry a `yaml` tag naming the
+// setting and a `usage` tag describing it. Nested structs become dotted keys, so
+// the field for key "tls.cert_file" is set by
+//
+//	tls:
+//	  cert_file: server.pem    (in the config file)
+//	CHITCHAT_TLS_CERT_FILE     (in the environment)
+//	-tls-cert-file             (on the command line)
+package config
+
+import (
+	"bytes"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"reflect"
+	"strconv"
+	"strings"
+	"time"
+
+	"gopkg.in/yaml.v3"
+)
+
+// EnvPrefix is put in front of every environment variable read by Load.
+const EnvPrefix = "CHITCHAT_"
+
+// Validator is implemented by settings structs that can check themselves after loading.
+type Validator interface {
+	Validate() error
+}
+
+// Loader fills a settings struct from its sources. It keeps the command line so the
+// same settings can be loaded again later, for instance when the server is asked to reload.
+type Loader[T any] struct {
+	name     string
+	args     []string
+	defaults T
+	//whether the command line may end in arguments that are not flags, and what they were
+	allowArgs bool
+	remaining []string
+}
+
+// NewLoader returns a loader for the program called name. defaults is a settings struct
+// holding the value each setting has when no source sets it.
+func NewLoader[T any](name string, args []string, defaults T) *Loader[T] {
+	return &Loader[T]{name: name, args: args, defaults: defaults}
+}
+
+// WithArgs lets the command line end in arguments that are not flags. Args returns them after Load.
+func (loader *Loader[T]) WithArgs() *Loader[T] {
+	loader.allowArgs = true
+	return loader
+}
+
+// Args returns the arguments after the flags, from the last Load.
+func (loader *Loader[T]) Args() []string {
+	return loader.remaining
+}
+
+// Load reads every source into a fresh copy of the defaults and returns it, validated.
+func (loader *Loader[T]) Load() (*T, error) {
+	settings := new(T)
+	*settings = loader.defaults
+	fields := collectFields(reflect.ValueOf(settings).Elem(), "")
+
+	flags := flag.NewFlagSet(loader.name, flag.ContinueOnError)
+	configPath := flags.String("config", os.Getenv(EnvPrefix+"CONFIG"), "YAML file to read settings from (env "+EnvPrefix+"CONFIG)")
+	flagValues := make(map[string]*flagValue)
+	for _, field := range fields {
+		flagValues[field.key] = &flagValue{isBool: field.value.Kind() == reflect.Bool}
+		flags.Var(flagValues[field.key], field.flagName(), field.usage+" (env "+field.envName()+")")
+	}
+	if err := flags.Parse(loader.args); err != nil {
+		return nil, err
+	}
+	if flags.NArg() > 0 && !loader.allowArgs {
+		return nil, fmt.Errorf("unexpected argument %q", flags.Arg(0))
+	}
+	loader.remaining = flags.Args()
+
+	//lowest precedence first: file, then environment, then flags the user actually passed.
+	if *configPath != "" {
+		if err := loadFile(*configPath, settings); err != nil {
+			return nil, err
+		}
+	}
+	for _, field := range fields {
+		if value, ok := os.LookupEnv(field.envName()); ok {
+			if err := field.set(value); err != nil {
+				return nil, fmt.Errorf("%s: %w", field.envName(), err)
+			}
+		}
+	}
+	var flagErr error
+	flags.Visit(func(visited *flag.Flag) {
+		for _, field := range fields {
+			if field.flagName() == visited.Name && flagErr == nil {
+				if err := field.set(flagValues[field.key].text); err != nil {
+					flagErr = fmt.Errorf("-%s: %w", visited.Name, err)
+				}
+			}
+		}
+	})
+	if flagErr != nil {
+		return nil, flagErr
+	}
+
+	if validator, ok := any(settings).(Validator); ok {
+		if err := validator.Validate(); err != nil {
+			return nil, fmt.Errorf("invalid configuration: %w", err)
+		}
+	}
+	return settings, nil
+}
+
+func loadFile(path string, settings any) error {
+	contents, err := os.ReadFile(path)
+	if err != nil {
+		return err
+	}
+	decoder := yaml.NewDecoder(bytes.NewReader(contents))
+	decoder.KnownFields(true)
+	if err := decoder.Decode(settings); err != nil && !errors.Is(err, io.EOF) {
+		return fmt.Errorf("config file %s: %w", path, err)
+	}
+	return nil
+}
+
+// flagValue holds a setting as given on the command line until the other sources have been read.
+type flagValue struct {
+	text   string
+	isBool bool
+}
+
+func (value *flagValue) String() string { return value.text }
+func (value *flagValue) Set(text string) error {
+	value.text = text
+	return nil
+}
+
+// IsBoolFlag lets boolean settings be given as just -name.
+func (value *flagValue) IsBoolFlag() bool { return value.isBool }
+
+// field is one setting found in a settings struct.
+type field struct {
+	key   string
+	usage string
+	value reflect.Value
+}
+
+func (f *field) envName() string {
+	return EnvPrefix + strings.ToUpper(strings.NewReplacer(".", "_").Replace(f.key))
+}
+
+func (f *field) flagName() string {
+	return strings.NewReplacer(".", "-", "_", "-").Replace(f.key)
+}
+
+// set parses a string from the environment or the command line into the field.
+func (f *field) set(text string) error {
+	switch f.value.Interface().(type) {
+	case time.Duration:
+		duration, err := time.ParseDuration(text)
+		if err != nil {
+			return err
+		}
+		f.value.SetInt(int64(duration))
+		return nil
+	case []string:
+		var list []string
+		for _, item := range strings.Split(text, ",") {
+			if item = strings.TrimSpace(item); item != "" {
+				list = append(list, item)
+			}
+		}
+		f.value.Set(reflect.ValueOf(list))
+		return nil
+	}
+
+	switch f.value.Kind() {
+	case reflect.String:
+		f.value.SetString(text)
+	case reflect.Bool:
+		parsed, err := strconv.ParseBool(text)
+		if err != nil {
+			return err
+		}
+		f.value.SetBool(parsed)
+	case reflect.Int, reflect.Int32, reflect.Int64:
+		parsed, err := strconv.ParseInt(text, 10, f.value.Type().Bits())
+		if err != nil {
+			return err
+		}
+		f.value.SetInt(parsed)
+	case reflect.Float64:
+		parsed, err := strconv.ParseFloat(text, 64)
+		if err != nil {
+			return err
+		}
+		f.value.SetFloat(parsed)
+	default:
+		return fmt.Errorf("settings of type %s are not supported", f.value.Type())
+	}
+	return nil
+}
+
+// collectFields lists the settings in a struct, descending into nested structs.
+func collectFields(value reflect.Value, prefix string) []*field {
+	var fields []*field
+	for i := 0; i < value.NumField(); i++ {
+		structField := value.Type().Field(i)
+		name, _, _ := strings.Cut(structField.Tag.Get("yaml"), ",")
+		if name == "" || name == "-" {
+			continue
+		}
+		if structField.Type.Kind() == reflect.Struct {
+			fields = append(fields, collectFields(value.Field(i), prefix+name+".")...)
+			continue
+		}
+		//maps have no single value to give, so they can only be set in the file.
+		if structField.Type.Kind() == reflect.Map {
+			continue
+		}
+		fields = append(fields, &field{
+			key:   prefix + name,
+			usage: structField.Tag.Get("usage"),
+			value: value.Field(i),
+		})
+	}
+	return fields
+}
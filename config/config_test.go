@@ -0,0 +1,150 @@
+package config
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+type testSettings struct {
+	Address string         `yaml:"address" usage:"where to listen"`
+	Timeout time.Duration  `yaml:"timeout" usage:"how long to wait"`
+	Verbose bool           `yaml:"verbose" usage:"log more"`
+	Names   []string       `yaml:"names" usage:"who may"`
+	Limits  testLimits     `yaml:"limits"`
+	Rooms   map[string]int `yaml:"rooms"`
+}
+
+type testLimits struct {
+	Size int `yaml:"size" usage:"how many"`
+}
+
+func (settings *testSettings) Validate() error {
+	if settings.Limits.Size < 0 {
+		return errors.New("limits.size: must not be negative")
+	}
+	return nil
+}
+
+var testDefaults = testSettings{Address: ":5678", Timeout: time.Second}
+
+// writeConfig writes a config file and returns its path.
+func writeConfig(t *testing.T, contents string) string {
+	t.Helper()
+	path := filepath.Join(t.TempDir(), "config.yaml")
+	if err := os.WriteFile(path, []byte(contents), 0600); err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+func TestLoadPrecedence(t *testing.T) {
+	path := writeConfig(t, `
+address: file:1
+timeout: 3s
+names: [alice, bob]
+limits:
+  size: 3
+rooms:
+  ops: 2
+`)
+	tests := []struct {
+		name string
+		env  map[string]string
+		args []string
+		want testSettings
+	}{
+		{"defaults", nil, nil, testDefaults},
+		{"file", nil, []string{"-config", path}, testSettings{
+			Address: "file:1", Timeout: 3 * time.Second, Names: []string{"alice", "bob"},
+			Limits: testLimits{3}, Rooms: map[string]int{"ops": 2},
+		}},
+		{"environment over file", map[string]string{"CHITCHAT_CONFIG": path, "CHITCHAT_ADDRESS": "env:1", "CHITCHAT_LIMITS_SIZE": "4", "CHITCHAT_NAMES": "carol, dave,"}, nil, testSettings{
+			Address: "env:1", Timeout: 3 * time.Second, Names: []string{"carol", "dave"},
+			Limits: testLimits{4}, Rooms: map[string]int{"ops": 2},
+		}},
+		{"flags over environment", map[string]string{"CHITCHAT_ADDRESS": "env:1", "CHITCHAT_TIMEOUT": "5s"}, []string{"-address", "flag:1", "-verbose", "-limits-size", "5"}, testSettings{
+			Address: "flag:1", Timeout: 5 * time.Second, Verbose: true,
+			Limits: testLimits{5},
+		}},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			for name, value := range test.env {
+				t.Setenv(name, value)
+			}
+			loaded, err := NewLoader("test", test.args, testDefaults).Load()
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !reflect.DeepEqual(*loaded, test.want) {
+				t.Errorf("loaded %+v, want %+v", *loaded, test.want)
+			}
+		})
+	}
+}
+
+func TestLoadErrors(t *testing.T) {
+	tests := []struct {
+		name string
+		env  map[string]string
+		args []string
+		want string
+	}{
+		{"unknown flag", nil, []string{"-port", "1"}, "flag provided but not defined"},
+		{"argument", nil, []string{"extra"}, `unexpected argument "extra"`},
+		{"bad duration", map[string]string{"CHITCHAT_TIMEOUT": "soon"}, nil, "CHITCHAT_TIMEOUT"},
+		{"bad number", nil, []string{"-limits-size", "many"}, "-limits-size"},
+		{"unknown key in the file", nil, []string{"-config", writeConfig(t, "port: 1\n")}, "field port not found"},
+		{"missing file", nil, []string{"-config", filepath.Join(t.TempDir(), "missing.yaml")}, "no such file"},
+		{"invalid", nil, []string{"-limits-size", "-1"}, "invalid configuration: limits.size: must not be negative"},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			for name, value := range test.env {
+				t.Setenv(name, value)
+			}
+			_, err := NewLoader("test", test.args, testDefaults).Load()
+			if err == nil || !strings.Contains(err.Error(), test.want) {
+				t.Errorf("loading got %v, want an error about %q", err, test.want)
+			}
+		})
+	}
+}
+
+func TestLoadArgs(t *testing.T) {
+	loader := NewLoader("test", []string{"-address", "flag:1", "hello", "world"}, testDefaults).WithArgs()
+	loaded, err := loader.Load()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if loaded.Address != "flag:1" || !reflect.DeepEqual(loader.Args(), []string{"hello", "world"}) {
+		t.Errorf("loaded address %q and arguments %q", loaded.Address, loader.Args())
+	}
+}
+
+func TestLoadAgain(t *testing.T) {
+	path := writeConfig(t, "address: file:1\n")
+	loader := NewLoader("test", []string{"-config", path}, testDefaults)
+	if _, err := loader.Load(); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(path, []byte("address: file:2\n"), 0600); err != nil {
+		t.Fatal(err)
+	}
+	reloaded, err := loader.Load()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if reloaded.Address != "file:2" {
+		t.Errorf("loading again read address %q, want the file's new one", reloaded.Address)
+	}
+	//the defaults are copied, not changed.
+	if testDefaults.Address != ":5678" {
+		t.Errorf("loading changed the defaults to %+v", testDefaults)
+	}
+}
@@ -0,0 +1,204 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"text/tabwriter"
+	"time"
+
+	chitchat "homework3/chitchat"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/credentials/insecure"
+)
+
+// runAdminCommand implements 'server admin ...', a small client for the Admin service.
+// It returns the exit code for the process.
+func runAdminCommand(args []string) int {
+	usage := func() {
+		fmt.Fprintln(os.Stderr, "usage: server admin [-address host:port] sessions|rooms|stats")
+		fmt.Fprintln(os.Stderr, "       server admin [-address host:port] disconnect <session id> [reason]")
+		fmt.Fprintln(os.Stderr, "       server admin [-address host:port] export [-format jsonl|csv|markdown] <room>")
+		fmt.Fprintln(os.Stderr, "       server admin [-address host:port] import [-format jsonl|csv] [-room room] <file>")
+	}
+	flags := flag.NewFlagSet("admin", flag.ContinueOnError)
+	address := flags.String("address", defaultSettings.Admin, "address of the Admin service")
+	if err := flags.Parse(args); err != nil {
+		return 2
+	}
+	if flags.NArg() == 0 {
+		usage()
+		return 2
+	}
+
+	conn, err := grpc.Dial(*address, grpc.WithTransportCredentials(insecure.NewCredentials()))
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Could not connect to %s: %v\n", *address, err)
+		return 1
+	}
+	defer conn.Close()
+	admin := chitchat.NewAdminClient(conn)
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	table := tabwriter.NewWriter(os.Stdout, 0, 4, 2, ' ', 0)
+	defer table.Flush()
+	switch flags.Arg(0) {
+	case "sessions":
+		response, err := admin.ListSessions(ctx, &chitchat.ListSessionsRequest{})
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Could not list sessions: %v\n", err)
+			return 1
+		}
+		fmt.Fprintln(table, "ID\tNAME\tROOM\tREMOTE ADDRESS\tCONNECTED SINCE\tLAMPORT")
+		for _, session := range response.Sessions {
+			fmt.Fprintf(table, "%d\t%s\t%s\t%s\t%s\t%d\n", session.Id, session.Name, session.Room, session.RemoteAddress,
+				session.ConnectedSince.AsTime().Local().Format(time.DateTime), session.Lamport)
+		}
+	case "rooms":
+		response, err := admin.ListRooms(ctx, &chitchat.ListRoomsRequest{})
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Could not list rooms: %v\n", err)
+			return 1
+		}
+		fmt.Fprintln(table, "ROOM\tPARTICIPANTS\tCREATED")
+		for _, room := range response.Rooms {
+			created := "-"
+			if room.Created != nil {
+				created = room.Created.AsTime().Local().Format(time.DateTime)
+			}
+			fmt.Fprintf(table, "%s\t%d\t%s\n", room.Name, room.Participants, created)
+		}
+	case "stats":
+		stats, err := admin.GetStats(ctx, &chitchat.StatsRequest{})
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Could not get stats: %v\n", err)
+			return 1
+		}
+		fmt.Fprintf(table, "started at\t%s\n", stats.StartedAt.AsTime().Local().Format(time.DateTime))
+		fmt.Fprintf(table, "participants\t%d\n", stats.Participants)
+		fmt.Fprintf(table, "rooms\t%d\n", stats.Rooms)
+		fmt.Fprintf(table, "registered users\t%d\n", stats.Users)
+		fmt.Fprintf(table, "messages broadcast\t%d\n", stats.MessagesBroadcast)
+		fmt.Fprintf(table, "send failures\t%d\n", stats.SendFailures)
+		fmt.Fprintf(table, "lamport\t%d\n", stats.Lamport)
+	case "disconnect":
+		if flags.NArg() < 2 {
+			usage()
+			return 2
+		}
+		id, err := strconv.ParseInt(flags.Arg(1), 10, 32)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Session id must be a number: %v\n", err)
+			return 2
+		}
+		if _, err := admin.Disconnect(ctx, &chitchat.DisconnectRequest{Id: int32(id), Reason: flags.Arg(2)}); err != nil {
+			fmt.Fprintf(os.Stderr, "Could not disconnect session %d: %v\n", id, err)
+			return 1
+		}
+		fmt.Printf("Disconnected session %d\n", id)
+	case "export":
+		return exportHistory(ctx, admin, flags.Args()[1:])
+	case "import":
+		return importHistory(ctx, admin, flags.Args()[1:])
+	default:
+		usage()
+		return 2
+	}
+	return 0
+}
+
+// how much of a history file goes in each chunk of an import
+const importChunkSize = 64 << 10
+
+// exportHistory implements 'server admin export', writing a room's history to stdout.
+func exportHistory(ctx context.Context, admin chitchat.AdminClient, args []string) int {
+	flags := flag.NewFlagSet("admin export", flag.ContinueOnError)
+	format := flags.String("format", "jsonl", "export format: jsonl, csv or markdown")
+	if err := flags.Parse(args); err != nil {
+		return 2
+	}
+	if flags.NArg() != 1 {
+		fmt.Fprintln(os.Stderr, "usage: server admin export [-format jsonl|csv|markdown] <room>")
+		return 2
+	}
+	stream, err := admin.ExportHistory(ctx, &chitchat.ExportHistoryRequest{Room: flags.Arg(0), Format: *format})
+	if err == nil {
+		var chunk *chitchat.HistoryChunk
+		for chunk, err = stream.Recv(); err == nil; chunk, err = stream.Recv() {
+			if _, err = os.Stdout.Write(chunk.Data); err != nil {
+				break
+			}
+		}
+	}
+	if err != io.EOF {
+		fmt.Fprintf(os.Stderr, "Could not export the history of %s: %v\n", flags.Arg(0), err)
+		return 1
+	}
+	return 0
+}
+
+// importHistory implements 'server admin import', loading an exported history into the server.
+// The format is taken from the file's extension unless -format says otherwise.
+func importHistory(ctx context.Context, admin chitchat.AdminClient, args []string) int {
+	flags := flag.NewFlagSet("admin import", flag.ContinueOnError)
+	format := flags.String("format", "", "format of the file: jsonl or csv (default from the file's extension)")
+	room := flags.String("room", "", "room to import into instead of the rooms in the file")
+	if err := flags.Parse(args); err != nil {
+		return 2
+	}
+	if flags.NArg() != 1 {
+		fmt.Fprintln(os.Stderr, "usage: server admin import [-format jsonl|csv] [-room room] <file>")
+		return 2
+	}
+	path := flags.Arg(0)
+	if *format == "" {
+		*format = "jsonl"
+		if strings.EqualFold(filepath.Ext(path), ".csv") {
+			*format = "csv"
+		}
+	}
+	file, err := os.Open(path)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Could not open %s: %v\n", path, err)
+		return 1
+	}
+	defer file.Close()
+
+	stream, err := admin.ImportHistory(ctx)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Could not import %s: %v\n", path, err)
+		return 1
+	}
+	chunk := &chitchat.ImportHistoryChunk{Header: &chitchat.ImportHistoryHeader{Format: *format, Room: *room}}
+	buffer := make([]byte, importChunkSize)
+	for {
+		n, readErr := file.Read(buffer)
+		chunk.Data = buffer[:n]
+		//the server stops reading after an error, which CloseAndRecv below says.
+		if err := stream.Send(chunk); err != nil {
+			break
+		}
+		chunk = &chitchat.ImportHistoryChunk{}
+		if readErr == io.EOF {
+			break
+		}
+		if readErr != nil {
+			fmt.Fprintf(os.Stderr, "Could not read %s: %v\n", path, readErr)
+			return 1
+		}
+	}
+	response, err := stream.CloseAndRecv()
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Could not import %s: %v\n", path, err)
+		return 1
+	}
+	fmt.Printf("Imported %d entries from %s at Lamport times %d to %d\n", response.Entries, path, response.FirstLamport, response.LastLamport)
+	return 0
+}
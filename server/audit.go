@@ -0,0 +1,56 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"os"
+
+	"homework3/chatserver"
+)
+
+// runAuditCommand implements 'server audit verify' and 'server audit export'.
+// It returns the exit code for the process.
+func runAuditCommand(args []string) int {
+	usage := func() {
+		fmt.Fprintln(os.Stderr, "usage: server audit verify [-log file]")
+		fmt.Fprintln(os.Stderr, "       server audit export [-log file] [-format jsonl|csv]")
+	}
+	if len(args) == 0 {
+		usage()
+		return 2
+	}
+
+	flags := flag.NewFlagSet("audit "+args[0], flag.ContinueOnError)
+	path := flags.String("log", "audit.log", "audit log to read")
+	format := flags.String("format", "jsonl", "export format: jsonl or csv")
+	if err := flags.Parse(args[1:]); err != nil {
+		return 2
+	}
+
+	file, err := os.Open(*path)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Could not open audit log: %v\n", err)
+		return 1
+	}
+	defer file.Close()
+
+	switch args[0] {
+	case "verify":
+		var count int
+		if err := chatserver.ReadAuditLog(file, func(*chatserver.AuditEntry) { count++ }); err != nil {
+			fmt.Printf("Audit log %s is BROKEN: %v\n", *path, err)
+			return 1
+		}
+		fmt.Printf("Audit log %s is intact: %d entries\n", *path, count)
+		return 0
+	case "export":
+		if err := chatserver.ExportAuditLog(file, os.Stdout, *format); err != nil {
+			fmt.Fprintf(os.Stderr, "Could not export audit log: %v\n", err)
+			return 1
+		}
+		return 0
+	default:
+		usage()
+		return 2
+	}
+}
@@ -0,0 +1,303 @@
+package main
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"log/slog"
+	"os"
+	"reflect"
+	"sync/atomic"
+	"time"
+
+	"homework3/chatserver"
+	"homework3/config"
+	"homework3/tracing"
+
+	"google.golang.org/grpc/credentials"
+	"google.golang.org/grpc/keepalive"
+)
+
+// Settings holds everything about the server that can be configured.
+// Settings marked "restart" are only read at startup; the rest are picked up again on SIGHUP.
+type Settings struct {
+	Listen     []string          `yaml:"listen" usage:"comma separated addresses to listen on (restart)"`
+	StorageDir string            `yaml:"storage_dir" usage:"directory registered keys, the audit log and shared files are kept in (restart)"`
+	LogLevel   string            `yaml:"log_level" usage:"least severe messages to log: debug, info, warn or error"`
+	LogFormat  string            `yaml:"log_format" usage:"how log lines are written: text or json (restart)"`
+	Metrics    string            `yaml:"metrics_address" usage:"address to serve Prometheus metrics at, disabled if empty (restart)"`
+	Admin      string            `yaml:"admin_address" usage:"address to serve the Admin service at, disabled if empty (restart)"`
+	TLS        TLSSettings       `yaml:"tls"`
+	Limits     LimitSettings     `yaml:"limits"`
+	Keepalive  KeepaliveSettings `yaml:"keepalive"`
+	Rooms      RoomSettings      `yaml:"rooms"`
+	Moderators []string          `yaml:"moderators" usage:"comma separated users who may edit and delete anyone's messages"`
+	Tracing    tracing.Settings  `yaml:"tracing"`
+}
+
+type TLSSettings struct {
+	CertFile string `yaml:"cert_file" usage:"PEM certificate to serve TLS with, plaintext if empty (restart)"`
+	KeyFile  string `yaml:"key_file" usage:"PEM private key for the TLS certificate (restart)"`
+}
+
+type LimitSettings struct {
+	MaxMessageLength  int           `yaml:"max_message_length" usage:"longest message accepted, in characters"`
+	MaxParticipants   int           `yaml:"max_participants" usage:"most users connected at once, 0 for no limit"`
+	StreamQueueSize   int           `yaml:"stream_queue_size" usage:"messages queued for a slow client before new ones are dropped"`
+	IdleTimeout       time.Duration `yaml:"idle_timeout" usage:"how long a participant can do nothing before they show as idle, 0 to never"`
+	HistoryReplay     int           `yaml:"history_replay" usage:"how many of a room's latest messages someone joining it is sent, 0 for none"`
+	MailboxRetention  time.Duration `yaml:"mailbox_retention" usage:"how long direct messages wait for their recipient, 0 for as long as it takes"`
+	MailboxSize       int           `yaml:"mailbox_size" usage:"most direct messages waiting for one recipient, 0 for no limit"`
+	MaxAttachmentSize int64         `yaml:"max_attachment_size" usage:"largest file users can share, in bytes, 0 to not let them share files"`
+	MaxIndexed        int           `yaml:"max_indexed_messages" usage:"most of the latest messages search can find, 0 for no limit"`
+}
+
+type KeepaliveSettings struct {
+	Time              time.Duration `yaml:"time" usage:"idle time before the server pings a client (restart)"`
+	Timeout           time.Duration `yaml:"timeout" usage:"time to wait for a ping reply before closing the connection (restart)"`
+	MinClientInterval time.Duration `yaml:"min_client_interval" usage:"shortest interval clients may send pings at (restart)"`
+}
+
+// RoomSettings are the defaults every room gets.
+type RoomSettings struct {
+	MaxParticipants int           `yaml:"max_participants" usage:"most users in a single room, 0 for no limit"`
+	MaxAge          time.Duration `yaml:"max_age" usage:"how long a room keeps messages, 0 for as long as it can"`
+	MaxMessages     int           `yaml:"max_messages" usage:"most messages a room keeps, 0 for no limit"`
+	MaxBytes        int64         `yaml:"max_bytes" usage:"most bytes a room's messages with their edits, reactions and files take up, 0 for no limit"`
+	//rooms that keep more or less than the defaults above, by name. Only the config file can set them.
+	Retention map[string]RetentionSettings `yaml:"retention"`
+}
+
+// RetentionSettings are how much of its history one room keeps. Settings left out are no limit,
+// rather than the defaults.
+type RetentionSettings struct {
+	MaxAge      time.Duration `yaml:"max_age"`
+	MaxMessages int           `yaml:"max_messages"`
+	MaxBytes    int64         `yaml:"max_bytes"`
+}
+
+// defaultRetention is how much of its history a room keeps when it has no settings of its own.
+func (rooms RoomSettings) defaultRetention() RetentionSettings {
+	return RetentionSettings{MaxAge: rooms.MaxAge, MaxMessages: rooms.MaxMessages, MaxBytes: rooms.MaxBytes}
+}
+
+func (retention RetentionSettings) validate(key string) []error {
+	var problems []error
+	if retention.MaxAge < 0 {
+		problems = append(problems, fmt.Errorf("%s.max_age: must not be negative", key))
+	}
+	if retention.MaxMessages < 0 {
+		problems = append(problems, fmt.Errorf("%s.max_messages: must not be negative", key))
+	}
+	if retention.MaxBytes < 0 {
+		problems = append(problems, fmt.Errorf("%s.max_bytes: must not be negative", key))
+	}
+	return problems
+}
+
+func (retention RetentionSettings) retention() chatserver.Retention {
+	return chatserver.Retention{MaxAge: retention.MaxAge, MaxMessages: retention.MaxMessages, MaxBytes: retention.MaxBytes}
+}
+
+var defaultSettings = Settings{
+	Listen:     []string{":5678"},
+	StorageDir: ".",
+	LogLevel:   "info",
+	LogFormat:  "text",
+	Metrics:    "localhost:9090",
+	Admin:      "localhost:5679",
+	Limits: LimitSettings{
+		MaxMessageLength:  128,
+		StreamQueueSize:   128,
+		IdleTimeout:       5 * time.Minute,
+		HistoryReplay:     50,
+		MailboxRetention:  30 * 24 * time.Hour,
+		MailboxSize:       100,
+		MaxAttachmentSize: 10 << 20,
+		MaxIndexed:        100000,
+	},
+	Keepalive: KeepaliveSettings{
+		Time:              2 * time.Hour,
+		Timeout:           20 * time.Second,
+		MinClientInterval: 5 * time.Minute,
+	},
+	Tracing: tracing.DefaultSettings,
+}
+
+func (settings *Settings) Validate() error {
+	var problems []error
+	if len(settings.Listen) == 0 {
+		problems = append(problems, errors.New("listen: at least one address is required"))
+	}
+	if settings.StorageDir == "" {
+		problems = append(problems, errors.New("storage_dir: must not be empty"))
+	}
+	var level slog.Level
+	if err := level.UnmarshalText([]byte(settings.LogLevel)); err != nil {
+		problems = append(problems, fmt.Errorf("log_level: %w", err))
+	}
+	if settings.LogFormat != "text" && settings.LogFormat != "json" {
+		problems = append(problems, errors.New("log_format: must be text or json"))
+	}
+	if (settings.TLS.CertFile == "") != (settings.TLS.KeyFile == "") {
+		problems = append(problems, errors.New("tls: cert_file and key_file must be set together"))
+	}
+	if settings.Limits.MaxMessageLength <= 0 {
+		problems = append(problems, errors.New("limits.max_message_length: must be positive"))
+	}
+	if settings.Limits.MaxParticipants < 0 {
+		problems = append(problems, errors.New("limits.max_participants: must not be negative"))
+	}
+	if settings.Limits.StreamQueueSize <= 0 {
+		problems = append(problems, errors.New("limits.stream_queue_size: must be positive"))
+	}
+	if settings.Limits.IdleTimeout < 0 {
+		problems = append(problems, errors.New("limits.idle_timeout: must not be negative"))
+	}
+	if settings.Limits.HistoryReplay < 0 {
+		problems = append(problems, errors.New("limits.history_replay: must not be negative"))
+	}
+	if settings.Limits.MailboxRetention < 0 {
+		problems = append(problems, errors.New("limits.mailbox_retention: must not be negative"))
+	}
+	if settings.Limits.MailboxSize < 0 {
+		problems = append(problems, errors.New("limits.mailbox_size: must not be negative"))
+	}
+	if settings.Limits.MaxAttachmentSize < 0 {
+		problems = append(problems, errors.New("limits.max_attachment_size: must not be negative"))
+	}
+	if settings.Limits.MaxIndexed < 0 {
+		problems = append(problems, errors.New("limits.max_indexed_messages: must not be negative"))
+	}
+	if settings.Keepalive.Time <= 0 || settings.Keepalive.Timeout <= 0 || settings.Keepalive.MinClientInterval <= 0 {
+		problems = append(problems, errors.New("keepalive: durations must be positive"))
+	}
+	if settings.Rooms.MaxParticipants < 0 {
+		problems = append(problems, errors.New("rooms.max_participants: must not be negative"))
+	}
+	problems = append(problems, settings.Rooms.defaultRetention().validate("rooms")...)
+	for room, retention := range settings.Rooms.Retention {
+		problems = append(problems, retention.validate("rooms.retention."+room)...)
+	}
+	if err := settings.Tracing.Validate(); err != nil {
+		problems = append(problems, err)
+	}
+	return errors.Join(problems...)
+}
+
+// limits are the limits the chat server enforces, taken from the settings.
+func (settings *Settings) limits() chatserver.Limits {
+	var roomRetention map[string]chatserver.Retention
+	for room, retention := range settings.Rooms.Retention {
+		if roomRetention == nil {
+			roomRetention = make(map[string]chatserver.Retention)
+		}
+		roomRetention[room] = retention.retention()
+	}
+	return chatserver.Limits{
+		MaxMessageLength:    settings.Limits.MaxMessageLength,
+		MaxParticipants:     settings.Limits.MaxParticipants,
+		MaxRoomParticipants: settings.Rooms.MaxParticipants,
+		StreamQueueSize:     settings.Limits.StreamQueueSize,
+		IdleTimeout:         settings.Limits.IdleTimeout,
+		HistoryReplay:       settings.Limits.HistoryReplay,
+		MailboxRetention:    settings.Limits.MailboxRetention,
+		MailboxSize:         settings.Limits.MailboxSize,
+		MaxAttachmentSize:   settings.Limits.MaxAttachmentSize,
+		MaxIndexedMessages:  settings.Limits.MaxIndexed,
+		Retention:           settings.Rooms.defaultRetention().retention(),
+		RoomRetention:       roomRetention,
+	}
+}
+
+// serverOptions turns the startup settings into options for the chat server.
+func (settings *Settings) serverOptions() ([]chatserver.Option, error) {
+	options := []chatserver.Option{
+		chatserver.WithAddresses(settings.Listen...),
+		chatserver.WithAdminAddress(settings.Admin),
+		chatserver.WithMetricsAddress(settings.Metrics),
+		chatserver.WithLimits(settings.limits()),
+		chatserver.WithModerators(settings.Moderators...),
+		chatserver.WithKeepalive(keepalive.ServerParameters{
+			Time:    settings.Keepalive.Time,
+			Timeout: settings.Keepalive.Timeout,
+		}, keepalive.EnforcementPolicy{
+			MinTime:             settings.Keepalive.MinClientInterval,
+			PermitWithoutStream: true,
+		}),
+	}
+	if settings.TLS.CertFile != "" {
+		transportCreds, err := credentials.NewServerTLSFromFile(settings.TLS.CertFile, settings.TLS.KeyFile)
+		if err != nil {
+			return nil, fmt.Errorf("could not load TLS certificate: %w", err)
+		}
+		options = append(options, chatserver.WithTLS(transportCreds))
+	}
+	return options, nil
+}
+
+// the settings currently in effect. Replaced as a whole on reload.
+var currentSettings atomic.Pointer[Settings]
+
+// least severe level that gets logged
+var logLevel slog.LevelVar
+
+func settings() *Settings {
+	return currentSettings.Load()
+}
+
+// applySettings puts loaded settings into effect. chatServer is nil at startup, before it is built.
+func applySettings(loaded *Settings, chatServer *chatserver.Server) {
+	var level slog.Level
+	level.UnmarshalText([]byte(loaded.LogLevel))
+	logLevel.Set(level)
+	if chatServer != nil {
+		chatServer.SetLimits(loaded.limits())
+		chatServer.SetModerators(loaded.Moderators)
+	}
+	currentSettings.Store(loaded)
+}
+
+// reloadSettings loads the settings again and applies those that can change while running.
+// Settings that need a restart keep their old value; a warning is logged if they changed.
+func reloadSettings(loader *config.Loader[Settings], chatServer *chatserver.Server) {
+	reloaded, err := loader.Load()
+	if err != nil {
+		slog.Error("keeping current settings, reload failed", "error", err)
+		return
+	}
+	current := settings()
+	if !reflect.DeepEqual(reloaded.Listen, current.Listen) ||
+		reloaded.StorageDir != current.StorageDir ||
+		reloaded.LogFormat != current.LogFormat ||
+		reloaded.Metrics != current.Metrics ||
+		reloaded.Admin != current.Admin ||
+		reloaded.TLS != current.TLS ||
+		reloaded.Keepalive != current.Keepalive ||
+		reloaded.Tracing != current.Tracing {
+		slog.Warn("changes to listen, storage_dir, log_format, metrics_address, admin_address, tls, keepalive and tracing settings take effect after a restart")
+	}
+	reloaded.Listen = current.Listen
+	reloaded.StorageDir = current.StorageDir
+	reloaded.LogFormat = current.LogFormat
+	reloaded.Metrics = current.Metrics
+	reloaded.Admin = current.Admin
+	reloaded.TLS = current.TLS
+	reloaded.Keepalive = current.Keepalive
+	reloaded.Tracing = current.Tracing
+	applySettings(reloaded, chatServer)
+	slog.Info("reloaded settings")
+}
+
+// loadSettingsOrExit loads the startup settings, exiting with a usage error if they are invalid.
+func loadSettingsOrExit(loader *config.Loader[Settings]) *Settings {
+	loaded, err := loader.Load()
+	if errors.Is(err, flag.ErrHelp) {
+		os.Exit(0)
+	}
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(2)
+	}
+	return loaded
+}
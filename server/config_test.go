@@ -0,0 +1,108 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	"homework3/chatserver"
+	"homework3/config"
+)
+
+func TestDefaultSettings(t *testing.T) {
+	defaults := defaultSettings
+	if err := defaults.Validate(); err != nil {
+		t.Fatalf("the default settings are invalid: %v", err)
+	}
+	//what the server runs with when nothing is configured should be what it would run with on its own.
+	if got := defaults.limits(); !reflect.DeepEqual(got, chatserver.DefaultLimits) {
+		t.Errorf("the default settings give limits %+v, want %+v", got, chatserver.DefaultLimits)
+	}
+	for _, address := range []string{defaults.Metrics, defaults.Admin} {
+		if !strings.HasPrefix(address, "localhost:") {
+			t.Errorf("%q is served to everyone by default, want localhost only", address)
+		}
+	}
+}
+
+func TestValidate(t *testing.T) {
+	tests := []struct {
+		name   string
+		change func(*Settings)
+		want   string
+	}{
+		{"no address", func(s *Settings) { s.Listen = nil }, "listen:"},
+		{"no storage", func(s *Settings) { s.StorageDir = "" }, "storage_dir:"},
+		{"log level", func(s *Settings) { s.LogLevel = "loud" }, "log_level:"},
+		{"log format", func(s *Settings) { s.LogFormat = "xml" }, "log_format:"},
+		{"half of TLS", func(s *Settings) { s.TLS.CertFile = "server.pem" }, "tls:"},
+		{"message length", func(s *Settings) { s.Limits.MaxMessageLength = 0 }, "limits.max_message_length:"},
+		{"queue size", func(s *Settings) { s.Limits.StreamQueueSize = 0 }, "limits.stream_queue_size:"},
+		{"idle timeout", func(s *Settings) { s.Limits.IdleTimeout = -time.Second }, "limits.idle_timeout:"},
+		{"indexed messages", func(s *Settings) { s.Limits.MaxIndexed = -1 }, "limits.max_indexed_messages:"},
+		{"keepalive", func(s *Settings) { s.Keepalive.Timeout = 0 }, "keepalive:"},
+		{"room age", func(s *Settings) { s.Rooms.MaxAge = -time.Hour }, "rooms.max_age:"},
+		{"one room", func(s *Settings) {
+			s.Rooms.Retention = map[string]RetentionSettings{"ops": {MaxMessages: -1}}
+		}, "rooms.retention.ops.max_messages:"},
+	}
+	for _, test := range tests {
+		settings := defaultSettings
+		test.change(&settings)
+		if err := settings.Validate(); err == nil || !strings.Contains(err.Error(), test.want) {
+			t.Errorf("%s: got %v, want an error about %s", test.name, err, test.want)
+		}
+	}
+
+	//every problem is reported at once.
+	settings := defaultSettings
+	settings.Listen = nil
+	settings.LogFormat = "xml"
+	if err := settings.Validate(); err == nil || !strings.Contains(err.Error(), "listen:") || !strings.Contains(err.Error(), "log_format:") {
+		t.Errorf("got %v, want both problems", err)
+	}
+}
+
+func TestRoomRetention(t *testing.T) {
+	settings := defaultSettings
+	settings.Rooms.MaxAge = time.Hour
+	settings.Rooms.Retention = map[string]RetentionSettings{"ops": {MaxMessages: 10}}
+	limits := settings.limits()
+	if limits.Retention != (chatserver.Retention{MaxAge: time.Hour}) {
+		t.Errorf("rooms keep %+v by default, want the room defaults", limits.Retention)
+	}
+	//a room with settings of its own does not get the defaults it leaves out.
+	if got := limits.RoomRetention["ops"]; got != (chatserver.Retention{MaxMessages: 10}) {
+		t.Errorf("ops keeps %+v, want only its own settings", got)
+	}
+}
+
+func TestReloadSettings(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "server.yaml")
+	write := func(contents string) {
+		t.Helper()
+		if err := os.WriteFile(path, []byte(contents), 0600); err != nil {
+			t.Fatal(err)
+		}
+	}
+	write("storage_dir: first\nlog_level: info\nlimits:\n  max_message_length: 100\n")
+	loader := config.NewLoader("server", []string{"-config", path}, defaultSettings)
+	applySettings(loadSettingsOrExit(loader), nil)
+
+	write("storage_dir: second\nlog_level: debug\nlimits:\n  max_message_length: 200\n")
+	reloadSettings(loader, nil)
+	if got := settings(); got.StorageDir != "first" || got.LogLevel != "debug" || got.Limits.MaxMessageLength != 200 {
+		t.Errorf("after reloading, storage_dir is %q, log_level %q and max_message_length %d; want first, debug and 200",
+			got.StorageDir, got.LogLevel, got.Limits.MaxMessageLength)
+	}
+
+	//settings that do not load are not applied at all.
+	write("log_level: loud\nlimits:\n  max_message_length: 300\n")
+	reloadSettings(loader, nil)
+	if got := settings(); got.LogLevel != "debug" || got.Limits.MaxMessageLength != 200 {
+		t.Errorf("an invalid reload changed the settings to log_level %q and max_message_length %d", got.LogLevel, got.Limits.MaxMessageLength)
+	}
+}
@@ -0,0 +1,22 @@
+package main
+
+import (
+	"log/slog"
+	"os"
+)
+
+// setupLogging makes the default slog logger write text or JSON lines to stderr at logLevel.
+func setupLogging(format string) {
+	options := &slog.HandlerOptions{Level: &logLevel}
+	var handler slog.Handler = slog.NewTextHandler(os.Stderr, options)
+	if format == "json" {
+		handler = slog.NewJSONHandler(os.Stderr, options)
+	}
+	slog.SetDefault(slog.New(handler))
+}
+
+// fatal logs an error and exits, for failures the server cannot run without.
+func fatal(msg string, args ...any) {
+	slog.Error(msg, args...)
+	os.Exit(1)
+}
@@ -2,135 +2,87 @@ package main
 
 import (
 	"context"
-	"fmt"
-	chitchat "homework3/chitchat"
-	"log"
-	"net"
-	"sync"
-
-	"google.golang.org/grpc"
+	"homework3/chatserver"
+	"homework3/config"
+	"homework3/tracing"
+	"log/slog"
+	"os"
+	"os/signal"
+	"path/filepath"
+	"syscall"
+	"time"
 )
 
-type UserStream struct {
-	UserId int32
-	Stream chitchat.ChatService_JoinServer // The gRPC stream
-}
-
-type Server struct {
-	chitchat.UnimplementedChatServiceServer
-}
+// how long queued messages get to reach clients before remaining streams are cut off
+const drainTimeout = 10 * time.Second
 
-// create map of all userstreams (streans to connected clients)
-var userStreams = make(map[int32]*UserStream)
-var lamport int32
+// the directory inside the storage directory shared files are kept in
+const blobDir = "files"
 
 func main() {
+	//'server audit ...' inspects the audit log and 'server admin ...' talks to a running
+	//server's Admin service, instead of starting the server.
+	if len(os.Args) > 1 && os.Args[1] == "audit" {
+		os.Exit(runAuditCommand(os.Args[2:]))
+	}
+	if len(os.Args) > 1 && os.Args[1] == "admin" {
+		os.Exit(runAdminCommand(os.Args[2:]))
+	}
+	loader := config.NewLoader("server", os.Args[1:], defaultSettings)
+	startup := loadSettingsOrExit(loader)
+	setupLogging(startup.LogFormat)
+	applySettings(startup, nil)
 
-	lamport = 0
-	port := "5678" //set the default port to 5678
-
-	//initialize the listener on the specified port. net.Listen listens for incoming connections with tcp socket
-	listen, err := net.Listen("tcp", ":"+port)
+	flushTraces, err := tracing.Setup(context.Background(), "chitchat-server", startup.Tracing)
 	if err != nil {
-		log.Fatalf("Could not listen at port: %s : %v", port, err)
+		fatal("could not set up tracing", "error", err)
 	}
-	//close listener in case of unexpected exit.
-	defer listen.Close()
-	log.Println("Listening at: " + port)
-
-	//We make an instance of grpc server and chat server structure
-	grpcServer := grpc.NewServer()
-	serverStructure := Server{}
-	//We associate the chat service implementation, represented by the serverStructure structure
-	//with the (new and empty) gRPC server.
-	chitchat.RegisterChatServiceServer(grpcServer, &serverStructure)
 
-	//grpc listen and serve
-	err = grpcServer.Serve(listen)
+	//registered keys, the server's signing key, the audit log and shared files are kept in the storage directory.
+	storage, err := chatserver.OpenFileStorage(startup.StorageDir)
 	if err != nil {
-		log.Fatalf("Failed to start grpc server: %v", err)
-		log.Print(err)
+		fatal("could not open storage", "path", startup.StorageDir, "error", err)
 	}
-	select {}
-}
-
-var mutex sync.Mutex
-
-func (s *Server) Broadcast(ctx context.Context, message *chitchat.ClientMessage) (*chitchat.Confirmation, error) {
-	messageLamport := message.Lamport
-	//Use mutex to ensure consistency in the lamport timestamp across the server and all connected clients.
-	mutex.Lock()
-	lamport = max(lamport, messageLamport)
-	lamport++
-	mutex.Unlock()
-
-	message.Lamport = lamport
-
-	fmt.Println(" - ", message.Lamport, message.Name, ":", message.Text)
-
-	//Send the message to all connected users by cycling through the userStreams and sending the message.
-	for _, userStream := range userStreams {
-		if err := userStream.Stream.Send((*chitchat.ServerMessage)(message)); err != nil {
-			log.Fatalf("Failed to send message to client with id %d", userStream.UserId)
-		}
+	signingKey, err := storage.SigningKey()
+	if err != nil {
+		fatal("could not load the server's signing key", "error", err)
 	}
-
-	return &chitchat.Confirmation{}, nil
-}
-
-func (s *Server) Join(User *chitchat.User, userStream chitchat.ChatService_JoinServer) error {
-
-	//Compare lamport timestamps and select the highest value, then increment to maintain lamport time stamp across chat room.
-	userLamport := User.Lamport
-	mutex.Lock()
-	lamport = max(lamport, userLamport)
-	lamport++
-	mutex.Unlock()
-
-	// Send and broadcast a welcome message
-	welcomeMessage := fmt.Sprintf("Participant %s joined Chitty-Chat at Lamport time %d", User.Name, lamport)
-	message := &chitchat.ClientMessage{
-		Name:    "SERVER MESSAGE",
-		Text:    welcomeMessage,
-		Lamport: lamport,
+	serverOptions, err := startup.serverOptions()
+	if err != nil {
+		fatal("invalid settings", "error", err)
 	}
-	s.Broadcast(context.Background(), message)
-
-	//Add user to map of userstreams.
-	newUserStream := &UserStream{
-		UserId: User.Id,
-		Stream: userStream,
+	serverOptions = append(serverOptions, chatserver.WithStorage(storage), chatserver.WithSigningKey(signingKey),
+		chatserver.WithBlobDir(filepath.Join(startup.StorageDir, blobDir)))
+	chatServer, err := chatserver.New(serverOptions...)
+	if err != nil {
+		fatal("could not create the chat server", "error", err)
+	}
+	if err := chatServer.Start(); err != nil {
+		fatal("could not start the chat server", "error", err)
 	}
-	//Use mutex to ensure consistency in shared resource userStreams.
-	mutex.Lock()
-	userStreams[User.Id] = newUserStream
-	mutex.Unlock()
-	//keep method running to keep the userstream open.
-	select {}
-}
-
-func (s *Server) Leave(ctx context.Context, User *chitchat.User) (*chitchat.Confirmation, error) {
-
-	userLamport := User.Lamport
-	//Use mutex to ensure consistency in the lamport timestamp across the server and all connected clients.
-	mutex.Lock()
-	lamport = max(lamport, userLamport)
-	lamport++
-	mutex.Unlock()
 
-	//delete the userstream mapped to the given id from the userstreams map.
-	//Use mutex to ensure consistency in shared resource userStreams.
-	mutex.Lock()
-	delete(userStreams, User.Id)
-	mutex.Unlock()
+	//wait for ctrl+c or a termination request, then shut down without dropping queued messages.
+	//SIGHUP reloads the settings instead.
+	signals := make(chan os.Signal, 1)
+	signal.Notify(signals, os.Interrupt, syscall.SIGTERM, syscall.SIGHUP)
+	for received := range signals {
+		if received == syscall.SIGHUP {
+			reloadSettings(loader, chatServer)
+			continue
+		}
+		slog.Info("shutting down", "signal", received.String())
+		break
+	}
 
-	//Broadcast leave message
-	leaveMessage := fmt.Sprintf("Participant %s left Chitty-Chat at Lamport time %d", User.Name, lamport)
-	message := &chitchat.ClientMessage{
-		Name:    "SERVER MESSAGE",
-		Text:    leaveMessage,
-		Lamport: lamport,
+	ctx, cancel := context.WithTimeout(context.Background(), drainTimeout)
+	defer cancel()
+	if err := chatServer.Stop(ctx); err != nil {
+		slog.Error("failed to close storage", "error", err)
+	}
+	flushCtx, cancelFlush := context.WithTimeout(context.Background(), drainTimeout)
+	defer cancelFlush()
+	if err := flushTraces(flushCtx); err != nil {
+		slog.Error("failed to flush traces", "error", err)
 	}
-	s.Broadcast(context.Background(), message)
-	return &chitchat.Confirmation{}, nil
+	slog.Info("server stopped")
 }
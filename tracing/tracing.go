@@ -0,0 +1,130 @@
+// Package tracing sets up OpenTelemetry tracing for the Chitty-Chat binaries.
+package tracing
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+
+	"go.opentelemetry.io/contrib/instrumentation/google.golang.org/grpc/otelgrpc"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/exporters/otlp/otlptrace/otlptracegrpc"
+	"go.opentelemetry.io/otel/exporters/stdout/stdouttrace"
+	"go.opentelemetry.io/otel/propagation"
+	"go.opentelemetry.io/otel/sdk/resource"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	semconv "go.opentelemetry.io/otel/semconv/v1.21.0"
+	"go.opentelemetry.io/otel/trace"
+	"google.golang.org/grpc"
+)
+
+// Settings configure where traces go. They are embedded in the settings of each binary.
+type Settings struct {
+	Exporter     string  `yaml:"exporter" usage:"where to send traces: none, file or otlp"`
+	File         string  `yaml:"file" usage:"file spans are appended to as JSON when exporter is file"`
+	OTLPEndpoint string  `yaml:"otlp_endpoint" usage:"host:port of the OTLP/gRPC collector when exporter is otlp"`
+	OTLPInsecure bool    `yaml:"otlp_insecure" usage:"talk to the OTLP collector without TLS"`
+	SampleRatio  float64 `yaml:"sample_ratio" usage:"fraction of new traces to record, from 0 to 1"`
+}
+
+// DefaultSettings leave tracing off.
+var DefaultSettings = Settings{
+	Exporter:     "none",
+	File:         "traces.jsonl",
+	OTLPEndpoint: "localhost:4317",
+	SampleRatio:  1,
+}
+
+func (settings *Settings) Validate() error {
+	switch settings.Exporter {
+	case "none", "otlp":
+	case "file":
+		if settings.File == "" {
+			return errors.New("tracing.file: must be set when tracing.exporter is file")
+		}
+	default:
+		return fmt.Errorf("tracing.exporter: must be none, file or otlp, not %q", settings.Exporter)
+	}
+	if settings.SampleRatio < 0 || settings.SampleRatio > 1 {
+		return errors.New("tracing.sample_ratio: must be between 0 and 1")
+	}
+	return nil
+}
+
+// Setup installs the global tracer provider and W3C trace context propagation.
+// The returned function flushes buffered spans and must be called before the program exits.
+func Setup(ctx context.Context, serviceName string, settings Settings) (func(context.Context) error, error) {
+	otel.SetTextMapPropagator(propagation.TraceContext{})
+	if settings.Exporter == "none" {
+		return func(context.Context) error { return nil }, nil
+	}
+
+	var exporter sdktrace.SpanExporter
+	var closeFile func() error
+	switch settings.Exporter {
+	case "file":
+		file, err := os.OpenFile(settings.File, os.O_WRONLY|os.O_APPEND|os.O_CREATE, 0600)
+		if err != nil {
+			return nil, err
+		}
+		exporter, err = stdouttrace.New(stdouttrace.WithWriter(file))
+		if err != nil {
+			file.Close()
+			return nil, err
+		}
+		closeFile = file.Close
+	case "otlp":
+		options := []otlptracegrpc.Option{otlptracegrpc.WithEndpoint(settings.OTLPEndpoint)}
+		if settings.OTLPInsecure {
+			options = append(options, otlptracegrpc.WithInsecure())
+		}
+		var err error
+		exporter, err = otlptracegrpc.New(ctx, options...)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	provider := sdktrace.NewTracerProvider(
+		sdktrace.WithBatcher(exporter),
+		sdktrace.WithSampler(sdktrace.ParentBased(sdktrace.TraceIDRatioBased(settings.SampleRatio))),
+		sdktrace.WithResource(resource.NewWithAttributes(semconv.SchemaURL, semconv.ServiceName(serviceName))),
+	)
+	otel.SetTracerProvider(provider)
+
+	return func(ctx context.Context) error {
+		err := provider.Shutdown(ctx)
+		if closeFile != nil {
+			err = errors.Join(err, closeFile())
+		}
+		return err
+	}, nil
+}
+
+// Tracer returns the tracer Chitty-Chat code creates its own spans with.
+func Tracer() trace.Tracer {
+	return otel.Tracer("homework3/chitchat")
+}
+
+// ServerOption traces every call a gRPC server handles, continuing traces the client started.
+func ServerOption() grpc.ServerOption {
+	return grpc.StatsHandler(otelgrpc.NewServerHandler())
+}
+
+// DialOption traces every call a gRPC client makes and passes the trace on to the server.
+func DialOption() grpc.DialOption {
+	return grpc.WithStatsHandler(otelgrpc.NewClientHandler())
+}
+
+// Inject returns the trace context of ctx as a map that can travel inside a message.
+func Inject(ctx context.Context) map[string]string {
+	carrier := propagation.MapCarrier{}
+	otel.GetTextMapPropagator().Inject(ctx, carrier)
+	return carrier
+}
+
+// Extract returns a context continuing the trace carried in a message.
+func Extract(ctx context.Context, carried map[string]string) context.Context {
+	return otel.GetTextMapPropagator().Extract(ctx, propagation.MapCarrier(carried))
+}